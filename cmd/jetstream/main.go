@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"jetstream/internal/config"
 	"jetstream/internal/handlers"
+	"jetstream/internal/safego"
+	"jetstream/internal/scrobbler"
 	"jetstream/internal/service"
-	"log"
 	"log/slog"
 	"net/http"
 	"os"
@@ -20,21 +22,50 @@ func main() {
 	// 1. Load Config
 	cfg, err := config.Load()
 	if err != nil {
-		log.Printf("Warning: Could not load config: %v", err)
+		slog.Error("Could not load config", "error", err)
+		os.Exit(1)
 	}
 
 	// 2. Initialize Services
-	squidService := service.NewSquidService(cfg)
+	// Services read live so SIGHUP can swap in a reloaded config
+	live := config.NewLive(cfg)
+	squidService := service.NewSquidService(live)
 	proxyHandler := handlers.NewProxyHandler(cfg)
-	syncService := service.NewSyncService(squidService, cfg)
-	searchHandler := handlers.NewSearchHandler(squidService, syncService, cfg, proxyHandler)
-	metadataHandler := handlers.NewMetadataHandler(squidService, syncService, proxyHandler)
-	handler := handlers.NewHandler(squidService, syncService, proxyHandler)
+	providers := service.NewProviders(cfg, squidService)
+	syncService := service.NewSyncService(squidService, providers, live)
+	searchHandler := handlers.NewSearchHandler(squidService, providers, syncService, live, proxyHandler)
+	metadataHandler := handlers.NewMetadataHandler(squidService, providers, syncService, proxyHandler, scrobbler.NewListenBrainz(cfg))
+	handler := handlers.NewHandler(squidService, providers, syncService, live, proxyHandler)
 	maintenanceHandler := handlers.NewMaintenanceHandler(syncService)
+	syncHandler := handlers.NewSyncHandler(providers, syncService)
 	navidromeAPIHandler := handlers.NewNavidromeAPIHandler(squidService, proxyHandler)
+	radioHandler := handlers.NewRadioHandler(squidService, handler, proxyHandler, live)
+
+	// Sweep temp files orphaned by a previous run in the background so a large library doesn't delay startup
+	safego.Go(func() {
+		removed, err := syncService.CleanupTempFiles(context.Background(), cfg.TempFileMaxAge)
+		if err != nil {
+			slog.Warn("Temp file cleanup failed", "error", err)
+		}
+		slog.Info("Temp file cleanup finished", "removed", removed, "maxAge", cfg.TempFileMaxAge)
+	})
+
+	// Learn the library's size for LIBRARY_MAX_BYTES and /health
+	safego.Go(func() {
+		if err := syncService.ComputeLibraryUsage(context.Background()); err != nil {
+			slog.Warn("Library usage scan failed", "error", err)
+		}
+	})
+
+	// Keep the Squid mirrors ordered fastest first
+	safego.Go(func() { squidService.RunLatencyProbe(context.Background()) })
 
 	// 3. Setup Router
-	r := gin.Default()
+	// gin.New rather than gin.Default: RequestLoggingMiddleware replaces gin's access log
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.Use(handlers.RequestLoggingMiddleware())
+	r.Use(handlers.BodyLimitMiddleware(cfg.MaxRequestBody))
 	r.Use(handlers.CORSMiddleware())
 	r.Use(handlers.DebugLoggingMiddleware())
 	r.SetTrustedProxies(nil)
@@ -48,141 +79,166 @@ func main() {
 	}
 
 	// 5. Subsonic API Routes
-	subsonicGroup := r.Group("/rest")
-	{
+	// Endpoints are registered with and without the .view suffix. Anything listed in
+	// PROXY_ENDPOINTS, and anything not listed here at all, goes straight to Navidrome.
+	subsonicGroup := r.Group("/rest", handlers.AuthMiddleware(cfg, squidService.GetCache(), proxyHandler.Navidrome()))
+	registerSubsonicRoutes(subsonicGroup, cfg, proxyHandler.Handle, []subsonicRoute{
 		// System
-		subsonicGroup.Any("/ping.view", proxyHandler.Handle)
-		subsonicGroup.Any("/ping", proxyHandler.Handle)
-		subsonicGroup.Any("/getLicense.view", proxyHandler.Handle)
-		subsonicGroup.Any("/getLicense", proxyHandler.Handle)
+		{"ping", proxyHandler.Handle},
+		{"getLicense", metadataHandler.GetLicense},
 
 		// Browsing
-		subsonicGroup.Any("/getMusicFolders.view", proxyHandler.Handle)
-		subsonicGroup.Any("/getMusicFolders", proxyHandler.Handle)
-		subsonicGroup.Any("/getIndexes.view", proxyHandler.Handle)
-		subsonicGroup.Any("/getIndexes", proxyHandler.Handle)
-		subsonicGroup.Any("/getMusicDirectory.view", metadataHandler.GetMusicDirectory)
-		subsonicGroup.Any("/getMusicDirectory", metadataHandler.GetMusicDirectory)
-		subsonicGroup.Any("/getGenres.view", proxyHandler.Handle)
-		subsonicGroup.Any("/getGenres", proxyHandler.Handle)
-		subsonicGroup.Any("/getArtists.view", proxyHandler.Handle)
-		subsonicGroup.Any("/getArtists", proxyHandler.Handle)
-		subsonicGroup.Any("/getArtist.view", metadataHandler.GetArtist)
-		subsonicGroup.Any("/getArtist", metadataHandler.GetArtist)
-		subsonicGroup.Any("/getAlbum.view", metadataHandler.GetAlbum)
-		subsonicGroup.Any("/getAlbum", metadataHandler.GetAlbum)
-		subsonicGroup.Any("/getAlbumInfo.view", metadataHandler.GetAlbumInfo)
-		subsonicGroup.Any("/getAlbumInfo", metadataHandler.GetAlbumInfo)
-		subsonicGroup.Any("/getAlbumInfo2.view", metadataHandler.GetAlbumInfo2)
-		subsonicGroup.Any("/getAlbumInfo2", metadataHandler.GetAlbumInfo2)
-		subsonicGroup.Any("/getSong.view", metadataHandler.GetSong)
-		subsonicGroup.Any("/getSong", metadataHandler.GetSong)
+		{"getMusicFolders", proxyHandler.Handle},
+		{"getIndexes", metadataHandler.GetIndexes},
+		{"getMusicDirectory", metadataHandler.GetMusicDirectory},
+		{"getGenres", metadataHandler.GetGenres},
+		{"getArtists", metadataHandler.GetArtists},
+		{"getArtist", metadataHandler.GetArtist},
+		{"getAlbum", metadataHandler.GetAlbum},
+		{"getAlbumInfo", metadataHandler.GetAlbumInfo},
+		{"getAlbumInfo2", metadataHandler.GetAlbumInfo2},
+		{"getSong", metadataHandler.GetSong},
+		{"getSongs", metadataHandler.GetSongs},
+		{"getAlbums", metadataHandler.GetAlbums},
 
 		// Lists
-		subsonicGroup.Any("/getAlbumList.view", searchHandler.GetAlbumList2)
-		subsonicGroup.Any("/getAlbumList", searchHandler.GetAlbumList2)
-		subsonicGroup.Any("/getAlbumList2.view", searchHandler.GetAlbumList2)
-		subsonicGroup.Any("/getAlbumList2", searchHandler.GetAlbumList2)
-		subsonicGroup.Any("/getRandomSongs.view", metadataHandler.GetRandomSongs)
-		subsonicGroup.Any("/getRandomSongs", metadataHandler.GetRandomSongs)
-		subsonicGroup.Any("/getSongsByGenre.view", metadataHandler.GetSongsByGenre)
-		subsonicGroup.Any("/getSongsByGenre", metadataHandler.GetSongsByGenre)
-		subsonicGroup.Any("/getNowPlaying.view", proxyHandler.Handle)
-		subsonicGroup.Any("/getNowPlaying", proxyHandler.Handle)
-		subsonicGroup.Any("/getStarred.view", metadataHandler.GetStarred)
-		subsonicGroup.Any("/getStarred", metadataHandler.GetStarred)
-		subsonicGroup.Any("/getStarred2.view", metadataHandler.GetStarred2)
-		subsonicGroup.Any("/getStarred2", metadataHandler.GetStarred2)
+		{"getAlbumList", searchHandler.GetAlbumList2},
+		{"getAlbumList2", searchHandler.GetAlbumList2},
+		{"getRandomSongs", metadataHandler.GetRandomSongs},
+		{"getSongsByGenre", metadataHandler.GetSongsByGenre},
+		{"getNowPlaying", proxyHandler.Handle},
+		{"getStarred", metadataHandler.GetStarred},
+		{"getStarred2", metadataHandler.GetStarred2},
 
 		// Extra Metadata (legacy compatibility)
-		subsonicGroup.Any("/getArtistInfo.view", metadataHandler.GetArtistInfo)
-		subsonicGroup.Any("/getArtistInfo", metadataHandler.GetArtistInfo)
-		subsonicGroup.Any("/getArtistInfo2.view", metadataHandler.GetArtistInfo2)
-		subsonicGroup.Any("/getArtistInfo2", metadataHandler.GetArtistInfo2)
-		subsonicGroup.Any("/getSimilarArtists.view", metadataHandler.GetSimilarArtists)
-		subsonicGroup.Any("/getSimilarArtists", metadataHandler.GetSimilarArtists)
-		subsonicGroup.Any("/getSimilarArtists2", metadataHandler.GetSimilarArtists2)
-		subsonicGroup.Any("/getSimilarSongs.view", metadataHandler.GetSimilarSongs)
-		subsonicGroup.Any("/getSimilarSongs", metadataHandler.GetSimilarSongs)
-		subsonicGroup.Any("/getSimilarSongs2.view", metadataHandler.GetSimilarSongs2)
-		subsonicGroup.Any("/getSimilarSongs2", metadataHandler.GetSimilarSongs2)
-		subsonicGroup.Any("/getTopSongs.view", searchHandler.GetTopSongs)
-		subsonicGroup.Any("/getTopSongs", searchHandler.GetTopSongs)
+		{"getArtistInfo", metadataHandler.GetArtistInfo},
+		{"getArtistInfo2", metadataHandler.GetArtistInfo2},
+		{"getSimilarArtists", metadataHandler.GetSimilarArtists},
+		{"getSimilarArtists2", metadataHandler.GetSimilarArtists2},
+		{"getSimilarSongs", metadataHandler.GetSimilarSongs},
+		{"getSimilarSongs2", metadataHandler.GetSimilarSongs2},
+		{"getTopSongs", searchHandler.GetTopSongs},
 
 		// User Interaction
-		subsonicGroup.Any("/scrobble.view", metadataHandler.Scrobble)
-		subsonicGroup.Any("/scrobble", metadataHandler.Scrobble)
-		subsonicGroup.Any("/star.view", metadataHandler.Star)
-		subsonicGroup.Any("/star", metadataHandler.Star)
-		subsonicGroup.Any("/unstar.view", metadataHandler.Unstar)
-		subsonicGroup.Any("/unstar", metadataHandler.Unstar)
-		subsonicGroup.Any("/getUser.view", proxyHandler.Handle)
-		subsonicGroup.Any("/getUser", proxyHandler.Handle)
+		{"scrobble", metadataHandler.Scrobble},
+		{"star", metadataHandler.Star},
+		{"unstar", metadataHandler.Unstar},
+		{"getUser", proxyHandler.Handle},
 
 		// Search
-		subsonicGroup.Any("/search.view", searchHandler.Search)
-		subsonicGroup.Any("/search", searchHandler.Search)
-		subsonicGroup.Any("/search2.view", searchHandler.Search2)
-		subsonicGroup.Any("/search2", searchHandler.Search2)
-		subsonicGroup.Any("/search3.view", searchHandler.Search3)
-		subsonicGroup.Any("/search3", searchHandler.Search3)
+		{"search", searchHandler.Search},
+		{"search2", searchHandler.Search2},
+		{"search3", searchHandler.Search3},
 
 		// OpenSubsonic Extensions (Lyrics, etc)
-		subsonicGroup.Any("/getLyrics.view", metadataHandler.GetLyrics)
-		subsonicGroup.Any("/getLyrics", metadataHandler.GetLyrics)
-		subsonicGroup.Any("/getLyricsBySongId.view", metadataHandler.GetLyricsBySongId)
-		subsonicGroup.Any("/getLyricsBySongId", metadataHandler.GetLyricsBySongId)
-		subsonicGroup.Any("/getOpenSubsonicExtensions.view", metadataHandler.GetOpenSubsonicExtensions)
-		subsonicGroup.Any("/getOpenSubsonicExtensions", metadataHandler.GetOpenSubsonicExtensions)
+		{"getLyrics", metadataHandler.GetLyrics},
+		{"getLyricsBySongId", metadataHandler.GetLyricsBySongId},
+		{"getOpenSubsonicExtensions", metadataHandler.GetOpenSubsonicExtensions},
 
 		// Playlists
-		subsonicGroup.Any("/getPlaylists.view", metadataHandler.GetPlaylists)
-		subsonicGroup.Any("/getPlaylists", metadataHandler.GetPlaylists)
-		subsonicGroup.Any("/getPlaylist.view", metadataHandler.GetPlaylist)
-		subsonicGroup.Any("/getPlaylist", metadataHandler.GetPlaylist)
-		subsonicGroup.Any("/createPlaylist.view", proxyHandler.Handle)
-		subsonicGroup.Any("/createPlaylist", proxyHandler.Handle)
-		subsonicGroup.Any("/deletePlaylist.view", proxyHandler.Handle)
-		subsonicGroup.Any("/deletePlaylist", proxyHandler.Handle)
-		subsonicGroup.Any("/updatePlaylist.view", proxyHandler.Handle)
-		subsonicGroup.Any("/updatePlaylist", proxyHandler.Handle)
+		{"getPlaylists", metadataHandler.GetPlaylists},
+		{"getPlaylist", metadataHandler.GetPlaylist},
+		{"createPlaylist", metadataHandler.CreatePlaylist},
+		{"deletePlaylist", proxyHandler.Handle},
+		{"updatePlaylist", metadataHandler.UpdatePlaylist},
 
 		// Media Retrieval
-		subsonicGroup.Any("/stream.view", handler.Stream)
-		subsonicGroup.Any("/stream", handler.Stream)
-		subsonicGroup.Any("/download.view", handler.Stream)
-		subsonicGroup.Any("/download", handler.Stream)
-		subsonicGroup.Any("/getCoverArt.view", metadataHandler.GetCoverArt)
-		subsonicGroup.Any("/getCoverArt", metadataHandler.GetCoverArt)
-	}
+		{"stream", handler.Stream},
+		{"download", handler.Stream},
+		{"getCoverArt", metadataHandler.GetCoverArt},
+
+		// Internet Radio
+		{"getInternetRadioStations", radioHandler.GetInternetRadioStations},
+		{"createInternetRadioStation", proxyHandler.Handle},
+		{"updateInternetRadioStation", radioHandler.UpdateInternetRadioStation},
+		{"deleteInternetRadioStation", radioHandler.DeleteInternetRadioStation},
+	})
+
+	// Stream URLs of RADIO_STATIONS. Radio players can't log in, so the URLs carry the
+	// credentials of the client that listed them.
+	r.GET("/radio/:station", handlers.RadioAuthMiddleware(cfg, squidService.GetCache(), proxyHandler.Navidrome()), radioHandler.Stream)
 
 	r.NoRoute(proxyHandler.Handle)
 
 	// Health & Maintenance
-	r.GET("/health", func(c *gin.Context) { c.JSON(200, gin.H{"status": "ok"}) })
-	r.GET("/maintenance/scan", maintenanceHandler.Scan)
-	r.GET("/sync", func(c *gin.Context) {
-		id := c.Query("id")
-		if id == "" {
-			c.JSON(400, gin.H{"error": "id is required"})
+	r.GET("/health", func(c *gin.Context) {
+		used, quota := syncService.LibraryUsage()
+		c.JSON(200, gin.H{
+			"status":    "ok",
+			"navidrome": proxyHandler.Navidrome().State(),
+			"panics":    safego.Panics(),
+			"library":   gin.H{"used_bytes": used, "max_bytes": quota},
+		})
+	})
+	r.GET("/health/squid", func(c *gin.Context) {
+		states, currentIndex := squidService.EndpointStates()
+		available := 0
+		for _, st := range states {
+			if st.Available {
+				available++
+			}
+		}
+		c.JSON(200, gin.H{
+			"current_url_index": currentIndex,
+			"available":         available,
+			"total":             len(states),
+			"endpoints":         states,
+		})
+	})
+	// Routes that delete or download library files need ADMIN_TOKEN like /admin
+	maintenanceGroup := r.Group("/maintenance", handlers.AdminAuthMiddleware(live))
+	maintenanceGroup.GET("/scan", maintenanceHandler.Scan)
+	maintenanceGroup.POST("/hydrate", maintenanceHandler.Hydrate)
+	adminGroup := r.Group("/admin", handlers.AdminAuthMiddleware(live))
+	adminGroup.GET("/library", maintenanceHandler.Library)
+	adminGroup.POST("/library/delete", maintenanceHandler.DeleteLibraryAlbum)
+	purgeCache := func(c *gin.Context) {
+		pattern := c.Query("pattern")
+		if pattern == "" {
+			c.JSON(400, gin.H{"error": "pattern is required (use * to purge everything)"})
 			return
 		}
-		album, songs, err := squidService.GetAlbum(context.Background(), id)
+		removed, err := squidService.PurgeCache(c.Request.Context(), pattern)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to fetch album info: " + err.Error()})
+			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		if err := syncService.SyncAlbum(context.Background(), album, songs); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
+		c.JSON(200, gin.H{"status": "purged", "pattern": pattern, "removed": removed})
+	}
+	adminGroup.POST("/cache/purge", purgeCache)
+	adminGroup.DELETE("/cache/purge", purgeCache)
+	adminGroup.POST("/prefetch", func(c *gin.Context) {
+		// IDs come as a JSON body {"ids": [...]} or repeated ?id= parameters
+		var body struct {
+			IDs []string `json:"ids"`
+		}
+		if c.ContentType() == "application/json" {
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(400, gin.H{"error": "invalid JSON body: " + err.Error()})
+				return
+			}
+		}
+		ids := append(body.IDs, c.QueryArray("id")...)
+		if len(ids) == 0 {
+			c.JSON(400, gin.H{"error": "ids are required"})
 			return
 		}
-
-		c.JSON(200, gin.H{"status": "synced", "id": id})
+		if len(ids) > service.MaxPrefetchIDs {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("at most %d ids per job", service.MaxPrefetchIDs)})
+			return
+		}
+		// Runs until done or the caller disconnects
+		c.JSON(200, squidService.Prefetch(c.Request.Context(), ids))
 	})
+	syncGroup := r.Group("/sync", handlers.AdminAuthMiddleware(live))
+	syncGroup.GET("", syncHandler.Album)
+	syncGroup.GET("/stream", syncHandler.AlbumStream)
+	syncGroup.GET("/artist", syncHandler.Artist)
 
 	srv := &http.Server{
-		Addr:    ":" + cfg.Port,
-		Handler: r,
+		Addr:           ":" + cfg.Port,
+		Handler:        r,
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
 	}
 
 	// 5. Graceful Shutdown
@@ -194,16 +250,39 @@ func main() {
 		}
 	}()
 
+	// SIGHUP reloads the environment and .env without dropping in-flight streams
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if _, err := live.Reload(); err != nil {
+				slog.Error("Config reload failed, keeping current config", "error", err)
+				continue
+			}
+			squidService.ReloadURLs()
+			slog.Info("Config reloaded")
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	log.Println("Shutting down JetStream...")
+	slog.Info("Shutting down JetStream...")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown:", err)
+		slog.Error("Server forced to shutdown", "error", err)
+	}
+
+	// Background syncs outlive their requests; let running transcodes finish so they don't
+	// leave temp files and half-written metadata behind
+	syncCtx, syncCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer syncCancel()
+	if err := syncService.Shutdown(syncCtx); err != nil {
+		slog.Error("In-flight syncs were cancelled", "error", err)
+		os.Exit(1)
 	}
 
-	log.Println("JetStream exited")
+	slog.Info("JetStream exited")
 }
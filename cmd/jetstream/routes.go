@@ -0,0 +1,54 @@
+package main
+
+import (
+	"jetstream/internal/config"
+	"jetstream/internal/handlers"
+	"log/slog"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// subsonicRoute maps a Subsonic endpoint name (without "/rest/" or ".view") to its handler
+type subsonicRoute struct {
+	endpoint string
+	handler  gin.HandlerFunc
+}
+
+// binaryEndpoints answer with audio or image bytes, which GzipMiddleware must not touch
+var binaryEndpoints = map[string]bool{
+	"stream":      true,
+	"download":    true,
+	"getcoverart": true,
+}
+
+// registerSubsonicRoutes registers each route under both /name and /name.view, swapping in
+// proxy for endpoints the operator listed in PROXY_ENDPOINTS. Routes that answer with XML or
+// JSON are gzipped for clients that accept it.
+func registerSubsonicRoutes(group *gin.RouterGroup, cfg *config.Config, proxy gin.HandlerFunc, routes []subsonicRoute) {
+	forceProxy := make(map[string]bool)
+	for _, endpoint := range cfg.ProxyEndpoints {
+		forceProxy[strings.ToLower(strings.TrimSuffix(endpoint, ".view"))] = true
+	}
+
+	for _, route := range routes {
+		handler := route.handler
+		name := strings.ToLower(route.endpoint)
+		if forceProxy[name] {
+			slog.Info("Endpoint interception disabled, proxying to Navidrome", "endpoint", route.endpoint)
+			handler = proxy
+			delete(forceProxy, name)
+		}
+		chain := []gin.HandlerFunc{handler}
+		if !binaryEndpoints[name] {
+			chain = []gin.HandlerFunc{handlers.GzipMiddleware(), handler}
+		}
+		group.Any("/"+route.endpoint, chain...)
+		group.Any("/"+route.endpoint+".view", chain...)
+	}
+
+	// Unregistered endpoints are proxied already through NoRoute
+	for name := range forceProxy {
+		slog.Warn("PROXY_ENDPOINTS entry is not an intercepted endpoint", "endpoint", name)
+	}
+}
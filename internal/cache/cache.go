@@ -0,0 +1,29 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrMiss is returned by Get when the key is absent or expired
+var ErrMiss = errors.New("cache miss")
+
+// Cache is a string key/value store with per-entry TTLs
+type Cache interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	// Purge removes every key matching a Redis-style glob (* and ?) and returns how many were removed
+	Purge(ctx context.Context, pattern string) (int, error)
+}
+
+// globRegexp converts a Redis-style glob into an anchored regular expression
+func globRegexp(pattern string) *regexp.Regexp {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	return regexp.MustCompile("^" + expr + "$")
+}
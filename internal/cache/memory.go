@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryCache is an in-process LRU used when Redis is disabled or unreachable.
+// Expired entries are dropped lazily on access or when evicted.
+type MemoryCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // Front is most recently used
+	items    map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	value   string
+	expires time.Time // Zero means no expiry
+}
+
+func NewMemoryCache(capacity int) *MemoryCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &MemoryCache{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (m *MemoryCache) Get(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.items[key]
+	if !ok {
+		return "", ErrMiss
+	}
+	entry := el.Value.(*memoryEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		m.order.Remove(el)
+		delete(m.items, key)
+		return "", ErrMiss
+	}
+
+	m.order.MoveToFront(el)
+	return entry.value, nil
+}
+
+func (m *MemoryCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	if el, ok := m.items[key]; ok {
+		entry := el.Value.(*memoryEntry)
+		entry.value = value
+		entry.expires = expires
+		m.order.MoveToFront(el)
+		return nil
+	}
+
+	m.items[key] = m.order.PushFront(&memoryEntry{key: key, value: value, expires: expires})
+	for m.order.Len() > m.capacity {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.items, oldest.Value.(*memoryEntry).key)
+	}
+	return nil
+}
+
+func (m *MemoryCache) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.items[key]; ok {
+		m.order.Remove(el)
+		delete(m.items, key)
+	}
+	return nil
+}
+
+func (m *MemoryCache) Purge(ctx context.Context, pattern string) (int, error) {
+	re := globRegexp(pattern)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	for key, el := range m.items {
+		if re.MatchString(key) {
+			m.order.Remove(el)
+			delete(m.items, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
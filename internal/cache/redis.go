@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisCache stores entries in Redis
+type RedisCache struct {
+	rdb *redis.Client
+}
+
+func NewRedisCache(rdb *redis.Client) *RedisCache {
+	return &RedisCache{rdb: rdb}
+}
+
+func (r *RedisCache) Get(ctx context.Context, key string) (string, error) {
+	val, err := r.rdb.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrMiss
+	}
+	return val, err
+}
+
+func (r *RedisCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return r.rdb.Set(ctx, key, value, ttl).Err()
+}
+
+func (r *RedisCache) Delete(ctx context.Context, key string) error {
+	return r.rdb.Del(ctx, key).Err()
+}
+
+func (r *RedisCache) Purge(ctx context.Context, pattern string) (int, error) {
+	removed := 0
+	iter := r.rdb.Scan(ctx, 0, pattern, 500).Iterator()
+
+	batch := make([]string, 0, 500)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := r.rdb.Del(ctx, batch...).Result()
+		removed += int(n)
+		batch = batch[:0]
+		return err
+	}
+
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return removed, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return removed, err
+	}
+	return removed, flush()
+}
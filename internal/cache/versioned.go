@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Versioned stamps every entry with a schema version. Entries written by a build with a
+// different version (or before versioning existed) are treated as misses and dropped, so
+// a struct change never deserializes old JSON into half-empty values. Keys under one of the
+// unversioned prefixes hold plain strings that no struct change affects; they are stored as
+// is and survive a version bump.
+type Versioned struct {
+	inner       Cache
+	version     int
+	unversioned []string
+}
+
+type versionedEntry struct {
+	Version int    `json:"v"`
+	Data    string `json:"d"`
+}
+
+func NewVersioned(inner Cache, version int, unversioned ...string) *Versioned {
+	return &Versioned{inner: inner, version: version, unversioned: unversioned}
+}
+
+// isUnversioned reports whether key is stored without a version stamp
+func (v *Versioned) isUnversioned(key string) bool {
+	for _, prefix := range v.unversioned {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Versioned) Get(ctx context.Context, key string) (string, error) {
+	raw, err := v.inner.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	var entry versionedEntry
+	if v.isUnversioned(key) {
+		// Entries written before the prefix was exempt still carry a stamp of any version
+		if strings.HasPrefix(raw, `{"v":`) && json.Unmarshal([]byte(raw), &entry) == nil {
+			return entry.Data, nil
+		}
+		return raw, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &entry); err != nil || entry.Version != v.version {
+		v.inner.Delete(ctx, key)
+		return "", ErrMiss
+	}
+	return entry.Data, nil
+}
+
+func (v *Versioned) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if v.isUnversioned(key) {
+		return v.inner.Set(ctx, key, value, ttl)
+	}
+	data, err := json.Marshal(versionedEntry{Version: v.version, Data: value})
+	if err != nil {
+		return err
+	}
+	return v.inner.Set(ctx, key, string(data), ttl)
+}
+
+func (v *Versioned) Delete(ctx context.Context, key string) error {
+	return v.inner.Delete(ctx, key)
+}
+
+func (v *Versioned) Purge(ctx context.Context, pattern string) (int, error) {
+	return v.inner.Purge(ctx, pattern)
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVersionedRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	v := NewVersioned(NewMemoryCache(10), 2)
+
+	if err := v.Set(ctx, "song:1", `{"id":"1"}`, time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	got, err := v.Get(ctx, "song:1")
+	if err != nil || got != `{"id":"1"}` {
+		t.Fatalf("Get = %q, %v; want the stored value", got, err)
+	}
+}
+
+func TestVersionedStaleEntryIsRefreshed(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryCache(10)
+	NewVersioned(inner, 1).Set(ctx, "song:1", `{"id":"1"}`, time.Minute)
+	inner.Set(ctx, "song:2", `{"id":"2"}`, time.Minute) // Written before versioning
+
+	v := NewVersioned(inner, 2)
+	for _, key := range []string{"song:1", "song:2"} {
+		if _, err := v.Get(ctx, key); !errors.Is(err, ErrMiss) {
+			t.Fatalf("Get(%s) err = %v, want ErrMiss", key, err)
+		}
+		if _, err := inner.Get(ctx, key); !errors.Is(err, ErrMiss) {
+			t.Fatalf("stale %s was not dropped", key)
+		}
+	}
+
+	// The caller re-fetches and stores the fresh value, which is then served
+	v.Set(ctx, "song:1", `{"id":"1","genre":"Jazz"}`, time.Minute)
+	got, err := v.Get(ctx, "song:1")
+	if err != nil || got != `{"id":"1","genre":"Jazz"}` {
+		t.Fatalf("Get after refresh = %q, %v", got, err)
+	}
+}
+
+func TestVersionedUnversionedKeys(t *testing.T) {
+	ctx := context.Background()
+	inner := NewMemoryCache(10)
+	v := NewVersioned(inner, 1, "path:", "verified:")
+
+	v.Set(ctx, "path:ext-squidwtf-song-1", "/music/a.opus", time.Minute)
+	if raw, _ := inner.Get(ctx, "path:ext-squidwtf-song-1"); raw != "/music/a.opus" {
+		t.Fatalf("unversioned key stored as %q, want the plain value", raw)
+	}
+
+	// A version bump keeps them
+	bumped := NewVersioned(inner, 2, "path:", "verified:")
+	if got, err := bumped.Get(ctx, "path:ext-squidwtf-song-1"); err != nil || got != "/music/a.opus" {
+		t.Fatalf("Get after bump = %q, %v; want the path", got, err)
+	}
+
+	// Stamped entries from before the prefix was exempt are unwrapped, whatever their version
+	NewVersioned(inner, 1).Set(ctx, "verified:/music/b.opus", "1:2|opus", time.Minute)
+	if got, err := bumped.Get(ctx, "verified:/music/b.opus"); err != nil || got != "1:2|opus" {
+		t.Fatalf("Get of legacy entry = %q, %v; want the unwrapped value", got, err)
+	}
+}
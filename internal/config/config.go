@@ -2,11 +2,15 @@ package config
 
 import (
 	"encoding/base64"
-	"log"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"slices"
 	"strconv"
-
-	"github.com/joho/godotenv"
+	"strings"
+	"text/template"
+	"time"
 )
 
 type Config struct {
@@ -16,12 +20,84 @@ type Config struct {
 	SquidURLs      []string // All URLs including fallbacks
 	MusicFolder    string
 	DownloadFormat string
+	OpusBitrate    string // ffmpeg -b:a for opus, e.g. "128k"
+	MP3Quality     int    // LAME VBR quality for -q:a (0 best, 9 worst)
+	AACBitrate     string // ffmpeg -b:a for aac, e.g. "192k"
+	StreamQuality  string // Squid quality tier: LOW, HIGH, LOSSLESS, HI_RES
+	SearchLocal    bool   // SEARCH_SOURCES includes "local": query Navidrome
+	SearchExternal bool   // SEARCH_SOURCES includes "external": query the enabled providers
 	SearchLimit    int
+	MatchThreshold int // Minimum 0-100 similarity for SearchOne lookups to accept a match
 	RedisAddr      string
+	CacheBackend   string // "redis" or "memory"
+	CacheEntries   int    // Max entries kept by the in-memory cache
+
+	SearchMergeLimit int           // Max items per category after local and external results are merged
+	SearchTimeout    time.Duration // How long a search waits for external results before answering without them
+	SearchCategories []string      // Squid search categories fetched: "songs", "albums", "artists", "playlists"
+	SearchDelay      time.Duration // Pause between search categories, fetched one at a time (0 = all in parallel)
+	BatchMaxIDs      int           // Max IDs per getSongs/getAlbums request
+
+	SyncConcurrency      int                // Max concurrent ffmpeg sync jobs
+	ScanConcurrency      int                // Max concurrent integrity checks during a maintenance scan
+	VerifyOnSync         bool               // Check synced files with ffprobe/ffmpeg before trusting them
+	CoverConcurrency     int                // Max concurrent upstream cover image fetches
+	FallbackCoverPath    string             // Image served by getCoverArt when an external cover can't be found ("" = send an error)
+	JetStreamLibraryPath string             // Root directory synced songs are written to
+	TempFileMaxAge       time.Duration      // Leftover .tmp/.part files older than this are removed at startup
+	TempDir              string             // Scratch space for cover downloads and in-progress transcodes ("" = defaults)
+	LibraryMaxBytes      int64              // Quota for synced files; least recently played ones are evicted (0 = unlimited)
+	GhostSizeThreshold   int64              // Files at least this large (bytes) are always real audio
+	GhostDetection       string             // How smaller files are judged: "size" (all ghosts) or "duration"
+	GhostMinBitRate      int                // kbps a small file's audio must average to count as real under "duration"
+	SyncPathTemplate     *template.Template // Optional layout for synced files below the library path
+	AuthEnforce          bool               // Validate Subsonic credentials against Navidrome before serving
+	OverrideLicense      bool               // Answer getLicense with a valid license instead of Navidrome's
+	AdminToken           string             // Bearer token required by the /admin routes ("" leaves them open)
+
+	SquidCooldownBase time.Duration // First cooldown applied to a failing Squid URL
+	SquidCooldownMax  time.Duration // Upper bound for the exponential cooldown
+	SquidMaxPasses    int           // Full passes over the URL list before a request gives up
+	SquidUserAgents   []string      // User-Agent pool rotated per Squid/CDN request
+	SquidMirrors      []SquidMirror // Extra mirrors (e.g. a private, authenticated one) tried before the public ones
+	SquidLatencyProbe time.Duration // Interval between mirror latency probes (0 disables)
+	NegativeCacheTTL  time.Duration // How long failed lookups are remembered (0 disables)
+	ResolveCacheTTL   time.Duration // How long a Navidrome ID's resolved external ID is remembered (0 disables)
+	ServeStaleOnError bool          // Serve expired search/album/artist results when Squid fails
+	StaleCacheTTL     time.Duration // How long the copies used by ServeStaleOnError are kept
+
+	NavidromeTimeout          time.Duration // Timeout for JetStream's own Navidrome requests (not proxied ones)
+	NavidromeBreakerThreshold int           // Consecutive Navidrome failures before lookups fail fast (0 disables)
+	NavidromeBreakerCooldown  time.Duration // How long the breaker stays open before probing again
+
+	StreamDialTimeout   time.Duration // Connect/TLS timeout for upstream CDN streams
+	StreamHeaderTimeout time.Duration // Max wait for the CDN's response headers (the body itself is unbounded)
+	StreamTimeOffset    bool          // Honor stream's timeOffset for external songs by seeking with ffmpeg
+	StreamExactLength   bool          // Download a stream fully before serving when its length can't be learned
+	StreamMode          string        // "proxy" copies CDN bytes through JetStream; "redirect" sends clients to the CDN
+	StreamMaxBitRate    int           // Ceiling in kbps for on-the-fly maxBitRate transcodes (0 disables them)
+	StreamTranscodes    int           // Max concurrent on-the-fly transcodes
+
+	ListenBrainzToken string // User token for scrobbling external plays (empty disables)
+	EnrichMusicBrainz bool   // Look up MusicBrainz IDs for synced files
+	EnrichSongs       bool   // Fill getSong responses for virtual songs from the synced file and its sidecar
+	EnableReplayGain  bool   // Measure loudness before transcoding and write ReplayGain tags
+	KeepOriginal      bool   // Keep the untouched CDN source under .originals next to the transcode
+
+	FeaturedPlaylists []string // Tidal playlist UUIDs appended to getPlaylists
+
+	RadioStations []RadioStation // External stations appended to getInternetRadioStations
+
+	Providers []string // External catalogs searched, in result order ("squidwtf", "deezer")
+
+	ProxyEndpoints []string // Subsonic endpoints (e.g. "getLyricsBySongId") forwarded to Navidrome untouched
+
+	MaxRequestBody int64 // Largest accepted request body in bytes (0 disables the limit)
+	MaxHeaderBytes int   // Largest accepted request line plus headers in bytes
 }
 
 func Load() (*Config, error) {
-	_ = godotenv.Load() // Ignore error if .env doesn't exist
+	loadDotEnv()
 
 	musicFolder := getEnv("MUSIC_FOLDER", "/music")
 	primarySquidURL := getEnv("SQUID_URL", "https://triton.squid.wtf")
@@ -54,6 +130,43 @@ func Load() (*Config, error) {
 		squidURLs = append([]string{primarySquidURL}, squidURLs...)
 	}
 
+	// SQUID_MIRRORS go first, each listed once
+	squidMirrors := parseSquidMirrors(getEnv("SQUID_MIRRORS", ""))
+	if len(squidMirrors) > 0 {
+		seen := make(map[string]bool)
+		urls := make([]string, 0, len(squidMirrors)+len(squidURLs))
+		for _, m := range squidMirrors {
+			if !seen[m.URL] {
+				seen[m.URL] = true
+				urls = append(urls, m.URL)
+			}
+		}
+		for _, u := range squidURLs {
+			if !seen[u] {
+				seen[u] = true
+				urls = append(urls, u)
+			}
+		}
+		squidURLs = urls
+	}
+
+	syncPathTemplate, err := ParseSyncPathTemplate(getEnv("SYNC_PATH_TEMPLATE", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	searchLocal, searchExternal := parseSearchSources(getEnv("SEARCH_SOURCES", "local,external"))
+
+	// SEARCH_LIMIT bounds each source; merged results default to room for all of them
+	searchLimit := getEnvInt("SEARCH_LIMIT", 50)
+	sources := 0
+	if searchLocal {
+		sources++
+	}
+	if searchExternal {
+		sources++
+	}
+
 	cfg := &Config{
 		Port:           getEnv("PORT", "8080"),
 		NavidromeURL:   getEnv("NAVIDROME_URL", getEnv("UPSTREAM_URL", getEnv("SUBSONIC_URL", "http://navidrome:4533"))),
@@ -61,12 +174,83 @@ func Load() (*Config, error) {
 		SquidURLs:      squidURLs,
 		MusicFolder:    musicFolder,
 		DownloadFormat: getEnv("DOWNLOAD_FORMAT", "opus"),
-		SearchLimit:    getEnvInt("SEARCH_LIMIT", 50),
+		OpusBitrate:    getEnvBitrate("OPUS_BITRATE", 128, 6, 510),
+		MP3Quality:     getEnvIntRange("MP3_QUALITY", 0, 0, 9),
+		AACBitrate:     getEnvBitrate("AAC_BITRATE", 192, 32, 512),
+		StreamQuality:  getEnv("STREAM_QUALITY", "LOSSLESS"),
+		SearchLocal:    searchLocal,
+		SearchExternal: searchExternal,
+		SearchLimit:    searchLimit,
+		MatchThreshold: getEnvIntRange("MATCH_THRESHOLD", 70, 0, 100),
 		RedisAddr:      getEnv("REDIS_ADDR", "localhost:6379"),
+		CacheBackend:   strings.ToLower(getEnv("CACHE_BACKEND", "redis")),
+		CacheEntries:   getEnvInt("CACHE_MEMORY_ENTRIES", 10000),
+
+		SearchMergeLimit: getEnvInt("SEARCH_MERGE_LIMIT", searchLimit*sources),
+		SearchTimeout:    getEnvDuration("SEARCH_TIMEOUT", 5*time.Second),
+		SearchCategories: parseSearchCategories(getEnv("SEARCH_CATEGORIES", "")),
+		SearchDelay:      getEnvDuration("SEARCH_CATEGORY_DELAY", 0),
+		BatchMaxIDs:      getEnvInt("BATCH_MAX_IDS", 100),
+
+		SyncConcurrency:      getEnvInt("SYNC_CONCURRENCY", 2),
+		ScanConcurrency:      getEnvInt("SCAN_CONCURRENCY", 4),
+		VerifyOnSync:         getEnvBool("VERIFY_ON_SYNC", true),
+		CoverConcurrency:     getEnvInt("COVER_CONCURRENCY", 8),
+		FallbackCoverPath:    getEnv("FALLBACK_COVER_PATH", ""),
+		JetStreamLibraryPath: getEnv("JETSTREAM_LIBRARY_PATH", "/music/jetstream"),
+		TempFileMaxAge:       getEnvDuration("TEMP_FILE_MAX_AGE", time.Hour),
+		TempDir:              getEnv("TEMP_DIR", ""),
+		LibraryMaxBytes:      int64(getEnvInt("LIBRARY_MAX_BYTES", 0)),
+		GhostSizeThreshold:   int64(getEnvInt("GHOST_SIZE_THRESHOLD", 256*1024)),
+		GhostDetection:       strings.ToLower(getEnv("GHOST_DETECTION", "duration")),
+		GhostMinBitRate:      getEnvInt("GHOST_MIN_BITRATE", 16),
+		SyncPathTemplate:     syncPathTemplate,
+		AuthEnforce:          getEnvBool("AUTH_ENFORCE", false),
+		OverrideLicense:      getEnvBool("OVERRIDE_LICENSE", false),
+		AdminToken:           getEnv("ADMIN_TOKEN", ""),
+
+		SquidCooldownBase: getEnvDuration("SQUID_COOLDOWN_BASE", time.Minute),
+		SquidCooldownMax:  getEnvDuration("SQUID_COOLDOWN_MAX", 30*time.Minute),
+		SquidMaxPasses:    getEnvInt("SQUID_MAX_PASSES", 1),
+		SquidUserAgents:   parseUserAgents(getEnv("SQUID_USER_AGENT", "")),
+		SquidMirrors:      squidMirrors,
+		SquidLatencyProbe: getEnvDuration("SQUID_LATENCY_PROBE_INTERVAL", 5*time.Minute),
+		NegativeCacheTTL:  getEnvDuration("NEGATIVE_CACHE_TTL", 10*time.Minute),
+		ResolveCacheTTL:   getEnvDuration("RESOLVE_CACHE_TTL", 24*time.Hour),
+		ServeStaleOnError: getEnvBool("SERVE_STALE_ON_ERROR", false),
+		StaleCacheTTL:     getEnvDuration("STALE_CACHE_TTL", 30*24*time.Hour),
+
+		NavidromeTimeout:          getEnvDuration("NAVIDROME_TIMEOUT", 10*time.Second),
+		NavidromeBreakerThreshold: getEnvInt("NAVIDROME_BREAKER_THRESHOLD", 5),
+		NavidromeBreakerCooldown:  getEnvDuration("NAVIDROME_BREAKER_COOLDOWN", 30*time.Second),
+
+		StreamDialTimeout:   getEnvDuration("STREAM_DIAL_TIMEOUT", 10*time.Second),
+		StreamHeaderTimeout: getEnvDuration("STREAM_HEADER_TIMEOUT", 30*time.Second),
+		StreamTimeOffset:    getEnvBool("STREAM_TIME_OFFSET", true),
+		StreamExactLength:   getEnvBool("STREAM_EXACT_LENGTH", false),
+		StreamMode:          parseStreamMode(getEnv("STREAM_MODE", "proxy")),
+		StreamMaxBitRate:    getEnvInt("STREAM_MAX_BITRATE", 320),
+		StreamTranscodes:    getEnvInt("STREAM_TRANSCODE_CONCURRENCY", 4),
+
+		ListenBrainzToken: getEnv("LISTENBRAINZ_TOKEN", ""),
+		EnrichMusicBrainz: getEnvBool("ENRICH_MUSICBRAINZ", false),
+		EnrichSongs:       getEnvBool("ENRICH_SONGS", false),
+		EnableReplayGain:  getEnvBool("ENABLE_REPLAYGAIN", false),
+		KeepOriginal:      getEnvBool("KEEP_ORIGINAL", false),
+
+		FeaturedPlaylists: parseList(getEnv("FEATURED_PLAYLISTS", "")),
+
+		RadioStations: parseRadioStations(getEnv("RADIO_STATIONS", "")),
+
+		Providers: parseList(getEnv("PROVIDERS", "squidwtf")),
+
+		ProxyEndpoints: parseList(getEnv("PROXY_ENDPOINTS", "")),
+
+		MaxRequestBody: int64(getEnvInt("MAX_REQUEST_BODY", 4<<20)),
+		MaxHeaderBytes: getEnvInt("MAX_HEADER_BYTES", http.DefaultMaxHeaderBytes),
 	}
 
-	log.Printf("[Config] Loaded RedisAddr: %s", cfg.RedisAddr)
-	log.Printf("[Config] Loaded %d Squid fallback URLs", len(cfg.SquidURLs))
+	slog.Info("Config loaded", "redisAddr", cfg.RedisAddr, "squidURLs", len(cfg.SquidURLs))
 	return cfg, nil
 }
 
@@ -85,3 +269,238 @@ func getEnvInt(key string, fallback int) int {
 	}
 	return fallback
 }
+
+func getEnvBool(key string, fallback bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+// getEnvIntRange reads an int, falling back (with a warning) when it is invalid or outside [min, max]
+func getEnvIntRange(key string, fallback, min, max int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists || value == "" {
+		return fallback
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || i < min || i > max {
+		slog.Warn("Invalid config value, using default", "key", key, "value", value, "min", min, "max", max, "default", fallback)
+		return fallback
+	}
+	return i
+}
+
+// getEnvBitrate reads a bitrate in kbps ("256" or "256k") and returns it in ffmpeg form ("256k").
+// Values outside [min, max] kbps fall back to the default with a warning.
+func getEnvBitrate(key string, fallbackKbps, min, max int) string {
+	fallback := strconv.Itoa(fallbackKbps) + "k"
+	value, exists := os.LookupEnv(key)
+	if !exists || value == "" {
+		return fallback
+	}
+	kbps, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(value)), "k"))
+	if err != nil || kbps < min || kbps > max {
+		slog.Warn("Invalid config bitrate, using default", "key", key, "value", value, "minKbps", min, "maxKbps", max, "default", fallback)
+		return fallback
+	}
+	return strconv.Itoa(kbps) + "k"
+}
+
+// SquidMirror is a Squid base URL with headers (typically credentials) sent on every request to it
+type SquidMirror struct {
+	URL     string
+	Headers map[string]string
+}
+
+// parseSquidMirrors reads SQUID_MIRRORS: entries separated by ";" or newlines, each
+// "url[,Header=Value...]", e.g. "https://squid.example.com,Authorization=Bearer abc".
+// Header values can't contain commas.
+func parseSquidMirrors(value string) []SquidMirror {
+	var mirrors []SquidMirror
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '\n' }) {
+		parts := strings.Split(entry, ",")
+		mirror := SquidMirror{URL: strings.TrimRight(strings.TrimSpace(parts[0]), "/")}
+		if mirror.URL == "" {
+			continue
+		}
+		for _, part := range parts[1:] {
+			name, val, ok := strings.Cut(part, "=")
+			name = strings.TrimSpace(name)
+			if !ok || name == "" {
+				slog.Warn("Ignoring malformed SQUID_MIRRORS header, expected Header=Value", "url", mirror.URL)
+				continue
+			}
+			if mirror.Headers == nil {
+				mirror.Headers = make(map[string]string)
+			}
+			mirror.Headers[name] = strings.TrimSpace(val)
+		}
+		mirrors = append(mirrors, mirror)
+	}
+	return mirrors
+}
+
+// RadioStation is an internet radio station that plays songs similar to an external seed
+type RadioStation struct {
+	Name string
+	Seed string // External song or artist ID fed to GetSimilarSongs
+}
+
+// parseRadioStations reads RADIO_STATIONS: entries separated by ";" or newlines, each
+// "Name=seedID", e.g. "Daft Punk Radio=ext-squidwtf-artist-8847"
+func parseRadioStations(value string) []RadioStation {
+	var stations []RadioStation
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '\n' }) {
+		name, seed, ok := strings.Cut(entry, "=")
+		name, seed = strings.TrimSpace(name), strings.TrimSpace(seed)
+		if !ok || name == "" || seed == "" {
+			slog.Warn("Ignoring malformed RADIO_STATIONS entry, expected Name=seedID", "entry", entry)
+			continue
+		}
+		stations = append(stations, RadioStation{Name: name, Seed: seed})
+	}
+	return stations
+}
+
+// parseUserAgents splits a newline- or comma-separated User-Agent pool. Since UAs often contain
+// commas themselves ("KHTML, like Gecko"), a comma only starts a new entry when the text after
+// it begins with a product/version token such as "Mozilla/5.0".
+func parseUserAgents(value string) []string {
+	var agents []string
+	for _, line := range strings.Split(value, "\n") {
+		var current string
+		for _, part := range strings.Split(line, ",") {
+			fields := strings.Fields(part)
+			if current != "" && (len(fields) == 0 || !strings.Contains(fields[0], "/")) {
+				current += "," + part
+				continue
+			}
+			if ua := strings.TrimSpace(current); ua != "" {
+				agents = append(agents, ua)
+			}
+			current = part
+		}
+		if ua := strings.TrimSpace(current); ua != "" {
+			agents = append(agents, ua)
+		}
+	}
+	return agents
+}
+
+// parseSearchSources reads SEARCH_SOURCES ("local", "external" or both). Unknown or empty
+// values fall back to both so search never silently returns nothing.
+func parseSearchSources(value string) (local, external bool) {
+	for _, source := range parseList(strings.ToLower(value)) {
+		switch source {
+		case "local":
+			local = true
+		case "external":
+			external = true
+		default:
+			slog.Warn("Unknown SEARCH_SOURCES entry ignored", "source", source)
+		}
+	}
+	if !local && !external {
+		slog.Warn("SEARCH_SOURCES enables nothing, searching both sources", "value", value)
+		return true, true
+	}
+	return local, external
+}
+
+// searchCategories are the Squid search categories in the order they are fetched
+var searchCategories = []string{"songs", "albums", "artists", "playlists"}
+
+// parseSearchCategories reads SEARCH_CATEGORIES into searchCategories order. Empty means all
+// of them, as does a value naming none, so search never silently returns nothing.
+func parseSearchCategories(value string) []string {
+	wanted := make(map[string]bool)
+	for _, category := range parseList(strings.ToLower(value)) {
+		if !slices.Contains(searchCategories, category) {
+			slog.Warn("Unknown SEARCH_CATEGORIES entry ignored", "category", category)
+			continue
+		}
+		wanted[category] = true
+	}
+	if len(wanted) == 0 {
+		if value != "" {
+			slog.Warn("SEARCH_CATEGORIES enables nothing, searching every category", "value", value)
+		}
+		return searchCategories
+	}
+
+	var categories []string
+	for _, category := range searchCategories {
+		if wanted[category] {
+			categories = append(categories, category)
+		}
+	}
+	return categories
+}
+
+// parseStreamMode reads STREAM_MODE, falling back to "proxy" for anything it doesn't know
+func parseStreamMode(value string) string {
+	switch mode := strings.ToLower(strings.TrimSpace(value)); mode {
+	case "proxy", "redirect":
+		return mode
+	default:
+		slog.Warn("Unknown STREAM_MODE, proxying streams", "value", value)
+		return "proxy"
+	}
+}
+
+// parseList splits a comma-separated value, dropping empty entries
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// SyncPathFields are the values available to SYNC_PATH_TEMPLATE
+type SyncPathFields struct {
+	Artist string
+	Album  string
+	Title  string
+	ID     string
+	Track  int
+	Disc   int
+	Year   int
+}
+
+// ParseSyncPathTemplate parses SYNC_PATH_TEMPLATE and executes it once against sample
+// values so unknown fields or bad syntax fail at startup. An empty template returns nil.
+func ParseSyncPathTemplate(text string) (*template.Template, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	tmpl, err := template.New("syncPath").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SYNC_PATH_TEMPLATE: %w", err)
+	}
+
+	var sb strings.Builder
+	sample := SyncPathFields{Artist: "Artist", Album: "Album", Title: "Title", ID: "ext-squidwtf-song-1", Track: 1, Disc: 1, Year: 2000}
+	if err := tmpl.Execute(&sb, sample); err != nil {
+		return nil, fmt.Errorf("invalid SYNC_PATH_TEMPLATE: %w", err)
+	}
+	if strings.TrimSpace(sb.String()) == "" {
+		return nil, fmt.Errorf("invalid SYNC_PATH_TEMPLATE: renders an empty path")
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+
+	"github.com/joho/godotenv"
+)
+
+// restartOnly lists the fields a reload can't apply because they are baked into listeners,
+// clients or pools built at startup, or because they lay out the library, which would leave
+// everything already synced at paths JetStream no longer looks at. A reload keeps their old
+// values and logs a warning. Every other field is hot-reloadable; the encoder settings apply
+// from the next sync, which reads them once so a reload can't change them halfway.
+var restartOnly = []string{
+	"Port",
+	"NavidromeURL",
+	"NavidromeTimeout",
+	"NavidromeBreakerThreshold",
+	"NavidromeBreakerCooldown",
+	"RedisAddr",
+	"CacheBackend",
+	"CacheEntries",
+	"SyncConcurrency",
+	"CoverConcurrency",
+	"StreamTranscodes",
+	"JetStreamLibraryPath",
+	"SyncPathTemplate",
+	"TempFileMaxAge",
+	"AuthEnforce",
+	"StreamDialTimeout",
+	"StreamHeaderTimeout",
+	"SquidUserAgents",
+	"ListenBrainzToken",
+	"EnrichMusicBrainz",
+	"Providers",
+	"ProxyEndpoints",
+	"MaxRequestBody",
+	"MaxHeaderBytes",
+}
+
+// Live holds the Config services read at request time. Reload swaps it atomically, so each
+// Get sees either the old or the new settings, never a mix.
+type Live struct {
+	current atomic.Pointer[Config]
+	mu      sync.Mutex // Serializes reloads
+}
+
+func NewLive(cfg *Config) *Live {
+	l := &Live{}
+	l.current.Store(cfg)
+	return l
+}
+
+// Get returns the current Config. Callers should not hold on to it across requests.
+func (l *Live) Get() *Config {
+	return l.current.Load()
+}
+
+// Reload re-reads the environment and .env and swaps in the result. Restart-only fields keep
+// their current values. On error the current Config stays in place.
+func (l *Live) Reload() (*Config, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	next, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	old := l.current.Load()
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem()
+	for _, name := range restartOnly {
+		of, nf := ov.FieldByName(name), nv.FieldByName(name)
+		if !sameSetting(of.Interface(), nf.Interface()) {
+			slog.Warn("Config field can't change at runtime, restart to apply", "field", name)
+			nf.Set(of)
+		}
+	}
+
+	l.current.Store(next)
+	return next, nil
+}
+
+// sameSetting reports whether a reload left a field unchanged. Templates are compared by their
+// source, since every reload parses a fresh one.
+func sameSetting(old, next any) bool {
+	if ot, ok := old.(*template.Template); ok {
+		nt, _ := next.(*template.Template)
+		if ot == nil || nt == nil {
+			return ot == nt
+		}
+		return ot.Root.String() == nt.Root.String()
+	}
+	return reflect.DeepEqual(old, next)
+}
+
+var (
+	dotEnvMu   sync.Mutex
+	processEnv map[string]bool // Variables set before .env was first read; they always win
+	dotEnvKeys map[string]bool // Variables the last .env read set
+)
+
+// loadDotEnv applies .env on top of the process environment. Unlike godotenv.Load it can be
+// called again: values changed in .env replace the ones it set earlier and removed ones are
+// unset, while variables from the real environment are never touched.
+func loadDotEnv() {
+	dotEnvMu.Lock()
+	defer dotEnvMu.Unlock()
+
+	if processEnv == nil {
+		processEnv = make(map[string]bool)
+		for _, kv := range os.Environ() {
+			key, _, _ := strings.Cut(kv, "=")
+			processEnv[key] = true
+		}
+	}
+
+	values, err := godotenv.Read()
+	if err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to read .env", "error", err)
+		return
+	}
+
+	for key := range dotEnvKeys {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+		}
+	}
+	dotEnvKeys = make(map[string]bool)
+	for key, value := range values {
+		if processEnv[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		dotEnvKeys[key] = true
+	}
+}
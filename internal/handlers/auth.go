@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"jetstream/internal/cache"
+	"jetstream/internal/config"
+	"jetstream/pkg/subsonic"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	authCachePrefix = "jetstream:auth:"
+	authCacheTTL    = 5 * time.Minute
+)
+
+// AuthMiddleware validates Subsonic credentials (u + t/s or p) against Navidrome's
+// ping endpoint when AUTH_ENFORCE is enabled. Successful logins are cached
+// for a short TTL so we don't ping upstream on every request.
+func AuthMiddleware(cfg *config.Config, authCache cache.Cache, client *NavidromeClient) gin.HandlerFunc {
+	return subsonicAuth(cfg, authCache, client, cfg.AuthEnforce)
+}
+
+// RadioAuthMiddleware validates Subsonic credentials like AuthMiddleware, but regardless of
+// AUTH_ENFORCE: /radio URLs are handed to players outside the Subsonic API, and nothing
+// upstream would check them otherwise.
+func RadioAuthMiddleware(cfg *config.Config, authCache cache.Cache, client *NavidromeClient) gin.HandlerFunc {
+	return subsonicAuth(cfg, authCache, client, true)
+}
+
+func subsonicAuth(cfg *config.Config, authCache cache.Cache, client *NavidromeClient, enforce bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !enforce {
+			c.Next()
+			return
+		}
+
+		user := c.Request.FormValue("u")
+		token := c.Request.FormValue("t")
+		salt := c.Request.FormValue("s")
+		password := c.Request.FormValue("p")
+
+		if user == "" || (token == "" && password == "") {
+			SendSubsonicError(c, subsonic.ErrRequiredParameter, "Required parameter is missing: credentials")
+			c.Abort()
+			return
+		}
+
+		// Never store raw credentials, only a digest of them
+		sum := sha256.Sum256([]byte(user + "\x00" + token + "\x00" + salt + "\x00" + password))
+		cacheKey := authCachePrefix + hex.EncodeToString(sum[:])
+
+		ctx := c.Request.Context()
+		if _, err := authCache.Get(ctx, cacheKey); err == nil {
+			c.Next()
+			return
+		}
+
+		if err := pingNavidrome(ctx, client, cfg.NavidromeURL, c.Request); err != nil {
+			// Only Navidrome's own answer says the credentials are wrong; anything else is an
+			// outage, and clients drop saved credentials on a wrong-password error
+			var refusal *pingRefusal
+			if errors.As(err, &refusal) {
+				requestLogger(c).Warn("Rejected Subsonic credentials", "user", user, "path", c.Request.URL.Path, "error", err)
+				SendSubsonicError(c, refusal.Code, refusal.Message)
+			} else if errors.Is(err, ErrNavidromeUnavailable) {
+				requestLogger(c).Warn("Can't verify Subsonic credentials while Navidrome is down", "user", user, "path", c.Request.URL.Path)
+				SendSubsonicError(c, subsonic.ErrGeneric, "Navidrome is unavailable, try again later")
+			} else {
+				requestLogger(c).Error("Failed to verify Subsonic credentials", "user", user, "path", c.Request.URL.Path, "error", err)
+				SendSubsonicError(c, subsonic.ErrGeneric, "Failed to verify credentials with Navidrome")
+			}
+			c.Abort()
+			return
+		}
+
+		authCache.Set(ctx, cacheKey, "1", authCacheTTL)
+		c.Next()
+	}
+}
+
+// AdminAuthMiddleware guards the /admin, /maintenance and /sync routes with ADMIN_TOKEN, sent
+// as "Authorization: Bearer <token>" or a token query parameter. Without ADMIN_TOKEN they stay
+// open.
+func AdminAuthMiddleware(cfg *config.Live) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		want := cfg.Get().AdminToken
+		if want == "" {
+			c.Next()
+			return
+		}
+
+		got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok {
+			got = c.Query("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+			requestLogger(c).Warn("Rejected admin request", "path", c.Request.URL.Path)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or missing admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// pingRefusal is the error Navidrome answered a ping with
+type pingRefusal struct {
+	Code    int
+	Message string
+}
+
+func (e *pingRefusal) Error() string {
+	return fmt.Sprintf("navidrome: %s (code %d)", e.Message, e.Code)
+}
+
+// pingNavidrome replays the request's credentials against /rest/ping.view. A *pingRefusal
+// means Navidrome answered and refused them; other errors mean it couldn't be asked.
+func pingNavidrome(ctx context.Context, client *NavidromeClient, navidromeURL string, r *http.Request) error {
+	u, err := url.Parse(navidromeURL + "/rest/ping.view")
+	if err != nil {
+		return err
+	}
+
+	q := url.Values{}
+	for _, key := range []string{"u", "t", "s", "p", "v", "c"} {
+		if v := r.FormValue(key); v != "" {
+			q.Set(key, v)
+		}
+	}
+	if q.Get("v") == "" {
+		q.Set("v", subsonic.Version)
+	}
+	if q.Get("c") == "" {
+		q.Set("c", "jetstream")
+	}
+	q.Set("f", "xml")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status string          `xml:"status,attr"`
+		Error  *subsonic.Error `xml:"error"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode ping response: %v", err)
+	}
+
+	if result.Status != subsonic.StatusOk {
+		if result.Error != nil {
+			return &pingRefusal{Code: result.Error.Code, Message: result.Error.Message}
+		}
+		return fmt.Errorf("navidrome returned status %q", result.Status)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"jetstream/internal/logging"
+	"jetstream/internal/safego"
+	"jetstream/pkg/subsonic"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// batchWorkers bounds how many lookups one request runs at once
+const batchWorkers = 8
+
+// batchIDs reads the repeated id parameter, sending an error when there is none or more than
+// BATCH_MAX_IDS. Only external IDs can be resolved, so others are dropped.
+func (h *MetadataHandler) batchIDs(c *gin.Context) ([]string, bool) {
+	c.Request.ParseForm()
+	ids := c.Request.Form["id"]
+	if len(ids) == 0 {
+		SendSubsonicError(c, subsonic.ErrRequiredParameter, "Missing id parameter")
+		return nil, false
+	}
+	if max := h.squidService.GetConfig().BatchMaxIDs; max > 0 && len(ids) > max {
+		SendSubsonicError(c, subsonic.ErrGeneric, fmt.Sprintf("At most %d ids per request", max))
+		return nil, false
+	}
+
+	external := ids[:0:0]
+	for _, id := range ids {
+		if strings.HasPrefix(id, "ext-") {
+			external = append(external, id)
+		}
+	}
+	return external, true
+}
+
+// resolveBatch runs lookup for every id on batchWorkers workers and returns the found items in
+// request order. Failed lookups are logged and left out.
+func resolveBatch[T any](ctx context.Context, what string, ids []string, lookup func(ctx context.Context, id string) (T, error)) []T {
+	found := make([]*T, len(ids))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < batchWorkers && w < len(ids); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer safego.Recover()
+			for i := range jobs {
+				item, err := lookup(ctx, ids[i])
+				if err != nil {
+					logging.FromContext(ctx).Warn("Batch lookup failed, leaving it out", "type", what, "id", ids[i], "error", err)
+					continue
+				}
+				found[i] = &item
+			}
+		}()
+	}
+	for i := range ids {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	items := make([]T, 0, len(ids))
+	for _, item := range found {
+		if item != nil {
+			items = append(items, *item)
+		}
+	}
+	return items
+}
+
+// GetSongs is a JetStream extension that returns several external songs in one response,
+// sparing list views a getSong round-trip per row
+func (h *MetadataHandler) GetSongs(c *gin.Context) {
+	ids, ok := h.batchIDs(c)
+	if !ok {
+		return
+	}
+	songs := resolveBatch(c.Request.Context(), "song", ids, func(ctx context.Context, id string) (subsonic.Song, error) {
+		song, err := h.providers.GetSong(ctx, id)
+		if err != nil {
+			return subsonic.Song{}, err
+		}
+		h.syncService.EnrichSong(ctx, song)
+		return *song, nil
+	})
+
+	SendSubsonicResponse(c, subsonic.Response{
+		Status:  "ok",
+		Version: "1.16.1",
+		Songs:   &subsonic.Songs{Song: songs},
+	})
+}
+
+// GetAlbums is the album counterpart of GetSongs. Albums come without their track lists.
+func (h *MetadataHandler) GetAlbums(c *gin.Context) {
+	ids, ok := h.batchIDs(c)
+	if !ok {
+		return
+	}
+	albums := resolveBatch(c.Request.Context(), "album", ids, func(ctx context.Context, id string) (subsonic.Album, error) {
+		album, _, err := h.providers.GetAlbum(ctx, id)
+		if err != nil {
+			return subsonic.Album{}, err
+		}
+		return *album, nil
+	})
+
+	SendSubsonicResponse(c, subsonic.Response{
+		Status:  "ok",
+		Version: "1.16.1",
+		Albums:  &subsonic.Albums{Album: albums},
+	})
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"jetstream/pkg/subsonic"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// normalizeKey builds a comparison key from the given fields: lowercased,
+// with punctuation and whitespace stripped so "AC/DC" and "ac dc" collide.
+func normalizeKey(fields ...string) string {
+	var b strings.Builder
+	for i, field := range fields {
+		if i > 0 {
+			b.WriteByte('|')
+		}
+		for _, r := range strings.ToLower(field) {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
+
+func songKey(s subsonic.Song) string {
+	return normalizeKey(s.Artist, s.Title, s.Album)
+}
+
+func albumKey(a subsonic.Album) string {
+	title := a.Title
+	if title == "" {
+		title = a.Name
+	}
+	return normalizeKey(a.Artist, title, strconv.Itoa(a.Year))
+}
+
+func artistKey(a subsonic.Artist) string {
+	return normalizeKey(a.Name)
+}
+
+// mergeSongs appends external songs to local ones, dropping any external entry
+// that duplicates a song already present. Local entries always win.
+func mergeSongs(local, external []subsonic.Song) []subsonic.Song {
+	seen := make(map[string]bool, len(local)+len(external))
+	for _, s := range local {
+		seen[songKey(s)] = true
+	}
+	for _, s := range external {
+		key := songKey(s)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		local = append(local, s)
+	}
+	return local
+}
+
+// mergeAlbums appends external albums to local ones, keyed on artist+title+year.
+func mergeAlbums(local, external []subsonic.Album) []subsonic.Album {
+	seen := make(map[string]bool, len(local)+len(external))
+	for _, a := range local {
+		seen[albumKey(a)] = true
+	}
+	for _, a := range external {
+		key := albumKey(a)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		local = append(local, a)
+	}
+	return local
+}
+
+// mergeArtists appends external artists to local ones, keyed on name.
+func mergeArtists(local, external []subsonic.Artist) []subsonic.Artist {
+	seen := make(map[string]bool, len(local)+len(external))
+	for _, a := range local {
+		seen[artistKey(a)] = true
+	}
+	for _, a := range external {
+		key := artistKey(a)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		local = append(local, a)
+	}
+	return local
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipTypes are the response types GzipMiddleware compresses: Subsonic XML, JSON and JSONP
+var gzipTypes = []string{"application/json", "application/xml", "text/xml", "application/javascript"}
+
+// GzipMiddleware compresses Subsonic XML/JSON responses for clients that accept gzip. The
+// decision is made on the first write, once the handler has set its headers, so binary bodies
+// and responses that are already encoded (e.g. proxied from Navidrome) pass through untouched.
+// Register it only on routes that answer with documents; stream and cover routes stay raw.
+func GzipMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.Contains(strings.ToLower(c.GetHeader("Accept-Encoding")), "gzip") {
+			c.Next()
+			return
+		}
+
+		w := &gzipWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		defer func() {
+			if w.gz != nil {
+				w.gz.Close()
+			}
+			c.Writer = w.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+// gzipWriter compresses the body when, at the first write, the response turns out to be one
+// of gzipTypes without a Content-Encoding of its own
+type gzipWriter struct {
+	gin.ResponseWriter
+	decided bool
+	gz      *gzip.Writer // nil when passing through
+}
+
+func (w *gzipWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return
+	}
+	contentType := strings.ToLower(h.Get("Content-Type"))
+	for _, t := range gzipTypes {
+		if strings.HasPrefix(contentType, t) {
+			h.Set("Content-Encoding", "gzip")
+			h.Add("Vary", "Accept-Encoding")
+			h.Del("Content-Length")
+			w.gz = gzip.NewWriter(w.ResponseWriter)
+			return
+		}
+	}
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	w.decide()
+	if w.gz == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.gz.Write(data)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipWriter) Flush() {
+	w.decide()
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
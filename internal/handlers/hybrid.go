@@ -1,14 +1,19 @@
 package handlers
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
+	"jetstream/internal/logging"
+	"jetstream/internal/safego"
+	"jetstream/internal/scrobbler"
 	"jetstream/internal/service"
 	"jetstream/pkg/subsonic"
-	"log"
-	"log/slog"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,29 +23,33 @@ import (
 
 type MetadataHandler struct {
 	squidService *service.SquidService
+	providers    *service.Providers
 	syncService  *service.SyncService
 	proxyHandler *ProxyHandler // Fallback
+	listenBrainz *scrobbler.ListenBrainz
+	scans        scanBatcher // Shares Navidrome rescans between playlist edits
 }
 
-func NewMetadataHandler(squidService *service.SquidService, syncService *service.SyncService, proxyHandler *ProxyHandler) *MetadataHandler {
+func NewMetadataHandler(squidService *service.SquidService, providers *service.Providers, syncService *service.SyncService, proxyHandler *ProxyHandler, listenBrainz *scrobbler.ListenBrainz) *MetadataHandler {
 	return &MetadataHandler{
 		squidService: squidService,
+		providers:    providers,
 		syncService:  syncService,
 		proxyHandler: proxyHandler,
+		listenBrainz: listenBrainz,
 	}
 }
 
 func (h *MetadataHandler) GetAlbum(c *gin.Context) {
 	id := c.Request.FormValue("id")
-	log.Printf("[Metadata] GetAlbum request for ID: %s", id)
+	requestLogger(c).Debug("GetAlbum request", "id", id)
 
 	// 1. Check if it's already an external ID (from search results)
 	if strings.HasPrefix(id, "ext-") {
-		log.Printf("[Metadata] Fetching external album info from Squid: %s", id)
-		album, songs, err := h.squidService.GetAlbum(c.Request.Context(), id)
+		requestLogger(c).Info("Fetching external album", "id", id)
+		album, songs, err := h.providers.GetAlbum(c.Request.Context(), id)
 		if err != nil {
-			log.Printf("[Metadata] GetAlbum error for %s: %v", id, err)
-			SendSubsonicError(c, subsonic.ErrGeneric, err.Error())
+			sendLookupError(c, "Album", id, err)
 			return
 		}
 		resp := subsonic.Response{
@@ -58,8 +67,8 @@ func (h *MetadataHandler) GetAlbum(c *gin.Context) {
 	// 2. Try to resolve local ID to external ID (enrichment)
 	resolvedID, _, err := ResolveVirtualAlbumID(c, h.proxyHandler, h.squidService, id)
 	if err == nil && resolvedID != id {
-		log.Printf("[Metadata] Resolved local Album ID %s to external ID: %s", id, resolvedID)
-		album, songs, err := h.squidService.GetAlbum(c.Request.Context(), resolvedID)
+		requestLogger(c).Info("Resolved local album to external ID", "id", id, "resolved", resolvedID)
+		album, songs, err := h.providers.GetAlbum(c.Request.Context(), resolvedID)
 		if err == nil {
 			resp := subsonic.Response{
 				Status:  "ok",
@@ -83,11 +92,10 @@ func (h *MetadataHandler) GetArtist(c *gin.Context) {
 
 	// 1. Check if it's already an external ID (from search results)
 	if strings.HasPrefix(id, "ext-") {
-		log.Printf("[Metadata] Fetching external artist info from Squid: %s", id)
+		requestLogger(c).Info("Fetching external artist from Squid", "id", id)
 		artist, albums, err := h.squidService.GetArtist(c.Request.Context(), id)
 		if err != nil {
-			log.Printf("[Metadata] GetArtist error for %s: %v", id, err)
-			SendSubsonicError(c, subsonic.ErrArtistNotFound, err.Error())
+			sendLookupError(c, "Artist", id, err)
 			return
 		}
 		resp := subsonic.Response{
@@ -105,7 +113,7 @@ func (h *MetadataHandler) GetArtist(c *gin.Context) {
 	// 2. Try to resolve local ID to external ID (enrichment)
 	resolvedID, _, err := ResolveVirtualArtistID(c, h.proxyHandler, h.squidService, id)
 	if err == nil && resolvedID != id {
-		log.Printf("[Metadata] Resolved local Artist ID %s to external ID: %s", id, resolvedID)
+		requestLogger(c).Info("Resolved local artist to external ID", "id", id, "resolved", resolvedID)
 		artist, albums, err := h.squidService.GetArtist(c.Request.Context(), resolvedID)
 		if err == nil {
 			resp := subsonic.Response{
@@ -127,16 +135,20 @@ func (h *MetadataHandler) GetArtist(c *gin.Context) {
 
 func (h *MetadataHandler) GetSong(c *gin.Context) {
 	id := c.Request.FormValue("id")
-	resolvedID, isVirtual, err := ResolveVirtualID(c, h.proxyHandler, h.squidService, id)
+	resolvedID, isVirtual, err := ResolveVirtualID(c, h.proxyHandler, h.squidService, h.syncService, id)
 	if err == nil && isVirtual {
-		log.Printf("[Metadata] Intercepted virtual song metadata request: %s (Resolved: %s)", id, resolvedID)
-		song, err := h.squidService.GetSong(c.Request.Context(), resolvedID)
+		requestLogger(c).Info("Intercepted virtual song metadata request", "id", id, "resolved", resolvedID)
+		song, err := h.providers.GetSong(c.Request.Context(), resolvedID)
 		if err != nil {
-			log.Printf("[Metadata] GetSong error for %s: %v", resolvedID, err)
+			requestLogger(c).Error("GetSong failed", "id", resolvedID, "error", err)
 			SendSubsonicError(c, subsonic.ErrDataNotFound, "Song not found")
 			return
 		}
 
+		if h.syncService.EnrichSong(c.Request.Context(), song) {
+			requestLogger(c).Debug("Enriched song from synced copy", "id", resolvedID)
+		}
+
 		resp := subsonic.Response{
 			Status:  "ok",
 			Version: "1.16.1",
@@ -155,8 +167,7 @@ func (h *MetadataHandler) GetPlaylist(c *gin.Context) {
 	if strings.HasPrefix(id, "ext-") {
 		playlist, songs, err := h.squidService.GetPlaylist(c.Request.Context(), id)
 		if err != nil {
-			log.Printf("[Metadata] GetPlaylist error for %s: %v", id, err)
-			SendSubsonicError(c, subsonic.ErrGeneric, err.Error())
+			sendLookupError(c, "Playlist", id, err)
 			return
 		}
 
@@ -186,17 +197,17 @@ func (h *MetadataHandler) GetPlaylists(c *gin.Context) {
 	// A. Navidrome (Upstream)
 	go func() {
 		defer wg.Done()
+		defer safego.Recover()
 		u, _ := url.Parse(h.proxyHandler.GetTargetURL() + "/rest/getPlaylists.view")
 		q := c.Request.URL.Query()
 		q.Set("f", "xml")
 		u.RawQuery = q.Encode()
 
-		req, _ := http.NewRequest("GET", u.String(), nil)
+		req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", u.String(), nil)
 		req.Header = c.Request.Header.Clone()
 		req.Header.Del("Accept-Encoding")
 
-		client := &http.Client{Timeout: 10 * time.Second}
-		resp, err := client.Do(req)
+		resp, err := h.proxyHandler.Navidrome().Do(req)
 		if err != nil {
 			return
 		}
@@ -204,20 +215,16 @@ func (h *MetadataHandler) GetPlaylists(c *gin.Context) {
 
 		navidromeResult = &subsonic.Response{}
 		if err := xml.NewDecoder(resp.Body).Decode(navidromeResult); err != nil {
-			slog.Error("Decoding Upstream playlists", "error", err)
+			requestLogger(c).Error("Decoding Upstream playlists", "error", err)
 		}
 
 	}()
 
-	// B. Squid (External - Featured/Popular)
+	// B. Squid (External) - Configured featured playlists, if any
 	go func() {
 		defer wg.Done()
-		// Since there's no "list all", we show a few featured ones or just leave it
-		// For now, let's try a default search for "Featured" to populate some
-		res, err := h.squidService.Search(c.Request.Context(), "Featured")
-		if err == nil && res != nil {
-			squidPlaylists = res.Playlist
-		}
+		defer safego.Recover()
+		squidPlaylists = h.squidService.FeaturedPlaylists(c.Request.Context())
 	}()
 
 	wg.Wait()
@@ -242,66 +249,168 @@ func (h *MetadataHandler) GetPlaylists(c *gin.Context) {
 	SendSubsonicResponse(c, *navidromeResult)
 }
 
-func (h *MetadataHandler) GetCoverArt(c *gin.Context) {
-	id := c.Request.FormValue("id")
-	resolvedID, isVirtual, err := ResolveVirtualID(c, h.proxyHandler, h.squidService, id)
+func (h *MetadataHandler) GetGenres(c *gin.Context) {
+	// 1. Parallel Requests
+	var navidromeResult *subsonic.Response
+	var squidGenres []subsonic.Genre
+	var wg sync.WaitGroup
 
-	if err == nil && isVirtual {
-		log.Printf("[Metadata] Intercepted virtual cover request: %s (Resolved: %s)", id, resolvedID)
-		url, err := h.squidService.GetCoverURL(c.Request.Context(), resolvedID)
-		if err != nil {
-			log.Printf("[Metadata] Cover not found for %s: %v", resolvedID, err)
-			SendSubsonicError(c, subsonic.ErrDataNotFound, "Cover not found")
-			return
-		}
+	wg.Add(2)
 
-		// Fetch and Proxy with proper User-Agent to avoid 403
-		req, _ := http.NewRequest("GET", url, nil)
-		req.Header.Set("User-Agent", service.UserAgent)
-		req.Header.Set("Accept", "image/*,*/*")
+	// A. Navidrome (Upstream)
+	go func() {
+		defer wg.Done()
+		defer safego.Recover()
+		u, _ := url.Parse(h.proxyHandler.GetTargetURL() + "/rest/getGenres.view")
+		q := c.Request.URL.Query()
+		q.Set("f", "xml")
+		u.RawQuery = q.Encode()
+
+		req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", u.String(), nil)
+		req.Header = c.Request.Header.Clone()
+		req.Header.Del("Accept-Encoding")
 
-		client := &http.Client{Timeout: 30 * time.Second}
-		resp, err := client.Do(req)
+		resp, err := h.proxyHandler.Navidrome().Do(req)
 		if err != nil {
-			log.Printf("[Metadata] Failed to fetch cover from %s: %v", url, err)
-			SendSubsonicError(c, subsonic.ErrGeneric, "Failed to fetch cover")
 			return
 		}
 		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("[Metadata] Cover server returned %d for %s", resp.StatusCode, url)
+		navidromeResult = &subsonic.Response{}
+		if err := xml.NewDecoder(resp.Body).Decode(navidromeResult); err != nil {
+			requestLogger(c).Error("Decoding Upstream genres", "error", err)
+		}
+	}()
+
+	// B. Squid (External)
+	go func() {
+		defer wg.Done()
+		defer safego.Recover()
+		genres, err := h.squidService.GetGenres(c.Request.Context())
+		if err == nil {
+			squidGenres = genres
+		}
+	}()
+
+	wg.Wait()
+
+	// 2. Merge Results
+	if navidromeResult == nil {
+		navidromeResult = &subsonic.Response{
+			Status:  "ok",
+			Version: "1.16.1",
+			Genres:  &subsonic.Genres{},
+		}
+	}
+
+	if navidromeResult.Genres == nil {
+		navidromeResult.Genres = &subsonic.Genres{}
+	}
+
+	// Union by name, summing counts for genres present in both sources
+	index := make(map[string]int, len(navidromeResult.Genres.Genre))
+	for i, g := range navidromeResult.Genres.Genre {
+		index[normalizeKey(g.Name)] = i
+	}
+	for _, g := range squidGenres {
+		key := normalizeKey(g.Name)
+		if i, ok := index[key]; ok {
+			navidromeResult.Genres.Genre[i].SongCount += g.SongCount
+			navidromeResult.Genres.Genre[i].AlbumCount += g.AlbumCount
+			continue
+		}
+		index[key] = len(navidromeResult.Genres.Genre)
+		navidromeResult.Genres.Genre = append(navidromeResult.Genres.Genre, g)
+	}
+
+	// 3. Return Response
+	SendSubsonicResponse(c, *navidromeResult)
+}
+
+func (h *MetadataHandler) GetCoverArt(c *gin.Context) {
+	id := c.Request.FormValue("id")
+	resolvedID, isVirtual, err := ResolveVirtualID(c, h.proxyHandler, h.squidService, h.syncService, id)
+
+	if err == nil && isVirtual {
+		requestLogger(c).Info("Intercepted virtual cover request", "id", id, "resolved", resolvedID)
+		size, _ := strconv.Atoi(c.Request.FormValue("size"))
+		cover, err := h.syncService.Cover(c.Request.Context(), resolvedID, size)
+		if err != nil {
+			requestLogger(c).Warn("Cover not found", "id", resolvedID, "error", err)
+			if h.sendFallbackCover(c) {
+				return
+			}
 			SendSubsonicError(c, subsonic.ErrDataNotFound, "Cover not found")
 			return
 		}
 
-		log.Printf("[Metadata] Proxying cover from %s (Size: %d, Type: %s)", url, resp.ContentLength, resp.Header.Get("Content-Type"))
-		c.DataFromReader(http.StatusOK, resp.ContentLength, resp.Header.Get("Content-Type"), resp.Body, nil)
+		c.Header("Content-Type", cover.ContentType)
+		c.Header("Cache-Control", "public, max-age=2592000")
+		// c.File answers If-None-Match and If-Modified-Since with 304 on its own
+		c.Header("ETag", cover.ETag())
+		c.File(cover.Path)
 		return
 	}
 	h.proxyHandler.Handle(c)
 }
 
+// sendFallbackCover serves FALLBACK_COVER_PATH in place of a missing cover. It reports false
+// when none is configured or the file can't be read, leaving the caller to send its error.
+// The placeholder is only cached for NEGATIVE_CACHE_TTL so the real cover shows up once it exists.
+func (h *MetadataHandler) sendFallbackCover(c *gin.Context) bool {
+	cfg := h.squidService.GetConfig()
+	if cfg.FallbackCoverPath == "" {
+		return false
+	}
+	if _, err := os.Stat(cfg.FallbackCoverPath); err != nil {
+		requestLogger(c).Error("FALLBACK_COVER_PATH is unreadable", "path", cfg.FallbackCoverPath, "error", err)
+		return false
+	}
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(cfg.NegativeCacheTTL.Seconds())))
+	c.File(cfg.FallbackCoverPath)
+	return true
+}
+
+// GetOpenSubsonicExtensions advertises only the extensions JetStream implements for external
+// content too; anything else would promise clients behaviour that only works for local songs
 func (h *MetadataHandler) GetOpenSubsonicExtensions(c *gin.Context) {
+	extensions := []subsonic.OpenSubsonicExtension{
+		{Name: "songLyrics", Versions: []string{"1"}}, // Structured lyrics via getLyricsBySongId; "1" is the only published version
+		{Name: "formPost", Versions: []string{"1"}},
+	}
+	if h.squidService.GetConfig().StreamTimeOffset {
+		extensions = append(extensions, subsonic.OpenSubsonicExtension{Name: "transcodeOffset", Versions: []string{"1"}})
+	}
+
 	resp := subsonic.Response{
 		Status:  "ok",
 		Version: "1.16.1",
 		OpenSubsonicExtensions: &subsonic.OpenSubsonicExtensions{
-			Extension: []subsonic.OpenSubsonicExtension{
-				{Name: "songLyrics", Versions: []string{"1"}},
-				{Name: "formPost", Versions: []string{"1"}},
-				{Name: "transcoding", Versions: []string{"1"}},
-				{Name: "scrobble", Versions: []string{"1"}},
-				{Name: "bookmarks", Versions: []string{"1"}},
-				{Name: "getPlaylist", Versions: []string{"1"}},
-				{Name: "search3", Versions: []string{"1"}},
-			},
+			Extension: extensions,
 		},
 	}
 
 	SendSubsonicResponse(c, resp)
 }
 
+// GetLicense reports a valid license when OVERRIDE_LICENSE is set, for clients that stop
+// working when Navidrome's own answer says the license has expired
+func (h *MetadataHandler) GetLicense(c *gin.Context) {
+	if !h.squidService.GetConfig().OverrideLicense {
+		h.proxyHandler.Handle(c)
+		return
+	}
+
+	SendSubsonicResponse(c, subsonic.Response{
+		Status:  "ok",
+		Version: "1.16.1",
+		License: &subsonic.License{
+			Valid:          true,
+			LicenseExpires: "2099-12-31T23:59:59Z",
+		},
+	})
+}
+
 func (h *MetadataHandler) GetLyrics(c *gin.Context) {
 	// Legacy Subsonic getLyrics.view
 	h.proxyHandler.Handle(c)
@@ -309,24 +418,30 @@ func (h *MetadataHandler) GetLyrics(c *gin.Context) {
 
 func (h *MetadataHandler) GetLyricsBySongId(c *gin.Context) {
 	id := c.Request.FormValue("id")
-	resolvedID, isVirtual, _ := ResolveVirtualID(c, h.proxyHandler, h.squidService, id)
+	resolvedID, isVirtual, _ := ResolveVirtualID(c, h.proxyHandler, h.squidService, h.syncService, id)
 
 	if isVirtual {
 		lyrics, err := h.squidService.GetLyrics(c.Request.Context(), resolvedID)
-		if err != nil {
-			log.Printf("[Metadata] Lyrics not found for %s: %v", resolvedID, err)
+		if err != nil || strings.TrimSpace(lyrics) == "" {
+			requestLogger(c).Warn("Lyrics not found", "id", resolvedID, "error", err)
 			SendSubsonicResponse(c, subsonic.Response{
-				Status:  "ok",
-				Version: "1.16.1",
+				Status:     "ok",
+				Version:    "1.16.1",
+				LyricsList: &subsonic.LyricsList{},
 			})
 			return
 		}
 
+		structured := service.ParseLyrics(lyrics)
+		if song, err := h.providers.GetSong(c.Request.Context(), resolvedID); err == nil {
+			structured.DisplayArtist = song.Artist
+			structured.DisplayTitle = song.Title
+		}
 		SendSubsonicResponse(c, subsonic.Response{
 			Status:  "ok",
 			Version: "1.16.1",
-			Lyrics: &subsonic.Lyrics{
-				Value: lyrics,
+			LyricsList: &subsonic.LyricsList{
+				StructuredLyrics: []subsonic.StructuredLyrics{structured},
 			},
 		})
 		return
@@ -337,13 +452,10 @@ func (h *MetadataHandler) GetLyricsBySongId(c *gin.Context) {
 func (h *MetadataHandler) GetArtistInfo(c *gin.Context) {
 	id := c.Request.FormValue("id")
 	if strings.HasPrefix(id, "ext-") {
-		// Minimum biographical info to satisfy legacy clients
 		resp := subsonic.Response{
-			Status:  "ok",
-			Version: "1.16.1",
-			ArtistInfo: &subsonic.ArtistInfo{
-				Biography: "Information provided by Tidal.",
-			},
+			Status:     "ok",
+			Version:    "1.16.1",
+			ArtistInfo: h.externalArtistInfo(c, id),
 		}
 		SendSubsonicResponse(c, resp)
 		return
@@ -355,11 +467,9 @@ func (h *MetadataHandler) GetArtistInfo2(c *gin.Context) {
 	id := c.Request.FormValue("id")
 	if strings.HasPrefix(id, "ext-") {
 		resp := subsonic.Response{
-			Status:  "ok",
-			Version: "1.16.1",
-			ArtistInfo2: &subsonic.ArtistInfo{
-				Biography: "Information provided by Tidal.",
-			},
+			Status:      "ok",
+			Version:     "1.16.1",
+			ArtistInfo2: h.externalArtistInfo(c, id),
 		}
 		SendSubsonicResponse(c, resp)
 		return
@@ -367,12 +477,23 @@ func (h *MetadataHandler) GetArtistInfo2(c *gin.Context) {
 	h.proxyHandler.Handle(c)
 }
 
+// externalArtistInfo fetches artist info from Squid, returning an empty info on failure
+// so clients still get an "ok" response.
+func (h *MetadataHandler) externalArtistInfo(c *gin.Context, id string) *subsonic.ArtistInfo {
+	info, err := h.squidService.GetArtistInfo(c.Request.Context(), id)
+	if err != nil {
+		requestLogger(c).Error("GetArtistInfo failed", "id", id, "error", err)
+		return &subsonic.ArtistInfo{}
+	}
+	return info
+}
+
 func (h *MetadataHandler) GetSimilarArtists(c *gin.Context) {
 	id := c.Request.FormValue("id")
 	if strings.HasPrefix(id, "ext-") {
 		artists, err := h.squidService.GetSimilarArtists(c.Request.Context(), id)
 		if err != nil {
-			log.Printf("[Metadata] GetSimilarArtists error for %s: %v", id, err)
+			requestLogger(c).Error("GetSimilarArtists failed", "id", id, "error", err)
 			SendSubsonicError(c, subsonic.ErrGeneric, err.Error())
 			return
 		}
@@ -397,12 +518,12 @@ func (h *MetadataHandler) GetSimilarArtists2(c *gin.Context) {
 func (h *MetadataHandler) GetMusicDirectory(c *gin.Context) {
 	id := c.Request.FormValue("id")
 	if strings.HasPrefix(id, "ext-") {
-		log.Printf("[Metadata] GetMusicDirectory for external ID: %s", id)
+		requestLogger(c).Info("GetMusicDirectory for external ID", "id", id)
 
 		if strings.Contains(id, "-artist-") {
 			artist, albums, err := h.squidService.GetArtist(c.Request.Context(), id)
 			if err != nil {
-				SendSubsonicError(c, subsonic.ErrGeneric, err.Error())
+				sendLookupError(c, "Artist", id, err)
 				return
 			}
 			var children []subsonic.Song
@@ -429,9 +550,9 @@ func (h *MetadataHandler) GetMusicDirectory(c *gin.Context) {
 			SendSubsonicResponse(c, resp)
 			return
 		} else if strings.Contains(id, "-album-") {
-			album, songs, err := h.squidService.GetAlbum(c.Request.Context(), id)
+			album, songs, err := h.providers.GetAlbum(c.Request.Context(), id)
 			if err != nil {
-				SendSubsonicError(c, subsonic.ErrGeneric, err.Error())
+				sendLookupError(c, "Album", id, err)
 				return
 			}
 			resp := subsonic.Response{
@@ -455,11 +576,9 @@ func (h *MetadataHandler) GetAlbumInfo(c *gin.Context) {
 	id := c.Request.FormValue("id")
 	if strings.HasPrefix(id, "ext-") {
 		resp := subsonic.Response{
-			Status:  "ok",
-			Version: "1.16.1",
-			AlbumInfo: &subsonic.AlbumInfo{
-				Notes: "Information provided by Tidal.",
-			},
+			Status:    "ok",
+			Version:   "1.16.1",
+			AlbumInfo: h.externalAlbumInfo(c, id),
 		}
 		SendSubsonicResponse(c, resp)
 		return
@@ -471,11 +590,9 @@ func (h *MetadataHandler) GetAlbumInfo2(c *gin.Context) {
 	id := c.Request.FormValue("id")
 	if strings.HasPrefix(id, "ext-") {
 		resp := subsonic.Response{
-			Status:  "ok",
-			Version: "1.16.1",
-			AlbumInfo2: &subsonic.AlbumInfo{
-				Notes: "Information provided by Tidal.",
-			},
+			Status:     "ok",
+			Version:    "1.16.1",
+			AlbumInfo2: h.externalAlbumInfo(c, id),
 		}
 		SendSubsonicResponse(c, resp)
 		return
@@ -483,48 +600,288 @@ func (h *MetadataHandler) GetAlbumInfo2(c *gin.Context) {
 	h.proxyHandler.Handle(c)
 }
 
+// externalAlbumInfo fetches album info from Squid, returning an empty info on failure
+// so clients still get an "ok" response.
+func (h *MetadataHandler) externalAlbumInfo(c *gin.Context, id string) *subsonic.AlbumInfo {
+	info, err := h.squidService.GetAlbumInfo(c.Request.Context(), id)
+	if err != nil {
+		requestLogger(c).Error("GetAlbumInfo failed", "id", id, "error", err)
+		return &subsonic.AlbumInfo{}
+	}
+	return info
+}
+
 func (h *MetadataHandler) Scrobble(c *gin.Context) {
-	id := c.Request.FormValue("id")
-	if strings.HasPrefix(id, "ext-") {
-		// Just return OK for external IDs to prevent client errors
-		SendSubsonicResponse(c, subsonic.Response{Status: "ok", Version: "1.16.1"})
+	c.Request.ParseForm()
+
+	var external []string
+	for _, id := range c.Request.Form["id"] {
+		if strings.HasPrefix(id, "ext-") {
+			external = append(external, id)
+		}
+	}
+
+	if len(external) == 0 {
+		h.proxyHandler.Handle(c)
 		return
 	}
-	h.proxyHandler.Handle(c)
-}
 
-func (h *MetadataHandler) Star(c *gin.Context) {
-	id := c.Request.FormValue("id")
-	if strings.HasPrefix(id, "ext-") {
-		// Mock success for now
-		SendSubsonicResponse(c, subsonic.Response{Status: "ok", Version: "1.16.1"})
+	// "submission=false" is a now-playing notification, not a finished listen
+	if c.Request.FormValue("submission") != "false" && h.listenBrainz.Enabled() {
+		playedAt := time.Now()
+		if ms, err := strconv.ParseInt(c.Request.FormValue("time"), 10, 64); err == nil && ms > 0 {
+			playedAt = time.UnixMilli(ms)
+		}
+
+		go func(ids []string) {
+			defer safego.Recover()
+			// Detached from the request so the submission outlives the response, but keeps its logger
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), 30*time.Second)
+			defer cancel()
+
+			for _, id := range ids {
+				song, err := h.providers.GetSong(ctx, id)
+				if err != nil {
+					logging.FromContext(ctx).Warn("Scrobble: failed to resolve song", "id", id, "error", err)
+					continue
+				}
+				if err := h.listenBrainz.SubmitListen(ctx, song, playedAt); err != nil {
+					logging.FromContext(ctx).Error("Scrobble: ListenBrainz submit failed", "id", id, "error", err)
+					continue
+				}
+				logging.FromContext(ctx).Info("Scrobble: submitted to ListenBrainz", "artist", song.Artist, "title", song.Title)
+			}
+		}(external)
+	}
+
+	// Local IDs in the same request still go to Navidrome
+	q := c.Request.URL.Query()
+	var local []string
+	for _, id := range q["id"] {
+		if !strings.HasPrefix(id, "ext-") {
+			local = append(local, id)
+		}
+	}
+	if len(local) > 0 {
+		q["id"] = local
+		c.Request.URL.RawQuery = q.Encode()
+		h.proxyHandler.Handle(c)
 		return
 	}
-	h.proxyHandler.Handle(c)
+
+	SendSubsonicResponse(c, subsonic.Response{Status: "ok", Version: "1.16.1"})
+}
+
+func (h *MetadataHandler) Star(c *gin.Context) {
+	h.updateStarred(c, h.squidService.Star)
 }
 
 func (h *MetadataHandler) Unstar(c *gin.Context) {
-	id := c.Request.FormValue("id")
-	if strings.HasPrefix(id, "ext-") {
-		SendSubsonicResponse(c, subsonic.Response{Status: "ok", Version: "1.16.1"})
+	h.updateStarred(c, h.squidService.Unstar)
+}
+
+// updateStarred applies a star/unstar to external IDs. IDs may come in the query string or a
+// POST body; local IDs in the same request are forwarded to Navidrome with the external ones
+// stripped out.
+func (h *MetadataHandler) updateStarred(c *gin.Context, apply func(ctx context.Context, id string) error) {
+	c.Request.ParseForm()
+	form := c.Request.Form
+
+	var external []string
+	hasLocal := false
+	for _, key := range []string{"id", "albumId", "artistId"} {
+		var local []string
+		for _, id := range form[key] {
+			if strings.HasPrefix(id, "ext-") {
+				external = append(external, id)
+			} else if id != "" {
+				local = append(local, id)
+			}
+		}
+		if len(local) > 0 {
+			form[key] = local
+			hasLocal = true
+		} else {
+			form.Del(key)
+		}
+	}
+
+	if len(external) == 0 {
+		h.proxyHandler.HandleForm(c, form)
 		return
 	}
-	h.proxyHandler.Handle(c)
+
+	for _, id := range external {
+		if err := apply(c.Request.Context(), id); err != nil {
+			requestLogger(c).Error("Failed to update starred state", "id", id, "error", err)
+			SendSubsonicError(c, subsonic.ErrGeneric, err.Error())
+			return
+		}
+	}
+
+	if hasLocal {
+		h.proxyHandler.HandleForm(c, form)
+		return
+	}
+
+	SendSubsonicResponse(c, subsonic.Response{Status: "ok", Version: "1.16.1"})
 }
 
 func (h *MetadataHandler) GetStarred(c *gin.Context) {
-	// Proxy to Navidrome for now.
-	// Future: Merge with Tidal favorites if implemented.
-	h.proxyHandler.Handle(c)
+	h.getStarred(c, false)
 }
 
 func (h *MetadataHandler) GetStarred2(c *gin.Context) {
-	h.proxyHandler.Handle(c)
+	h.getStarred(c, true)
+}
+
+// getStarred merges externally starred songs, albums and artists into Navidrome's starred list
+func (h *MetadataHandler) getStarred(c *gin.Context, v2 bool) {
+	endpoint := "/rest/getStarred.view"
+	if v2 {
+		endpoint = "/rest/getStarred2.view"
+	}
+
+	// 1. Parallel Requests
+	var navidromeResult *subsonic.Response
+	var external subsonic.Starred
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	// A. Navidrome (Upstream)
+	go func() {
+		defer wg.Done()
+		defer safego.Recover()
+		u, _ := url.Parse(h.proxyHandler.GetTargetURL() + endpoint)
+		q := c.Request.URL.Query()
+		q.Set("f", "xml")
+		u.RawQuery = q.Encode()
+
+		req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", u.String(), nil)
+		req.Header = c.Request.Header.Clone()
+		req.Header.Del("Accept-Encoding")
+
+		resp, err := h.proxyHandler.Navidrome().Do(req)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+
+		navidromeResult = &subsonic.Response{}
+		if err := xml.NewDecoder(resp.Body).Decode(navidromeResult); err != nil {
+			requestLogger(c).Error("Decoding Upstream starred", "error", err)
+		}
+	}()
+
+	// B. External starred items
+	go func() {
+		defer wg.Done()
+		defer safego.Recover()
+		external = h.externalStarred(c.Request.Context())
+	}()
+
+	wg.Wait()
+
+	// 2. Merge Results
+	if navidromeResult == nil {
+		navidromeResult = &subsonic.Response{
+			Status:  "ok",
+			Version: "1.16.1",
+		}
+	}
+
+	starred := navidromeResult.Starred
+	if v2 {
+		starred = navidromeResult.Starred2
+	}
+	if starred == nil {
+		starred = &subsonic.Starred{}
+	}
+
+	starred.Song = append(starred.Song, external.Song...)
+	starred.Album = append(starred.Album, external.Album...)
+	starred.Artist = append(starred.Artist, external.Artist...)
+
+	if v2 {
+		navidromeResult.Starred2 = starred
+	} else {
+		navidromeResult.Starred = starred
+	}
+
+	// 3. Return Response
+	SendSubsonicResponse(c, *navidromeResult)
+}
+
+// externalStarred resolves every starred external ID into full Subsonic entities, newest
+// star first. Lookups run on resolveBatch's bounded worker pool.
+func (h *MetadataHandler) externalStarred(ctx context.Context) subsonic.Starred {
+	var result subsonic.Starred
+
+	// starred returns the IDs of a media type in StarredItems order and when each was starred
+	starred := func(mediaType string) ([]string, map[string]string) {
+		items, err := h.squidService.StarredItems(ctx, mediaType)
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to load starred items", "type", mediaType, "error", err)
+		}
+		ids := make([]string, 0, len(items))
+		starredAt := make(map[string]string, len(items))
+		for _, item := range items {
+			ids = append(ids, item.ID)
+			starredAt[item.ID] = item.StarredAt.UTC().Format(time.RFC3339)
+		}
+		return ids, starredAt
+	}
+
+	ids, starredAt := starred("song")
+	result.Song = resolveBatch(ctx, "song", ids, func(ctx context.Context, id string) (subsonic.Song, error) {
+		song, err := h.providers.GetSong(ctx, id)
+		if err != nil {
+			return subsonic.Song{}, err
+		}
+		song.Starred = starredAt[id]
+		return *song, nil
+	})
+
+	ids, starredAt = starred("album")
+	result.Album = resolveBatch(ctx, "album", ids, func(ctx context.Context, id string) (subsonic.Album, error) {
+		album, _, err := h.providers.GetAlbum(ctx, id)
+		if err != nil {
+			return subsonic.Album{}, err
+		}
+		album.Starred = starredAt[id]
+		return *album, nil
+	})
+
+	ids, starredAt = starred("artist")
+	result.Artist = resolveBatch(ctx, "artist", ids, func(ctx context.Context, id string) (subsonic.Artist, error) {
+		artist, _, err := h.squidService.GetArtist(ctx, id)
+		if err != nil {
+			return subsonic.Artist{}, err
+		}
+		artist.Starred = starredAt[id]
+		return *artist, nil
+	})
+	return result
 }
 
 func (h *MetadataHandler) GetRandomSongs(c *gin.Context) {
 	artistName := c.Request.FormValue("artist")
-	// size := c.Request.FormValue("size") // Not used for now
+	genre := c.Request.FormValue("genre")
+	size := 10
+	if v := c.Request.FormValue("size"); v != "" {
+		fmt.Sscanf(v, "%d", &size)
+	}
+	if size > 500 {
+		size = 500
+	}
+	var fromYear, toYear int
+	if v := c.Request.FormValue("fromYear"); v != "" {
+		fmt.Sscanf(v, "%d", &fromYear)
+	}
+	if v := c.Request.FormValue("toYear"); v != "" {
+		fmt.Sscanf(v, "%d", &toYear)
+	}
 
 	// 1. Parallel Requests
 	var navidromeResult *subsonic.Response
@@ -536,17 +893,17 @@ func (h *MetadataHandler) GetRandomSongs(c *gin.Context) {
 	// A. Navidrome (Upstream)
 	go func() {
 		defer wg.Done()
+		defer safego.Recover()
 		u, _ := url.Parse(h.proxyHandler.GetTargetURL() + "/rest/getRandomSongs.view")
 		q := c.Request.URL.Query()
 		q.Set("f", "xml")
 		u.RawQuery = q.Encode()
 
-		req, _ := http.NewRequest("GET", u.String(), nil)
+		req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", u.String(), nil)
 		req.Header = c.Request.Header.Clone()
 		req.Header.Del("Accept-Encoding")
 
-		client := &http.Client{Timeout: 10 * time.Second}
-		resp, err := client.Do(req)
+		resp, err := h.proxyHandler.Navidrome().Do(req)
 		if err != nil {
 			return
 		}
@@ -559,18 +916,16 @@ func (h *MetadataHandler) GetRandomSongs(c *gin.Context) {
 	// B. Squid (External)
 	go func() {
 		defer wg.Done()
-		var res *subsonic.SearchResult3
+		defer safego.Recover()
 		var err error
 		if artistName != "" {
 			// If artist is provided, get top songs for that artist
-			count := 20
-			squidSongs, err = h.squidService.GetTopSongsByArtist(c.Request.Context(), artistName, count)
+			squidSongs, err = h.squidService.GetTopSongsByArtist(c.Request.Context(), artistName, size)
 		} else {
-			// Otherwise just generic hits
-			res, err = h.squidService.Search(c.Request.Context(), "Hits")
-			if err == nil && res != nil {
-				squidSongs = res.Song
-			}
+			squidSongs, err = h.squidService.GetRandomSongs(c.Request.Context(), size, genre, fromYear, toYear)
+		}
+		if err != nil {
+			requestLogger(c).Error("Squid random songs failed", "error", err)
 		}
 	}()
 
@@ -589,12 +944,13 @@ func (h *MetadataHandler) GetRandomSongs(c *gin.Context) {
 		navidromeResult.RandomSongs = &subsonic.RandomSongs{}
 	}
 
-	// Inject some external songs if we have them
-	limit := 10
-	if len(squidSongs) < limit {
-		limit = len(squidSongs)
+	// Mix local and external songs, then trim to the requested size
+	merged := mergeSongs(navidromeResult.RandomSongs.Song, squidSongs)
+	rand.Shuffle(len(merged), func(i, j int) { merged[i], merged[j] = merged[j], merged[i] })
+	if len(merged) > size {
+		merged = merged[:size]
 	}
-	navidromeResult.RandomSongs.Song = append(navidromeResult.RandomSongs.Song, squidSongs[:limit]...)
+	navidromeResult.RandomSongs.Song = merged
 
 	// 3. Return Response
 	SendSubsonicResponse(c, *navidromeResult)
@@ -602,6 +958,24 @@ func (h *MetadataHandler) GetRandomSongs(c *gin.Context) {
 
 func (h *MetadataHandler) GetSongsByGenre(c *gin.Context) {
 	genre := c.Request.FormValue("genre")
+	count := 10
+	if v := c.Request.FormValue("count"); v != "" {
+		fmt.Sscanf(v, "%d", &count)
+	}
+	if count > 500 {
+		count = 500
+	}
+	offset := 0
+	if v := c.Request.FormValue("offset"); v != "" {
+		fmt.Sscanf(v, "%d", &offset)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	// The merged list is paged as a whole, so both sources are read from the start up to the
+	// end of the requested page
+	window := min(offset+count, mergedListWindow)
 
 	// 1. Parallel Requests
 	var navidromeResult *subsonic.Response
@@ -613,17 +987,19 @@ func (h *MetadataHandler) GetSongsByGenre(c *gin.Context) {
 	// A. Navidrome (Upstream)
 	go func() {
 		defer wg.Done()
+		defer safego.Recover()
 		u, _ := url.Parse(h.proxyHandler.GetTargetURL() + "/rest/getSongsByGenre.view")
 		q := c.Request.URL.Query()
 		q.Set("f", "xml")
+		q.Set("count", strconv.Itoa(window))
+		q.Set("offset", "0")
 		u.RawQuery = q.Encode()
 
-		req, _ := http.NewRequest("GET", u.String(), nil)
+		req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", u.String(), nil)
 		req.Header = c.Request.Header.Clone()
 		req.Header.Del("Accept-Encoding")
 
-		client := &http.Client{Timeout: 10 * time.Second}
-		resp, err := client.Do(req)
+		resp, err := h.proxyHandler.Navidrome().Do(req)
 		if err != nil {
 			return
 		}
@@ -636,14 +1012,16 @@ func (h *MetadataHandler) GetSongsByGenre(c *gin.Context) {
 	// B. Squid (External) - Search for the genre
 	go func() {
 		defer wg.Done()
-		var res *subsonic.SearchResult3 // Declare res here
-		var err error                   // Declare err here
-		if genre != "" {
-			res, err = h.squidService.Search(c.Request.Context(), "Hits")
-			if err == nil && res != nil {
-				squidSongs = res.Song
-			}
+		defer safego.Recover()
+		if genre == "" {
+			return
+		}
+		songs, err := h.squidService.SearchByGenre(c.Request.Context(), genre, window, 0)
+		if err != nil {
+			requestLogger(c).Error("Squid genre search failed", "genre", genre, "error", err)
+			return
 		}
+		squidSongs = songs
 	}()
 
 	wg.Wait()
@@ -661,43 +1039,51 @@ func (h *MetadataHandler) GetSongsByGenre(c *gin.Context) {
 		navidromeResult.SongsByGenre = &subsonic.RandomSongs{}
 	}
 
-	// Inject external songs
-	navidromeResult.SongsByGenre.Song = append(navidromeResult.SongsByGenre.Song, squidSongs...)
+	// Inject external songs after the local ones, then cut the requested page out of the
+	// merged list
+	merged := mergeSongs(navidromeResult.SongsByGenre.Song, squidSongs)
+	navidromeResult.SongsByGenre.Song = paginate(merged, searchWindow{Count: count, Offset: offset})
 
 	// 3. Return Response
 	SendSubsonicResponse(c, *navidromeResult)
 }
 
 func (h *MetadataHandler) GetSimilarSongs(c *gin.Context) {
+	h.getSimilarSongs(c, false)
+}
+
+func (h *MetadataHandler) GetSimilarSongs2(c *gin.Context) {
+	h.getSimilarSongs(c, true)
+}
+
+func (h *MetadataHandler) getSimilarSongs(c *gin.Context, v2 bool) {
 	id := c.Request.FormValue("id")
 	countStr := c.Request.FormValue("count")
-	count := 20
+	count := 50
 	if countStr != "" {
 		fmt.Sscanf(countStr, "%d", &count)
 	}
 
-	if strings.HasPrefix(id, "ext-") {
-		artist, _, err := h.squidService.GetArtist(c.Request.Context(), id)
-		if err == nil {
-			songs, err := h.squidService.GetTopSongsByArtist(c.Request.Context(), artist.Name, count)
-
-			if err == nil {
-				resp := subsonic.Response{
-					Status:  "ok",
-					Version: "1.16.1",
-					SimilarSongs: &subsonic.SimilarSongs{
-						Song: songs,
-					},
-				}
-				SendSubsonicResponse(c, resp)
-				return
-			}
+	resolvedID, isVirtual, err := ResolveVirtualID(c, h.proxyHandler, h.squidService, h.syncService, id)
+	if err == nil && isVirtual {
+		songs, err := h.squidService.GetSimilarSongs(c.Request.Context(), resolvedID, count)
+		if err != nil {
+			requestLogger(c).Error("GetSimilarSongs failed", "id", resolvedID, "error", err)
+			songs = []subsonic.Song{}
+		}
+
+		resp := subsonic.Response{
+			Status:  "ok",
+			Version: "1.16.1",
+		}
+		if v2 {
+			resp.SimilarSongs2 = &subsonic.SimilarSongs{Song: songs}
+		} else {
+			resp.SimilarSongs = &subsonic.SimilarSongs{Song: songs}
 		}
+		SendSubsonicResponse(c, resp)
+		return
 	}
 
 	h.proxyHandler.Handle(c)
 }
-
-func (h *MetadataHandler) GetSimilarSongs2(c *gin.Context) {
-	h.GetSimilarSongs(c)
-}
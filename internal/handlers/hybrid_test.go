@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"jetstream/internal/config"
+	"jetstream/internal/service"
+	"jetstream/pkg/subsonic"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// advertisedExtensions returns the extension names getOpenSubsonicExtensions reports under cfg
+func advertisedExtensions(t *testing.T, cfg *config.Config) []string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cfg.CacheBackend = "memory"
+	cfg.CacheEntries = 10
+	h := &MetadataHandler{squidService: service.NewSquidService(config.NewLive(cfg))}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/rest/getOpenSubsonicExtensions.view?f=json", nil)
+	h.GetOpenSubsonicExtensions(c)
+
+	var body struct {
+		Response subsonic.Response `json:"subsonic-response"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if body.Response.OpenSubsonicExtensions == nil {
+		t.Fatal("response has no openSubsonicExtensions")
+	}
+	var names []string
+	for _, ext := range body.Response.OpenSubsonicExtensions.Extension {
+		names = append(names, ext.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Every advertised extension must be implemented for external songs, and every implemented
+// one advertised: songLyrics (getLyricsBySongId) and formPost always, transcodeOffset only
+// while STREAM_TIME_OFFSET lets Stream honor timeOffset
+func TestOpenSubsonicExtensionsMatchImplementation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *config.Config
+		want []string
+	}{
+		{"time offset on", &config.Config{StreamTimeOffset: true}, []string{"formPost", "songLyrics", "transcodeOffset"}},
+		{"time offset off", &config.Config{StreamTimeOffset: false}, []string{"formPost", "songLyrics"}},
+	}
+	for _, tt := range tests {
+		if got := advertisedExtensions(t, tt.cfg); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: advertised %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"encoding/xml"
+	"jetstream/internal/logging"
+	"jetstream/internal/safego"
+	"jetstream/pkg/subsonic"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Navidrome's default ignored articles, used when it couldn't be reached
+const defaultIgnoredArticles = "The El La Los Las Le Les Os As O A"
+
+func (h *MetadataHandler) GetIndexes(c *gin.Context) {
+	h.getIndexes(c, false)
+}
+
+func (h *MetadataHandler) GetArtists(c *gin.Context) {
+	h.getIndexes(c, true)
+}
+
+// getIndexes merges starred and synced external artists into Navidrome's alphabetical index,
+// so they show up before Navidrome has rescanned the synced files
+func (h *MetadataHandler) getIndexes(c *gin.Context, id3 bool) {
+	endpoint := "/rest/getIndexes.view"
+	if id3 {
+		endpoint = "/rest/getArtists.view"
+	}
+
+	// 1. Parallel Requests
+	var navidromeResult *subsonic.Response
+	var external []subsonic.Artist
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	// A. Navidrome (Upstream)
+	go func() {
+		defer wg.Done()
+		defer safego.Recover()
+		u, _ := url.Parse(h.proxyHandler.GetTargetURL() + endpoint)
+		q := c.Request.URL.Query()
+		q.Set("f", "xml")
+		u.RawQuery = q.Encode()
+
+		req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", u.String(), nil)
+		req.Header = c.Request.Header.Clone()
+		req.Header.Del("Accept-Encoding")
+
+		resp, err := h.proxyHandler.Navidrome().Do(req)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+
+		result := &subsonic.Response{}
+		if err := xml.NewDecoder(resp.Body).Decode(result); err != nil {
+			requestLogger(c).Error("Decoding Upstream indexes", "error", err)
+			return
+		}
+		navidromeResult = result
+	}()
+
+	// B. Redis (External starred and synced artists)
+	go func() {
+		defer wg.Done()
+		defer safego.Recover()
+		external = h.externalArtists(c.Request.Context())
+	}()
+
+	wg.Wait()
+
+	// Navidrome answered but refused (bad credentials, unknown folder): pass its error through
+	if navidromeResult != nil && navidromeResult.Status != "ok" {
+		SendSubsonicResponse(c, *navidromeResult)
+		return
+	}
+
+	// 2. Merge Results
+	if navidromeResult == nil {
+		navidromeResult = &subsonic.Response{
+			Status:  "ok",
+			Version: "1.16.1",
+		}
+	}
+
+	indexes := navidromeResult.Indexes
+	if id3 {
+		indexes = navidromeResult.Artists
+	}
+	if indexes == nil {
+		indexes = &subsonic.Indexes{IgnoredArticles: defaultIgnoredArticles}
+	}
+
+	mergeIndexes(indexes, external)
+
+	if id3 {
+		navidromeResult.Artists = indexes
+	} else {
+		navidromeResult.Indexes = indexes
+	}
+
+	// 3. Return Response
+	SendSubsonicResponse(c, *navidromeResult)
+}
+
+// externalArtists returns the starred and synced external artists, each once
+func (h *MetadataHandler) externalArtists(ctx context.Context) []subsonic.Artist {
+	var artists []subsonic.Artist
+	seen := make(map[string]bool)
+
+	synced, err := h.syncService.SyncedArtists(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to load synced artists", "error", err)
+	}
+	for _, artist := range synced {
+		seen[artist.ID] = true
+		artists = append(artists, artist)
+	}
+
+	items, err := h.squidService.StarredItems(ctx, "artist")
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to load starred items", "type", "artist", "error", err)
+	}
+
+	starredAt := make(map[string]string)
+	var missing []string
+	for _, item := range items {
+		starredAt[item.ID] = item.StarredAt.UTC().Format(time.RFC3339)
+		if seen[item.ID] {
+			continue
+		}
+		seen[item.ID] = true
+		missing = append(missing, item.ID)
+	}
+	artists = append(artists, resolveBatch(ctx, "artist", missing, func(ctx context.Context, id string) (subsonic.Artist, error) {
+		artist, _, err := h.squidService.GetArtist(ctx, id)
+		if err != nil {
+			return subsonic.Artist{}, err
+		}
+		return *artist, nil
+	})...)
+
+	for i := range artists {
+		artists[i].Starred = starredAt[artists[i].ID]
+	}
+	return artists
+}
+
+// mergeIndexes files each external artist under its initial, skipping artists Navidrome
+// already lists under the same name
+func mergeIndexes(indexes *subsonic.Indexes, external []subsonic.Artist) {
+	articles := strings.Fields(indexes.IgnoredArticles)
+
+	known := make(map[string]bool)
+	for _, index := range indexes.Index {
+		for _, artist := range index.Artist {
+			known[strings.ToLower(artist.Name)] = true
+		}
+	}
+
+	touched := make(map[string]bool)
+	for _, artist := range external {
+		name := strings.ToLower(artist.Name)
+		if name == "" || known[name] {
+			continue
+		}
+		known[name] = true
+
+		letter := indexLetter(artist.Name, articles)
+		i := findIndex(indexes.Index, letter)
+		if i < 0 {
+			indexes.Index = append(indexes.Index, subsonic.Index{Name: letter})
+			i = len(indexes.Index) - 1
+		}
+		indexes.Index[i].Artist = append(indexes.Index[i].Artist, artist)
+		touched[indexes.Index[i].Name] = true
+	}
+	if len(touched) == 0 {
+		return
+	}
+
+	sort.SliceStable(indexes.Index, func(i, j int) bool {
+		a, b := indexes.Index[i].Name, indexes.Index[j].Name
+		if (a == "#") != (b == "#") {
+			return b == "#" // "#" goes last
+		}
+		return a < b
+	})
+	for i := range indexes.Index {
+		if !touched[indexes.Index[i].Name] {
+			continue
+		}
+		index := indexes.Index[i].Artist
+		sort.SliceStable(index, func(a, b int) bool {
+			return sortName(index[a].Name, articles) < sortName(index[b].Name, articles)
+		})
+	}
+}
+
+// indexLetter is the index an artist belongs under: the uppercased first letter of its name
+// with leading articles removed, or "#" for names starting with a digit or symbol
+func indexLetter(name string, articles []string) string {
+	r, _ := utf8.DecodeRuneInString(sortName(name, articles))
+	if !unicode.IsLetter(r) {
+		return "#"
+	}
+	return string(unicode.ToUpper(r))
+}
+
+// sortName lowercases a name and strips a leading ignored article ("The Beatles" -> "beatles")
+func sortName(name string, articles []string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, article := range articles {
+		if rest, ok := strings.CutPrefix(name, strings.ToLower(article)+" "); ok {
+			return strings.TrimSpace(rest)
+		}
+	}
+	return name
+}
+
+// findIndex returns the index whose name is letter, or a range like "X-Z" containing it
+func findIndex(indexes []subsonic.Index, letter string) int {
+	for i, index := range indexes {
+		if index.Name == letter {
+			return i
+		}
+	}
+	r, _ := utf8.DecodeRuneInString(letter)
+	for i, index := range indexes {
+		from, to, ok := strings.Cut(index.Name, "-")
+		if !ok || utf8.RuneCountInString(from) != 1 || utf8.RuneCountInString(to) != 1 {
+			continue
+		}
+		lo, _ := utf8.DecodeRuneInString(from)
+		hi, _ := utf8.DecodeRuneInString(to)
+		if lo <= r && r <= hi {
+			return i
+		}
+	}
+	return -1
+}
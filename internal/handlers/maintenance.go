@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"jetstream/internal/service"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,15 +20,90 @@ func NewMaintenanceHandler(syncService *service.SyncService) *MaintenanceHandler
 }
 
 func (h *MaintenanceHandler) Scan(c *gin.Context) {
-	total, corrupt, err := h.syncService.MaintenanceScan(c.Request.Context())
+	dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+
+	result, err := h.syncService.MaintenanceScan(c.Request.Context(), dryRun)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		sendAdminFailure(c, "Maintenance scan failed", err)
+		return
+	}
+	wrongFormatFiles := result.WrongFormatFiles
+	if wrongFormatFiles == nil {
+		wrongFormatFiles = []string{}
+	}
+
+	if dryRun {
+		wouldDelete := result.WouldDelete
+		if wouldDelete == nil {
+			wouldDelete = []string{}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"status":          "completed",
+			"dry_run":         true,
+			"total_files":     result.Total,
+			"corrupt_deleted": 0,
+			"corrupt":         result.Corrupt,
+			"wrong_format":    result.WrongFormat,
+			"wrong_files":     wrongFormatFiles,
+			"would_delete":    wouldDelete,
+		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":          "completed",
-		"total_files":     total,
-		"corrupt_deleted": corrupt,
+		"dry_run":         false,
+		"total_files":     result.Total,
+		"corrupt_deleted": result.Corrupt,
+		"wrong_format":    result.WrongFormat,
+		"wrong_files":     wrongFormatFiles,
+	})
+}
+
+// Hydrate replaces ghost placeholders in the music folder with fully synced tracks
+func (h *MaintenanceHandler) Hydrate(c *gin.Context) {
+	result, err := h.syncService.HydrateGhosts(c.Request.Context())
+	if err != nil {
+		requestLogger(c).Error("Hydrating ghosts failed", "error", err)
+		status, body := adminFailure(err, "Hydrating ghosts failed")
+		c.JSON(status, gin.H{"error": body, "result": result})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "completed",
+		"result": result,
+	})
+}
+
+// Library lists the synced files by artist and album, with their sizes and total disk usage
+func (h *MaintenanceHandler) Library(c *gin.Context) {
+	listing, err := h.syncService.ListLibrary(c.Request.Context())
+	if err != nil {
+		sendAdminFailure(c, "Listing the library failed", err)
+		return
+	}
+	c.JSON(http.StatusOK, listing)
+}
+
+// DeleteLibraryAlbum removes a synced album's files, sidecars and path index entries
+func (h *MaintenanceHandler) DeleteLibraryAlbum(c *gin.Context) {
+	id := c.Query("id")
+	if id == "" {
+		sendAdminError(c, http.StatusBadRequest, AdminErrInvalidID, "id is required")
+		return
+	}
+	result, err := h.syncService.DeleteAlbum(c.Request.Context(), id)
+	if errors.Is(err, service.ErrNotFound) {
+		sendAdminError(c, http.StatusNotFound, AdminErrNotFound, "No synced tracks for this album")
+		return
+	}
+	if err != nil {
+		sendAdminFailure(c, "Deleting the album failed", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "deleted",
+		"id":     id,
+		"result": result,
 	})
 }
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"jetstream/internal/config"
+	"jetstream/internal/logging"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrNavidromeUnavailable is returned without contacting Navidrome while the breaker is open
+var ErrNavidromeUnavailable = errors.New("navidrome unavailable (circuit open)")
+
+// Circuit breaker states
+const (
+	breakerClosed   = "closed"    // Requests flow normally
+	breakerOpen     = "open"      // Requests fail fast until the cooldown ends
+	breakerHalfOpen = "half-open" // One probe request decides whether to close again
+)
+
+// NavidromeClient is the shared client for JetStream's own requests to Navidrome (resolvers,
+// merged listings, search), as opposed to the reverse proxy which forwards client requests.
+// A circuit breaker stops it from waiting on a Navidrome that is down, so merged responses
+// fall back to external results immediately.
+type NavidromeClient struct {
+	client    *http.Client
+	threshold int           // Consecutive failures that open the breaker; 0 disables it
+	cooldown  time.Duration // How long the breaker stays open before probing
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openUntil time.Time
+	probing   bool // A half-open probe is in flight
+}
+
+// BreakerState describes the Navidrome circuit breaker for /health
+type BreakerState struct {
+	State     string     `json:"state"`
+	Failures  int        `json:"consecutive_failures"`
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+}
+
+func newNavidromeClient(cfg *config.Config) *NavidromeClient {
+	return &NavidromeClient{
+		threshold: cfg.NavidromeBreakerThreshold,
+		cooldown:  cfg.NavidromeBreakerCooldown,
+		state:     breakerClosed,
+		client: &http.Client{
+			Timeout: cfg.NavidromeTimeout,
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConns:        100,
+				MaxIdleConnsPerHost: 20, // Everything goes to the one Navidrome host
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}
+}
+
+// Do sends req through the circuit breaker. Transport errors and 5xx responses count as
+// failures; while the breaker is open Do returns ErrNavidromeUnavailable straight away.
+func (n *NavidromeClient) Do(req *http.Request) (*http.Response, error) {
+	if !n.allow() {
+		return nil, ErrNavidromeUnavailable
+	}
+	resp, err := n.send(req)
+	if req.Context().Err() != nil {
+		n.abandon() // A caller giving up says nothing about Navidrome's health
+	} else {
+		n.record(err == nil && resp.StatusCode < 500)
+	}
+	return resp, err
+}
+
+// send performs req, retrying once when the connection itself failed (refused, reset, DNS).
+// Timeouts aren't retried: a slow Navidrome would only get slower.
+func (n *NavidromeClient) send(req *http.Request) (*http.Response, error) {
+	resp, err := n.client.Do(req)
+	if err == nil || !isConnectionError(err) || req.Context().Err() != nil {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return nil, err // Body already consumed, can't resend
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	logging.FromContext(req.Context()).Debug("Retrying Navidrome request after connection error", "path", req.URL.Path, "error", err)
+	return n.client.Do(retry)
+}
+
+// isConnectionError reports whether err is a network failure other than a timeout
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return errors.As(err, &opErr) || errors.As(err, &dnsErr)
+}
+
+// allow reports whether a request may go out, moving an expired open breaker to half-open
+// and letting exactly one probe through
+func (n *NavidromeClient) allow() bool {
+	if n.threshold <= 0 {
+		return true
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch n.state {
+	case breakerOpen:
+		if time.Now().Before(n.openUntil) {
+			return false
+		}
+		n.state = breakerHalfOpen
+		n.probing = true
+		slog.Info("Navidrome circuit half-open, probing")
+		return true
+	case breakerHalfOpen:
+		if n.probing {
+			return false
+		}
+		n.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record feeds a request outcome into the breaker
+func (n *NavidromeClient) record(ok bool) {
+	if n.threshold <= 0 {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if ok {
+		if n.state != breakerClosed {
+			slog.Info("Navidrome circuit closed", "after", n.state)
+		}
+		n.state, n.failures, n.probing = breakerClosed, 0, false
+		return
+	}
+
+	n.failures++
+	if n.state == breakerHalfOpen || n.failures >= n.threshold {
+		n.state = breakerOpen
+		n.openUntil = time.Now().Add(n.cooldown)
+		n.probing = false
+		slog.Warn("Navidrome circuit open", "failures", n.failures, "cooldown", n.cooldown)
+	}
+}
+
+// abandon releases a half-open probe whose caller went away, so the next request probes instead
+func (n *NavidromeClient) abandon() {
+	n.mu.Lock()
+	n.probing = false
+	n.mu.Unlock()
+}
+
+// State reports the breaker's current state
+func (n *NavidromeClient) State() BreakerState {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	st := BreakerState{State: n.state, Failures: n.failures}
+	if n.threshold <= 0 {
+		st.State = "disabled"
+	}
+	if n.state == breakerOpen {
+		until := n.openUntil
+		st.OpenUntil = &until
+	}
+	return st
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"jetstream/internal/safego"
+	"jetstream/pkg/subsonic"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// playlistScanTimeout bounds how long createPlaylist waits for Navidrome to pick up synced songs
+	playlistScanTimeout = 2 * time.Minute
+	scanPollInterval    = 2 * time.Second
+	// scanDebounce is how long a rescan waits for further playlist edits to share it
+	scanDebounce = 2 * time.Second
+)
+
+// scanBatcher coalesces the Navidrome rescans playlist edits ask for: requests arriving within
+// scanDebounce of each other share one scan, and scans never overlap, so each one started
+// after the files of every request it serves were synced
+type scanBatcher struct {
+	mu      sync.Mutex
+	pending *scanBatch // Scan not started yet that new requests join, or nil
+	running sync.Mutex // Held while a scan runs
+}
+
+// scanBatch is one shared scan; done is closed once it finished with err
+type scanBatch struct {
+	done chan struct{}
+	err  error
+}
+
+// CreatePlaylist syncs any external songs into the library before forwarding to Navidrome
+func (h *MetadataHandler) CreatePlaylist(c *gin.Context) {
+	h.forwardPlaylistEdit(c, "songId")
+}
+
+// UpdatePlaylist does the same as CreatePlaylist for the songs being added
+func (h *MetadataHandler) UpdatePlaylist(c *gin.Context) {
+	h.forwardPlaylistEdit(c, "songIdToAdd")
+}
+
+// forwardPlaylistEdit replaces external IDs in the songParam list with the Navidrome IDs of their
+// synced copies, then proxies the request. Navidrome rejects IDs it doesn't know, so every
+// external song must be synced and scanned first; otherwise the request fails with error 70.
+func (h *MetadataHandler) forwardPlaylistEdit(c *gin.Context, songParam string) {
+	if err := c.Request.ParseForm(); err != nil {
+		SendSubsonicError(c, subsonic.ErrGeneric, "Invalid form")
+		return
+	}
+	form := c.Request.Form
+
+	var external []string
+	for _, id := range form[songParam] {
+		if strings.HasPrefix(id, "ext-") {
+			external = append(external, id)
+		}
+	}
+
+	if len(external) > 0 {
+		ctx := c.Request.Context()
+		songs, err := h.syncForPlaylist(ctx, external)
+		if err != nil {
+			SendSubsonicError(c, subsonic.ErrDataNotFound, err.Error())
+			return
+		}
+
+		if err := h.waitForScan(c); err != nil {
+			requestLogger(c).Warn("Navidrome scan did not finish, resolving synced songs anyway", "error", err)
+		}
+
+		localIDs := make(map[string]string, len(songs))
+		for _, song := range songs {
+			localID, err := h.findLocalSong(c, song)
+			if err != nil {
+				SendSubsonicError(c, subsonic.ErrDataNotFound, fmt.Sprintf("Song %s is not in the library yet: %v", song.ID, err))
+				return
+			}
+			localIDs[song.ID] = localID
+		}
+
+		ids := form[songParam]
+		for i, id := range ids {
+			if localID, ok := localIDs[id]; ok {
+				ids[i] = localID
+			}
+		}
+		requestLogger(c).Info("Forwarding playlist edit with synced songs", "synced", len(localIDs))
+	}
+
+	h.proxyHandler.HandleForm(c, form)
+}
+
+// syncForPlaylist syncs the given external songs concurrently; SyncSong itself queues on the
+// worker pool so this does not bypass SYNC_CONCURRENCY
+func (h *MetadataHandler) syncForPlaylist(ctx context.Context, ids []string) ([]*subsonic.Song, error) {
+	songs := make([]*subsonic.Song, len(ids))
+	errs := make([]error, len(ids))
+
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			defer safego.Recover()
+			song, err := h.providers.GetSong(ctx, id)
+			if err != nil {
+				errs[i] = fmt.Errorf("song %s not found: %w", id, err)
+				return
+			}
+			if err := h.syncService.SyncSong(ctx, song); err != nil {
+				errs[i] = fmt.Errorf("failed to sync %s: %w", id, err)
+				return
+			}
+			songs[i] = song
+		}(i, id)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return songs, nil
+}
+
+// waitForScan has Navidrome rescan the library and waits until it finished. The scan is
+// shared with other playlist edits made around the same time.
+func (h *MetadataHandler) waitForScan(c *gin.Context) error {
+	h.scans.mu.Lock()
+	batch := h.scans.pending
+	if batch == nil {
+		batch = &scanBatch{done: make(chan struct{})}
+		h.scans.pending = batch
+		// The scan outlives this request if it joins a batch, so it runs detached with the
+		// credentials of the edit that started it
+		ctx := context.WithoutCancel(c.Request.Context())
+		creds := navidromeCredentials(c)
+		header := c.Request.Header.Clone()
+		safego.Go(func() { h.runScan(ctx, batch, creds, header) })
+	}
+	h.scans.mu.Unlock()
+
+	select {
+	case <-batch.done:
+		return batch.err
+	case <-c.Request.Context().Done():
+		return c.Request.Context().Err()
+	}
+}
+
+// runScan waits out scanDebounce and any scan still running, then starts a Navidrome scan for
+// batch and polls until it finishes
+func (h *MetadataHandler) runScan(ctx context.Context, batch *scanBatch, creds url.Values, header http.Header) {
+	defer close(batch.done)
+	time.Sleep(scanDebounce)
+
+	h.scans.running.Lock()
+	defer h.scans.running.Unlock()
+	h.scans.mu.Lock()
+	h.scans.pending = nil // Later requests need a scan that starts after their sync
+	h.scans.mu.Unlock()
+
+	batch.err = h.scanLibrary(ctx, creds, header)
+}
+
+// scanLibrary starts a Navidrome library scan and polls until it finishes
+func (h *MetadataHandler) scanLibrary(ctx context.Context, creds url.Values, header http.Header) error {
+	if _, err := h.navidromeCall(ctx, creds, header, "startScan", nil); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(playlistScanTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(scanPollInterval)
+
+		resp, err := h.navidromeCall(ctx, creds, header, "getScanStatus", nil)
+		if err != nil {
+			return err
+		}
+		if resp.ScanStatus == nil || !resp.ScanStatus.Scanning {
+			return nil
+		}
+	}
+	return fmt.Errorf("scan still running after %s", playlistScanTimeout)
+}
+
+// findLocalSong looks up the Navidrome ID of a synced song by its path: the [ext-...] marker
+// in it, or for path templates without one the exact path it was synced to. Songs that merely
+// share its title and artist are never taken for it.
+func (h *MetadataHandler) findLocalSong(c *gin.Context, song *subsonic.Song) (string, error) {
+	resp, err := h.navidromeRequest(c, "search3", url.Values{
+		"query":       {song.Title},
+		"songCount":   {"50"},
+		"artistCount": {"0"},
+		"albumCount":  {"0"},
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.SearchResult3 == nil {
+		return "", fmt.Errorf("no search results")
+	}
+
+	marker := "[" + song.ID + "]"
+	// Navidrome reports paths relative to its music folder, which may contain the library
+	synced := "/" + filepath.ToSlash(h.syncService.LocalPath(song))
+	for _, local := range resp.SearchResult3.Song {
+		if strings.HasPrefix(local.ID, "ext-") || local.Path == "" {
+			continue
+		}
+		path := filepath.ToSlash(local.Path)
+		if strings.Contains(path, marker) || strings.HasSuffix(synced, "/"+strings.TrimPrefix(path, "/")) {
+			return local.ID, nil
+		}
+	}
+	return "", fmt.Errorf("no library song at the synced path")
+}
+
+// navidromeCredentials copies the client's Subsonic credentials for calls made on its behalf
+func navidromeCredentials(c *gin.Context) url.Values {
+	creds := url.Values{}
+	for _, key := range []string{"u", "p", "t", "s", "c", "v", "apiKey"} {
+		if v := c.Request.Form.Get(key); v != "" {
+			creds.Set(key, v)
+		}
+	}
+	return creds
+}
+
+// navidromeRequest calls a Subsonic endpoint on Navidrome with the client's credentials
+func (h *MetadataHandler) navidromeRequest(c *gin.Context, endpoint string, params url.Values) (*subsonic.Response, error) {
+	return h.navidromeCall(c.Request.Context(), navidromeCredentials(c), c.Request.Header, endpoint, params)
+}
+
+// navidromeCall calls a Subsonic endpoint on Navidrome with the given credentials and headers
+func (h *MetadataHandler) navidromeCall(ctx context.Context, creds url.Values, header http.Header, endpoint string, params url.Values) (*subsonic.Response, error) {
+	u, _ := url.Parse(h.proxyHandler.GetTargetURL() + "/rest/" + endpoint + ".view")
+	q := url.Values{}
+	for key, values := range creds {
+		q[key] = values
+	}
+	for key, values := range params {
+		q[key] = values
+	}
+	q.Set("f", "xml")
+	u.RawQuery = q.Encode()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	req.Header = header.Clone()
+	req.Header.Del("Accept-Encoding")
+	req.Header.Del("Content-Type")
+
+	resp, err := h.proxyHandler.Navidrome().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &subsonic.Response{}
+	if err := xml.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, err
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("navidrome %s: %s", endpoint, result.Error.Message)
+	}
+	return result, nil
+}
@@ -1,48 +1,150 @@
 package handlers
 
 import (
-	"log"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"jetstream/internal/config"
+	"jetstream/internal/logging"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"jetstream/internal/config"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 )
 
+// ResponseInspector can read and rewrite a proxied Navidrome response. body is already
+// decompressed; the returned bytes replace it.
+type ResponseInspector func(resp *http.Response, body []byte) ([]byte, error)
+
 type ProxyHandler struct {
 	target *url.URL
 	proxy  *httputil.ReverseProxy
+
+	inspectorsMu sync.RWMutex
+	inspectors   map[string]ResponseInspector // Keyed by endpoint, e.g. "getArtists"
+
+	navidrome *NavidromeClient
 }
 
 func NewProxyHandler(cfg *config.Config) *ProxyHandler {
 	target, err := url.Parse(cfg.NavidromeURL)
 	if err != nil {
-		log.Fatalf("Invalid Navidrome URL: %v", err)
+		slog.Error("Invalid Navidrome URL", "url", cfg.NavidromeURL, "error", err)
+		os.Exit(1)
 	}
 
 	proxy := httputil.NewSingleHostReverseProxy(target)
 	// Flush immediately to support SSE (Server Sent Events)
 	proxy.FlushInterval = -1
 
+	h := &ProxyHandler{
+		target:     target,
+		proxy:      proxy,
+		inspectors: make(map[string]ResponseInspector),
+		navidrome:  newNavidromeClient(cfg),
+	}
+
 	// Optional: Custom error handling or request logic for proxy
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
 		originalDirector(req)
 		// Ensure Host header matches target for some servers (though Navidrome usually doesn't care)
 		req.Host = target.Host
+		// Inspected responses must be decodable, so only offer gzip (no brotli) for those
+		if h.inspector(req.URL.Path) != nil {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
 	}
+	proxy.ModifyResponse = h.modifyResponse
 
-	return &ProxyHandler{
-		target: target,
-		proxy:  proxy,
-	}
+	return h
 }
 
 func (h *ProxyHandler) GetTargetURL() string {
 	return h.target.String()
 }
 
+// Navidrome returns the shared client for requests JetStream makes to Navidrome itself
+func (h *ProxyHandler) Navidrome() *NavidromeClient {
+	return h.navidrome
+}
+
 func (h *ProxyHandler) Handle(c *gin.Context) {
 	h.proxy.ServeHTTP(c.Writer, c.Request)
 }
+
+// HandleForm proxies the request with form as its parameters. ParseForm consumed any POST
+// body, so every parameter goes through the query string.
+func (h *ProxyHandler) HandleForm(c *gin.Context, form url.Values) {
+	c.Request.URL.RawQuery = form.Encode()
+	c.Request.Body = http.NoBody
+	c.Request.ContentLength = 0
+	c.Request.Header.Del("Content-Type")
+	h.Handle(c)
+}
+
+// Inspect registers fn for a Subsonic endpoint ("getArtists" matches /rest/getArtists and
+// /rest/getArtists.view). Endpoints without an inspector are streamed through untouched.
+func (h *ProxyHandler) Inspect(endpoint string, fn ResponseInspector) {
+	h.inspectorsMu.Lock()
+	defer h.inspectorsMu.Unlock()
+	h.inspectors[endpoint] = fn
+}
+
+func (h *ProxyHandler) inspector(path string) ResponseInspector {
+	endpoint := strings.TrimSuffix(path[strings.LastIndex(path, "/")+1:], ".view")
+
+	h.inspectorsMu.RLock()
+	defer h.inspectorsMu.RUnlock()
+	return h.inspectors[endpoint]
+}
+
+// modifyResponse hands inspected endpoints a decompressed body and rewrites the headers to
+// match whatever the inspector returns
+func (h *ProxyHandler) modifyResponse(resp *http.Response) error {
+	fn := h.inspector(resp.Request.URL.Path)
+	if fn == nil {
+		return nil
+	}
+
+	encoding := strings.ToLower(resp.Header.Get("Content-Encoding"))
+	if encoding != "" && encoding != "gzip" && encoding != "identity" {
+		logging.FromContext(resp.Request.Context()).Warn("Skipping inspection of proxied response with unsupported encoding", "path", resp.Request.URL.Path, "encoding", encoding)
+		return nil
+	}
+
+	var reader io.Reader = resp.Body
+	if encoding == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	body, err := io.ReadAll(reader)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	out, err := fn(resp, body)
+	if err != nil {
+		// Fall back to the original body rather than failing the client's request
+		logging.FromContext(resp.Request.Context()).Error("Proxy response inspector failed", "path", resp.Request.URL.Path, "error", err)
+		out = body
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(out))
+	resp.ContentLength = int64(len(out))
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(out)))
+	return nil
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"jetstream/internal/config"
+	"jetstream/internal/service"
+	"jetstream/pkg/subsonic"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// radioPoolSize is how many similar songs a station queues at a time
+const radioPoolSize = 50
+
+// radioNowTTL is how long a listener's current song is remembered for range requests. It
+// outlasts any song, and lets entries of listeners who tuned out be dropped.
+const radioNowTTL = time.Hour
+
+// radioCredentials are the Subsonic parameters copied into station stream URLs, so players
+// that can't log in still pass RadioAuthMiddleware
+var radioCredentials = []string{"u", "t", "s", "p", "v", "c"}
+
+// nowPlaying is the song a listener's station is playing
+type nowPlaying struct {
+	songID  string
+	started time.Time
+}
+
+// RadioHandler serves RADIO_STATIONS as internet radio: each station is listed next to
+// Navidrome's own, and its stream URL plays songs similar to the station's seed, one per request
+type RadioHandler struct {
+	squidService *service.SquidService
+	stream       *Handler
+	proxyHandler *ProxyHandler
+	cfg          *config.Live
+
+	queueMu sync.Mutex                 // Held across a whole pop, refill included
+	queues  map[string][]subsonic.Song // Seed -> songs still to play
+
+	nowMu sync.Mutex
+	now   map[string]nowPlaying // Listener -> song playing, for range requests
+}
+
+func NewRadioHandler(squidService *service.SquidService, stream *Handler, proxyHandler *ProxyHandler, cfg *config.Live) *RadioHandler {
+	return &RadioHandler{
+		squidService: squidService,
+		stream:       stream,
+		proxyHandler: proxyHandler,
+		cfg:          cfg,
+		queues:       make(map[string][]subsonic.Song),
+		now:          make(map[string]nowPlaying),
+	}
+}
+
+// radioStationID is the Subsonic ID of the ith configured station
+func radioStationID(i int) string {
+	return subsonic.BuildID("jetstream", "radio", strconv.Itoa(i))
+}
+
+// isRadioStationID reports whether id names a configured station rather than a Navidrome one
+func isRadioStationID(id string) bool {
+	_, provider, mediaType, _ := subsonic.ParseID(id)
+	return provider == "jetstream" && mediaType == "radio"
+}
+
+// GetInternetRadioStations appends the configured stations to Navidrome's list
+func (h *RadioHandler) GetInternetRadioStations(c *gin.Context) {
+	var navidromeResult *subsonic.Response
+
+	u, _ := url.Parse(h.proxyHandler.GetTargetURL() + "/rest/getInternetRadioStations.view")
+	q := c.Request.URL.Query()
+	q.Set("f", "xml")
+	u.RawQuery = q.Encode()
+
+	req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", u.String(), nil)
+	req.Header = c.Request.Header.Clone()
+	req.Header.Del("Accept-Encoding")
+
+	if resp, err := h.proxyHandler.Navidrome().Do(req); err == nil {
+		defer resp.Body.Close()
+		result := &subsonic.Response{}
+		if err := xml.NewDecoder(resp.Body).Decode(result); err != nil {
+			requestLogger(c).Error("Decoding Upstream radio stations", "error", err)
+		} else {
+			navidromeResult = result
+		}
+	}
+
+	// Navidrome answered but refused: pass its error through
+	if navidromeResult != nil && navidromeResult.Status != "ok" {
+		SendSubsonicResponse(c, *navidromeResult)
+		return
+	}
+	if navidromeResult == nil {
+		navidromeResult = &subsonic.Response{
+			Status:  "ok",
+			Version: "1.16.1",
+		}
+	}
+	if navidromeResult.InternetRadioStations == nil {
+		navidromeResult.InternetRadioStations = &subsonic.InternetRadioStations{}
+	}
+
+	base := externalBaseURL(c)
+	credentials := url.Values{}
+	for _, key := range radioCredentials {
+		if v := c.Request.FormValue(key); v != "" {
+			credentials.Set(key, v)
+		}
+	}
+	for i, station := range h.cfg.Get().RadioStations {
+		navidromeResult.InternetRadioStations.InternetRadioStation = append(navidromeResult.InternetRadioStations.InternetRadioStation, subsonic.InternetRadioStation{
+			ID:        radioStationID(i),
+			Name:      station.Name,
+			StreamURL: base + "/radio/" + strconv.Itoa(i) + "?" + credentials.Encode(),
+		})
+	}
+
+	SendSubsonicResponse(c, *navidromeResult)
+}
+
+// UpdateInternetRadioStation proxies to Navidrome, refusing to edit configured stations
+func (h *RadioHandler) UpdateInternetRadioStation(c *gin.Context) {
+	h.refuseConfigured(c)
+}
+
+// DeleteInternetRadioStation proxies to Navidrome, refusing to delete configured stations
+func (h *RadioHandler) DeleteInternetRadioStation(c *gin.Context) {
+	h.refuseConfigured(c)
+}
+
+func (h *RadioHandler) refuseConfigured(c *gin.Context) {
+	if isRadioStationID(c.Request.FormValue("id")) {
+		SendSubsonicError(c, subsonic.ErrNotAuthorized, "Station is configured by RADIO_STATIONS and can't be changed through the API")
+		return
+	}
+	h.proxyHandler.Handle(c)
+}
+
+// Stream handles /radio/:station: it serves the station's next song through the regular
+// stream path. A range request past the start continues the song already playing instead.
+func (h *RadioHandler) Stream(c *gin.Context) {
+	index := c.Param("station")
+	i, err := strconv.Atoi(index)
+	stations := h.cfg.Get().RadioStations
+	if err != nil || i < 0 || i >= len(stations) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown station"})
+		return
+	}
+	station := stations[i]
+	listener := index + "|" + c.Request.FormValue("u") + "|" + c.ClientIP()
+
+	songID := ""
+	if r := c.GetHeader("Range"); r != "" && !strings.HasPrefix(r, "bytes=0-") {
+		songID = h.playing(listener)
+	}
+	if songID == "" {
+		song, err := h.next(c, station.Seed)
+		if err != nil {
+			requestLogger(c).Error("Radio station has nothing to play", "station", station.Name, "seed", station.Seed, "error", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to load station: " + err.Error()})
+			return
+		}
+		songID = song.ID
+		h.play(listener, songID)
+		requestLogger(c).Info("Radio station playing", "station", station.Name, "song", song.Title, "artist", song.Artist)
+	}
+
+	q := c.Request.URL.Query()
+	q.Set("id", songID)
+	q.Del("timeOffset")
+	for _, key := range radioCredentials {
+		q.Del(key)
+	}
+	c.Request.URL.RawQuery = q.Encode()
+	h.stream.Stream(c)
+}
+
+// playing returns the song listener's station is playing, or "" if there is none
+func (h *RadioHandler) playing(listener string) string {
+	h.nowMu.Lock()
+	defer h.nowMu.Unlock()
+	now, ok := h.now[listener]
+	if !ok || time.Since(now.started) > radioNowTTL {
+		return ""
+	}
+	return now.songID
+}
+
+// play records that listener's station started songID, dropping listeners who tuned out
+func (h *RadioHandler) play(listener, songID string) {
+	h.nowMu.Lock()
+	defer h.nowMu.Unlock()
+	for l, now := range h.now {
+		if time.Since(now.started) > radioNowTTL {
+			delete(h.now, l)
+		}
+	}
+	h.now[listener] = nowPlaying{songID: songID, started: time.Now()}
+}
+
+// next pops the next song for seed, refilling the queue with a fresh shuffle of similar songs.
+// The lock is held throughout so concurrent listeners neither get the same song nor refill
+// the queue twice.
+func (h *RadioHandler) next(c *gin.Context, seed string) (subsonic.Song, error) {
+	h.queueMu.Lock()
+	defer h.queueMu.Unlock()
+
+	queue := h.queues[seed]
+	if len(queue) == 0 {
+		songs, err := h.squidService.GetSimilarSongs(c.Request.Context(), seed, radioPoolSize)
+		if err != nil {
+			return subsonic.Song{}, err
+		}
+		if len(songs) == 0 {
+			return subsonic.Song{}, service.ErrNotFound
+		}
+		queue = append([]subsonic.Song(nil), songs...)
+		rand.Shuffle(len(queue), func(a, b int) { queue[a], queue[b] = queue[b], queue[a] })
+	}
+
+	h.queues[seed] = queue[1:]
+	return queue[0], nil
+}
+
+// externalBaseURL is the scheme and host the client reached JetStream on
+func externalBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
@@ -1,40 +1,113 @@
 package handlers
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"jetstream/internal/config"
+	"jetstream/internal/safego"
 	"jetstream/internal/service"
 	"jetstream/pkg/subsonic"
-	"log/slog"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"strconv"
 	"sync"
-	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type SearchHandler struct {
 	squidService *service.SquidService
+	providers    *service.Providers
 	syncService  *service.SyncService
-	cfg          *config.Config
-	client       *http.Client
+	cfg          *config.Live
 	proxyHandler *ProxyHandler
 }
 
-func NewSearchHandler(squidService *service.SquidService, syncService *service.SyncService, cfg *config.Config, proxyHandler *ProxyHandler) *SearchHandler {
+func NewSearchHandler(squidService *service.SquidService, providers *service.Providers, syncService *service.SyncService, cfg *config.Live, proxyHandler *ProxyHandler) *SearchHandler {
 	return &SearchHandler{
 		squidService: squidService,
+		providers:    providers,
 		syncService:  syncService,
 		cfg:          cfg,
-		client:       &http.Client{Timeout: 10 * time.Second},
 		proxyHandler: proxyHandler,
 	}
 }
 
+// searchWindow is a Subsonic count/offset pair for one result category
+type searchWindow struct {
+	Count     int // Merged items returned
+	Offset    int
+	PerSource int // Items requested from each source
+}
+
+// searchPage reads a count/offset pair from the request. The count defaults to, and is capped
+// at, SearchMergeLimit; PerSource is what each source is asked for, bounded by SearchLimit.
+func (h *SearchHandler) searchPage(c *gin.Context, countKey, offsetKey string) searchWindow {
+	cfg := h.cfg.Get()
+	w := searchWindow{Count: cfg.SearchMergeLimit, PerSource: cfg.SearchLimit}
+	if w.Count <= 0 {
+		w.Count = 50
+	}
+	if w.PerSource <= 0 {
+		w.PerSource = 50
+	}
+	if v, err := strconv.Atoi(c.Request.FormValue(countKey)); err == nil && v >= 0 && v < w.Count {
+		w.Count = v
+	}
+	if w.PerSource > w.Count {
+		w.PerSource = w.Count
+	}
+	if v, err := strconv.Atoi(c.Request.FormValue(offsetKey)); err == nil && v > 0 {
+		w.Offset = v
+	}
+	return w
+}
+
+// setUpstream asks Navidrome for every item up to the end of its share of the window, starting at zero
+func (w searchWindow) setUpstream(q url.Values, countKey, offsetKey string) {
+	q.Set(countKey, strconv.Itoa(w.Offset+w.PerSource))
+	q.Set(offsetKey, "0")
+}
+
+// paginate slices a merged, deduplicated result list down to the requested window
+func paginate[T any](items []T, w searchWindow) []T {
+	if w.Offset >= len(items) {
+		return items[:0]
+	}
+	items = items[w.Offset:]
+	if len(items) > w.Count {
+		items = items[:w.Count]
+	}
+	return items
+}
+
+// externalSearch queries the enabled providers, giving up after SEARCH_TIMEOUT so a slow
+// mirror can't hold back Navidrome's results. It returns nil on failure or timeout.
+func (h *SearchHandler) externalSearch(c *gin.Context, query string) *subsonic.SearchResult3 {
+	ctx := c.Request.Context()
+	if timeout := h.cfg.Get().SearchTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	res, err := h.providers.Search(ctx, query)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			requestLogger(c).Warn("External search timed out, answering without it", "query", query, "timeout", h.cfg.Get().SearchTimeout)
+		}
+		return nil
+	}
+	return res
+}
+
 func (h *SearchHandler) Search3(c *gin.Context) {
 	query := c.Request.FormValue("query")
+	songPage := h.searchPage(c, "songCount", "songOffset")
+	albumPage := h.searchPage(c, "albumCount", "albumOffset")
+	artistPage := h.searchPage(c, "artistCount", "artistOffset")
 	if query == "" {
 		// Fallback to proxy if no query (though usually search has query)
 		// Or return empty
@@ -50,27 +123,28 @@ func (h *SearchHandler) Search3(c *gin.Context) {
 	// A. Navidrome (Upstream)
 	go func() {
 		defer wg.Done()
+		defer safego.Recover()
+		if !h.cfg.Get().SearchLocal {
+			return
+		}
 
 		// Force XML from Navidrome for parsing consistency
-		fURL, _ := url.Parse(h.cfg.NavidromeURL + c.Request.RequestURI)
+		fURL, _ := url.Parse(h.cfg.Get().NavidromeURL + c.Request.RequestURI)
 		q := fURL.Query()
 		q.Set("f", "xml")
-		ls := fmt.Sprintf("%d", h.cfg.SearchLimit)
-		if h.cfg.SearchLimit <= 0 {
-			ls = "50"
-		}
-		q.Set("songCount", ls)
-		q.Set("albumCount", ls)
-		q.Set("artistCount", ls)
+		// Fetch everything up to the end of the requested window; offsets are applied after merging
+		songPage.setUpstream(q, "songCount", "songOffset")
+		albumPage.setUpstream(q, "albumCount", "albumOffset")
+		artistPage.setUpstream(q, "artistCount", "artistOffset")
 		fURL.RawQuery = q.Encode()
 
-		req, _ := http.NewRequest("GET", fURL.String(), nil)
+		req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", fURL.String(), nil)
 		req.Header = c.Request.Header.Clone()
 		req.Header.Del("Accept-Encoding") // Let Go's http.Client handle decompression
 
-		resp, err := h.client.Do(req)
+		resp, err := h.proxyHandler.Navidrome().Do(req)
 		if err != nil {
-			slog.Error("Upstream search request failed", "error", err)
+			requestLogger(c).Error("Upstream search request failed", "error", err)
 			return
 		}
 
@@ -78,7 +152,7 @@ func (h *SearchHandler) Search3(c *gin.Context) {
 
 		navidromeResult = &subsonic.Response{}
 		if err := xml.NewDecoder(resp.Body).Decode(navidromeResult); err != nil {
-			slog.Error("Decoding Upstream search response", "error", err)
+			requestLogger(c).Error("Decoding Upstream search response", "error", err)
 		}
 
 	}()
@@ -86,10 +160,11 @@ func (h *SearchHandler) Search3(c *gin.Context) {
 	// B. Squid (External)
 	go func() {
 		defer wg.Done()
-		res, err := h.squidService.Search(c.Request.Context(), query)
-		if err == nil {
-			squidResult = res
+		defer safego.Recover()
+		if !h.cfg.Get().SearchExternal {
+			return
 		}
+		squidResult = h.externalSearch(c, query)
 
 	}()
 
@@ -131,41 +206,29 @@ func (h *SearchHandler) Search3(c *gin.Context) {
 	}
 
 	if squidResult != nil {
-		slog.Info("Squid search results",
+		requestLogger(c).Info("Squid search results",
 			"songs", len(squidResult.Song),
 			"albums", len(squidResult.Album),
 			"artists", len(squidResult.Artist),
 			"playlists", len(squidResult.Playlist),
 			"query", query)
 
-		// Append Songs
-		navidromeResult.SearchResult3.Song = append(navidromeResult.SearchResult3.Song, squidResult.Song...)
-		// Append Albums
-		navidromeResult.SearchResult3.Album = append(navidromeResult.SearchResult3.Album, squidResult.Album...)
-		// Append Artists
-		navidromeResult.SearchResult3.Artist = append(navidromeResult.SearchResult3.Artist, squidResult.Artist...)
+		// Merge Songs, Albums and Artists, preferring local entries over external duplicates
+		navidromeResult.SearchResult3.Song = mergeSongs(navidromeResult.SearchResult3.Song, squidResult.Song)
+		navidromeResult.SearchResult3.Album = mergeAlbums(navidromeResult.SearchResult3.Album, squidResult.Album)
+		navidromeResult.SearchResult3.Artist = mergeArtists(navidromeResult.SearchResult3.Artist, squidResult.Artist)
 		// Append Playlists
 		navidromeResult.SearchResult3.Playlist = append(navidromeResult.SearchResult3.Playlist, squidResult.Playlist...)
 
 	} else {
-		slog.Debug("Squid returned 0 results (or error)", "query", query)
+		requestLogger(c).Debug("Squid returned 0 results (or error)", "query", query)
 	}
 
-	// 3. Return Response & Limit
-	limit := h.cfg.SearchLimit
-	if limit <= 0 {
-		limit = 50
-	}
+	// 3. Return Response & Paginate
 	if navidromeResult.SearchResult3 != nil {
-		if len(navidromeResult.SearchResult3.Song) > limit {
-			navidromeResult.SearchResult3.Song = navidromeResult.SearchResult3.Song[:limit]
-		}
-		if len(navidromeResult.SearchResult3.Album) > limit {
-			navidromeResult.SearchResult3.Album = navidromeResult.SearchResult3.Album[:limit]
-		}
-		if len(navidromeResult.SearchResult3.Artist) > limit {
-			navidromeResult.SearchResult3.Artist = navidromeResult.SearchResult3.Artist[:limit]
-		}
+		navidromeResult.SearchResult3.Song = paginate(navidromeResult.SearchResult3.Song, songPage)
+		navidromeResult.SearchResult3.Album = paginate(navidromeResult.SearchResult3.Album, albumPage)
+		navidromeResult.SearchResult3.Artist = paginate(navidromeResult.SearchResult3.Artist, artistPage)
 	}
 
 	SendSubsonicResponse(c, *navidromeResult)
@@ -173,6 +236,9 @@ func (h *SearchHandler) Search3(c *gin.Context) {
 
 func (h *SearchHandler) Search2(c *gin.Context) {
 	query := c.Request.FormValue("query")
+	songPage := h.searchPage(c, "songCount", "songOffset")
+	albumPage := h.searchPage(c, "albumCount", "albumOffset")
+	artistPage := h.searchPage(c, "artistCount", "artistOffset")
 
 	// 1. Parallel Requests
 	var navidromeResult *subsonic.Response
@@ -184,27 +250,28 @@ func (h *SearchHandler) Search2(c *gin.Context) {
 	// A. Navidrome (Upstream)
 	go func() {
 		defer wg.Done()
+		defer safego.Recover()
+		if !h.cfg.Get().SearchLocal {
+			return
+		}
 
 		// Force XML from Navidrome for parsing consistency
-		fURL, _ := url.Parse(h.cfg.NavidromeURL + c.Request.RequestURI)
+		fURL, _ := url.Parse(h.cfg.Get().NavidromeURL + c.Request.RequestURI)
 		q := fURL.Query()
 		q.Set("f", "xml")
-		ls := fmt.Sprintf("%d", h.cfg.SearchLimit)
-		if h.cfg.SearchLimit <= 0 {
-			ls = "50"
-		}
-		q.Set("songCount", ls)
-		q.Set("albumCount", ls)
-		q.Set("artistCount", ls)
+		// Fetch everything up to the end of the requested window; offsets are applied after merging
+		songPage.setUpstream(q, "songCount", "songOffset")
+		albumPage.setUpstream(q, "albumCount", "albumOffset")
+		artistPage.setUpstream(q, "artistCount", "artistOffset")
 		fURL.RawQuery = q.Encode()
 
-		req, _ := http.NewRequest("GET", fURL.String(), nil)
+		req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", fURL.String(), nil)
 		req.Header = c.Request.Header.Clone()
 		req.Header.Del("Accept-Encoding")
 
-		resp, err := h.client.Do(req)
+		resp, err := h.proxyHandler.Navidrome().Do(req)
 		if err != nil {
-			slog.Error("Upstream search2 request failed", "error", err)
+			requestLogger(c).Error("Upstream search2 request failed", "error", err)
 			return
 		}
 
@@ -212,7 +279,7 @@ func (h *SearchHandler) Search2(c *gin.Context) {
 
 		navidromeResult = &subsonic.Response{}
 		if err := xml.NewDecoder(resp.Body).Decode(navidromeResult); err != nil {
-			slog.Error("Decoding Upstream search2 response", "error", err)
+			requestLogger(c).Error("Decoding Upstream search2 response", "error", err)
 		}
 
 	}()
@@ -220,10 +287,11 @@ func (h *SearchHandler) Search2(c *gin.Context) {
 	// B. Squid (External)
 	go func() {
 		defer wg.Done()
-		res, err := h.squidService.Search(c.Request.Context(), query)
-		if err == nil {
-			squidResult = res
+		defer safego.Recover()
+		if !h.cfg.Get().SearchExternal {
+			return
 		}
+		squidResult = h.externalSearch(c, query)
 
 	}()
 
@@ -261,29 +329,17 @@ func (h *SearchHandler) Search2(c *gin.Context) {
 	}
 
 	if squidResult != nil {
-		// Append Songs
-		navidromeResult.SearchResult2.Song = append(navidromeResult.SearchResult2.Song, squidResult.Song...)
-		// Append Albums
-		navidromeResult.SearchResult2.Album = append(navidromeResult.SearchResult2.Album, squidResult.Album...)
-		// Append Artists
-		navidromeResult.SearchResult2.Artist = append(navidromeResult.SearchResult2.Artist, squidResult.Artist...)
+		// Merge Songs, Albums and Artists, preferring local entries over external duplicates
+		navidromeResult.SearchResult2.Song = mergeSongs(navidromeResult.SearchResult2.Song, squidResult.Song)
+		navidromeResult.SearchResult2.Album = mergeAlbums(navidromeResult.SearchResult2.Album, squidResult.Album)
+		navidromeResult.SearchResult2.Artist = mergeArtists(navidromeResult.SearchResult2.Artist, squidResult.Artist)
 	}
 
-	// 3. Return Response & Limit
-	limit := h.cfg.SearchLimit
-	if limit <= 0 {
-		limit = 50
-	}
+	// 3. Return Response & Paginate
 	if navidromeResult.SearchResult2 != nil {
-		if len(navidromeResult.SearchResult2.Song) > limit {
-			navidromeResult.SearchResult2.Song = navidromeResult.SearchResult2.Song[:limit]
-		}
-		if len(navidromeResult.SearchResult2.Album) > limit {
-			navidromeResult.SearchResult2.Album = navidromeResult.SearchResult2.Album[:limit]
-		}
-		if len(navidromeResult.SearchResult2.Artist) > limit {
-			navidromeResult.SearchResult2.Artist = navidromeResult.SearchResult2.Artist[:limit]
-		}
+		navidromeResult.SearchResult2.Song = paginate(navidromeResult.SearchResult2.Song, songPage)
+		navidromeResult.SearchResult2.Album = paginate(navidromeResult.SearchResult2.Album, albumPage)
+		navidromeResult.SearchResult2.Artist = paginate(navidromeResult.SearchResult2.Artist, artistPage)
 	}
 
 	SendSubsonicResponse(c, *navidromeResult)
@@ -291,6 +347,7 @@ func (h *SearchHandler) Search2(c *gin.Context) {
 
 func (h *SearchHandler) Search(c *gin.Context) {
 	query := c.Request.FormValue("query")
+	page := h.searchPage(c, "count", "offset")
 
 	// 1. Parallel Requests
 	var navidromeResult *subsonic.Response
@@ -302,23 +359,24 @@ func (h *SearchHandler) Search(c *gin.Context) {
 	// A. Navidrome (Upstream)
 	go func() {
 		defer wg.Done()
-		fURL, _ := url.Parse(h.cfg.NavidromeURL + c.Request.RequestURI)
+		defer safego.Recover()
+		if !h.cfg.Get().SearchLocal {
+			return
+		}
+		fURL, _ := url.Parse(h.cfg.Get().NavidromeURL + c.Request.RequestURI)
 		q := fURL.Query()
 		q.Set("f", "xml")
-		ls := fmt.Sprintf("%d", h.cfg.SearchLimit)
-		if h.cfg.SearchLimit <= 0 {
-			ls = "50"
-		}
-		q.Set("songCount", ls)
+		// Fetch everything up to the end of the requested window; offsets are applied after merging
+		page.setUpstream(q, "count", "offset")
 		fURL.RawQuery = q.Encode()
 
-		req, _ := http.NewRequest("GET", fURL.String(), nil)
+		req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", fURL.String(), nil)
 		req.Header = c.Request.Header.Clone()
 		req.Header.Del("Accept-Encoding")
 
-		resp, err := h.client.Do(req)
+		resp, err := h.proxyHandler.Navidrome().Do(req)
 		if err != nil {
-			slog.Error("Upstream search1 request failed", "error", err)
+			requestLogger(c).Error("Upstream search1 request failed", "error", err)
 			return
 		}
 
@@ -331,10 +389,11 @@ func (h *SearchHandler) Search(c *gin.Context) {
 	// B. Squid (External)
 	go func() {
 		defer wg.Done()
-		res, err := h.squidService.Search(c.Request.Context(), query)
-		if err == nil {
-			squidResult = res
+		defer safego.Recover()
+		if !h.cfg.Get().SearchExternal {
+			return
 		}
+		squidResult = h.externalSearch(c, query)
 
 	}()
 
@@ -363,20 +422,12 @@ func (h *SearchHandler) Search(c *gin.Context) {
 
 	if squidResult != nil {
 		// Search1 only has "Match" (songs)
-		for _, s := range squidResult.Song {
-			navidromeResult.SearchResult.Match = append(navidromeResult.SearchResult.Match, s)
-		}
+		navidromeResult.SearchResult.Match = mergeSongs(navidromeResult.SearchResult.Match, squidResult.Song)
 	}
 
-	// 3. Return Response & Limit
-	limit := h.cfg.SearchLimit
-	if limit <= 0 {
-		limit = 50
-	}
+	// 3. Return Response & Paginate
 	if navidromeResult.SearchResult != nil {
-		if len(navidromeResult.SearchResult.Match) > limit {
-			navidromeResult.SearchResult.Match = navidromeResult.SearchResult.Match[:limit]
-		}
+		navidromeResult.SearchResult.Match = paginate(navidromeResult.SearchResult.Match, page)
 	}
 
 	SendSubsonicResponse(c, *navidromeResult)
@@ -390,9 +441,19 @@ func (h *SearchHandler) GetTopSongs(c *gin.Context) {
 		fmt.Sscanf(countStr, "%d", &count)
 	}
 
-	if artist != "" {
-		slog.Info("Fetching top songs", "artist", artist)
-		songs, err := h.squidService.GetTopSongsByArtist(c.Request.Context(), artist, count)
+	if artist != "" && h.cfg.Get().SearchExternal {
+		requestLogger(c).Info("Fetching top songs", "artist", artist)
+		ctx := c.Request.Context()
+
+		var songs []subsonic.Song
+		artistID, err := h.squidService.SearchOneArtist(ctx, artist)
+		if err == nil {
+			songs, err = h.squidService.GetArtistTopTracks(ctx, artistID, count)
+		}
+		if err != nil || len(songs) == 0 {
+			requestLogger(c).Warn("Top tracks endpoint unavailable, falling back to search", "artist", artist, "error", err)
+			songs, err = h.squidService.GetTopSongsByArtist(ctx, artist, count)
+		}
 
 		if err == nil && len(songs) > 0 {
 			resp := subsonic.Response{
@@ -413,7 +474,32 @@ func (h *SearchHandler) GetTopSongs(c *gin.Context) {
 func (h *SearchHandler) GetAlbumList2(c *gin.Context) {
 	listType := c.Request.FormValue("type")
 
-	if listType == "random" {
+	if h.cfg.Get().SearchExternal && service.SupportsAlbumList(listType) {
+		size := 10
+		if v, err := strconv.Atoi(c.Request.FormValue("size")); err == nil && v > 0 {
+			size = v
+		}
+		if size > 500 {
+			size = 500
+		}
+		offset, _ := strconv.Atoi(c.Request.FormValue("offset"))
+		if offset < 0 {
+			offset = 0
+		}
+		genre := c.Request.FormValue("genre")
+		fromYear, _ := strconv.Atoi(c.Request.FormValue("fromYear"))
+		toYear, _ := strconv.Atoi(c.Request.FormValue("toYear"))
+
+		// The merged list is paged as a whole, so both sources are read from the start up to
+		// the end of the requested page. Random lists have no pages.
+		window := offset + size
+		if listType == "random" {
+			window = size
+		}
+		if window > mergedListWindow {
+			window = mergedListWindow
+		}
+
 		// 1. Parallel Requests
 		var navidromeResult *subsonic.Response
 		var squidAlbums []subsonic.Album
@@ -424,17 +510,19 @@ func (h *SearchHandler) GetAlbumList2(c *gin.Context) {
 		// A. Navidrome
 		go func() {
 			defer wg.Done()
+			defer safego.Recover()
 			u, _ := url.Parse(h.proxyHandler.GetTargetURL() + "/rest/getAlbumList2.view")
 			q := c.Request.URL.Query()
 			q.Set("f", "xml")
+			q.Set("size", strconv.Itoa(window))
+			q.Set("offset", "0")
 			u.RawQuery = q.Encode()
 
-			req, _ := http.NewRequest("GET", u.String(), nil)
+			req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", u.String(), nil)
 			req.Header = c.Request.Header.Clone()
 			req.Header.Del("Accept-Encoding")
 
-			client := &http.Client{Timeout: 10 * time.Second}
-			resp, err := client.Do(req)
+			resp, err := h.proxyHandler.Navidrome().Do(req)
 			if err != nil {
 				return
 			}
@@ -444,14 +532,16 @@ func (h *SearchHandler) GetAlbumList2(c *gin.Context) {
 			xml.NewDecoder(resp.Body).Decode(navidromeResult)
 		}()
 
-		// B. Squid - Search for "Hits" to get some "random" albums
+		// B. Squid
 		go func() {
 			defer wg.Done()
-			res, err := h.squidService.Search(c.Request.Context(), "Hits")
-			if err == nil && res != nil {
-				squidAlbums = res.Album
+			defer safego.Recover()
+			albums, err := h.squidService.GetAlbumList(c.Request.Context(), listType, genre, fromYear, toYear, window, 0)
+			if err != nil {
+				requestLogger(c).Warn("External album list failed", "type", listType, "error", err)
+				return
 			}
-
+			squidAlbums = albums
 		}()
 
 		wg.Wait()
@@ -469,12 +559,14 @@ func (h *SearchHandler) GetAlbumList2(c *gin.Context) {
 			navidromeResult.AlbumList2 = &subsonic.AlbumList2{}
 		}
 
-		// Inject external albums
-		limit := 10
-		if len(squidAlbums) < limit {
-			limit = len(squidAlbums)
+		// Inject external albums after the local ones, then cut the requested page out of the
+		// merged list. Random lists are shuffled first, and their page is always the first.
+		merged := mergeAlbums(navidromeResult.AlbumList2.Album, squidAlbums)
+		if listType == "random" {
+			rand.Shuffle(len(merged), func(i, j int) { merged[i], merged[j] = merged[j], merged[i] })
+			offset = 0
 		}
-		navidromeResult.AlbumList2.Album = append(navidromeResult.AlbumList2.Album, squidAlbums[:limit]...)
+		navidromeResult.AlbumList2.Album = albumPage(merged, offset, size)
 
 		SendSubsonicResponse(c, *navidromeResult)
 		return
@@ -482,3 +574,15 @@ func (h *SearchHandler) GetAlbumList2(c *gin.Context) {
 
 	h.proxyHandler.Handle(c)
 }
+
+// mergedListWindow bounds how far into a merged album or song list GetAlbumList2 and
+// GetSongsByGenre page, matching the largest size Subsonic allows per request
+const mergedListWindow = 500
+
+// albumPage returns the size albums starting at offset, or none past the end
+func albumPage(albums []subsonic.Album, offset, size int) []subsonic.Album {
+	if offset >= len(albums) {
+		return []subsonic.Album{}
+	}
+	return albums[offset:min(offset+size, len(albums))]
+}
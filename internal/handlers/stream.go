@@ -4,32 +4,45 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"jetstream/internal/config"
+	"jetstream/internal/logging"
+	"jetstream/internal/safego"
 	"jetstream/internal/service"
 	"jetstream/pkg/subsonic"
-	"log"
-	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type Handler struct {
 	squidService *service.SquidService
+	providers    *service.Providers
 	syncService  *service.SyncService
 	proxyHandler *ProxyHandler
+	streamClient *http.Client
+	cfg          *config.Live
+	transcodeSem chan struct{} // Limits concurrent on-the-fly transcodes
 }
 
-func NewHandler(squidService *service.SquidService, syncService *service.SyncService, proxyHandler *ProxyHandler) *Handler {
+func NewHandler(squidService *service.SquidService, providers *service.Providers, syncService *service.SyncService, cfg *config.Live, proxyHandler *ProxyHandler) *Handler {
 	return &Handler{
 		squidService: squidService,
+		providers:    providers,
 		syncService:  syncService,
 		proxyHandler: proxyHandler,
+		streamClient: service.NewStreamClient(cfg.Get()),
+		cfg:          cfg,
+		transcodeSem: make(chan struct{}, max(cfg.Get().StreamTranscodes, 1)),
 	}
 }
 
-// Stream handles /rest/stream and /rest/stream.view
+// Stream handles /rest/stream and /rest/stream.view. HEAD requests get the same headers
+// without a body and don't trigger a sync.
 func (h *Handler) Stream(c *gin.Context) {
 	id := c.Query("id")
 	if id == "" {
@@ -37,80 +50,165 @@ func (h *Handler) Stream(c *gin.Context) {
 		return
 	}
 
+	// transcodeOffset: start this many seconds in. Only external songs are seeked here; local
+	// ones are proxied and Navidrome handles the parameter itself.
+	offset, _ := strconv.Atoi(c.Query("timeOffset"))
+	if !h.cfg.Get().StreamTimeOffset {
+		offset = 0
+	}
+
 	// 1. Resolve ID (Handles external IDs and Virtual indexed IDs)
-	externalID, isVirtual, err := ResolveVirtualID(c, h.proxyHandler, h.squidService, id)
+	externalID, isVirtual, err := ResolveVirtualID(c, h.proxyHandler, h.squidService, h.syncService, id)
 	if err != nil || !isVirtual {
-		log.Printf("[Stream] [%s] Not an external or virtual song: %s", c.GetHeader("User-Agent"), id)
+		requestLogger(c).Debug("Stream: not an external or virtual song, proxying", "id", id, "ua", c.GetHeader("User-Agent"))
 		h.proxyHandler.Handle(c)
 		return
 	}
 
-	log.Printf("[Stream] [%s] %s %s (Resolved: %s)", c.GetHeader("User-Agent"), c.Request.Method, c.Request.URL.String(), externalID)
+	requestLogger(c).Info("Stream request", "id", id, "resolved", externalID, "method", c.Request.Method, "ua", c.GetHeader("User-Agent"))
 
 	// 2. Resolve Metadata (Check Local Library first for real or ghost files)
-	song, err := h.squidService.GetSong(c.Request.Context(), externalID)
+	song, err := h.providers.GetSong(c.Request.Context(), externalID)
 	if err != nil {
 		SendSubsonicError(c, subsonic.ErrDataNotFound, "Failed to resolve song info: "+err.Error())
 		return
 	}
 
-	// 3. Local Check (Real or Ghost)
-	artistDir := h.syncService.SanitizePath(song.Artist)
-	albumDir := h.syncService.SanitizePath(song.Album)
+	// Gapless players use the duration to line up the next track
+	if song.Duration > offset {
+		c.Header("X-Content-Duration", strconv.Itoa(song.Duration-offset))
+	}
 
-	// New Filename Format: {Track} - [{ID}] {Title}.{ext}
-	fileName := fmt.Sprintf("%02d - [%s] %s.%s", song.Track, externalID, h.syncService.SanitizePath(song.Title), h.syncService.GetDownloadFormat())
-	localPath := filepath.Join("/music", "jetstream", artistDir, albumDir, fileName)
+	// 3. Local Check (Real or Ghost) at the same path SyncSong writes to
+	localPath := h.syncService.LocalPath(song)
 
-	if _, err := os.Stat(localPath); err == nil {
+	if !h.syncService.IsGhostFile(localPath) {
 		// Perform integrity check
-		if err := h.syncService.VerifyIntegrity(localPath); err == nil {
-			log.Printf("[Stream] Serving local file from jetstream: %s", localPath)
+		if err := h.syncService.VerifyIntegrity(c.Request.Context(), localPath); err == nil {
+			requestLogger(c).Info("Stream: serving synced file", "path", localPath)
+			if c.Request.Method != http.MethodHead {
+				h.syncService.MarkPlayed(c.Request.Context(), externalID)
+			}
+			if format, kbps, ok := h.transcodeTarget(c, fileBitRate(localPath, song.Duration)); ok {
+				h.streamTranscoded(c, externalID, localPath, offset, format, kbps)
+				return
+			}
+			if offset > 0 {
+				h.streamFromOffset(c, externalID, localPath, service.AudioContentType(strings.TrimPrefix(filepath.Ext(localPath), ".")), offset)
+				return
+			}
+			h.setDownloadName(c, song, filepath.Ext(localPath))
 			c.File(localPath)
 			return
 		}
-		log.Printf("[Stream] Corrupt or incomplete file detected in jetstream at %s, falling back to external stream", localPath)
+		requestLogger(c).Warn("Stream: synced file is corrupt or incomplete, falling back to external stream", "path", localPath)
+	}
+
+	// 3b. Fully cached upstream source from an earlier stream: serve locally with range support
+	quality := streamQuality(c)
+	if cachePath, mimeType, ok := h.syncService.CachedStream(externalID, quality); ok {
+		requestLogger(c).Info("Stream: serving cached upstream source", "path", cachePath)
+		if c.Request.Method != http.MethodHead {
+			h.syncInBackground(c, song)
+		}
+		if format, kbps, ok := h.transcodeTarget(c, fileBitRate(cachePath, song.Duration)); ok {
+			h.streamTranscoded(c, externalID, cachePath, offset, format, kbps)
+			return
+		}
+		if offset > 0 {
+			h.streamFromOffset(c, externalID, cachePath, mimeType, offset)
+			return
+		}
+		c.Header("Content-Type", mimeType)
+		h.setDownloadName(c, song, filepath.Ext(cachePath))
+		c.File(cachePath)
+		return
 	}
 
 	// 4. Fallback: Get Stream URL from Squid Service & Proxy
-	trackInfo, err := h.squidService.GetStreamURL(c.Request.Context(), externalID)
+	trackInfo, err := h.providers.GetStreamURL(c.Request.Context(), externalID, quality)
 	if err != nil {
 		SendSubsonicError(c, subsonic.ErrGeneric, "Failed to resolve stream: "+err.Error())
 		return
 	}
 
-	// SYNC-ON-PLAY: Trigger background sync for this song
-	go func() {
-		if err := h.syncService.SyncSong(context.Background(), song); err != nil {
-			slog.Error("Failed to sync song", "id", externalID, "error", err)
+	if format, kbps, ok := h.transcodeTarget(c, h.streamBitRate(c, song)); ok {
+		h.streamTranscoded(c, externalID, trackInfo.DownloadURL, offset, format, kbps)
+		if c.Request.Method != http.MethodHead {
+			h.syncInBackground(c, song)
 		}
-	}()
+		return
+	}
+
+	if offset > 0 {
+		h.streamFromOffset(c, externalID, trackInfo.DownloadURL, trackInfo.MimeType, offset)
+		if c.Request.Method != http.MethodHead {
+			h.syncInBackground(c, song)
+		}
+		return
+	}
+
+	// 3b. Redirect mode: let the client fetch straight from the CDN
+	if h.redirectStream(c, song, trackInfo) {
+		return
+	}
 
 	// 3. Proxy the Stream
-	// We need to request the actual file from the CDN
-	req, err := http.NewRequest("GET", trackInfo.DownloadURL, nil)
+	// We need to request the actual file from the CDN. HEAD requests still GET upstream since
+	// signed CDN URLs are only valid for GET; the body is just never read.
+	// Bound to the client request so a disconnect cancels the upstream fetch
+	req, err := http.NewRequestWithContext(c.Request.Context(), "GET", trackInfo.DownloadURL, nil)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upstream request"})
 		return
 	}
 
-	// Pass range header if present for seeking support
-	if rangeHeader := c.GetHeader("Range"); rangeHeader != "" {
+	// Pass range header if present for seeking support. Otherwise ask for the whole file as an
+	// open range: a CDN that answers without a length still states it in Content-Range.
+	rangeHeader := c.GetHeader("Range")
+	if rangeHeader != "" {
 		req.Header.Set("Range", rangeHeader)
+	} else {
+		req.Header.Set("Range", "bytes=0-")
 	}
 
-	client := &http.Client{} // Use default client or one with timeouts
-	resp, err := client.Do(req)
+	resp, err := h.streamClient.Do(req)
 	if err != nil {
 		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to connect to upstream CDN"})
 		return
 	}
 	defer resp.Body.Close()
 
+	// The client asked for the whole file, so a full 206 answer is passed on as a plain 200
+	size := resp.ContentLength
+	if rangeHeader == "" && resp.StatusCode == http.StatusPartialContent {
+		size = fullRangeLength(resp.Header.Get("Content-Range"))
+		if size <= 0 {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Upstream CDN sent a partial file"})
+			return
+		}
+		resp.StatusCode = http.StatusOK
+		resp.Header.Del("Content-Range")
+	}
+
 	// 4. Copy Headers
-	c.Header("Content-Type", trackInfo.MimeType)
-	if resp.ContentLength > 0 {
-		c.Header("Content-Length", fmt.Sprintf("%d", resp.ContentLength))
+	contentType := trackInfo.MimeType
+	if contentType == "" {
+		contentType = resp.Header.Get("Content-Type")
+	}
+	// Players need a length up front to show a scrubber and to play gaplessly
+	if size <= 0 && resp.StatusCode == http.StatusOK && h.cfg.Get().StreamExactLength &&
+		c.Request.Method != http.MethodHead && service.AudioExtension(contentType) != "" &&
+		h.syncService.CachesQuality(quality) {
+		h.serveBuffered(c, song, externalID, quality, contentType, resp.Body)
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	// A guessed length would make the response fail whenever the guess is off, either short
+	// of the real body or past it, so an unknown length is left out and the body is chunked
+	if size > 0 {
+		c.Header("Content-Length", fmt.Sprintf("%d", size))
 	}
 	c.Header("Accept-Ranges", "bytes") // Critical for scrubbing
 
@@ -122,17 +220,246 @@ func (h *Handler) Stream(c *gin.Context) {
 		c.Status(http.StatusOK)
 	}
 
-	// Support Download
-	if filepath.Base(c.Request.URL.Path) == "download.view" || filepath.Base(c.Request.URL.Path) == "download" {
-		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", externalID, "mp3")) // Simplified filename
+	ext := service.AudioExtension(contentType)
+	if ext == "" && song.Suffix != "" {
+		ext = "." + song.Suffix
 	}
+	h.setDownloadName(c, song, ext)
 
-	// 5. Zero-Copy Streaming
-	log.Printf("[Stream] Streaming external content: %s (Mime: %s)", externalID, trackInfo.MimeType)
-	// io.Copy efficiently copies from Reader to Writer
-	_, err = io.Copy(c.Writer, resp.Body)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+
+	// 5. Stream, teeing full (non-range) responses into the stream cache
+	requestLogger(c).Info("Stream: streaming external content", "id", externalID, "mime", contentType, "length", size)
+	if resp.StatusCode == http.StatusOK {
+		_, err = h.syncService.StreamAndCache(externalID, quality, contentType, resp.Body, c.Writer, size)
+	} else {
+		_, err = io.Copy(c.Writer, resp.Body)
+	}
 	if err != nil {
 		// Connection might be broken, log it but can't really change status now
-		log.Printf("[Stream] Error streaming content: %v", err)
+		requestLogger(c).Error("Stream: error streaming content", "id", externalID, "error", err)
+	}
+
+	// SYNC-ON-PLAY: Trigger background sync once streaming is done so it can reuse the cached source
+	h.syncInBackground(c, song)
+}
+
+// redirectGrace is how long a redirected CDN URL must outlive the track, so clients that
+// buffer slowly or re-request ranges while seeking don't hit an expired signature
+const redirectGrace = 5 * time.Minute
+
+// redirectStream answers with a 302 to the CDN URL when STREAM_MODE=redirect and the URL can be
+// fetched directly. Downloads stay proxied so they keep their Content-Disposition name, as do
+// URLs whose signature expires before the track could finish playing. Reports whether it redirected.
+func (h *Handler) redirectStream(c *gin.Context, song *subsonic.Song, trackInfo *service.TrackInfo) bool {
+	if h.cfg.Get().StreamMode != "redirect" {
+		return false
+	}
+	if base := filepath.Base(c.Request.URL.Path); base == "download" || base == "download.view" {
+		return false
+	}
+	if !trackInfo.Expires.IsZero() {
+		needed := time.Duration(song.Duration)*time.Second + redirectGrace
+		if time.Until(trackInfo.Expires) < needed {
+			requestLogger(c).Debug("Stream: CDN URL expires too soon to redirect, proxying", "id", song.ID, "expires", trackInfo.Expires)
+			return false
+		}
+	}
+
+	requestLogger(c).Info("Stream: redirecting to CDN", "id", song.ID)
+	c.Redirect(http.StatusFound, trackInfo.DownloadURL)
+
+	// Nothing is teed into the stream cache, so the sync fetches the source itself
+	if c.Request.Method != http.MethodHead {
+		h.syncInBackground(c, song)
+	}
+	return true
+}
+
+// syncInBackground syncs song after the request, detached from its cancellation. Previews
+// are never synced.
+func (h *Handler) syncInBackground(c *gin.Context, song *subsonic.Song) {
+	if h.providers.PreviewOnly(song.ID) {
+		return
+	}
+	syncCtx := context.WithoutCancel(c.Request.Context())
+	safego.Go(func() {
+		if err := h.syncService.SyncSong(syncCtx, song); err != nil {
+			logging.FromContext(syncCtx).Error("Failed to sync song", "id", song.ID, "error", err)
+		}
+	})
+}
+
+// fullRangeLength returns the total size from a Content-Range header such as
+// "bytes 0-12344/12345" when the range spans the whole file, or 0 otherwise
+func fullRangeLength(contentRange string) int64 {
+	span, total, ok := strings.Cut(strings.TrimPrefix(contentRange, "bytes "), "/")
+	if !ok {
+		return 0
 	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil || size <= 0 {
+		return 0
+	}
+	if span != "0-"+strconv.FormatInt(size-1, 10) {
+		return 0
+	}
+	return size
+}
+
+// serveBuffered downloads body, fetched at quality, into the stream cache and serves the
+// finished file, trading a delayed start for an exact Content-Length (STREAM_EXACT_LENGTH).
+// Only qualities the stream cache keeps can be buffered. A download of the same song already
+// in flight is waited for rather than repeated.
+func (h *Handler) serveBuffered(c *gin.Context, song *subsonic.Song, id, quality, contentType string, body io.Reader) {
+	requestLogger(c).Info("Stream: length unknown, buffering before serving", "id", id)
+	cachePath, mimeType, err := h.syncService.BufferStream(c.Request.Context(), id, quality, contentType, body)
+	if err != nil {
+		requestLogger(c).Error("Stream: buffering failed", "id", id, "error", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to buffer upstream stream"})
+		return
+	}
+
+	c.Header("Content-Type", mimeType)
+	h.setDownloadName(c, song, filepath.Ext(cachePath))
+	c.File(cachePath)
+	h.syncInBackground(c, song)
+}
+
+// streamFromOffset serves input from offset seconds in, remuxed through ffmpeg. The length of
+// the result isn't known up front, so there is no Content-Length and no range support.
+func (h *Handler) streamFromOffset(c *gin.Context, id, input, mimeType string, offset int) {
+	requestLogger(c).Info("Stream: seeking with ffmpeg", "id", id, "timeOffset", offset)
+	c.Header("Content-Type", service.SeekContentType(mimeType))
+	c.Header("Accept-Ranges", "none")
+	c.Status(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+	if err := service.SeekStream(c.Request.Context(), input, mimeType, offset, c.Writer); err != nil && c.Request.Context().Err() == nil {
+		requestLogger(c).Error("Stream: error streaming from offset", "id", id, "error", err)
+	}
+}
+
+// transcodeTarget decides whether a stream is re-encoded on the fly: only when the client sent
+// a maxBitRate below the source's bitrate (sourceKbps, 0 for lossless or unknown) and
+// STREAM_MAX_BITRATE allows it. The bitrate is clamped to that ceiling. format=raw opts out.
+func (h *Handler) transcodeTarget(c *gin.Context, sourceKbps int) (format string, kbps int, ok bool) {
+	ceiling := h.cfg.Get().StreamMaxBitRate
+	maxBitRate, err := strconv.Atoi(c.Query("maxBitRate"))
+	if ceiling <= 0 || err != nil || maxBitRate <= 0 || strings.EqualFold(c.Query("format"), "raw") {
+		return "", 0, false
+	}
+	kbps = min(maxBitRate, ceiling)
+	if sourceKbps > 0 && kbps >= sourceKbps {
+		return "", 0, false
+	}
+	return service.TranscodeFormat(c.Query("format")), kbps, true
+}
+
+// streamTranscoded serves input re-encoded through ffmpeg, from offset seconds in. Like
+// streamFromOffset there is no Content-Length and no range support.
+func (h *Handler) streamTranscoded(c *gin.Context, id, input string, offset int, format string, kbps int) {
+	requestLogger(c).Info("Stream: transcoding with ffmpeg", "id", id, "format", format, "kbps", kbps, "timeOffset", offset)
+	c.Header("Content-Type", service.TranscodeContentType(format))
+	c.Header("Accept-Ranges", "none")
+	c.Status(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+
+	// Each transcode is an ffmpeg process, so only STREAM_TRANSCODE_CONCURRENCY run at once
+	select {
+	case h.transcodeSem <- struct{}{}:
+		defer func() { <-h.transcodeSem }()
+	case <-c.Request.Context().Done():
+		return
+	}
+	if err := service.TranscodeStream(c.Request.Context(), input, offset, format, kbps, c.Writer); err != nil && c.Request.Context().Err() == nil {
+		requestLogger(c).Error("Stream: error transcoding stream", "id", id, "error", err)
+	}
+}
+
+// fileBitRate is the average bitrate in kbps of a local file lasting durationSec, or 0 if unknown
+func fileBitRate(path string, durationSec int) int {
+	info, err := os.Stat(path)
+	if err != nil || durationSec <= 0 {
+		return 0
+	}
+	return int(info.Size() * 8 / 1000 / int64(durationSec))
+}
+
+// setDownloadName names the attachment "Artist - Title.ext" on the download endpoints
+func (h *Handler) setDownloadName(c *gin.Context, song *subsonic.Song, ext string) {
+	if base := filepath.Base(c.Request.URL.Path); base != "download" && base != "download.view" {
+		return
+	}
+	name := h.syncService.SanitizePath(song.Title)
+	if song.Artist != "" {
+		name = h.syncService.SanitizePath(song.Artist) + " - " + name
+	}
+	c.Header("Content-Disposition", contentDisposition(name+ext))
+}
+
+// contentDisposition builds an attachment header with an ASCII filename for old clients and
+// the exact UTF-8 name as an RFC 5987 filename* parameter
+func contentDisposition(filename string) string {
+	var ascii, encoded strings.Builder
+	for _, r := range filename {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			ascii.WriteByte('_')
+		} else {
+			ascii.WriteRune(r)
+		}
+	}
+	for _, b := range []byte(filename) {
+		if isRFC5987AttrChar(b) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s", ascii.String(), encoded.String())
+}
+
+// isRFC5987AttrChar reports whether b may appear unescaped in an RFC 5987 value
+func isRFC5987AttrChar(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
+
+// streamBitRate is the bitrate in kbps a proxied stream is expected to have, or 0 if unknown
+func (h *Handler) streamBitRate(c *gin.Context, song *subsonic.Song) int {
+	if song.BitRate > 0 {
+		return song.BitRate
+	}
+	quality := streamQuality(c)
+	if quality == "" {
+		quality = h.cfg.Get().StreamQuality
+	}
+	return service.QualityBitRate(quality)
+}
+
+// streamQuality maps the Subsonic format/maxBitRate params to a Squid quality tier.
+// Returns "" when the client expressed no preference so the configured default applies.
+func streamQuality(c *gin.Context) string {
+	if format := strings.ToUpper(c.Query("format")); service.IsValidQuality(format) {
+		return format
+	}
+
+	if maxBitRate, err := strconv.Atoi(c.Query("maxBitRate")); err == nil && maxBitRate > 0 {
+		switch {
+		case maxBitRate <= 128:
+			return service.QualityLow
+		case maxBitRate <= 320:
+			return service.QualityHigh
+		}
+	}
+
+	return ""
 }
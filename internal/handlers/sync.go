@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"jetstream/internal/safego"
+	"jetstream/internal/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SyncHandler serves /sync, which downloads external albums and artists into the library
+type SyncHandler struct {
+	providers   *service.Providers
+	syncService *service.SyncService
+}
+
+func NewSyncHandler(providers *service.Providers, syncService *service.SyncService) *SyncHandler {
+	return &SyncHandler{
+		providers:   providers,
+		syncService: syncService,
+	}
+}
+
+// syncID returns the id parameter, or sends invalid_id if it's missing, no provider owns it
+// or its provider only streams previews
+func (h *SyncHandler) syncID(c *gin.Context) (string, bool) {
+	id := c.Query("id")
+	if id == "" {
+		sendAdminError(c, http.StatusBadRequest, AdminErrInvalidID, "id is required")
+		return "", false
+	}
+	if _, err := h.providers.For(id); err != nil {
+		sendAdminError(c, http.StatusBadRequest, AdminErrInvalidID, "id doesn't name an external item")
+		return "", false
+	}
+	if h.providers.PreviewOnly(id) {
+		sendAdminError(c, http.StatusBadRequest, AdminErrInvalidID, "id's provider only streams previews, which can't be synced")
+		return "", false
+	}
+	return id, true
+}
+
+// albumProgress is the "progress" event of AlbumStream. A failed track carries the AdminError
+// its error maps to, never the raw error.
+type albumProgress struct {
+	Track  string      `json:"track"`
+	Status string      `json:"status"`
+	Error  *AdminError `json:"error,omitempty"`
+}
+
+// sendAlbumLookupError reports a failed album lookup before any sync started
+func sendAlbumLookupError(c *gin.Context, id string, err error) {
+	if errors.Is(err, service.ErrNotFound) && !errors.Is(err, service.ErrUpstream) {
+		requestLogger(c).Warn("Sync: album not found", "id", id, "error", err)
+		sendAdminError(c, http.StatusNotFound, AdminErrNotFound, "Album not found")
+		return
+	}
+	sendAdminFailure(c, "Failed to fetch album info", err)
+}
+
+// Album syncs every track of an album and answers once they're all done. The sync runs to
+// completion even if the client disconnects.
+func (h *SyncHandler) Album(c *gin.Context) {
+	id, ok := h.syncID(c)
+	if !ok {
+		return
+	}
+	album, songs, err := h.providers.GetAlbum(c.Request.Context(), id)
+	if err != nil {
+		sendAlbumLookupError(c, id, err)
+		return
+	}
+	if err := h.syncService.SyncAlbum(context.WithoutCancel(c.Request.Context()), album, songs, nil); err != nil {
+		sendAdminFailure(c, "Failed to sync album", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "synced", "id": id})
+}
+
+// AlbumStream syncs an album like Album, reporting per-track progress as server-sent events
+// followed by a "complete" event
+func (h *SyncHandler) AlbumStream(c *gin.Context) {
+	id, ok := h.syncID(c)
+	if !ok {
+		return
+	}
+	// Tied to the request so a client disconnect aborts the sync
+	ctx := c.Request.Context()
+	album, songs, err := h.providers.GetAlbum(ctx, id)
+	if err != nil {
+		sendAlbumLookupError(c, id, err)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	progress := make(chan service.SyncProgress)
+	var syncErr error
+	go func() {
+		defer close(progress)
+		defer safego.Recover()
+		syncErr = h.syncService.SyncAlbum(ctx, album, songs, progress)
+	}()
+
+	for p := range progress {
+		event := albumProgress{Track: p.Track, Status: p.Status}
+		if p.Err != nil {
+			_, body := adminFailure(p.Err, "Failed to sync track")
+			event.Error = &body
+		}
+		c.SSEvent("progress", event)
+		c.Writer.Flush()
+	}
+
+	if ctx.Err() != nil {
+		return // Client went away
+	}
+	result := gin.H{"status": "synced", "id": id}
+	if syncErr != nil {
+		requestLogger(c).Error("Failed to sync album", "id", id, "error", syncErr)
+		_, body := adminFailure(syncErr, "Failed to sync album")
+		result = gin.H{"status": "error", "id": id, "error": body}
+	}
+	c.SSEvent("complete", result)
+	c.Writer.Flush()
+}
+
+// Artist syncs every album of an artist
+func (h *SyncHandler) Artist(c *gin.Context) {
+	id, ok := h.syncID(c)
+	if !ok {
+		return
+	}
+	result, err := h.syncService.SyncArtist(context.WithoutCancel(c.Request.Context()), id)
+	if err != nil {
+		sendAdminFailure(c, "Failed to sync artist", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "synced",
+		"id":      id,
+		"albums":  result.Albums,
+		"synced":  result.Synced,
+		"skipped": result.Skipped,
+		"failed":  result.Failed,
+	})
+}
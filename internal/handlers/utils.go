@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"jetstream/internal/logging"
 	"jetstream/internal/service"
 	"jetstream/pkg/subsonic"
-	"log"
 	"log/slog"
 	"net/http"
 	"net/url"
@@ -15,7 +17,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/bogem/id3v2/v2"
 	"github.com/gin-gonic/gin"
 )
 
@@ -32,7 +33,7 @@ func CORSMiddleware() gin.HandlerFunc {
 
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Subsonic-Version, X-Subsonic-Client, X-ND-Authorization, X-ND-AppId")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
-		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, X-Subsonic-Version, X-Subsonic-Status")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, X-Subsonic-Version, X-Subsonic-Status, X-JetStream-Stale, X-Content-Duration")
 
 		// Add Subsonic specific headers that some clients expect
 		c.Writer.Header().Set("X-Subsonic-Version", "1.16.1")
@@ -47,6 +48,24 @@ func CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
+// BodyLimitMiddleware rejects request bodies larger than limit bytes with 413. Bodies without a
+// declared length are cut off at the limit instead. A limit of 0 or less disables the check.
+func BodyLimitMiddleware(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limit <= 0 || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > limit {
+			requestLogger(c).Warn("Request body too large", "length", c.Request.ContentLength, "limit", limit)
+			c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
 type bodyLogWriter struct {
 	gin.ResponseWriter
 	body *strings.Builder
@@ -59,6 +78,36 @@ func (w bodyLogWriter) Write(b []byte) (int, error) {
 	return w.ResponseWriter.Write(b)
 }
 
+// requestLogger returns the logger carrying this request's ID
+func requestLogger(c *gin.Context) *slog.Logger {
+	return logging.FromContext(c.Request.Context())
+}
+
+// RequestLoggingMiddleware tags each request with an ID (reusing a sane incoming X-Request-ID),
+// puts a logger carrying it on the request context, and logs a one-line summary when done
+func RequestLoggingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-ID")
+		if requestID == "" || len(requestID) > 64 {
+			requestID = logging.NewRequestID()
+		}
+		c.Set("requestID", requestID)
+		c.Header("X-Request-ID", requestID)
+		ctx := logging.WithRequestID(c.Request.Context(), requestID)
+		c.Request = c.Request.WithContext(service.WithStaleTracking(ctx))
+
+		start := time.Now()
+		c.Next()
+
+		logging.FromContext(c.Request.Context()).Info("Request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency", time.Since(start),
+		)
+	}
+}
+
 // DebugLoggingMiddleware logs the full request and response for debugging
 func DebugLoggingMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -82,7 +131,8 @@ func DebugLoggingMiddleware() gin.HandlerFunc {
 			}
 		}
 
-		slog.Info("Incoming Request",
+		logger := logging.FromContext(c.Request.Context())
+		logger.Debug("Incoming Request",
 			"method", c.Request.Method,
 			"url", c.Request.URL.String(),
 			"headers", headers,
@@ -91,7 +141,7 @@ func DebugLoggingMiddleware() gin.HandlerFunc {
 		c.Next()
 
 		latency := time.Since(start)
-		slog.Info("Outgoing Response",
+		logger.Debug("Outgoing Response",
 			"status", c.Writer.Status(),
 			"latency", latency,
 			"url", c.Request.URL.String(),
@@ -99,21 +149,55 @@ func DebugLoggingMiddleware() gin.HandlerFunc {
 	}
 }
 
-// SendSubsonicResponse sends a response in either XML or JSON format based on the 'f' query parameter.
+// jsonpCallbackRegex accepts a JavaScript identifier path such as "cb" or "app.handlers.cb",
+// so the callback can't smuggle script into the response
+var jsonpCallbackRegex = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+// SendSubsonicResponse sends a response in XML, JSON or JSONP format based on the 'f' query parameter.
 func SendSubsonicResponse(c *gin.Context, resp subsonic.Response) {
 	// Add Subsonic specific headers that some clients expect
 	c.Writer.Header().Set("X-Subsonic-Version", "1.16.1")
 	c.Writer.Header().Set("X-Subsonic-Status", "ok")
+	if service.ServedStale(c.Request.Context()) {
+		c.Writer.Header().Set("X-JetStream-Stale", "true")
+	}
 
-	format := c.Query("f")
-	if format == "json" {
+	switch c.Query("f") {
+	case "json":
 		c.JSON(http.StatusOK, gin.H{"subsonic-response": resp})
-	} else {
+	case "jsonp":
+		if !jsonpCallbackRegex.MatchString(c.Query("callback")) {
+			// Without a usable callback there is nothing to wrap the error in; send it as JSON
+			c.JSON(http.StatusOK, gin.H{"subsonic-response": subsonic.Response{
+				Status:  subsonic.StatusFailed,
+				Version: subsonic.Version,
+				Error: &subsonic.Error{
+					Code:    subsonic.ErrRequiredParameter,
+					Message: "Missing or invalid callback parameter",
+				},
+			}})
+			return
+		}
+		// Wraps the JSON body in callback(...) as application/javascript
+		c.JSONP(http.StatusOK, gin.H{"subsonic-response": resp})
+	default:
 		// Default to XML, which is the standard for Subsonic
 		c.XML(http.StatusOK, resp)
 	}
 }
 
+// sendLookupError reports a failed external lookup without leaking internal error text:
+// missing resources and malformed IDs become "data not found" (70), upstream outages a generic
+// error the client may retry
+func sendLookupError(c *gin.Context, what, id string, err error) {
+	requestLogger(c).Error(what+" lookup failed", "id", id, "error", err)
+	if errors.Is(err, service.ErrNotFound) && !errors.Is(err, service.ErrUpstream) {
+		SendSubsonicError(c, subsonic.ErrDataNotFound, what+" not found")
+		return
+	}
+	SendSubsonicError(c, subsonic.ErrGeneric, "Upstream service temporarily unavailable, try again later")
+}
+
 // SendSubsonicError sends a standardized Subsonic error response.
 func SendSubsonicError(c *gin.Context, code int, message string) {
 	resp := subsonic.Response{
@@ -127,15 +211,124 @@ func SendSubsonicError(c *gin.Context, code int, message string) {
 	SendSubsonicResponse(c, resp)
 }
 
+// Codes carried by AdminError, one per failure mode an admin client may want to handle
+const (
+	AdminErrInvalidID   = "invalid_id"       // Missing, or not an ID any provider owns
+	AdminErrNotFound    = "not_found"        // The provider or library has no such item
+	AdminErrUpstream    = "upstream_failure" // Every mirror failed; worth retrying later
+	AdminErrFFmpeg      = "ffmpeg_failure"   // A download couldn't be transcoded
+	AdminErrLibraryFull = "library_full"     // LIBRARY_MAX_BYTES couldn't make room
+	AdminErrUnavailable = "unavailable"      // Shutting down or the request was cancelled
+	AdminErrInternal    = "internal_error"
+)
+
+// AdminError is the error body of the /sync and /maintenance routes, sent as
+// {"error":{"code":...,"message":...}}. Messages are fixed strings safe to show; the underlying
+// error is only logged.
+type AdminError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// sendAdminError sends an AdminError with the given status
+func sendAdminError(c *gin.Context, status int, code, message string) {
+	c.JSON(status, gin.H{"error": AdminError{Code: code, Message: message}})
+}
+
+// adminFailure maps a service error to a status and AdminError. fallback is the message for
+// errors that match no known failure mode.
+func adminFailure(err error, fallback string) (int, AdminError) {
+	switch {
+	case errors.Is(err, service.ErrShuttingDown), errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable, AdminError{AdminErrUnavailable, "Server is shutting down or the request was cancelled"}
+	case errors.Is(err, service.ErrLibraryFull):
+		return http.StatusInsufficientStorage, AdminError{AdminErrLibraryFull, "Library is full and nothing could be evicted"}
+	case errors.Is(err, service.ErrTranscode):
+		return http.StatusInternalServerError, AdminError{AdminErrFFmpeg, "Transcoding failed"}
+	case errors.Is(err, service.ErrUpstream):
+		return http.StatusBadGateway, AdminError{AdminErrUpstream, "Upstream service temporarily unavailable, try again later"}
+	case errors.Is(err, service.ErrNotFound):
+		return http.StatusNotFound, AdminError{AdminErrNotFound, "Not found"}
+	}
+	return http.StatusInternalServerError, AdminError{AdminErrInternal, fallback}
+}
+
+// sendAdminFailure logs err and sends the AdminError it maps to
+func sendAdminFailure(c *gin.Context, fallback string, err error) {
+	requestLogger(c).Error(fallback, "error", err)
+	status, body := adminFailure(err, fallback)
+	c.JSON(status, gin.H{"error": body})
+}
+
 var idInPathRegex = regexp.MustCompile(`\[(ext-[^\]]+)\]`)
 
+// resolveCachePrefix keys cached Navidrome ID resolutions by kind and Navidrome ID
+const resolveCachePrefix = "jetstream:resolve:"
+
+// notVirtual is cached for Navidrome IDs that didn't resolve to an external ID
+const notVirtual = "__LOCAL__"
+
+// cachedResolve remembers what a Navidrome ID resolved to so repeated requests skip the
+// Navidrome round-trip and any search. Misses are kept for NEGATIVE_CACHE_TTL so they get
+// retried, and errors aren't cached at all. The key isn't per user, so resolve must return
+// an error, not a miss, when Navidrome refused the request or the search couldn't run.
+func cachedResolve(c *gin.Context, squid *service.SquidService, kind, navidromeID string, resolve func() (string, bool, error)) (string, bool, error) {
+	ctx := c.Request.Context()
+	cacheKey := resolveCachePrefix + kind + ":" + navidromeID
+	if val, err := squid.GetCache().Get(ctx, cacheKey); err == nil && val != "" {
+		if val == notVirtual {
+			return navidromeID, false, nil
+		}
+		requestLogger(c).Debug("Resolved from cache", "kind", kind, "id", navidromeID, "resolved", val)
+		return val, true, nil
+	}
+
+	resolved, isVirtual, err := resolve()
+	if err != nil {
+		return resolved, isVirtual, err
+	}
+
+	cfg := squid.GetConfig()
+	if isVirtual && cfg.ResolveCacheTTL > 0 {
+		squid.GetCache().Set(ctx, cacheKey, resolved, cfg.ResolveCacheTTL)
+	} else if !isVirtual && cfg.NegativeCacheTTL > 0 {
+		squid.GetCache().Set(ctx, cacheKey, notVirtual, cfg.NegativeCacheTTL)
+	}
+	return resolved, isVirtual, nil
+}
+
 // ResolveVirtualID attempts to find an external ID (ext-...) for a given Navidrome ID.
-func ResolveVirtualID(c *gin.Context, proxy *ProxyHandler, squid *service.SquidService, navidromeID string) (string, bool, error) {
+func ResolveVirtualID(c *gin.Context, proxy *ProxyHandler, squid *service.SquidService, syncService *service.SyncService, navidromeID string) (string, bool, error) {
 	if strings.HasPrefix(navidromeID, "ext-") {
 		return navidromeID, true, nil
 	}
+	return cachedResolve(c, squid, "song", navidromeID, func() (string, bool, error) {
+		return resolveVirtualID(c, proxy, squid, syncService, navidromeID)
+	})
+}
+
+// navidromeRefusal returns the error for a Navidrome response whose status isn't ok, such as
+// wrong credentials, or nil for an ok one
+func navidromeRefusal(status string, refusal *subsonic.Error) error {
+	if status == subsonic.StatusOk {
+		return nil
+	}
+	if refusal != nil {
+		return fmt.Errorf("navidrome refused the request: %s (%d)", refusal.Message, refusal.Code)
+	}
+	return fmt.Errorf("navidrome returned status %q", status)
+}
+
+// searchMissed reports whether a Squid search answered that nothing matched, as opposed to
+// failing to run
+func searchMissed(err error) bool {
+	return errors.Is(err, service.ErrNotFound) && !errors.Is(err, service.ErrUpstream)
+}
 
-	slog.Debug("Attempting to resolve Navidrome ID", "navidromeID", navidromeID)
+// resolveVirtualID is the uncached lookup behind ResolveVirtualID
+func resolveVirtualID(c *gin.Context, proxy *ProxyHandler, squid *service.SquidService, syncService *service.SyncService, navidromeID string) (string, bool, error) {
+
+	requestLogger(c).Debug("Attempting to resolve Navidrome ID", "navidromeID", navidromeID)
 
 	// Force XML and let http.Client handle decompression
 	parsedURL, _ := url.Parse(proxy.GetTargetURL() + "/rest/getSong.view")
@@ -144,20 +337,21 @@ func ResolveVirtualID(c *gin.Context, proxy *ProxyHandler, squid *service.SquidS
 	q.Set("f", "xml")
 	parsedURL.RawQuery = q.Encode()
 
-	req, _ := http.NewRequest("GET", parsedURL.String(), nil)
+	req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", parsedURL.String(), nil)
 	req.Header = c.Request.Header.Clone()
 	req.Header.Del("Accept-Encoding")
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := proxy.Navidrome().Do(req)
 	if err != nil {
-		slog.Error("Querying Navidrome", "error", err)
+		requestLogger(c).Error("Querying Navidrome", "error", err)
 		return "", false, err
 	}
 	defer resp.Body.Close()
 
 	var result struct {
-		XMLName xml.Name `xml:"subsonic-response"`
+		XMLName xml.Name        `xml:"subsonic-response"`
+		Status  string          `xml:"status,attr"`
+		Error   *subsonic.Error `xml:"error"`
 		Song    struct {
 			Path   string `xml:"path,attr"`
 			Artist string `xml:"artist,attr"`
@@ -166,12 +360,15 @@ func ResolveVirtualID(c *gin.Context, proxy *ProxyHandler, squid *service.SquidS
 	}
 
 	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
-		slog.Error("Decoding Navidrome response", "error", err)
+		requestLogger(c).Error("Decoding Navidrome response", "error", err)
+		return "", false, err
+	}
+	if err := navidromeRefusal(result.Status, result.Error); err != nil {
 		return "", false, err
 	}
 
-	slog.Debug("Navidrome reported path", "path", result.Song.Path)
-	slog.Debug("Navidrome reported metadata", "metadata", fmt.Sprintf("%s - %s", result.Song.Artist, result.Song.Title))
+	requestLogger(c).Debug("Navidrome reported path", "path", result.Song.Path)
+	requestLogger(c).Debug("Navidrome reported metadata", "metadata", fmt.Sprintf("%s - %s", result.Song.Artist, result.Song.Title))
 
 	if result.Song.Path == "" {
 		return navidromeID, false, nil
@@ -180,11 +377,11 @@ func ResolveVirtualID(c *gin.Context, proxy *ProxyHandler, squid *service.SquidS
 	// 1. Try Path-based Resolution first (Fastest)
 	match := idInPathRegex.FindStringSubmatch(result.Song.Path)
 	if len(match) > 1 {
-		slog.Info("Resolved from path", "id", navidromeID, "resolved", match[1])
+		requestLogger(c).Info("Resolved from path", "id", navidromeID, "resolved", match[1])
 		return match[1], true, nil
 	}
 
-	// 2. Try ID3 Tag Resolution & Ghost Detection
+	// 2. Try TIDAL_ID Tag Resolution & Ghost Detection
 	fullPath := result.Song.Path
 	if !filepath.IsAbs(fullPath) {
 		fullPath = filepath.Join("/music", result.Song.Path)
@@ -194,46 +391,39 @@ func ResolveVirtualID(c *gin.Context, proxy *ProxyHandler, squid *service.SquidS
 	if info, err := os.Stat(fullPath); err == nil {
 		if info.Mode().IsRegular() {
 			// Ghost check: Dummy files with covers can still be up to 100-200KB
-			if info.Size() < 256*1024 { // 256KB threshold
+			if syncService.IsGhostFile(fullPath) {
 				isGhost = true
-				slog.Debug("File is small, treating as virtual/ghost", "path", fullPath, "size", info.Size())
+				requestLogger(c).Debug("File is small, treating as virtual/ghost", "path", fullPath, "size", info.Size())
 			}
 
 			// Check tags regardless of size if it's a regular file
-			tag, err := id3v2.Open(fullPath, id3v2.Options{Parse: true})
-			if err == nil {
-				defer tag.Close()
-				frames := tag.GetFrames(tag.CommonID("User defined text information"))
-				for _, f := range frames {
-					utcf, ok := f.(id3v2.UserDefinedTextFrame)
-					if ok && utcf.Description == "TIDAL_ID" {
-						slog.Info("Resolved from ID3 tag", "id", navidromeID, "resolved", utcf.Value)
-						return utcf.Value, true, nil
-					}
-
-				}
-			} else {
-				slog.Debug("Could not read ID3 tags", "path", fullPath, "error", err)
+			if id := service.ReadTidalID(fullPath); id != "" {
+				requestLogger(c).Info("Resolved from TIDAL_ID tag", "id", navidromeID, "resolved", id)
+				return id, true, nil
 			}
+			requestLogger(c).Debug("No TIDAL_ID tag", "path", fullPath)
 
 		}
 	} else if os.IsNotExist(err) {
-		slog.Debug("File not found on disk, treating as virtual", "path", fullPath)
+		requestLogger(c).Debug("File not found on disk, treating as virtual", "path", fullPath)
 		isGhost = true
 	}
 
 	// 3. Robust Metadata Search Fallback (Self-Healing)
 	if isGhost && result.Song.Artist != "" && result.Song.Title != "" {
-		slog.Warn("Performing search lookup", "artist", result.Song.Artist, "title", result.Song.Title)
+		requestLogger(c).Warn("Performing search lookup", "artist", result.Song.Artist, "title", result.Song.Title)
 		resolvedID, err := squid.SearchOne(c.Request.Context(), result.Song.Artist, result.Song.Title)
 		if err == nil {
-			slog.Info("Self-healed via metadata search", "id", navidromeID, "resolved", resolvedID)
+			requestLogger(c).Info("Self-healed via metadata search", "id", navidromeID, "resolved", resolvedID)
 			return resolvedID, true, nil
 		}
-		slog.Error("Fallback search failed", "artist", result.Song.Artist, "title", result.Song.Title, "error", err)
+		requestLogger(c).Error("Fallback search failed", "artist", result.Song.Artist, "title", result.Song.Title, "error", err)
+		if !searchMissed(err) {
+			return "", false, err
+		}
 	}
 
-	log.Printf("[Resolver] [FAIL] Could not resolve %s to external ID", navidromeID)
+	logging.FromContext(c.Request.Context()).Warn("Could not resolve to external ID", "navidromeID", navidromeID)
 	return navidromeID, false, nil
 }
 
@@ -242,8 +432,15 @@ func ResolveVirtualArtistID(c *gin.Context, proxy *ProxyHandler, squid *service.
 	if strings.HasPrefix(navidromeID, "ext-") {
 		return navidromeID, true, nil
 	}
+	return cachedResolve(c, squid, "artist", navidromeID, func() (string, bool, error) {
+		return resolveVirtualArtistID(c, proxy, squid, navidromeID)
+	})
+}
 
-	slog.Debug("Resolving Artist ID", "id", navidromeID)
+// resolveVirtualArtistID is the uncached lookup behind ResolveVirtualArtistID
+func resolveVirtualArtistID(c *gin.Context, proxy *ProxyHandler, squid *service.SquidService, navidromeID string) (string, bool, error) {
+
+	requestLogger(c).Debug("Resolving Artist ID", "id", navidromeID)
 
 	parsedURL, _ := url.Parse(proxy.GetTargetURL() + "/rest/getArtist.view")
 	q := c.Request.URL.Query()
@@ -251,19 +448,20 @@ func ResolveVirtualArtistID(c *gin.Context, proxy *ProxyHandler, squid *service.
 	q.Set("f", "xml")
 	parsedURL.RawQuery = q.Encode()
 
-	req, _ := http.NewRequest("GET", parsedURL.String(), nil)
+	req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", parsedURL.String(), nil)
 	req.Header = c.Request.Header.Clone()
 	req.Header.Del("Accept-Encoding")
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := proxy.Navidrome().Do(req)
 	if err != nil {
 		return "", false, err
 	}
 	defer resp.Body.Close()
 
 	var result struct {
-		XMLName xml.Name `xml:"subsonic-response"`
+		XMLName xml.Name        `xml:"subsonic-response"`
+		Status  string          `xml:"status,attr"`
+		Error   *subsonic.Error `xml:"error"`
 		Artist  struct {
 			Name string `xml:"name,attr"`
 		} `xml:"artist"`
@@ -271,6 +469,9 @@ func ResolveVirtualArtistID(c *gin.Context, proxy *ProxyHandler, squid *service.
 	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return "", false, err
 	}
+	if err := navidromeRefusal(result.Status, result.Error); err != nil {
+		return "", false, err
+	}
 
 	if result.Artist.Name == "" {
 		return navidromeID, false, nil
@@ -278,9 +479,12 @@ func ResolveVirtualArtistID(c *gin.Context, proxy *ProxyHandler, squid *service.
 
 	resolvedID, err := squid.SearchOneArtist(c.Request.Context(), result.Artist.Name)
 	if err == nil {
-		slog.Info("Resolved Artist", "id", navidromeID, "resolved", resolvedID, "name", result.Artist.Name)
+		requestLogger(c).Info("Resolved Artist", "id", navidromeID, "resolved", resolvedID, "name", result.Artist.Name)
 		return resolvedID, true, nil
 	}
+	if !searchMissed(err) {
+		return "", false, err
+	}
 
 	return navidromeID, false, nil
 }
@@ -290,8 +494,15 @@ func ResolveVirtualAlbumID(c *gin.Context, proxy *ProxyHandler, squid *service.S
 	if strings.HasPrefix(navidromeID, "ext-") {
 		return navidromeID, true, nil
 	}
+	return cachedResolve(c, squid, "album", navidromeID, func() (string, bool, error) {
+		return resolveVirtualAlbumID(c, proxy, squid, navidromeID)
+	})
+}
+
+// resolveVirtualAlbumID is the uncached lookup behind ResolveVirtualAlbumID
+func resolveVirtualAlbumID(c *gin.Context, proxy *ProxyHandler, squid *service.SquidService, navidromeID string) (string, bool, error) {
 
-	slog.Debug("Resolving Album ID", "id", navidromeID)
+	requestLogger(c).Debug("Resolving Album ID", "id", navidromeID)
 
 	parsedURL, _ := url.Parse(proxy.GetTargetURL() + "/rest/getAlbum.view")
 	q := c.Request.URL.Query()
@@ -299,19 +510,20 @@ func ResolveVirtualAlbumID(c *gin.Context, proxy *ProxyHandler, squid *service.S
 	q.Set("f", "xml")
 	parsedURL.RawQuery = q.Encode()
 
-	req, _ := http.NewRequest("GET", parsedURL.String(), nil)
+	req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", parsedURL.String(), nil)
 	req.Header = c.Request.Header.Clone()
 	req.Header.Del("Accept-Encoding")
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := proxy.Navidrome().Do(req)
 	if err != nil {
 		return "", false, err
 	}
 	defer resp.Body.Close()
 
 	var result struct {
-		XMLName xml.Name `xml:"subsonic-response"`
+		XMLName xml.Name        `xml:"subsonic-response"`
+		Status  string          `xml:"status,attr"`
+		Error   *subsonic.Error `xml:"error"`
 		Album   struct {
 			Title  string `xml:"title,attr"`
 			Artist string `xml:"artist,attr"`
@@ -320,6 +532,9 @@ func ResolveVirtualAlbumID(c *gin.Context, proxy *ProxyHandler, squid *service.S
 	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return "", false, err
 	}
+	if err := navidromeRefusal(result.Status, result.Error); err != nil {
+		return "", false, err
+	}
 
 	if result.Album.Title == "" {
 		return navidromeID, false, nil
@@ -327,9 +542,12 @@ func ResolveVirtualAlbumID(c *gin.Context, proxy *ProxyHandler, squid *service.S
 
 	resolvedID, err := squid.SearchOneAlbum(c.Request.Context(), result.Album.Artist, result.Album.Title)
 	if err == nil {
-		slog.Info("Resolved Album", "id", navidromeID, "resolved", resolvedID, "artist", result.Album.Artist, "title", result.Album.Title)
+		requestLogger(c).Info("Resolved Album", "id", navidromeID, "resolved", resolvedID, "artist", result.Album.Artist, "title", result.Album.Title)
 		return resolvedID, true, nil
 	}
+	if !searchMissed(err) {
+		return "", false, err
+	}
 
 	return navidromeID, false, nil
 }
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jetstream/internal/config"
+	"jetstream/internal/service"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"shutting down", service.ErrShuttingDown, http.StatusServiceUnavailable, AdminErrUnavailable},
+		{"cancelled", context.Canceled, http.StatusServiceUnavailable, AdminErrUnavailable},
+		{"library full", service.ErrLibraryFull, http.StatusInsufficientStorage, AdminErrLibraryFull},
+		{"ffmpeg", fmt.Errorf("%w: ffmpeg: exit status 1", service.ErrTranscode), http.StatusInternalServerError, AdminErrFFmpeg},
+		{"upstream", fmt.Errorf("all mirrors failed: %w", service.ErrUpstream), http.StatusBadGateway, AdminErrUpstream},
+		{"not found", service.ErrNotFound, http.StatusNotFound, AdminErrNotFound},
+		// SyncAlbum joins its track errors; the first known failure mode decides
+		{"joined track errors", fmt.Errorf("2 of 9 tracks failed to sync: %w",
+			errors.Join(fmt.Errorf("%w: ffmpeg", service.ErrTranscode), service.ErrNotFound)), http.StatusInternalServerError, AdminErrFFmpeg},
+		{"unknown", errors.New("disk on fire"), http.StatusInternalServerError, AdminErrInternal},
+	}
+	for _, tt := range tests {
+		status, body := adminFailure(tt.err, "Failed to sync")
+		if status != tt.status || body.Code != tt.code {
+			t.Errorf("%s: got %d %s, want %d %s", tt.name, status, body.Code, tt.status, tt.code)
+		}
+		if body.Message == tt.err.Error() {
+			t.Errorf("%s: message leaks the raw error %q", tt.name, body.Message)
+		}
+	}
+
+	if _, body := adminFailure(errors.New("disk on fire"), "Failed to sync"); body.Message != "Failed to sync" {
+		t.Errorf("unknown error message = %q, want the fallback", body.Message)
+	}
+}
+
+// resolveTestContext returns a gin context for a getSong request for id made as user
+func resolveTestContext(user, id string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/rest/getSong.view?u="+user+"&p=secret&id="+id, nil)
+	return c
+}
+
+// A refusal from Navidrome or a failed Squid search is an error, never a miss, so it can't
+// leave a negative entry that sends every other user's request to the placeholder
+func TestResolveVirtualIDDoesNotCacheFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	paths := map[string]string{
+		"nd-ghost": filepath.Join(dir, "Artist - Ghost.flac"), // Never created
+		"nd-local": filepath.Join(dir, "Artist - Local.mp3"),
+	}
+	if err := os.WriteFile(paths["nd-local"], make([]byte, 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	navidrome := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("u") != "alice" {
+			fmt.Fprint(w, `<subsonic-response status="failed"><error code="40" message="Wrong username or password"/></subsonic-response>`)
+			return
+		}
+		id := r.URL.Query().Get("id")
+		fmt.Fprintf(w, `<subsonic-response status="ok"><song id=%q path=%q artist="Artist" title="Song"/></subsonic-response>`, id, paths[id])
+	}))
+	defer navidrome.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer mirror.Close()
+
+	cfg := &config.Config{
+		NavidromeURL:       navidrome.URL,
+		SquidURLs:          []string{mirror.URL},
+		SquidMaxPasses:     1,
+		SearchCategories:   []string{"songs"},
+		CacheBackend:       "memory",
+		CacheEntries:       10,
+		GhostSizeThreshold: 512,
+		NegativeCacheTTL:   time.Hour,
+		ResolveCacheTTL:    time.Hour,
+	}
+	live := config.NewLive(cfg)
+	squid := service.NewSquidService(live)
+	sync := service.NewSyncService(squid, nil, live)
+	proxy := NewProxyHandler(cfg)
+	cached := func(id string) string {
+		val, _ := squid.GetCache().Get(context.Background(), resolveCachePrefix+"song:"+id)
+		return val
+	}
+
+	if _, _, err := ResolveVirtualID(resolveTestContext("mallory", "nd-local"), proxy, squid, sync, "nd-local"); err == nil {
+		t.Error("wrong credentials: err = nil, want the Navidrome refusal")
+	}
+	if val := cached("nd-local"); val != "" {
+		t.Errorf("wrong credentials cached %q", val)
+	}
+
+	if _, _, err := ResolveVirtualID(resolveTestContext("alice", "nd-ghost"), proxy, squid, sync, "nd-ghost"); !errors.Is(err, service.ErrUpstream) {
+		t.Errorf("squid outage: err = %v, want ErrUpstream", err)
+	}
+	if val := cached("nd-ghost"); val != "" {
+		t.Errorf("squid outage cached %q", val)
+	}
+
+	// A file Navidrome really has on disk is the one miss worth remembering
+	if _, isVirtual, err := ResolveVirtualID(resolveTestContext("alice", "nd-local"), proxy, squid, sync, "nd-local"); err != nil || isVirtual {
+		t.Errorf("local song: isVirtual = %v, err = %v; want a local miss", isVirtual, err)
+	}
+	if val := cached("nd-local"); val != notVirtual {
+		t.Errorf("local song cached %q, want %q", val, notVirtual)
+	}
+}
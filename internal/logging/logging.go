@@ -0,0 +1,34 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+)
+
+type ctxKey struct{}
+
+// NewRequestID returns a short random ID for correlating a request's log lines
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a context whose logger tags every line with requestID
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, slog.Default().With("requestID", requestID))
+}
+
+// FromContext returns the request-scoped logger, or the default logger outside a request
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
@@ -0,0 +1,171 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"jetstream/internal/cache"
+	"jetstream/internal/config"
+	"jetstream/internal/logging"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	musicBrainzSearchURL = "https://musicbrainz.org/ws/2/recording"
+	musicBrainzUserAgent = "JetStream/1.0 ( https://github.com/juanqp07/JetStream )"
+	musicBrainzCacheKey  = "jetstream:musicbrainz:"
+
+	// Minimum search score (0-100) for a recording to be trusted
+	minMatchScore = 90
+)
+
+// MusicBrainzIDs are the identifiers written into synced files
+type MusicBrainzIDs struct {
+	TrackID string `json:"trackId,omitempty"` // Recording MBID
+	AlbumID string `json:"albumId,omitempty"` // Release MBID
+}
+
+// MusicBrainz resolves recordings by artist+title. Lookups are cached
+// and throttled to the 1 req/sec limit of the public API.
+type MusicBrainz struct {
+	enabled bool
+	cache   cache.Cache
+	client  *http.Client
+
+	mu       sync.Mutex
+	lastCall time.Time
+}
+
+func NewMusicBrainz(cfg *config.Config, c cache.Cache) *MusicBrainz {
+	return &MusicBrainz{
+		enabled: cfg.EnrichMusicBrainz,
+		cache:   c,
+		client:  &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Enabled reports whether enrichment was opted into via ENRICH_MUSICBRAINZ
+func (m *MusicBrainz) Enabled() bool {
+	return m.enabled
+}
+
+type recordingSearch struct {
+	Recordings []struct {
+		ID       string `json:"id"`
+		Score    int    `json:"score"`
+		Releases []struct {
+			ID    string `json:"id"`
+			Title string `json:"title"`
+		} `json:"releases"`
+	} `json:"recordings"`
+}
+
+// LookupRecording returns the IDs of the best confident match, or nil when there is none
+func (m *MusicBrainz) LookupRecording(ctx context.Context, artist, title, album string) (*MusicBrainzIDs, error) {
+	if !m.enabled || artist == "" || title == "" {
+		return nil, nil
+	}
+
+	cacheKey := musicBrainzCacheKey + strings.ToLower(artist+"|"+title+"|"+album)
+	if cached, err := m.cache.Get(ctx, cacheKey); err == nil {
+		var ids MusicBrainzIDs
+		if json.Unmarshal([]byte(cached), &ids) == nil {
+			if ids.TrackID == "" {
+				return nil, nil // Cached miss
+			}
+			return &ids, nil
+		}
+	}
+
+	if err := m.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	q.Set("query", fmt.Sprintf("recording:%s AND artist:%s", luceneQuote(title), luceneQuote(artist)))
+	q.Set("fmt", "json")
+	q.Set("limit", "5")
+
+	req, err := http.NewRequestWithContext(ctx, "GET", musicBrainzSearchURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", musicBrainzUserAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("musicbrainz: HTTP %d", resp.StatusCode)
+	}
+
+	var result recordingSearch
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	ids := MusicBrainzIDs{}
+	for _, rec := range result.Recordings {
+		if rec.Score < minMatchScore {
+			continue
+		}
+		ids.TrackID = rec.ID
+		// Prefer the release matching our album title, otherwise take the first one
+		for _, rel := range rec.Releases {
+			if strings.EqualFold(rel.Title, album) {
+				ids.AlbumID = rel.ID
+				break
+			}
+		}
+		if ids.AlbumID == "" && len(rec.Releases) > 0 {
+			ids.AlbumID = rec.Releases[0].ID
+		}
+		break
+	}
+
+	// Misses are cached too so unmatched tracks don't keep hitting the API
+	ttl := 30 * 24 * time.Hour
+	if ids.TrackID == "" {
+		ttl = 7 * 24 * time.Hour
+	}
+	if data, err := json.Marshal(ids); err == nil {
+		m.cache.Set(ctx, cacheKey, string(data), ttl)
+	}
+
+	if ids.TrackID == "" {
+		logging.FromContext(ctx).Debug("No confident MusicBrainz match", "artist", artist, "title", title)
+		return nil, nil
+	}
+	return &ids, nil
+}
+
+// wait blocks until at least one second has passed since the previous request
+func (m *MusicBrainz) wait(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if delay := time.Second - time.Since(m.lastCall); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	m.lastCall = time.Now()
+	return nil
+}
+
+// luceneQuote wraps a term in quotes, escaping characters with meaning inside a phrase
+func luceneQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,33 @@
+// Package safego keeps a panic in a background goroutine from taking down the server. gin's
+// recovery middleware only covers the request goroutine itself.
+package safego
+
+import (
+	"log/slog"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+var panics atomic.Int64
+
+// Go runs fn in a new goroutine, logging and counting a panic instead of crashing
+func Go(fn func()) {
+	go func() {
+		defer Recover()
+		fn()
+	}()
+}
+
+// Recover logs and counts a panic. Defer it first thing in goroutines that can't use Go, e.g.
+// ones taking arguments; it must be called directly by the deferred statement to work.
+func Recover() {
+	if r := recover(); r != nil {
+		panics.Add(1)
+		slog.Error("Recovered from panic in background goroutine", "panic", r, "stack", string(debug.Stack()))
+	}
+}
+
+// Panics returns how many background panics have been recovered since startup
+func Panics() int64 {
+	return panics.Load()
+}
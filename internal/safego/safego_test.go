@@ -0,0 +1,59 @@
+package safego
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGoRecoversPanic(t *testing.T) {
+	before := Panics()
+
+	Go(func() { panic("boom") })
+
+	// Nothing in fn runs after the panic, so poll for Recover to count it
+	deadline := time.Now().Add(time.Second)
+	for Panics() == before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := Panics() - before; got != 1 {
+		t.Fatalf("Panics grew by %d, want 1", got)
+	}
+}
+
+func TestGoRunsWithoutPanic(t *testing.T) {
+	before := Panics()
+
+	done := make(chan struct{})
+	Go(func() { close(done) })
+	<-done
+
+	if got := Panics() - before; got != 0 {
+		t.Fatalf("Panics grew by %d, want 0", got)
+	}
+}
+
+func TestRecoverInGoroutineWithArguments(t *testing.T) {
+	before := Panics()
+
+	done := make(chan struct{})
+	go func(msg string) {
+		defer close(done)
+		defer Recover()
+		panic(msg)
+	}("boom")
+	<-done
+
+	if got := Panics() - before; got != 1 {
+		t.Fatalf("Panics grew by %d, want 1", got)
+	}
+}
+
+func TestRecoverWithoutPanic(t *testing.T) {
+	before := Panics()
+	func() {
+		defer Recover()
+	}()
+	if got := Panics() - before; got != 0 {
+		t.Fatalf("Panics grew by %d, want 0", got)
+	}
+}
@@ -0,0 +1,105 @@
+package scrobbler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"jetstream/internal/config"
+	"jetstream/pkg/subsonic"
+	"net/http"
+	"time"
+)
+
+const listenBrainzSubmitURL = "https://api.listenbrainz.org/1/submit-listens"
+
+// ListenBrainz submits plays of external tracks to a ListenBrainz listen history
+type ListenBrainz struct {
+	token  string
+	client *http.Client
+}
+
+func NewListenBrainz(cfg *config.Config) *ListenBrainz {
+	return &ListenBrainz{
+		token:  cfg.ListenBrainzToken,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Enabled reports whether a user token is configured
+func (l *ListenBrainz) Enabled() bool {
+	return l.token != ""
+}
+
+type listenPayload struct {
+	ListenType string   `json:"listen_type"`
+	Payload    []listen `json:"payload"`
+}
+
+type listen struct {
+	ListenedAt    int64         `json:"listened_at"`
+	TrackMetadata trackMetadata `json:"track_metadata"`
+}
+
+type trackMetadata struct {
+	ArtistName     string         `json:"artist_name"`
+	TrackName      string         `json:"track_name"`
+	ReleaseName    string         `json:"release_name,omitempty"`
+	AdditionalInfo additionalInfo `json:"additional_info"`
+}
+
+type additionalInfo struct {
+	DurationMs       int    `json:"duration_ms,omitempty"`
+	TrackNumber      int    `json:"tracknumber,omitempty"`
+	SubmissionClient string `json:"submission_client"`
+	MediaPlayer      string `json:"media_player,omitempty"`
+}
+
+// SubmitListen records a single listen. It is a no-op when no token is configured.
+func (l *ListenBrainz) SubmitListen(ctx context.Context, song *subsonic.Song, playedAt time.Time) error {
+	if !l.Enabled() {
+		return nil
+	}
+	if song == nil || song.Title == "" || song.Artist == "" {
+		return fmt.Errorf("listenbrainz: song is missing title or artist")
+	}
+
+	body, err := json.Marshal(listenPayload{
+		ListenType: "single",
+		Payload: []listen{{
+			ListenedAt: playedAt.Unix(),
+			TrackMetadata: trackMetadata{
+				ArtistName:  song.Artist,
+				TrackName:   song.Title,
+				ReleaseName: song.Album,
+				AdditionalInfo: additionalInfo{
+					DurationMs:       song.Duration * 1000,
+					TrackNumber:      song.Track,
+					SubmissionClient: "JetStream",
+					MediaPlayer:      "Tidal",
+				},
+			},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", listenBrainzSubmitURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+l.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("listenbrainz: HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"jetstream/internal/logging"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// coverCacheDir holds content-addressed cover images, hidden from Navidrome like the stream cache
+	coverCacheDir = ".covercache"
+
+	coverIndexPrefix = "jetstream:covers:"
+	coverIndexTTL    = 90 * 24 * time.Hour
+
+	// Covers younger than this are served from disk without asking upstream
+	coverFreshFor = 7 * 24 * time.Hour
+)
+
+var coverExts = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+// CachedCover is a cover image stored on disk
+type CachedCover struct {
+	Path        string
+	ContentType string
+	FetchedAt   time.Time
+}
+
+// ETag identifies the cover's bytes. Cached covers are named by the SHA-256 of their content,
+// so the tag is stable across restarts and shared by every id and size serving the same image.
+func (c *CachedCover) ETag() string {
+	return `"` + strings.TrimSuffix(filepath.Base(c.Path), filepath.Ext(c.Path)) + `"`
+}
+
+// Cover returns a cover image for an external ID from the disk cache, fetching it on a
+// miss or once it has gone stale. If the refresh fails, the stale copy is returned.
+// Concurrent requests for the same id and size share one fetch, and fetches are capped
+// at COVER_CONCURRENCY so a client loading a large grid doesn't get throttled upstream.
+// Covers that don't exist are remembered for NEGATIVE_CACHE_TTL.
+func (s *SyncService) Cover(ctx context.Context, id string, size int) (*CachedCover, error) {
+	size = SnapCoverSize(size)
+	indexKey := fmt.Sprintf("%s%s:%d", coverIndexPrefix, id, size)
+
+	if val, err := s.cache.Get(ctx, indexKey); err == nil && val == negativeCacheValue {
+		return nil, negativeCacheError(id)
+	}
+	cached := s.cachedCover(ctx, indexKey)
+	if cached != nil && time.Since(cached.FetchedAt) < coverFreshFor {
+		return cached, nil
+	}
+
+	fresh, err := shared(s.squid, ctx, indexKey, func(ctx context.Context) (*CachedCover, error) {
+		select {
+		case s.coverSem <- struct{}{}:
+			defer func() { <-s.coverSem }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return s.fetchCover(ctx, id, size, indexKey)
+	})
+	if err != nil {
+		if cached != nil {
+			logging.FromContext(ctx).Warn("Cover refresh failed, serving stale copy", "id", id, "size", size, "error", err)
+			return cached, nil
+		}
+		s.squid.cacheNegative(ctx, indexKey, err)
+		return nil, err
+	}
+	return fresh, nil
+}
+
+// newCoverClient keeps enough idle connections for every concurrent cover fetch to reuse one
+func newCoverClient(concurrency int) *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: concurrency,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// cachedCover looks up the index entry for a cover and checks the file is still on disk
+func (s *SyncService) cachedCover(ctx context.Context, indexKey string) *CachedCover {
+	val, err := s.cache.Get(ctx, indexKey)
+	if err != nil {
+		return nil
+	}
+	var cover CachedCover
+	if err := json.Unmarshal([]byte(val), &cover); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(cover.Path); err != nil {
+		return nil
+	}
+	return &cover
+}
+
+func (s *SyncService) fetchCover(ctx context.Context, id string, size int, indexKey string) (*CachedCover, error) {
+	url, err := s.providers.GetCoverURL(ctx, id, size)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", s.squid.NextUserAgent())
+	req.Header.Set("Accept", "image/*,*/*")
+
+	resp, err := s.coverClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(resp)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]))
+	if _, ok := coverExts[contentType]; !ok {
+		contentType = http.DetectContentType(data)
+	}
+	ext, ok := coverExts[contentType]
+	if !ok {
+		return nil, fmt.Errorf("unexpected cover content type %q", contentType)
+	}
+
+	// Content-addressed so every id/size pointing at the same image shares one file
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := filepath.Join(s.libraryPath(), coverCacheDir, hash[:2], hash+ext)
+
+	if _, err := os.Stat(path); err != nil {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			os.Remove(tmp)
+			return nil, err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return nil, err
+		}
+	}
+
+	cover := &CachedCover{Path: path, ContentType: contentType, FetchedAt: time.Now()}
+	if entry, err := json.Marshal(cover); err == nil {
+		s.cache.Set(ctx, indexKey, string(entry), coverIndexTTL)
+	}
+	return cover, nil
+}
@@ -0,0 +1,377 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"jetstream/internal/cache"
+	"jetstream/internal/config"
+	"jetstream/internal/logging"
+	"jetstream/internal/safego"
+	"jetstream/pkg/subsonic"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const deezerAPI = "https://api.deezer.com"
+
+// DeezerService is a MusicProvider backed by Deezer's public API. The public API only exposes
+// 30-second MP3 previews, so streams from Deezer are previews and are never synced; metadata
+// and covers are complete.
+type DeezerService struct {
+	cfg    *config.Config
+	cache  cache.Cache
+	client *http.Client
+}
+
+func NewDeezerService(cfg *config.Config, c cache.Cache) *DeezerService {
+	return &DeezerService{
+		cfg:    cfg,
+		cache:  c,
+		client: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Name is the provider segment of Deezer IDs
+func (d *DeezerService) Name() string { return "deezer" }
+
+// PreviewOnly marks Deezer's streams as previews, which must not end up in the library
+func (d *DeezerService) PreviewOnly() bool { return true }
+
+// deezerError is the error object Deezer returns, with status 200, in place of a resource
+type deezerError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+type deezerArtist struct {
+	ID            int64  `json:"id"`
+	Name          string `json:"name"`
+	PictureMedium string `json:"picture_medium"`
+	NbAlbum       int    `json:"nb_album"`
+}
+
+type deezerAlbum struct {
+	ID          int64        `json:"id"`
+	Title       string       `json:"title"`
+	CoverMedium string       `json:"cover_medium"`
+	CoverBig    string       `json:"cover_big"`
+	CoverXL     string       `json:"cover_xl"`
+	ReleaseDate string       `json:"release_date"`
+	NbTracks    int          `json:"nb_tracks"`
+	Duration    int          `json:"duration"`
+	Artist      deezerArtist `json:"artist"`
+	Label       string       `json:"label"`
+	Genres      struct {
+		Data []struct {
+			Name string `json:"name"`
+		} `json:"data"`
+	} `json:"genres"`
+	Tracks struct {
+		Data []deezerTrack `json:"data"`
+	} `json:"tracks"`
+}
+
+type deezerTrack struct {
+	ID          int64        `json:"id"`
+	Title       string       `json:"title"`
+	Duration    int          `json:"duration"`
+	TrackNumber int          `json:"track_position"`
+	DiskNumber  int          `json:"disk_number"`
+	BPM         float64      `json:"bpm"`
+	ReleaseDate string       `json:"release_date"`
+	Preview     string       `json:"preview"`
+	Readable    *bool        `json:"readable"`
+	Artist      deezerArtist `json:"artist"`
+	Album       deezerAlbum  `json:"album"`
+}
+
+// get fetches a Deezer API path into out, turning Deezer's in-band errors into Go errors
+func (d *DeezerService) get(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", deezerAPI+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUpstream, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: deezer %s", ErrNotFound, path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %w", ErrUpstream, newStatusError(resp))
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return err
+	}
+	var envelope struct {
+		Error *deezerError `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &envelope); err == nil && envelope.Error != nil {
+		// 800 is "no data", Deezer's not-found
+		if envelope.Error.Code == 800 {
+			return fmt.Errorf("%w: deezer %s", ErrNotFound, path)
+		}
+		return fmt.Errorf("%w: deezer %s: %s (%d)", ErrUpstream, path, envelope.Error.Message, envelope.Error.Code)
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// deezerCached serves key from the cache or fills it with fetch for ttl
+func deezerCached[T any](d *DeezerService, ctx context.Context, key string, ttl time.Duration, fetch func() (T, error)) (T, error) {
+	cacheKey := CachePrefix + "deezer:" + key
+	if val, err := d.cache.Get(ctx, cacheKey); err == nil {
+		var v T
+		if err := json.Unmarshal([]byte(val), &v); err == nil {
+			return v, nil
+		}
+	}
+
+	v, err := fetch()
+	if err != nil {
+		return v, err
+	}
+	if data, err := json.Marshal(v); err == nil {
+		d.cache.Set(ctx, cacheKey, string(data), ttl)
+	}
+	return v, nil
+}
+
+func (d *DeezerService) Search(ctx context.Context, query string) (*subsonic.SearchResult3, error) {
+	return deezerCached(d, ctx, "search:"+query, 48*time.Hour, func() (*subsonic.SearchResult3, error) {
+		var (
+			tracks                        struct{ Data []deezerTrack }
+			albums                        struct{ Data []deezerAlbum }
+			artists                       struct{ Data []deezerArtist }
+			trackErr, albumErr, artistErr error
+			wg                            sync.WaitGroup
+		)
+		q := url.QueryEscape(query)
+
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			defer safego.Recover()
+			trackErr = d.get(ctx, "/search?q="+q, &tracks)
+		}()
+		go func() {
+			defer wg.Done()
+			defer safego.Recover()
+			albumErr = d.get(ctx, "/search/album?q="+q, &albums)
+		}()
+		go func() {
+			defer wg.Done()
+			defer safego.Recover()
+			artistErr = d.get(ctx, "/search/artist?q="+q, &artists)
+		}()
+		wg.Wait()
+
+		if trackErr != nil && albumErr != nil && artistErr != nil {
+			return nil, trackErr
+		}
+		for _, err := range []error{trackErr, albumErr, artistErr} {
+			if err != nil {
+				logging.FromContext(ctx).Error("Deezer search partially failed", "query", query, "error", err)
+			}
+		}
+
+		res := &subsonic.SearchResult3{}
+		for _, t := range tracks.Data {
+			res.Song = append(res.Song, deezerSong(t, t.Album))
+		}
+		for _, a := range albums.Data {
+			res.Album = append(res.Album, deezerAlbumEntry(a))
+		}
+		for _, a := range artists.Data {
+			res.Artist = append(res.Artist, subsonic.Artist{
+				ID:         subsonic.BuildID("deezer", "artist", strconv.FormatInt(a.ID, 10)),
+				Name:       a.Name,
+				CoverArt:   subsonic.BuildID("deezer", "artist", strconv.FormatInt(a.ID, 10)),
+				AlbumCount: a.NbAlbum,
+				Provider:   "deezer",
+			})
+		}
+		return res, nil
+	})
+}
+
+func (d *DeezerService) GetSong(ctx context.Context, id string) (*subsonic.Song, error) {
+	_, _, _, numericID := subsonic.ParseID(id)
+	return deezerCached(d, ctx, "song:"+numericID, 7*24*time.Hour, func() (*subsonic.Song, error) {
+		var t deezerTrack
+		if err := d.get(ctx, "/track/"+url.PathEscape(numericID), &t); err != nil {
+			return nil, err
+		}
+		if t.Readable != nil && !*t.Readable {
+			logging.FromContext(ctx).Warn("Deezer track is not readable, playback will likely fail", "id", id)
+		}
+		song := deezerSong(t, t.Album)
+		return &song, nil
+	})
+}
+
+type deezerAlbumResult struct {
+	Album subsonic.Album
+	Songs []subsonic.Song
+}
+
+func (d *DeezerService) GetAlbum(ctx context.Context, id string) (*subsonic.Album, []subsonic.Song, error) {
+	_, _, _, numericID := subsonic.ParseID(id)
+	res, err := deezerCached(d, ctx, "album:"+numericID, 7*24*time.Hour, func() (*deezerAlbumResult, error) {
+		var a deezerAlbum
+		if err := d.get(ctx, "/album/"+url.PathEscape(numericID), &a); err != nil {
+			return nil, err
+		}
+		res := &deezerAlbumResult{Album: deezerAlbumEntry(a)}
+		for _, t := range a.Tracks.Data {
+			song := deezerSong(t, a)
+			song.Genre = res.Album.Genre
+			// Album track listings omit positions; the listing order is the track order
+			if song.Track == 0 {
+				song.Track = len(res.Songs) + 1
+			}
+			res.Songs = append(res.Songs, song)
+		}
+		return res, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &res.Album, res.Songs, nil
+}
+
+// GetStreamURL returns the track's 30-second preview. Preview URLs are signed and expire, so
+// they are never cached. quality is ignored; previews only come as 128kbps MP3.
+func (d *DeezerService) GetStreamURL(ctx context.Context, id string, quality string) (*TrackInfo, error) {
+	_, _, _, numericID := subsonic.ParseID(id)
+	var t deezerTrack
+	if err := d.get(ctx, "/track/"+url.PathEscape(numericID), &t); err != nil {
+		return nil, err
+	}
+	if t.Preview == "" {
+		return nil, fmt.Errorf("%w: no preview for deezer track %s", ErrNotFound, numericID)
+	}
+	expires, _ := signedURLExpiry(t.Preview)
+	return &TrackInfo{DownloadURL: t.Preview, MimeType: "audio/mpeg", Expires: expires}, nil
+}
+
+// GetCoverURL resolves the cover for a song, album or artist, choosing the closest size Deezer
+// publishes
+func (d *DeezerService) GetCoverURL(ctx context.Context, id string, size int) (string, error) {
+	_, mediaType, _, numericID := subsonic.ParseID(id)
+	size = SnapCoverSize(size)
+
+	return deezerCached(d, ctx, fmt.Sprintf("cover:%s:%d", id, size), 7*7*24*time.Hour, func() (string, error) {
+		var a deezerAlbum
+		switch mediaType {
+		case "album":
+			if err := d.get(ctx, "/album/"+url.PathEscape(numericID), &a); err != nil {
+				return "", err
+			}
+		case "song":
+			var t deezerTrack
+			if err := d.get(ctx, "/track/"+url.PathEscape(numericID), &t); err != nil {
+				return "", err
+			}
+			a = t.Album
+		case "artist":
+			var artist struct {
+				PictureMedium string `json:"picture_medium"`
+				PictureBig    string `json:"picture_big"`
+				PictureXL     string `json:"picture_xl"`
+			}
+			if err := d.get(ctx, "/artist/"+url.PathEscape(numericID), &artist); err != nil {
+				return "", err
+			}
+			a = deezerAlbum{CoverMedium: artist.PictureMedium, CoverBig: artist.PictureBig, CoverXL: artist.PictureXL}
+		default:
+			return "", fmt.Errorf("%w: no cover for %s", ErrNotFound, id)
+		}
+
+		cover := deezerCover(a, size)
+		if cover == "" {
+			return "", fmt.Errorf("%w: no cover for %s", ErrNotFound, id)
+		}
+		return cover, nil
+	})
+}
+
+// deezerCover picks the smallest published cover (250, 500 or 1000px) that covers size
+func deezerCover(a deezerAlbum, size int) string {
+	switch {
+	case size <= 250 && a.CoverMedium != "":
+		return a.CoverMedium
+	case size <= 500 && a.CoverBig != "":
+		return a.CoverBig
+	case a.CoverXL != "":
+		return a.CoverXL
+	case a.CoverBig != "":
+		return a.CoverBig
+	default:
+		return a.CoverMedium
+	}
+}
+
+func deezerSong(t deezerTrack, album deezerAlbum) subsonic.Song {
+	artistID := strconv.FormatInt(t.Artist.ID, 10)
+	albumID := strconv.FormatInt(album.ID, 10)
+	year := releaseYear(album.ReleaseDate)
+	if year == 0 {
+		year = releaseYear(t.ReleaseDate)
+	}
+	return subsonic.Song{
+		ID:          subsonic.BuildID("deezer", "song", strconv.FormatInt(t.ID, 10)),
+		Parent:      subsonic.BuildID("deezer", "album", albumID),
+		Title:       t.Title,
+		Artist:      t.Artist.Name,
+		ArtistID:    subsonic.BuildID("deezer", "artist", artistID),
+		AlbumArtist: album.Artist.Name, // Empty when the track payload's album omits the artist
+		Album:       album.Title,
+		AlbumID:     subsonic.BuildID("deezer", "album", albumID),
+		CoverArt:    subsonic.BuildID("deezer", "album", albumID),
+		Duration:    t.Duration,
+		Track:       t.TrackNumber,
+		DiscNumber:  discNumber(0, t.DiskNumber),
+		Year:        year,
+		BPM:         int(t.BPM),
+		Suffix:      "mp3",
+		ContentType: "audio/mpeg",
+		Path:        fmt.Sprintf("deezer/%s/%s/%d.mp3", t.Artist.Name, album.Title, t.ID),
+		MediaType:   "song",
+		Provider:    "deezer",
+	}
+}
+
+func deezerAlbumEntry(a deezerAlbum) subsonic.Album {
+	album := subsonic.Album{
+		ID:        subsonic.BuildID("deezer", "album", strconv.FormatInt(a.ID, 10)),
+		Title:     a.Title,
+		Name:      a.Title,
+		Artist:    a.Artist.Name,
+		ArtistID:  subsonic.BuildID("deezer", "artist", strconv.FormatInt(a.Artist.ID, 10)),
+		CoverArt:  subsonic.BuildID("deezer", "album", strconv.FormatInt(a.ID, 10)),
+		SongCount: a.NbTracks,
+		Duration:  a.Duration,
+		Year:      releaseYear(a.ReleaseDate),
+		IsDir:     true,
+		Provider:  "deezer",
+	}
+	var genres nameList
+	for _, g := range a.Genres.Data {
+		genres = append(genres, g.Name)
+	}
+	var labels nameList
+	if a.Label != "" {
+		labels = nameList{a.Label}
+	}
+	setAlbumGenres(&album, "", genres, labels)
+	return album
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"jetstream/internal/logging"
+	"jetstream/internal/safego"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// HydrateResult summarizes a HydrateGhosts run
+type HydrateResult struct {
+	Scanned  int   `json:"scanned"`  // Audio files looked at
+	Ghosts   int   `json:"ghosts"`   // Ghost-sized files carrying a TIDAL_ID tag
+	Hydrated int64 `json:"hydrated"` // Ghosts replaced by a fully synced track
+	Failed   int64 `json:"failed"`
+	Untagged int   `json:"untagged"` // Ghost-sized files without a TIDAL_ID, left alone
+}
+
+// HydrateGhosts walks the music folder for ghost placeholders (smaller than
+// GHOST_SIZE_THRESHOLD with a TIDAL_ID tag) and syncs each one. The placeholder is removed
+// once the full track is in the library. Files with real content are never touched.
+func (s *SyncService) HydrateGhosts(ctx context.Context) (HydrateResult, error) {
+	var result HydrateResult
+	ghosts := make(map[string]string) // path -> external ID
+
+	root := s.cfg.Get().MusicFolder
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Skip errors
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if info.IsDir() {
+			if path != root && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.ToLower(filepath.Ext(path)) != ".mp3" {
+			return nil // Ghosts are ID3-tagged MP3 placeholders
+		}
+
+		result.Scanned++
+		if !s.IsGhostFile(path) {
+			return nil
+		}
+		id := ReadTidalID(path)
+		if id == "" {
+			result.Untagged++
+			return nil
+		}
+		ghosts[path] = id
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	result.Ghosts = len(ghosts)
+	logging.FromContext(ctx).Info("Hydrating ghost files", "ghosts", result.Ghosts, "scanned", result.Scanned)
+
+	// SyncSong queues on the ffmpeg worker pool; this only bounds how many wait at once
+	workers := s.cfg.Get().SyncConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan [2]string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer safego.Recover()
+			for job := range jobs {
+				if s.hydrateOne(ctx, job[0], job[1]) {
+					atomic.AddInt64(&result.Hydrated, 1)
+				} else {
+					atomic.AddInt64(&result.Failed, 1)
+				}
+			}
+		}()
+	}
+
+feed:
+	for path, id := range ghosts {
+		// Hold off while every mirror is cooling down instead of failing the rest of the batch
+		if err := s.squid.waitForMirror(ctx); err != nil {
+			break
+		}
+		select {
+		case jobs <- [2]string{path, id}:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return result, ctx.Err()
+}
+
+// hydrateOne syncs the song behind a ghost and removes the placeholder when the synced copy
+// lives elsewhere
+func (s *SyncService) hydrateOne(ctx context.Context, ghostPath, id string) bool {
+	logger := logging.FromContext(ctx)
+	song, err := s.providers.GetSong(ctx, id)
+	if err != nil {
+		logger.Warn("Failed to resolve ghost", "path", ghostPath, "id", id, "error", err)
+		return false
+	}
+	if err := s.SyncSong(ctx, song); err != nil {
+		logger.Warn("Failed to hydrate ghost", "path", ghostPath, "id", id, "error", err)
+		return false
+	}
+
+	if synced := s.LocalPath(song); synced != ghostPath {
+		if err := os.Remove(ghostPath); err != nil {
+			logger.Warn("Hydrated ghost but could not remove placeholder", "path", ghostPath, "error", err)
+		}
+	}
+	logger.Info("Hydrated ghost", "path", ghostPath, "id", id)
+	return true
+}
+
+// ReadTidalID returns the TIDAL_ID tag of a synced file, or "" if there is none. MP3s carry it
+// as a TXXX frame; opus and flac files as a Vorbis comment, read with ffprobe.
+func ReadTidalID(path string) string {
+	if strings.ToLower(filepath.Ext(path)) != ".mp3" {
+		return readVorbisTidalID(path)
+	}
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	if err != nil {
+		return ""
+	}
+	defer tag.Close()
+
+	for _, f := range tag.GetFrames(tag.CommonID("User defined text information")) {
+		if udtf, ok := f.(id3v2.UserDefinedTextFrame); ok && udtf.Description == "TIDAL_ID" {
+			return udtf.Value
+		}
+	}
+	return ""
+}
+
+// readVorbisTidalID reads TIDAL_ID from the Vorbis comments of path. FLAC keeps them with the
+// container, Ogg with the audio stream, so both are asked for.
+func readVorbisTidalID(path string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-show_entries", "format_tags:stream_tags",
+		"-of", "json",
+		path,
+	).Output()
+	if err != nil {
+		return ""
+	}
+
+	var probe struct {
+		Format struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"format"`
+		Streams []struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return ""
+	}
+	tags := []map[string]string{probe.Format.Tags}
+	for _, stream := range probe.Streams {
+		tags = append(tags, stream.Tags)
+	}
+	// Vorbis comment names are case-insensitive
+	for _, t := range tags {
+		for name, value := range t {
+			if strings.EqualFold(name, "TIDAL_ID") && value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"jetstream/internal/config"
+	"jetstream/pkg/subsonic"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// A synced file carries its external ID in a TIDAL_ID tag: a TXXX frame in MP3, a Vorbis
+// comment in opus and flac. ReadTidalID must recover it from what the sync transcode writes.
+func TestReadTidalIDRoundTrip(t *testing.T) {
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not installed", tool)
+		}
+	}
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.wav")
+	if out, err := exec.Command("ffmpeg", "-v", "error", "-f", "lavfi", "-i", "anullsrc=r=48000:cl=stereo", "-t", "1", source).CombinedOutput(); err != nil {
+		t.Fatalf("generating source: %v: %s", err, out)
+	}
+
+	live := config.NewLive(&config.Config{
+		JetStreamLibraryPath: dir,
+		CacheBackend:         "memory",
+		CacheEntries:         10,
+		OpusBitrate:          "96k",
+		MP3Quality:           2,
+	})
+	squid := NewSquidService(live)
+	s := NewSyncService(squid, NewProviders(live.Get(), squid), live)
+	song := &subsonic.Song{ID: "ext-squidwtf-song-1", Title: "Song", Artist: "Artist", AlbumArtist: "Artist", Album: "Album"}
+
+	for _, format := range []string{"mp3", "opus", "flac"} {
+		path := filepath.Join(dir, "song."+format)
+		if err := s.downloadAndTranscode(context.Background(), live.Get(), song, source, path, format, ""); err != nil {
+			t.Fatalf("%s: transcode: %v", format, err)
+		}
+
+		if format == "mp3" {
+			tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+			if err != nil {
+				t.Fatalf("mp3: id3v2.Open: %v", err)
+			}
+			var found string
+			for _, f := range tag.GetFrames(tag.CommonID("User defined text information")) {
+				if udtf, ok := f.(id3v2.UserDefinedTextFrame); ok && udtf.Description == "TIDAL_ID" {
+					found = udtf.Value
+				}
+			}
+			tag.Close()
+			if found != song.ID {
+				t.Errorf("mp3: TXXX:TIDAL_ID = %q, want %q", found, song.ID)
+			}
+		}
+
+		if got := ReadTidalID(path); got != song.ID {
+			t.Errorf("%s: ReadTidalID = %q, want %q", format, got, song.ID)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"jetstream/internal/logging"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// syncedExts are the extensions of the audio files JetStream writes to the library
+var syncedExts = map[string]bool{".opus": true, ".mp3": true, ".aac": true, ".flac": true}
+
+// LibraryTrack is one synced file. Size includes its sidecar and any kept original.
+type LibraryTrack struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+}
+
+// LibraryAlbum groups the synced tracks of one album
+type LibraryAlbum struct {
+	ID     string         `json:"id"`
+	Name   string         `json:"name"`
+	Size   int64          `json:"size"`
+	Tracks []LibraryTrack `json:"tracks"`
+}
+
+// LibraryArtist groups the synced albums of one album artist
+type LibraryArtist struct {
+	Name   string         `json:"name"`
+	Size   int64          `json:"size"`
+	Albums []LibraryAlbum `json:"albums"`
+}
+
+// LibraryListing is everything synced below the library path. TotalSize counts every file
+// there, including the stream and cover caches and files without a sidecar.
+type LibraryListing struct {
+	Artists   []LibraryArtist `json:"artists"`
+	Tracks    int             `json:"tracks"`
+	TotalSize int64           `json:"totalSize"`
+}
+
+// syncedFile is a synced audio file with its sidecar
+type syncedFile struct {
+	path    string
+	size    int64 // Audio, sidecar and original together
+	sidecar *songSidecar
+}
+
+// walkSynced calls fn for each synced file below the library that has a readable sidecar and
+// returns the size of everything walked
+func (s *SyncService) walkSynced(ctx context.Context, fn func(f syncedFile)) (int64, error) {
+	var total int64
+	err := filepath.Walk(s.libraryPath(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Skip errors
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if info.IsDir() {
+			return nil
+		}
+		total += info.Size()
+
+		if !syncedExts[strings.ToLower(filepath.Ext(path))] || isHiddenPath(s.libraryPath(), path) {
+			return nil
+		}
+		sidecar, err := readSidecar(path)
+		if err != nil || sidecar.ID == "" {
+			return nil
+		}
+		fn(syncedFile{path: path, size: s.syncedSize(path), sidecar: sidecar})
+		return nil
+	})
+	return total, err
+}
+
+// isHiddenPath reports whether path lies in one of the hidden cache directories below root
+func isHiddenPath(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(rel, ".") || strings.Contains(rel, string(filepath.Separator)+".")
+}
+
+// ListLibrary returns the synced files grouped by album artist and album, each sorted by name
+func (s *SyncService) ListLibrary(ctx context.Context) (*LibraryListing, error) {
+	artists := make(map[string]*LibraryArtist)
+	albums := make(map[string]*LibraryAlbum)
+	albumArtist := make(map[string]string)
+	tracks := 0
+
+	total, err := s.walkSynced(ctx, func(f syncedFile) {
+		song := f.sidecar.Song
+		artistName := song.AlbumArtist
+		if artistName == "" {
+			artistName = song.Artist
+		}
+		albumKey := song.AlbumID
+		if albumKey == "" {
+			albumKey = artistName + "\x00" + song.Album
+		}
+
+		album, ok := albums[albumKey]
+		if !ok {
+			album = &LibraryAlbum{ID: song.AlbumID, Name: song.Album}
+			albums[albumKey] = album
+			albumArtist[albumKey] = artistName
+		}
+		album.Tracks = append(album.Tracks, LibraryTrack{ID: song.ID, Title: song.Title, Path: f.path, Size: f.size})
+		album.Size += f.size
+		tracks++
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for key, album := range albums {
+		sort.Slice(album.Tracks, func(i, j int) bool { return album.Tracks[i].Path < album.Tracks[j].Path })
+		name := albumArtist[key]
+		artist, ok := artists[name]
+		if !ok {
+			artist = &LibraryArtist{Name: name}
+			artists[name] = artist
+		}
+		artist.Albums = append(artist.Albums, *album)
+		artist.Size += album.Size
+	}
+
+	listing := &LibraryListing{Artists: make([]LibraryArtist, 0, len(artists)), Tracks: tracks, TotalSize: total}
+	for _, artist := range artists {
+		sort.Slice(artist.Albums, func(i, j int) bool { return artist.Albums[i].Name < artist.Albums[j].Name })
+		listing.Artists = append(listing.Artists, *artist)
+	}
+	sort.Slice(listing.Artists, func(i, j int) bool { return listing.Artists[i].Name < listing.Artists[j].Name })
+	return listing, nil
+}
+
+// LibraryDeleteResult reports what DeleteAlbum removed
+type LibraryDeleteResult struct {
+	Files int   `json:"files"`
+	Size  int64 `json:"size"`
+}
+
+// DeleteAlbum removes every synced track of albumID with its sidecar and kept original, drops
+// their index entries, and removes album directories left with nothing but a cover.
+// Artists left without any synced song are dropped from the synced artist index.
+func (s *SyncService) DeleteAlbum(ctx context.Context, albumID string) (LibraryDeleteResult, error) {
+	var result LibraryDeleteResult
+	var matched []syncedFile
+	remaining := make(map[string]bool) // Artist IDs that still have synced songs
+
+	if _, err := s.walkSynced(ctx, func(f syncedFile) {
+		if f.sidecar.AlbumID == albumID {
+			matched = append(matched, f)
+		} else {
+			remaining[f.sidecar.ArtistID] = true
+		}
+	}); err != nil {
+		return result, err
+	}
+	if len(matched) == 0 {
+		return result, fmt.Errorf("%w: no synced tracks for album %s", ErrNotFound, albumID)
+	}
+
+	var gone []string
+	s.quotaMu.Lock()
+	for _, f := range matched {
+		song := f.sidecar.Song
+		result.Size += s.removeSynced(ctx, f.path, f.sidecar)
+		result.Files++
+		if song.ArtistID != "" && !remaining[song.ArtistID] {
+			gone = append(gone, song.ArtistID)
+		}
+	}
+	s.quotaMu.Unlock()
+	s.forgetSyncedArtists(ctx, gone)
+
+	logging.FromContext(ctx).Info("Deleted synced album", "albumID", albumID, "files", result.Files, "size", result.Size)
+	return result, nil
+}
+
+// removeIfOnlyCover deletes dir when all that's left in it is the album's cover.jpg
+func removeIfOnlyCover(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.Name() != "cover.jpg" {
+			return
+		}
+	}
+	os.Remove(filepath.Join(dir, "cover.jpg"))
+	os.Remove(dir)
+}
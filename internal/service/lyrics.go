@@ -0,0 +1,94 @@
+package service
+
+import (
+	"jetstream/pkg/subsonic"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// lrcTimestamp matches one leading [mm:ss], [mm:ss.xx] or [mm:ss:xx] tag
+	lrcTimestamp = regexp.MustCompile(`^\[(\d+):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+	// lrcOffset matches the [offset:+/-ms] header tag
+	lrcOffset = regexp.MustCompile(`^\[offset:\s*([+-]?\d+)\s*\]$`)
+	// lrcTag matches any other [key:value] header such as [ar:Artist]
+	lrcTag = regexp.MustCompile(`^\[[a-zA-Z#]+:.*\]$`)
+)
+
+// ParseLyrics turns Squid's lyrics text into OpenSubsonic structured lyrics. LRC timestamps
+// produce synced lines in time order; text without any yields unsynced plain lines.
+func ParseLyrics(text string) subsonic.StructuredLyrics {
+	lyrics := subsonic.StructuredLyrics{Lang: "xxx"}
+	var plain []subsonic.LyricLine
+
+	for _, raw := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line := strings.TrimSpace(raw)
+
+		var starts []int64
+		for {
+			m := lrcTimestamp.FindStringSubmatch(line)
+			if m == nil {
+				break
+			}
+			starts = append(starts, lrcMillis(m[1], m[2], m[3]))
+			line = strings.TrimSpace(line[len(m[0]):])
+		}
+
+		if len(starts) == 0 {
+			if m := lrcOffset.FindStringSubmatch(line); m != nil {
+				// LRC offsets shift lyrics earlier when positive; OpenSubsonic's shift them later
+				if ms, err := strconv.Atoi(m[1]); err == nil {
+					lyrics.Offset = -ms
+				}
+				continue
+			}
+			if lrcTag.MatchString(line) {
+				continue
+			}
+			plain = append(plain, subsonic.LyricLine{Value: line})
+			continue
+		}
+
+		// A line sung more than once carries one timestamp per repetition
+		for _, start := range starts {
+			start := start
+			lyrics.Line = append(lyrics.Line, subsonic.LyricLine{Start: &start, Value: line})
+		}
+	}
+
+	if len(lyrics.Line) > 0 {
+		lyrics.Synced = true
+		sort.SliceStable(lyrics.Line, func(i, j int) bool {
+			return *lyrics.Line[i].Start < *lyrics.Line[j].Start
+		})
+		return lyrics
+	}
+
+	// Unsynced: drop the blank lines surrounding the text but keep stanza breaks
+	for len(plain) > 0 && plain[0].Value == "" {
+		plain = plain[1:]
+	}
+	for len(plain) > 0 && plain[len(plain)-1].Value == "" {
+		plain = plain[:len(plain)-1]
+	}
+	lyrics.Offset = 0
+	lyrics.Line = plain
+	return lyrics
+}
+
+// lrcMillis converts LRC minutes, seconds and an optional 1-3 digit fraction to milliseconds
+func lrcMillis(min, sec, frac string) int64 {
+	m, _ := strconv.ParseInt(min, 10, 64)
+	s, _ := strconv.ParseInt(sec, 10, 64)
+	ms := (m*60 + s) * 1000
+	if frac != "" {
+		f, _ := strconv.ParseInt(frac, 10, 64)
+		for i := len(frac); i < 3; i++ {
+			f *= 10
+		}
+		ms += f
+	}
+	return ms
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// matchScore rates how well a candidate's fields match the wanted ones, 0-100. Each field is
+// compared with the mean of a token-set and a token-sort ratio: the set ratio forgives extra
+// words such as "(Remastered)", the sort ratio keeps "Weezer" from fully matching
+// "Weezer Karaoke Tribute". Weights must sum to 1.
+func matchScore(pairs ...matchField) int {
+	score := 0.0
+	for _, p := range pairs {
+		set := tokenSetRatio(p.want, p.got)
+		sorted := ratio(strings.Join(sortedTokens(p.want), " "), strings.Join(sortedTokens(p.got), " "))
+		score += p.weight * (set + sorted) / 2
+	}
+	return int(score*100 + 0.5)
+}
+
+// matchField is one wanted/candidate pair compared by matchScore
+type matchField struct {
+	want, got string
+	weight    float64
+}
+
+// bestMatch returns the index of the highest-scoring candidate and its score, preferring the
+// earliest (most relevant upstream) on ties. It returns -1 for no candidates.
+func bestMatch(n int, score func(i int) int) (int, int) {
+	best, bestScore := -1, -1
+	for i := 0; i < n; i++ {
+		if sc := score(i); sc > bestScore {
+			best, bestScore = i, sc
+		}
+	}
+	return best, bestScore
+}
+
+// tokens lowercases s and splits it on anything that isn't a letter or digit
+func tokens(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func sortedTokens(s string) []string {
+	t := tokens(s)
+	sort.Strings(t)
+	return t
+}
+
+// tokenSetRatio compares the shared tokens of a and b against each side's full token set,
+// so extra words on one side don't count against it
+func tokenSetRatio(a, b string) float64 {
+	setA, setB := make(map[string]bool), make(map[string]bool)
+	for _, t := range tokens(a) {
+		setA[t] = true
+	}
+	for _, t := range tokens(b) {
+		setB[t] = true
+	}
+
+	var common, onlyA, onlyB []string
+	for t := range setA {
+		if setB[t] {
+			common = append(common, t)
+		} else {
+			onlyA = append(onlyA, t)
+		}
+	}
+	for t := range setB {
+		if !setA[t] {
+			onlyB = append(onlyB, t)
+		}
+	}
+	sort.Strings(common)
+	sort.Strings(onlyA)
+	sort.Strings(onlyB)
+
+	base := strings.Join(common, " ")
+	withA := strings.TrimSpace(base + " " + strings.Join(onlyA, " "))
+	withB := strings.TrimSpace(base + " " + strings.Join(onlyB, " "))
+
+	best := ratio(withA, withB)
+	if base != "" {
+		best = max(best, ratio(base, withA), ratio(base, withB))
+	}
+	return best
+}
+
+// ratio is the normalized Levenshtein similarity of a and b, 0-1
+func ratio(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"jetstream/internal/config"
+	"testing"
+)
+
+type candidate struct{ artist, title string }
+
+// pick runs the SearchOne scoring over candidates and returns the chosen index and its score
+func pick(artist, title string, candidates []candidate) (int, int) {
+	return bestMatch(len(candidates), func(i int) int {
+		return matchScore(
+			matchField{artist, candidates[i].artist, 0.4},
+			matchField{title, candidates[i].title, 0.6},
+		)
+	})
+}
+
+func TestBestMatchSkipsNearMisses(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates []candidate
+		want       int
+	}{
+		{"karaoke listed first", []candidate{
+			{"Karaoke All Stars", "Say It Ain't So (Karaoke Version)"},
+			{"Weezer", "Buddy Holly"},
+			{"Weezer", "Say It Ain't So"},
+		}, 2},
+		{"remaster over other songs", []candidate{
+			{"Weezer", "Buddy Holly"},
+			{"Weezer", "Say It Ain't So (Remastered)"},
+			{"Karaoke All Stars", "Say It Ain't So (Karaoke Version)"},
+		}, 1},
+		{"ties keep upstream order", []candidate{
+			{"Weezer", "Say It Ain't So"},
+			{"Weezer", "Say It Ain't So"},
+		}, 0},
+	}
+	for _, tt := range tests {
+		if got, score := pick("Weezer", "Say It Ain't So", tt.candidates); got != tt.want {
+			t.Errorf("%s: picked %d (score %d), want %d", tt.name, got, score, tt.want)
+		}
+	}
+}
+
+func TestMatchScoreArtistNames(t *testing.T) {
+	exact := matchScore(matchField{"Weezer", "Weezer", 1})
+	tribute := matchScore(matchField{"Weezer", "Weezer Karaoke Tribute", 1})
+	if exact != 100 {
+		t.Errorf("exact artist scored %d, want 100", exact)
+	}
+	if tribute >= 70 {
+		t.Errorf("tribute artist scored %d, want below the default threshold of 70", tribute)
+	}
+}
+
+func TestCheckMatchThreshold(t *testing.T) {
+	s := &SquidService{cfg: config.NewLive(&config.Config{MatchThreshold: 70})}
+	ctx := context.Background()
+
+	// Only near misses: the best of them is still rejected
+	best, score := pick("Weezer", "Say It Ain't So", []candidate{
+		{"Karaoke All Stars", "Say It Ain't So (Karaoke Version)"},
+		{"Weezer", "Buddy Holly"},
+	})
+	if err := s.checkMatch(ctx, "q", best, score); !errors.Is(err, ErrNotFound) {
+		t.Errorf("near misses (best score %d): err = %v, want ErrNotFound", score, err)
+	}
+
+	if err := s.checkMatch(ctx, "q", -1, -1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("no candidates: err = %v, want ErrNotFound", err)
+	}
+	if err := s.checkMatch(ctx, "q", 0, 70); err != nil {
+		t.Errorf("score at the threshold: err = %v, want nil", err)
+	}
+	if err := s.checkMatch(ctx, "q", 0, 69); !errors.Is(err, ErrNotFound) {
+		t.Errorf("score just below the threshold: err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"jetstream/internal/logging"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// originalsDir holds untouched CDN sources kept by KEEP_ORIGINAL. Like the other caches it is
+// hidden so Navidrome only indexes the transcoded copy.
+const originalsDir = ".originals"
+
+// originalPath mirrors outputPath's place in the library under originalsDir, with the
+// extension of the source container
+func (s *SyncService) originalPath(outputPath, mimeType string) (string, error) {
+	ext, ok := streamCacheExts[strings.ToLower(mimeType)]
+	if !ok {
+		return "", fmt.Errorf("unknown source mime type %q", mimeType)
+	}
+	rel, err := filepath.Rel(s.libraryPath(), outputPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("output %s is outside the library", outputPath)
+	}
+	return filepath.Join(s.libraryPath(), originalsDir, strings.TrimSuffix(rel, filepath.Ext(rel))+ext), nil
+}
+
+// keepOriginal stores the untouched source (a stream cache file or CDN URL) next to the library
+// and returns its path, so the transcode can read from disk instead of downloading again.
+// An existing, intact original is reused.
+func (s *SyncService) keepOriginal(ctx context.Context, source, mimeType, outputPath string) (string, error) {
+	path, err := s.originalPath(outputPath, mimeType)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		if err := s.VerifyIntegrity(ctx, path); err == nil {
+			return path, nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+
+	partPath := path + ".part"
+	if err := s.copySource(ctx, source, partPath); err != nil {
+		return "", err
+	}
+	if err := os.Rename(partPath, path); err != nil {
+		os.Remove(partPath)
+		return "", err
+	}
+
+	if err := s.VerifyIntegrity(ctx, path); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("original failed integrity check: %w", err)
+	}
+	logging.FromContext(ctx).Info("Kept original source", "path", path)
+	return path, nil
+}
+
+// copySource writes source, a CDN URL or local file, to path. A partial copy is removed.
+// Syncs hold a worker slot meanwhile, so a download is bounded like the transcode that
+// follows it rather than left to a stalled CDN connection.
+func (s *SyncService) copySource(ctx context.Context, source, path string) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Minute)
+	defer cancel()
+
+	var body io.ReadCloser
+	if strings.HasPrefix(source, "http") {
+		req, err := http.NewRequestWithContext(ctx, "GET", source, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("User-Agent", s.squid.NextUserAgent())
+		resp, err := s.sourceClient.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("failed to download source: %d", resp.StatusCode)
+		}
+		body = resp.Body
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		body = f
+	}
+	defer body.Close()
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, copyErr := io.Copy(out, body)
+	closeErr := out.Close()
+	if copyErr != nil || closeErr != nil {
+		os.Remove(path)
+		if copyErr != nil {
+			return copyErr
+		}
+		return closeErr
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"jetstream/internal/logging"
+	"jetstream/internal/safego"
+	"jetstream/pkg/subsonic"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// prefetchWorkers and prefetchInterval keep a warm-up well below interactive traffic:
+	// at most one upstream lookup starts per interval, spread over a couple of workers
+	prefetchWorkers  = 2
+	prefetchInterval = 500 * time.Millisecond
+	// MaxPrefetchIDs caps a single prefetch job
+	MaxPrefetchIDs = 5000
+)
+
+// PrefetchResult summarizes a cache warm-up job
+type PrefetchResult struct {
+	Total    int      `json:"total"`
+	Warmed   int64    `json:"warmed"`
+	Failed   int64    `json:"failed"`
+	Skipped  int64    `json:"skipped"`
+	Errors   []string `json:"errors,omitempty"`
+	Duration string   `json:"duration"`
+	Canceled bool     `json:"canceled"`
+}
+
+// Prefetch warms the metadata cache for external song, album, artist and playlist IDs. Lookups
+// are rate limited, pause while every mirror is cooling down, and stop when ctx is cancelled.
+func (s *SquidService) Prefetch(ctx context.Context, ids []string) PrefetchResult {
+	start := time.Now()
+	result := PrefetchResult{Total: len(ids)}
+	logger := logging.FromContext(ctx)
+
+	var errMu sync.Mutex
+	jobs := make(chan string)
+	ticker := time.NewTicker(prefetchInterval)
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	for w := 0; w < prefetchWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer safego.Recover()
+			for id := range jobs {
+				if err := s.prefetchOne(ctx, id); err != nil {
+					if err == errPrefetchUnsupported {
+						atomic.AddInt64(&result.Skipped, 1)
+						continue
+					}
+					atomic.AddInt64(&result.Failed, 1)
+					errMu.Lock()
+					if len(result.Errors) < 50 {
+						result.Errors = append(result.Errors, id+": "+err.Error())
+					}
+					errMu.Unlock()
+					continue
+				}
+				atomic.AddInt64(&result.Warmed, 1)
+			}
+		}()
+	}
+
+feed:
+	for _, id := range ids {
+		if err := s.waitForMirror(ctx); err != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			break feed
+		case <-ticker.C:
+		}
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- id:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	result.Canceled = ctx.Err() != nil
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+	logger.Info("Prefetch finished", "total", result.Total, "warmed", result.Warmed, "failed", result.Failed, "skipped", result.Skipped, "canceled", result.Canceled)
+	return result
+}
+
+// errPrefetchUnsupported marks IDs that have nothing to warm (local or unknown media types)
+var errPrefetchUnsupported = errors.New("unsupported id")
+
+func (s *SquidService) prefetchOne(ctx context.Context, id string) error {
+	isExternal, _, mediaType, _ := subsonic.ParseID(id)
+	if !isExternal {
+		return errPrefetchUnsupported
+	}
+
+	var err error
+	switch mediaType {
+	case "song":
+		_, err = s.GetSong(ctx, id)
+	case "album":
+		_, _, err = s.GetAlbum(ctx, id)
+	case "artist":
+		_, _, err = s.GetArtist(ctx, id)
+	case "playlist":
+		_, _, err = s.GetPlaylist(ctx, id)
+	default:
+		return errPrefetchUnsupported
+	}
+	return err
+}
+
+// waitForMirror blocks while every Squid URL is on cooldown, so a warm-up backs off instead of
+// forcing requests onto mirrors that just rate limited us
+func (s *SquidService) waitForMirror(ctx context.Context) error {
+	for {
+		states, _ := s.EndpointStates()
+		var earliest time.Time
+		available := len(states) == 0
+		for _, st := range states {
+			if st.Available {
+				available = true
+				break
+			}
+			if earliest.IsZero() || st.NextAvailable.Before(earliest) {
+				earliest = st.NextAvailable
+			}
+		}
+		if available {
+			return nil
+		}
+
+		wait := time.Until(earliest)
+		logging.FromContext(ctx).Info("Prefetch paused, all mirrors on cooldown", "resumeIn", wait.Round(time.Second))
+		if err := sleepCtx(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"jetstream/internal/config"
+	"jetstream/internal/logging"
+	"jetstream/internal/safego"
+	"jetstream/pkg/subsonic"
+	"sync"
+)
+
+// MusicProvider is an external catalog that can be searched, browsed and streamed. IDs passed
+// in and returned are full external IDs ("ext-{provider}-{type}-{id}").
+type MusicProvider interface {
+	// Name is the provider segment used in external IDs
+	Name() string
+	Search(ctx context.Context, query string) (*subsonic.SearchResult3, error)
+	GetSong(ctx context.Context, id string) (*subsonic.Song, error)
+	GetAlbum(ctx context.Context, id string) (*subsonic.Album, []subsonic.Song, error)
+	GetStreamURL(ctx context.Context, id string, quality string) (*TrackInfo, error)
+	GetCoverURL(ctx context.Context, id string, size int) (string, error)
+}
+
+// previewProvider is implemented by providers whose streams are only previews. Their songs
+// are streamed but never synced.
+type previewProvider interface {
+	PreviewOnly() bool
+}
+
+// Name identifies Squid's Tidal IDs
+func (s *SquidService) Name() string { return "squidwtf" }
+
+// Providers routes requests to the MusicProvider named in an ID and fans searches out across
+// every enabled provider
+type Providers struct {
+	byName map[string]MusicProvider
+	order  []MusicProvider // PROVIDERS order; earlier providers rank first in merged searches
+}
+
+// NewProviders enables the providers listed in PROVIDERS. Squid is always available for
+// routing since most existing IDs and synced files point at it.
+func NewProviders(cfg *config.Config, squid *SquidService) *Providers {
+	p := &Providers{byName: map[string]MusicProvider{squid.Name(): squid}}
+
+	for _, name := range cfg.Providers {
+		var provider MusicProvider
+		switch name {
+		case squid.Name():
+			provider = squid
+		case "deezer":
+			provider = NewDeezerService(cfg, squid.GetCache())
+		default:
+			logging.FromContext(context.Background()).Warn("Unknown provider in PROVIDERS, ignoring", "provider", name)
+			continue
+		}
+		p.byName[name] = provider
+		p.order = append(p.order, provider)
+	}
+	if len(p.order) == 0 {
+		p.order = []MusicProvider{squid}
+	}
+	return p
+}
+
+// For returns the provider that owns an ID. Bare IDs predate provider-prefixed IDs and are
+// always Tidal's.
+func (p *Providers) For(id string) (MusicProvider, error) {
+	isExternal, name, _, _ := subsonic.ParseID(id)
+	if !isExternal {
+		name = "squidwtf"
+	}
+	provider, ok := p.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: no provider %q for %s", ErrNotFound, name, id)
+	}
+	return provider, nil
+}
+
+// PreviewOnly reports whether id belongs to a provider that only streams previews
+func (p *Providers) PreviewOnly(id string) bool {
+	provider, err := p.For(id)
+	if err != nil {
+		return false
+	}
+	preview, ok := provider.(previewProvider)
+	return ok && preview.PreviewOnly()
+}
+
+func (p *Providers) GetSong(ctx context.Context, id string) (*subsonic.Song, error) {
+	provider, err := p.For(id)
+	if err != nil {
+		return nil, err
+	}
+	return provider.GetSong(ctx, id)
+}
+
+func (p *Providers) GetAlbum(ctx context.Context, id string) (*subsonic.Album, []subsonic.Song, error) {
+	provider, err := p.For(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return provider.GetAlbum(ctx, id)
+}
+
+func (p *Providers) GetStreamURL(ctx context.Context, id string, quality string) (*TrackInfo, error) {
+	provider, err := p.For(id)
+	if err != nil {
+		return nil, err
+	}
+	return provider.GetStreamURL(ctx, id, quality)
+}
+
+func (p *Providers) GetCoverURL(ctx context.Context, id string, size int) (string, error) {
+	provider, err := p.For(id)
+	if err != nil {
+		return "", err
+	}
+	return provider.GetCoverURL(ctx, id, size)
+}
+
+// Search queries every enabled provider concurrently and concatenates the results in PROVIDERS
+// order. It only fails when every provider does.
+func (p *Providers) Search(ctx context.Context, query string) (*subsonic.SearchResult3, error) {
+	if len(p.order) == 1 {
+		return p.order[0].Search(ctx, query)
+	}
+
+	results := make([]*subsonic.SearchResult3, len(p.order))
+	errs := make([]error, len(p.order))
+	var wg sync.WaitGroup
+	for i, provider := range p.order {
+		wg.Add(1)
+		go func(i int, provider MusicProvider) {
+			defer wg.Done()
+			defer safego.Recover()
+			results[i], errs[i] = provider.Search(ctx, query)
+			if errs[i] != nil {
+				logging.FromContext(ctx).Warn("Provider search failed", "provider", provider.Name(), "query", query, "error", errs[i])
+			}
+		}(i, provider)
+	}
+	wg.Wait()
+
+	merged := &subsonic.SearchResult3{}
+	found := false
+	for _, res := range results {
+		if res == nil {
+			continue
+		}
+		found = true
+		merged.Song = append(merged.Song, res.Song...)
+		merged.Album = append(merged.Album, res.Album...)
+		merged.Artist = append(merged.Artist, res.Artist...)
+		merged.Playlist = append(merged.Playlist, res.Playlist...)
+	}
+	if !found {
+		return nil, errs[0]
+	}
+	return merged, nil
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jetstream/internal/config"
+	"jetstream/internal/logging"
+	"jetstream/pkg/subsonic"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// playedKey stores when a synced song was last streamed or synced, as Unix seconds.
+// LIBRARY_MAX_BYTES evicts the songs played longest ago; songs without an entry count as
+// played when their file was last modified.
+func playedKey(songID string) string {
+	return "played:" + songID
+}
+
+// ErrLibraryFull is returned by SyncSong when LIBRARY_MAX_BYTES can't make room for a song
+var ErrLibraryFull = errors.New("library quota exceeded")
+
+// MarkPlayed records that a synced song was just streamed, moving it to the back of the
+// eviction queue
+func (s *SyncService) MarkPlayed(ctx context.Context, songID string) {
+	s.cache.Set(ctx, playedKey(songID), strconv.FormatInt(time.Now().Unix(), 10), verifiedTTL)
+}
+
+// lastPlayed is when a synced file was last streamed or synced
+func (s *SyncService) lastPlayed(ctx context.Context, f syncedFile) time.Time {
+	if val, err := s.cache.Get(ctx, playedKey(f.sidecar.ID)); err == nil {
+		if unix, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return time.Unix(unix, 0)
+		}
+	}
+	if info, err := os.Stat(f.path); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+// ComputeLibraryUsage walks the library once to learn how much the synced files take up. It
+// holds the quota lock so no sync settles or eviction runs meanwhile; files of syncs still in
+// flight are skipped and counted by their reservations instead, so none is counted twice.
+func (s *SyncService) ComputeLibraryUsage(ctx context.Context) error {
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+
+	var used int64
+	_, err := s.walkSynced(ctx, func(f syncedFile) {
+		if _, inFlight := s.reserved[f.path]; !inFlight {
+			used += f.size
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, size := range s.reserved {
+		used += size
+	}
+	s.usage.Store(used)
+	logging.FromContext(ctx).Info("Library usage computed", "usedBytes", used, "maxBytes", s.cfg.Get().LibraryMaxBytes)
+	return nil
+}
+
+// LibraryUsage returns the bytes used by synced files and the LIBRARY_MAX_BYTES quota (0 if unlimited)
+func (s *SyncService) LibraryUsage() (used, max int64) {
+	return s.usage.Load(), s.cfg.Get().LibraryMaxBytes
+}
+
+// reserve sets aside an estimated size for a song about to be synced to path, where a file of
+// replaced bytes may already be, evicting the least recently played synced songs if that
+// would break the quota. The returned func settles the reservation with the size actually
+// written minus replaced.
+func (s *SyncService) reserve(ctx context.Context, cfg *config.Config, song *subsonic.Song, path string, replaced int64) (func(actual int64), error) {
+	estimate := estimateSize(cfg, song)
+
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+
+	if max := s.cfg.Get().LibraryMaxBytes; max > 0 && s.usage.Load()+estimate > max {
+		s.evict(ctx, s.usage.Load()+estimate-max, song.ID)
+		if s.usage.Load()+estimate > max {
+			return nil, fmt.Errorf("%w: %d of %d bytes used, %s needs about %d", ErrLibraryFull, s.usage.Load(), max, song.ID, estimate)
+		}
+	}
+	s.usage.Add(estimate)
+	s.reserved[path] = estimate + replaced
+	return func(actual int64) {
+		s.quotaMu.Lock()
+		defer s.quotaMu.Unlock()
+		delete(s.reserved, path)
+		s.usage.Add(actual - estimate)
+	}, nil
+}
+
+// estimateSize guesses how much a song synced under cfg will take up before it is downloaded
+func estimateSize(cfg *config.Config, song *subsonic.Song) int64 {
+	duration := int64(song.Duration)
+	if duration <= 0 {
+		duration = 5 * 60
+	}
+	var rate int64
+	switch downloadFormat(cfg) {
+	case "opus":
+		rate = int64(kbps(cfg.OpusBitrate))
+	case "aac":
+		rate = int64(kbps(cfg.AACBitrate))
+	case "mp3":
+		rate = 320
+	default:
+		rate = 1411 // Lossless, CD quality
+	}
+	if cfg.KeepOriginal {
+		rate += 1411
+	}
+	return duration * rate * 1000 / 8
+}
+
+// evict deletes least recently played synced songs until need bytes are freed or nothing else
+// may go. Starred songs, and songs on starred albums or by starred artists, are kept, as is
+// keep. The caller holds quotaMu.
+func (s *SyncService) evict(ctx context.Context, need int64, keep string) {
+	starred := make(map[string]bool)
+	for _, mediaType := range []string{"song", "album", "artist"} {
+		items, err := s.squid.StarredItems(ctx, mediaType)
+		if err != nil {
+			// Without the starred list nothing can safely be evicted
+			logging.FromContext(ctx).Error("Failed to load starred items, not evicting", "type", mediaType, "error", err)
+			return
+		}
+		for _, item := range items {
+			starred[item.ID] = true
+		}
+	}
+
+	type candidate struct {
+		file   syncedFile
+		played time.Time
+	}
+	var candidates []candidate
+	if _, err := s.walkSynced(ctx, func(f syncedFile) {
+		id := f.sidecar.ID
+		if _, inFlight := s.reserved[f.path]; inFlight || id == keep || starred[id] || starred[f.sidecar.AlbumID] || starred[f.sidecar.ArtistID] {
+			return
+		}
+		candidates = append(candidates, candidate{file: f, played: s.lastPlayed(ctx, f)})
+	}); err != nil {
+		logging.FromContext(ctx).Error("Failed to walk library, not evicting", "error", err)
+		return
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].played.Before(candidates[j].played) })
+
+	var freed int64
+	for _, c := range candidates {
+		if freed >= need {
+			break
+		}
+		size := s.removeSynced(ctx, c.file.path, c.file.sidecar)
+		freed += size
+		logging.FromContext(ctx).Info("Evicted synced song for LIBRARY_MAX_BYTES", "id", c.file.sidecar.ID, "path", c.file.path, "size", size)
+	}
+	if freed < need {
+		logging.FromContext(ctx).Warn("Could not free enough library space", "needed", need, "freed", freed)
+	}
+}
+
+// removeSynced deletes a synced file with its sidecar and kept original, drops its index
+// entries and returns the bytes freed. The caller holds quotaMu.
+func (s *SyncService) removeSynced(ctx context.Context, path string, sidecar *songSidecar) int64 {
+	var freed int64
+	for _, p := range []string{path, path + ".json", sidecar.Original} {
+		if p == "" {
+			continue
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(p); err != nil {
+			logging.FromContext(ctx).Warn("Failed to delete synced file", "path", p, "error", err)
+			continue
+		}
+		freed += info.Size()
+	}
+	s.cache.Delete(ctx, "path:"+sidecar.ID)
+	s.forgetVerified(ctx, path)
+	s.cache.Delete(ctx, playedKey(sidecar.ID))
+	s.usage.Add(-freed)
+	removeIfOnlyCover(filepath.Dir(path))
+	return freed
+}
+
+// syncedSize is the size of a synced file with its sidecar and kept original, 0 if it doesn't exist
+func (s *SyncService) syncedSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	size := info.Size()
+	if st, err := os.Stat(path + ".json"); err == nil {
+		size += st.Size()
+	}
+	if sidecar, err := readSidecar(path); err == nil && sidecar.Original != "" {
+		if st, err := os.Stat(sidecar.Original); err == nil {
+			size += st.Size()
+		}
+	}
+	return size
+}
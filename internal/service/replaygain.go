@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"jetstream/internal/logging"
+	"jetstream/pkg/subsonic"
+	"math"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+)
+
+// replayGainReference is the ReplayGain 2.0 target loudness in LUFS
+const replayGainReference = -18.0
+
+// ReplayGain holds the track gain/peak computed by the ebur128 analysis pass
+type ReplayGain struct {
+	TrackGain float64 `json:"trackGain"` // dB relative to -18 LUFS
+	TrackPeak float64 `json:"trackPeak"` // Linear true peak (1.0 = full scale)
+}
+
+var (
+	ebur128Integrated = regexp.MustCompile(`I:\s+(-?[\d.]+) LUFS`)
+	ebur128Peak       = regexp.MustCompile(`Peak:\s+(-?[\d.]+|-inf) dBFS`)
+)
+
+// metadataArgs returns the ffmpeg -metadata flags for the computed gain
+func (rg *ReplayGain) metadataArgs() []string {
+	return []string{
+		"-metadata", fmt.Sprintf("REPLAYGAIN_TRACK_GAIN=%.2f dB", rg.TrackGain),
+		"-metadata", fmt.Sprintf("REPLAYGAIN_TRACK_PEAK=%.6f", rg.TrackPeak),
+	}
+}
+
+// needsReplayGain reports whether syncing to outputPath will measure ReplayGain, i.e. it is on
+// and no earlier sync left a measurement in the sidecar
+func (s *SyncService) needsReplayGain(outputPath string) bool {
+	return s.cfg.Get().EnableReplayGain && sidecarReplayGain(outputPath) == nil
+}
+
+// downloadSource fetches a remote source into a scratch file (in TEMP_DIR when set) so the
+// ReplayGain analysis and the transcode read it once from the CDN. The caller removes it.
+func (s *SyncService) downloadSource(ctx context.Context, source, outputPath string) (string, error) {
+	path := outputPath + ".source.part"
+	if dir := s.cfg.Get().TempDir; dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+		f, err := os.CreateTemp(dir, "source-*.part")
+		if err != nil {
+			return "", err
+		}
+		f.Close()
+		path = f.Name()
+	}
+	if err := s.copySource(ctx, source, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// replayGain returns the ReplayGain for a song when ENABLE_REPLAYGAIN is on: reused from the
+// sidecar of a previous sync if present, otherwise measured from source. Any failure returns
+// nil so the sync carries on without gain tags.
+func (s *SyncService) replayGain(ctx context.Context, song *subsonic.Song, source, outputPath string) *ReplayGain {
+	if !s.cfg.Get().EnableReplayGain {
+		return nil
+	}
+	if rg := sidecarReplayGain(outputPath); rg != nil {
+		return rg
+	}
+
+	rg, err := analyzeLoudness(ctx, source)
+	if err != nil {
+		logging.FromContext(ctx).Warn("ReplayGain analysis failed, skipping gain tags", "songID", song.ID, "error", err)
+		return nil
+	}
+	logging.FromContext(ctx).Debug("Computed ReplayGain", "songID", song.ID, "gain", rg.TrackGain, "peak", rg.TrackPeak)
+	return rg
+}
+
+// analyzeLoudness runs ffmpeg's ebur128 filter over source and reads the summary it prints
+func analyzeLoudness(ctx context.Context, source string) (*ReplayGain, error) {
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-nostats",
+		"-i", source,
+		"-map", "0:a",
+		"-af", "ebur128=peak=true",
+		"-f", "null", "-",
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w", err)
+	}
+
+	// The filter logs running values per frame; the summary at the end comes last
+	integrated := ebur128Integrated.FindAllSubmatch(output, -1)
+	peaks := ebur128Peak.FindAllSubmatch(output, -1)
+	if len(integrated) == 0 || len(peaks) == 0 {
+		return nil, fmt.Errorf("no ebur128 summary in ffmpeg output")
+	}
+
+	lufs, err := strconv.ParseFloat(string(integrated[len(integrated)-1][1]), 64)
+	if err != nil {
+		return nil, err
+	}
+	peak := 0.0
+	if peakDB := string(peaks[len(peaks)-1][1]); peakDB != "-inf" {
+		db, err := strconv.ParseFloat(peakDB, 64)
+		if err != nil {
+			return nil, err
+		}
+		peak = math.Pow(10, db/20)
+	}
+
+	return &ReplayGain{
+		TrackGain: math.Round((replayGainReference-lufs)*100) / 100,
+		TrackPeak: peak,
+	}, nil
+}
+
+// sidecarReplayGain reads the gain saved by an earlier sync of the same file
+func sidecarReplayGain(mediaPath string) *ReplayGain {
+	sidecar, err := readSidecar(mediaPath)
+	if err != nil {
+		return nil
+	}
+	return sidecar.ReplayGain
+}
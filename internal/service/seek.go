@@ -0,0 +1,60 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"jetstream/internal/logging"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// seekFormats maps a source mime type to the ffmpeg muxer and Content-Type its audio is
+// copied into when seeking. MP4 can't be written to a pipe, so its AAC goes out as ADTS.
+var seekFormats = map[string][2]string{
+	"audio/flac": {"flac", "audio/flac"},
+	"audio/mpeg": {"mp3", "audio/mpeg"},
+	"audio/ogg":  {"ogg", "audio/ogg"},
+	"audio/mp4":  {"adts", "audio/aac"},
+	"audio/aac":  {"adts", "audio/aac"},
+}
+
+// SeekContentType is the Content-Type SeekStream writes for a source of mimeType
+func SeekContentType(mimeType string) string {
+	if f, ok := seekFormats[strings.ToLower(mimeType)]; ok {
+		return f[1]
+	}
+	return "audio/mpeg"
+}
+
+// SeekStream writes the audio of input (a URL or local path) to w starting offset seconds in.
+// Known containers are remuxed without re-encoding; anything else is transcoded to MP3.
+func SeekStream(ctx context.Context, input, mimeType string, offset int, w io.Writer) error {
+	args := []string{"-hide_banner", "-loglevel", "error", "-ss", strconv.Itoa(offset), "-i", input, "-map", "0:a"}
+	if f, ok := seekFormats[strings.ToLower(mimeType)]; ok {
+		args = append(args, "-c:a", "copy", "-f", f[0])
+	} else {
+		args = append(args, "-c:a", "libmp3lame", "-b:a", "320k", "-f", "mp3")
+	}
+	args = append(args, "pipe:1")
+
+	logging.FromContext(ctx).Debug("FFmpeg seek command", "offset", offset, "mime", mimeType)
+	return runFFmpegPipe(ctx, "seek", args, w)
+}
+
+// runFFmpegPipe runs ffmpeg with its output going to w. what names the operation in errors.
+func runFFmpegPipe(ctx context.Context, what string, args []string, w io.Writer) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("ffmpeg %s failed: %v (output: %s)", what, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signedURLExpiry reads the expiry baked into a signed CDN URL: CloudFront-style "Expires="
+// or an Akamai token ("hdnea"/"__token__") carrying "exp=". ok is false when the URL has none.
+func signedURLExpiry(rawURL string) (expires time.Time, ok bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return time.Time{}, false
+	}
+	q := u.Query()
+	if v := q.Get("Expires"); v != "" {
+		return unixExpiry(v)
+	}
+	for _, key := range []string{"hdnea", "__token__"} {
+		for _, field := range strings.Split(q.Get(key), "~") {
+			if v, found := strings.CutPrefix(field, "exp="); found {
+				return unixExpiry(v)
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func unixExpiry(v string) (time.Time, bool) {
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || sec <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(sec, 0), true
+}
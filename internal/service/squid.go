@@ -4,35 +4,169 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"jetstream/internal/cache"
 	"jetstream/internal/config"
+	"jetstream/internal/logging"
 	"jetstream/pkg/subsonic"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"log/slog"
+	"math/rand"
 
 	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
-	UserAgent   = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:83.0) Gecko/20100101 Firefox/83.0"
-	CachePrefix = "jetstream:cache:v2:"
+	DefaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:83.0) Gecko/20100101 Firefox/83.0"
+	CachePrefix      = "jetstream:cache:v2:"
+
+	// Bump whenever a cached struct (Song, Album, cache entries...) changes shape;
+	// entries written with another version are discarded and re-fetched
+	CacheSchemaVersion = 1
+
+	// Sentinel stored in place of a cached value when a lookup failed
+	negativeCacheValue = "__NOTFOUND__"
+)
+
+// unversionedKeys are the cache key prefixes kept outside CacheSchemaVersion: the library's
+// path index, verified markers and play times are plain strings, and rebuilding them means a
+// full scan. Stars are the user's own data and can't be rebuilt at all.
+var unversionedKeys = []string{"path:", "verified:", "played:", "starred:"}
+
+// Stream qualities accepted by the Squid /track/ endpoint
+const (
+	QualityLow      = "LOW"
+	QualityHigh     = "HIGH"
+	QualityLossless = "LOSSLESS"
+	QualityHiRes    = "HI_RES"
+)
+
+var validQualities = map[string]bool{
+	QualityLow:      true,
+	QualityHigh:     true,
+	QualityLossless: true,
+	QualityHiRes:    true,
+}
+
+// IsValidQuality reports whether q is a quality tier Squid understands
+func IsValidQuality(q string) bool {
+	return validQualities[strings.ToUpper(q)]
+}
+
+// NormalizeQuality uppercases q and falls back to LOSSLESS for unknown values
+func NormalizeQuality(q string) string {
+	q = strings.ToUpper(strings.TrimSpace(q))
+	if !validQualities[q] {
+		return QualityLossless
+	}
+	return q
+}
+
+// QualityBitRate is the nominal bitrate in kbps of a lossy quality tier, or 0 for the lossless
+// tiers whose bitrate depends on the recording
+func QualityBitRate(q string) int {
+	switch NormalizeQuality(q) {
+	case QualityLow:
+		return 96
+	case QualityHigh:
+		return 320
+	default:
+		return 0
+	}
+}
+
+// failureClass decides how harshly a failed Squid URL is penalized
+type failureClass int
+
+const (
+	failureTransient failureClass = iota // Rotate only, no cooldown
+	failureServer                        // 5xx or unreachable host: exponential cooldown
+	failureRateLimit                     // 429: exponential cooldown, one step further along the schedule
+)
+
+var (
+	// ErrNotFound is returned when Squid answers but the requested resource doesn't exist, or the
+	// ID can't name one
+	ErrNotFound = errors.New("not found")
+	// ErrUpstream is returned when every mirror failed, so the resource may well exist
+	ErrUpstream = errors.New("upstream unavailable")
 )
 
+// httpStatusError carries the status code of a non-200 Squid response
+type httpStatusError struct {
+	Code       int
+	RetryAfter time.Duration // Server's Retry-After hint, 0 when absent
+}
+
+// newStatusError builds a status error from a response, capturing any Retry-After hint
+func newStatusError(resp *http.Response) *httpStatusError {
+	return &httpStatusError{
+		Code:       resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// parseRetryAfter reads a Retry-After header in either delay-seconds or HTTP-date form.
+// It returns 0 for a missing, malformed or already-elapsed value.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP %d", e.Code)
+}
+
+// Is lets a 404 match ErrNotFound via errors.Is
+func (e *httpStatusError) Is(target error) bool {
+	return target == ErrNotFound && e.Code == http.StatusNotFound
+}
+
 type URLState struct {
 	URL           string
 	NextAvailable time.Time
+	Failures      int               // Consecutive failures, reset on success
+	Headers       map[string]string `json:"-"` // From SQUID_MIRRORS; usually credentials
+	Preferred     bool              // Listed in SQUID_MIRRORS, so kept ahead of faster public mirrors
+	Latency       time.Duration     // EWMA of probe round trips; 0 until first probed
 }
 
 type SquidService struct {
 	client          *http.Client
-	cfg             *config.Config
-	redis           *redis.Client
+	cfg             *config.Live
+	redis           *redis.Client // Only read to import stars older versions kept in sorted sets
+	redisBackend    bool          // The cache is backed by Redis rather than memory
+	cache           cache.Cache
+	starredMu       sync.Mutex // Serializes updates of the starred sets
 	currentURLIndex int
 	urlMutex        sync.RWMutex
 	urlStates       []URLState
+	inflight        singleflight.Group // Collapses concurrent cache misses for the same key
+	userAgents      []string
+	uaIndex         uint64
 }
 
 type albumCacheEntry struct {
@@ -53,9 +187,11 @@ type artistCacheEntry struct {
 type TrackInfo struct {
 	DownloadURL string
 	MimeType    string
+	Expires     time.Time // When the signed DownloadURL stops working; zero if unknown
 }
 
-func NewSquidService(cfg *config.Config) *SquidService {
+func NewSquidService(live *config.Live) *SquidService {
+	cfg := live.Get()
 	rdb := redis.NewClient(&redis.Options{
 		Addr: cfg.RedisAddr,
 	})
@@ -67,34 +203,113 @@ func NewSquidService(cfg *config.Config) *SquidService {
 		IdleConnTimeout:     90 * time.Second,
 	}
 
-	states := make([]URLState, 0)
-	if len(cfg.SquidURLs) > 0 {
-		for _, u := range cfg.SquidURLs {
-			states = append(states, URLState{URL: u, NextAvailable: time.Now()})
-		}
-	} else if cfg.SquidURL != "" {
-		states = append(states, URLState{URL: cfg.SquidURL, NextAvailable: time.Now()})
+	userAgents := cfg.SquidUserAgents
+	if len(userAgents) == 0 {
+		userAgents = []string{DefaultUserAgent}
 	}
 
-	return &SquidService{
-		client: &http.Client{
-			Transport: transport,
-			Timeout:   30 * time.Second,
-		},
-		cfg:             cfg,
+	backend := newCache(cfg, rdb)
+	_, redisBackend := backend.(*cache.RedisCache)
+
+	s := &SquidService{
+		cfg:             live,
 		redis:           rdb,
+		redisBackend:    redisBackend,
+		cache:           cache.NewVersioned(backend, CacheSchemaVersion, unversionedKeys...),
 		currentURLIndex: 0,
-		urlStates:       states,
+		urlStates:       buildURLStates(cfg, nil),
+		userAgents:      userAgents,
+	}
+	s.client = &http.Client{
+		Transport: &mirrorTransport{base: transport, squid: s},
+		Timeout:   30 * time.Second,
 	}
+	return s
 }
 
-// getCurrentURL returns the currently active Squid URL, skipping those on cooldown
-func (s *SquidService) getCurrentURL() string {
+// buildURLStates lists the configured Squid URLs in order, keeping the cooldown of any URL
+// that was already in prev
+func buildURLStates(cfg *config.Config, prev []URLState) []URLState {
+	urls := cfg.SquidURLs
+	if len(urls) == 0 && cfg.SquidURL != "" {
+		urls = []string{cfg.SquidURL}
+	}
+
+	known := make(map[string]URLState, len(prev))
+	for _, st := range prev {
+		known[st.URL] = st
+	}
+	headers := make(map[string]map[string]string, len(cfg.SquidMirrors))
+	for _, m := range cfg.SquidMirrors {
+		headers[m.URL] = m.Headers
+	}
+
+	states := make([]URLState, 0, len(urls))
+	for _, u := range urls {
+		st, ok := known[u]
+		if !ok {
+			st = URLState{URL: u, NextAvailable: time.Now()}
+		}
+		st.Headers, st.Preferred = headers[u]
+		states = append(states, st)
+	}
+	return states
+}
+
+// mirrorHeaders returns the SQUID_MIRRORS headers for the mirror rawURL belongs to, if any
+func (s *SquidService) mirrorHeaders(rawURL string) map[string]string {
 	s.urlMutex.RLock()
 	defer s.urlMutex.RUnlock()
+	for _, st := range s.urlStates {
+		if len(st.Headers) > 0 && (rawURL == st.URL || strings.HasPrefix(rawURL, st.URL+"/") || strings.HasPrefix(rawURL, st.URL+"?")) {
+			return st.Headers
+		}
+	}
+	return nil
+}
+
+// mirrorTransport adds each mirror's configured headers to the requests sent to it, so every
+// action run by tryWithFallback authenticates without building its requests differently
+type mirrorTransport struct {
+	base  http.RoundTripper
+	squid *SquidService
+}
+
+func (t *mirrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	headers := t.squid.mirrorHeaders(req.URL.String())
+	if len(headers) == 0 {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// ReloadURLs applies the Squid URL list of a reloaded config, restarting from the first URL
+func (s *SquidService) ReloadURLs() {
+	s.urlMutex.Lock()
+	defer s.urlMutex.Unlock()
+	s.urlStates = buildURLStates(s.cfg.Get(), s.urlStates)
+	s.currentURLIndex = 0
+}
+
+// NextUserAgent returns the next User-Agent from the configured pool (round-robin)
+func (s *SquidService) NextUserAgent() string {
+	n := atomic.AddUint64(&s.uaIndex, 1)
+	return s.userAgents[(n-1)%uint64(len(s.userAgents))]
+}
+
+// selectURL returns the Squid URL to use next, skipping those on cooldown, and makes it the
+// current one. Choosing and moving the index happen under one lock, so markFailure can tell
+// whether a failed URL is still current or a concurrent request already rotated past it.
+func (s *SquidService) selectURL() string {
+	s.urlMutex.Lock()
+	defer s.urlMutex.Unlock()
 
 	if len(s.urlStates) == 0 {
-		return s.cfg.SquidURL
+		return s.cfg.Get().SquidURL
 	}
 
 	now := time.Now()
@@ -102,6 +317,7 @@ func (s *SquidService) getCurrentURL() string {
 	for i := 0; i < len(s.urlStates); i++ {
 		idx := (s.currentURLIndex + i) % len(s.urlStates)
 		if s.urlStates[idx].NextAvailable.Before(now) {
+			s.currentURLIndex = idx
 			return s.urlStates[idx].URL
 		}
 	}
@@ -112,99 +328,213 @@ func (s *SquidService) getCurrentURL() string {
 	return s.urlStates[s.currentURLIndex].URL
 }
 
-// rotateURL moves to the next fallback URL and marks the current one as temporarily unavailable (cooldown)
-func (s *SquidService) markFailure(baseURL string, cooldown time.Duration) {
+// markFailure rotates to the next fallback URL and, for server errors and rate limits,
+// puts the failing URL on an exponential cooldown based on its consecutive failures.
+// A positive retryAfter from the server replaces the computed cooldown, clamped to
+// SQUID_COOLDOWN_MAX. Only the first of several concurrent failures on the current URL
+// counts; the rest find it already rotated away and change nothing, so a burst of failures
+// neither skips a healthy mirror nor escalates the cooldown more than once.
+func (s *SquidService) markFailure(baseURL string, class failureClass, retryAfter time.Duration) {
 	s.urlMutex.Lock()
 	defer s.urlMutex.Unlock()
 
-	found := false
-	for i := range s.urlStates {
-		if s.urlStates[i].URL == baseURL {
-			s.urlStates[i].NextAvailable = time.Now().Add(cooldown)
-			if cooldown > 0 {
-				slog.Warn("Marked URL on cooldown", "url", baseURL, "until", s.urlStates[i].NextAvailable)
-			}
-			found = true
-			break
+	if len(s.urlStates) == 0 || s.urlStates[s.currentURLIndex].URL != baseURL {
+		slog.Debug("Squid URL already rotated away, ignoring failure", "url", baseURL)
+		return
+	}
+
+	if class != failureTransient {
+		st := &s.urlStates[s.currentURLIndex]
+		st.Failures++
+		steps := st.Failures
+		if class == failureRateLimit {
+			steps++
 		}
+		cooldown := s.backoff(steps)
+		if retryAfter > 0 {
+			cooldown = s.clampCooldown(retryAfter)
+		}
+		st.NextAvailable = time.Now().Add(cooldown)
+		slog.Warn("Marked URL on cooldown", "url", baseURL, "failures", st.Failures, "until", st.NextAvailable)
 	}
 
-	if found && len(s.urlStates) > 1 {
+	if len(s.urlStates) > 1 {
 		s.currentURLIndex = (s.currentURLIndex + 1) % len(s.urlStates)
 		slog.Info("Rotating to next URL index", "newIndex", s.currentURLIndex)
 	}
 }
 
-// tryWithFallback attempts the action with all available URLs
+// markSuccess resets the consecutive failure counter for baseURL
+func (s *SquidService) markSuccess(baseURL string) {
+	s.urlMutex.Lock()
+	defer s.urlMutex.Unlock()
+
+	for i := range s.urlStates {
+		if s.urlStates[i].URL == baseURL {
+			s.urlStates[i].Failures = 0
+			return
+		}
+	}
+}
+
+// clampCooldown caps a server-provided cooldown at SQUID_COOLDOWN_MAX
+func (s *SquidService) clampCooldown(d time.Duration) time.Duration {
+	if max := s.cfg.Get().SquidCooldownMax; max > 0 && d > max {
+		return max
+	}
+	return d
+}
+
+// backoff returns the cooldown for the nth step of the schedule: base * 4^(n-1), capped at max
+func (s *SquidService) backoff(steps int) time.Duration {
+	base, max := s.cfg.Get().SquidCooldownBase, s.cfg.Get().SquidCooldownMax
+	if base <= 0 {
+		base = time.Minute
+	}
+	if max < base {
+		max = base
+	}
+
+	d := base
+	for i := 1; i < steps; i++ {
+		d *= 4
+		if d >= max {
+			return max
+		}
+	}
+	return d
+}
+
+// EndpointState is a point-in-time view of a Squid URL for health reporting
+type EndpointState struct {
+	URL           string    `json:"url"`
+	NextAvailable time.Time `json:"next_available"`
+	Available     bool      `json:"available"`
+	Failures      int       `json:"failures"`
+	LatencyMs     int64     `json:"latency_ms,omitempty"`
+}
+
+// EndpointStates returns a snapshot of every Squid URL and the current rotation index
+func (s *SquidService) EndpointStates() ([]EndpointState, int) {
+	s.urlMutex.RLock()
+	defer s.urlMutex.RUnlock()
+
+	now := time.Now()
+	states := make([]EndpointState, 0, len(s.urlStates))
+	for _, st := range s.urlStates {
+		states = append(states, EndpointState{
+			URL:           st.URL,
+			NextAvailable: st.NextAvailable,
+			Available:     st.NextAvailable.Before(now),
+			Failures:      st.Failures,
+			LatencyMs:     st.Latency.Milliseconds(),
+		})
+	}
+	return states, s.currentURLIndex
+}
+
+// jitter returns d plus a random extra of up to d, so concurrent retries don't line up
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration(rand.Int63n(int64(d)+1))
+}
+
+// sleepCtx waits for d or until ctx is done
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tryWithFallback attempts the action with all available URLs, walking the list
+// up to SQUID_MAX_PASSES times before giving up
 func (s *SquidService) tryWithFallback(ctx context.Context, action func(baseURL string) error) error {
 	var lastErr error
-	maxAttempts := len(s.urlStates)
-	if maxAttempts == 0 {
-		maxAttempts = 1
+	s.urlMutex.RLock()
+	perPass := len(s.urlStates)
+	s.urlMutex.RUnlock()
+	if perPass == 0 {
+		perPass = 1
+	}
+	passes := s.cfg.Get().SquidMaxPasses
+	if passes < 1 {
+		passes = 1
 	}
+	maxAttempts := perPass * passes
 
-	// We allow walking through the list once. If we hit the end and everything is failed/cooldown, we wrap
 	for attempt := 0; attempt < maxAttempts; attempt++ {
-		baseURL := s.getCurrentURL()
+		// Starting another full pass: back off so a glitch across every mirror can clear
+		if attempt > 0 && attempt%perPass == 0 {
+			pass := attempt / perPass
+			logging.FromContext(ctx).Warn("All Squid URLs failed, starting another pass", "pass", pass+1, "of", passes)
+			if err := sleepCtx(ctx, jitter(time.Duration(pass)*500*time.Millisecond)); err != nil {
+				return lastErr
+			}
+		}
+
+		baseURL := s.selectURL()
 		err := action(baseURL)
 		if err == nil {
+			s.markSuccess(baseURL)
 			return nil
 		}
 
 		lastErr = err
-		errStr := err.Error()
-
-		// Detect 429
-		is429 := contains(errStr, "429")
-		// Detect 404
-		is404 := contains(errStr, "404")
-		// Detect connectivity issues (e.g., "network error", "connection refused", "timeout")
-		isUnavailable := contains(errStr, "network error") ||
-			contains(errStr, "connection") ||
-			contains(errStr, "timeout") ||
-			contains(errStr, "no such host")
-
-		// Detect missing data (valid response but resource doesn't exist)
-		isMissingData := contains(errStr, "no picture") ||
-			contains(errStr, "no cover art") ||
-			contains(errStr, "no art") ||
-			contains(errStr, "not found")
-
-		if isMissingData || is404 {
-			slog.Debug("Resource missing or not found (404), stopping retries", "baseURL", baseURL, "error", err)
-			return err // Return immediately, no cooldown, no rotation
-		}
 
-		if is429 {
-			slog.Warn("Rate limited (429) on endpoint", "baseURL", baseURL)
-			s.markFailure(baseURL, 30*time.Minute)
-			continue
+		// Caller gave up, don't burn through the remaining mirrors
+		if ctx.Err() != nil {
+			return err
 		}
 
-		if isUnavailable {
-			slog.Warn("Endpoint unavailable, rotating", "baseURL", baseURL, "error", err)
-			s.markFailure(baseURL, 0) // Rotate only, no cooldown
-			continue
-		}
+		var statusErr *httpStatusError
+		var netErr net.Error
 
-		slog.Warn("Squid request failed with unknown error, rotating", "baseURL", baseURL, "error", err, "attempt", attempt+1)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			// Valid response but the resource doesn't exist
+			logging.FromContext(ctx).Debug("Resource missing or not found (404), stopping retries", "baseURL", baseURL, "error", err)
+			return err // Return immediately, no cooldown, no rotation
 
-		// Any other failure triggers a rotation without cooldown
-		s.markFailure(baseURL, 0)
-		time.Sleep(100 * time.Millisecond)
-	}
+		case errors.As(err, &statusErr) && statusErr.Code == http.StatusTooManyRequests:
+			logging.FromContext(ctx).Warn("Rate limited (429) on endpoint", "baseURL", baseURL, "retryAfter", statusErr.RetryAfter)
+			s.markFailure(baseURL, failureRateLimit, statusErr.RetryAfter)
 
-	slog.Error("All fallback endpoints failed or on cooldown", "lastErr", lastErr)
-	return lastErr
-}
+		case errors.As(err, &statusErr) && statusErr.Code >= 500:
+			logging.FromContext(ctx).Warn("Server error on endpoint", "baseURL", baseURL, "status", statusErr.Code)
+			s.markFailure(baseURL, failureServer, statusErr.RetryAfter)
+
+		case errors.As(err, &netErr):
+			// Connectivity issues (connection refused, timeout, DNS)
+			logging.FromContext(ctx).Warn("Endpoint unavailable, rotating", "baseURL", baseURL, "error", err)
+			s.markFailure(baseURL, failureServer, 0)
+
+		default:
+			logging.FromContext(ctx).Warn("Squid request failed with unknown error, rotating", "baseURL", baseURL, "error", err, "attempt", attempt+1)
 
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr || (len(substr) > 0 && (s[:len(substr)] == substr || contains(s[1:], substr))))
+			// Any other failure triggers a rotation without cooldown
+			s.markFailure(baseURL, failureTransient, 0)
+			if err := sleepCtx(ctx, jitter(100*time.Millisecond)); err != nil {
+				return lastErr
+			}
+		}
+	}
+
+	logging.FromContext(ctx).Error("All fallback endpoints failed or on cooldown", "lastErr", lastErr)
+	return fmt.Errorf("%w: %w", ErrUpstream, lastErr)
 }
 
-func (s *SquidService) GetStreamURL(ctx context.Context, trackID string) (*TrackInfo, error) {
+// GetStreamURL resolves the CDN URL for a track at the requested quality.
+// An empty quality falls back to the configured STREAM_QUALITY.
+func (s *SquidService) GetStreamURL(ctx context.Context, trackID string, quality string) (*TrackInfo, error) {
 	_, _, _, rawID := subsonic.ParseID(trackID)
-	quality := "LOSSLESS"
+	if quality == "" {
+		quality = s.cfg.Get().StreamQuality
+	}
+	quality = NormalizeQuality(quality)
 
 	var trackInfo *TrackInfo
 	err := s.tryWithFallback(ctx, func(baseURL string) error {
@@ -214,18 +544,18 @@ func (s *SquidService) GetStreamURL(ctx context.Context, trackID string) (*Track
 		if err != nil {
 			return err
 		}
-		req.Header.Set("User-Agent", UserAgent)
+		req.Header.Set("User-Agent", s.NextUserAgent())
 
-		slog.Debug("Requesting Stream Info", "url", url)
+		logging.FromContext(ctx).Debug("Requesting Stream Info", "url", url)
 
 		resp, err := s.client.Do(req)
 		if err != nil {
-			return fmt.Errorf("network error: %v", err)
+			return fmt.Errorf("network error: %w", err)
 		}
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("HTTP %d", resp.StatusCode)
+			return newStatusError(resp)
 		}
 
 		var result struct {
@@ -256,12 +586,13 @@ func (s *SquidService) GetStreamURL(ctx context.Context, trackID string) (*Track
 			return fmt.Errorf("no download urls in manifest")
 		}
 
-		slog.Debug("Decoded Stream URL", "trackID", trackID, "mime", manifest.MimeType)
+		logging.FromContext(ctx).Debug("Decoded Stream URL", "trackID", trackID, "quality", quality, "mime", manifest.MimeType)
 
 		trackInfo = &TrackInfo{
 			DownloadURL: manifest.URLs[0],
 			MimeType:    manifest.MimeType,
 		}
+		trackInfo.Expires, _ = signedURLExpiry(trackInfo.DownloadURL)
 		return nil
 	})
 
@@ -271,6 +602,79 @@ func (s *SquidService) GetStreamURL(ctx context.Context, trackID string) (*Track
 	return trackInfo, nil
 }
 
+// cacheNegative remembers a lookup that failed with err so repeated requests for a dead ID
+// don't trigger a full sweep across every mirror. Only misses are remembered: outages, rate
+// limits and timeouts are retried, and negativeCacheError reports every entry as ErrNotFound.
+func (s *SquidService) cacheNegative(ctx context.Context, cacheKey string, err error) {
+	if s.cfg.Get().NegativeCacheTTL <= 0 || ctx.Err() != nil {
+		return
+	}
+	if !errors.Is(err, ErrNotFound) || errors.Is(err, ErrUpstream) {
+		return
+	}
+	s.cache.Set(ctx, cacheKey, negativeCacheValue, s.cfg.Get().NegativeCacheTTL)
+}
+
+// negativeCacheError is returned when a lookup is served from the negative cache
+func negativeCacheError(id string) error {
+	return fmt.Errorf("%w: %s (cached)", ErrNotFound, id)
+}
+
+// shared runs fetch once for all concurrent callers of the same cache key. The fetch is
+// detached from any single caller's cancellation so one client going away doesn't fail
+// the others; each caller still stops waiting when its own ctx is done. Results, including
+// errors, are only shared for the duration of the call.
+func shared[T any](s *SquidService, ctx context.Context, key string, fetch func(ctx context.Context) (T, error)) (T, error) {
+	ch := s.inflight.DoChan(key, func() (interface{}, error) {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*time.Minute)
+		defer cancel()
+		return fetch(fetchCtx)
+	})
+
+	select {
+	case res := <-ch:
+		v, _ := res.Val.(T)
+		return v, res.Err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// newCache picks the cache backend, falling back to memory when Redis can't be reached
+func newCache(cfg *config.Config, rdb *redis.Client) cache.Cache {
+	if cfg.CacheBackend == "memory" {
+		slog.Info("Using in-memory cache", "entries", cfg.CacheEntries)
+		return cache.NewMemoryCache(cfg.CacheEntries)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		slog.Warn("Redis unreachable, falling back to in-memory cache", "addr", cfg.RedisAddr, "error", err)
+		return cache.NewMemoryCache(cfg.CacheEntries)
+	}
+	return cache.NewRedisCache(rdb)
+}
+
+// PurgeCache removes cached Squid metadata matching pattern, a glob relative to
+// CachePrefix such as "search:*". It returns the number of entries removed.
+func (s *SquidService) PurgeCache(ctx context.Context, pattern string) (int, error) {
+	n, err := s.cache.Purge(ctx, CachePrefix+pattern)
+	logging.FromContext(ctx).Info("Purged cache", "pattern", pattern, "removed", n, "error", err)
+	return n, err
+}
+
+// GetCache returns the cache backend shared by all services
+func (s *SquidService) GetCache() cache.Cache {
+	return s.cache
+}
+
+// GetConfig returns the current configuration, which a reload may replace between calls
+func (s *SquidService) GetConfig() *config.Config {
+	return s.cfg.Get()
+}
+
 func (s *SquidService) GetRedis() *redis.Client {
 	return s.redis
 }
@@ -2,23 +2,26 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"jetstream/internal/logging"
+	"jetstream/internal/safego"
 	"jetstream/pkg/subsonic"
-	"log/slog"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 )
 
-// GetLyrics fetches lyrics for a track ID
+// GetLyrics fetches lyrics for a track ID, as LRC when timed lyrics are available
 func (s *SquidService) GetLyrics(ctx context.Context, id string) (string, error) {
 	cacheKey := CachePrefix + fmt.Sprintf("lyrics:%s", id)
 
 	// Check Cache
-	if val, err := s.redis.Get(ctx, cacheKey).Result(); err == nil && val != "" {
+	if val, err := s.cache.Get(ctx, cacheKey); err == nil && val != "" {
 		return val, nil
 	}
 
@@ -28,7 +31,7 @@ func (s *SquidService) GetLyrics(ctx context.Context, id string) (string, error)
 	err := s.tryWithFallback(ctx, func(baseURL string) error {
 		urlStr := fmt.Sprintf("%s/lyrics/?id=%s", baseURL, numericID)
 		req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
-		req.Header.Set("User-Agent", UserAgent)
+		req.Header.Set("User-Agent", s.NextUserAgent())
 		resp, err := s.client.Do(req)
 		if err != nil {
 			return err
@@ -36,17 +39,32 @@ func (s *SquidService) GetLyrics(ctx context.Context, id string) (string, error)
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("HTTP %d", resp.StatusCode)
+			return newStatusError(resp)
 		}
 
 		var result struct {
-			Data string `json:"data"`
+			Data json.RawMessage `json:"data"`
 		}
 		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 			return err
 		}
 
-		lyrics = result.Data
+		// Mirrors return either the text itself or Tidal's object with plain lyrics and LRC
+		// subtitles; prefer the timed version
+		if err := json.Unmarshal(result.Data, &lyrics); err == nil {
+			return nil
+		}
+		var tidal struct {
+			Lyrics    string `json:"lyrics"`
+			Subtitles string `json:"subtitles"`
+		}
+		if err := json.Unmarshal(result.Data, &tidal); err != nil {
+			return err
+		}
+		lyrics = tidal.Subtitles
+		if lyrics == "" {
+			lyrics = tidal.Lyrics
+		}
 		return nil
 	})
 
@@ -56,7 +74,7 @@ func (s *SquidService) GetLyrics(ctx context.Context, id string) (string, error)
 
 	// Cache Result
 	if lyrics != "" {
-		s.redis.Set(ctx, cacheKey, lyrics, 7*24*time.Hour)
+		s.cache.Set(ctx, cacheKey, lyrics, 7*24*time.Hour)
 	}
 
 	return lyrics, nil
@@ -67,7 +85,10 @@ func (s *SquidService) GetSong(ctx context.Context, id string) (*subsonic.Song,
 	cacheKey := CachePrefix + fmt.Sprintf("song:%s", id)
 
 	// Check Cache
-	if val, err := s.redis.Get(ctx, cacheKey).Result(); err == nil {
+	if val, err := s.cache.Get(ctx, cacheKey); err == nil {
+		if val == negativeCacheValue {
+			return nil, negativeCacheError(id)
+		}
 		var song subsonic.Song
 		if err := json.Unmarshal([]byte(val), &song); err == nil {
 			return &song, nil
@@ -75,19 +96,31 @@ func (s *SquidService) GetSong(ctx context.Context, id string) (*subsonic.Song,
 	}
 
 	// NEW: Check for local metadata sidecar if sync path is known
-	if path, err := s.redis.Get(ctx, "path:"+id).Result(); err == nil {
+	if path, err := s.cache.Get(ctx, "path:"+id); err == nil {
 		jsonPath := path + ".json"
 		if data, err := os.ReadFile(jsonPath); err == nil {
 			var song subsonic.Song
 			if err := json.Unmarshal(data, &song); err == nil {
-				slog.Debug("Found local metadata sidecar", "path", jsonPath)
+				logging.FromContext(ctx).Debug("Found local metadata sidecar", "path", jsonPath)
 				// Put back into short-term cache
-				s.redis.Set(ctx, cacheKey, data, 24*time.Hour)
+				s.cache.Set(ctx, cacheKey, string(data), 24*time.Hour)
 				return &song, nil
 			}
 		}
 	}
 
+	res, err := shared(s, ctx, cacheKey, func(ctx context.Context) (*subsonic.Song, error) {
+		return s.loadSong(ctx, id, cacheKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+	song := *res
+	return &song, nil
+}
+
+// loadSong fetches a song from Squid and caches the result
+func (s *SquidService) loadSong(ctx context.Context, id, cacheKey string) (*subsonic.Song, error) {
 	_, _, _, numericID := subsonic.ParseID(id)
 
 	var song *subsonic.Song
@@ -95,24 +128,28 @@ func (s *SquidService) GetSong(ctx context.Context, id string) (*subsonic.Song,
 		// Try /info/ first for clean metadata
 		urlStr := fmt.Sprintf("%s/info/?id=%s", baseURL, numericID)
 		req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
-		req.Header.Set("User-Agent", UserAgent)
+		req.Header.Set("User-Agent", s.NextUserAgent())
 		resp, err := s.client.Do(req)
 
 		if err != nil || resp.StatusCode != http.StatusOK {
-			if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
-				return fmt.Errorf("HTTP 429")
+			if resp != nil {
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusTooManyRequests {
+					return newStatusError(resp)
+				}
 			}
 			// Fallback to /track/ if /info/ fails
-			slog.Warn("/info/ failed, trying /track/", "numericID", numericID)
+			logging.FromContext(ctx).Warn("/info/ failed, trying /track/", "numericID", numericID)
 			urlStr = fmt.Sprintf("%s/track/?id=%s", baseURL, numericID)
 			req, _ = http.NewRequestWithContext(ctx, "GET", urlStr, nil)
-			req.Header.Set("User-Agent", UserAgent)
+			req.Header.Set("User-Agent", s.NextUserAgent())
 			resp, err = s.client.Do(req)
-			if err != nil || resp.StatusCode != http.StatusOK {
-				if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
-					return fmt.Errorf("HTTP 429")
-				}
-				return fmt.Errorf("failed to fetch song info from both /info/ and /track/")
+			if err != nil {
+				return err
+			}
+			if resp.StatusCode != http.StatusOK {
+				resp.Body.Close()
+				return newStatusError(resp)
 			}
 		}
 		defer resp.Body.Close()
@@ -120,18 +157,28 @@ func (s *SquidService) GetSong(ctx context.Context, id string) (*subsonic.Song,
 		// Parse Response
 		var result struct {
 			Data struct {
-				ID          int64  `json:"id"`
-				Title       string `json:"title"`
-				Duration    int    `json:"duration"`
-				TrackNumber int    `json:"trackNumber"`
-				Artist      struct {
+				ID              int64    `json:"id"`
+				Title           string   `json:"title"`
+				Duration        int      `json:"duration"`
+				TrackNumber     int      `json:"trackNumber"`
+				VolumeNumber    int      `json:"volumeNumber"`
+				DiscNumber      int      `json:"discNumber"`
+				Bpm             int      `json:"bpm"`
+				StreamReady     *bool    `json:"streamReady"`
+				StreamStartDate string   `json:"streamStartDate"`
+				AudioQuality    string   `json:"audioQuality"`
+				AudioModes      []string `json:"audioModes"`
+				BitDepth        int      `json:"bitDepth"`   // Only on /track/ responses
+				SampleRate      int      `json:"sampleRate"` // Only on /track/ responses
+				Artist          struct {
 					ID   int64  `json:"id"`
 					Name string `json:"name"`
 				} `json:"artist"`
 				Album struct {
-					ID    int64  `json:"id"`
-					Title string `json:"title"`
-					Cover string `json:"cover"`
+					ID          int64  `json:"id"`
+					Title       string `json:"title"`
+					Cover       string `json:"cover"`
+					ReleaseDate string `json:"releaseDate"`
 				} `json:"album"`
 			} `json:"data"`
 		}
@@ -141,6 +188,17 @@ func (s *SquidService) GetSong(ctx context.Context, id string) (*subsonic.Song,
 		}
 
 		item := result.Data
+		if item.StreamReady != nil && !*item.StreamReady {
+			logging.FromContext(ctx).Warn("Track is not stream-ready upstream, playback and sync will likely fail", "id", id)
+		}
+
+		// The track's album object usually carries the release date; the stream start date is
+		// the closest substitute when it doesn't
+		year := releaseYear(item.Album.ReleaseDate)
+		if year == 0 {
+			year = releaseYear(item.StreamStartDate)
+		}
+
 		song = &subsonic.Song{
 			ID:          subsonic.BuildID("squidwtf", "song", fmt.Sprintf("%d", item.ID)),
 			Parent:      subsonic.BuildID("squidwtf", "album", fmt.Sprintf("%d", item.Album.ID)),
@@ -152,22 +210,37 @@ func (s *SquidService) GetSong(ctx context.Context, id string) (*subsonic.Song,
 			CoverArt:    subsonic.BuildID("squidwtf", "album", fmt.Sprintf("%d", item.Album.ID)),
 			Duration:    item.Duration,
 			Track:       item.TrackNumber,
+			DiscNumber:  discNumber(item.VolumeNumber, item.DiscNumber),
+			Year:        year,
+			BPM:         item.Bpm,
 			Suffix:      "mp3",
 			ContentType: "audio/mpeg",
 			IsDir:       false,
 			IsVideo:     false,
 			Path:        fmt.Sprintf("squidwtf/%s/%s/%d.mp3", item.Artist.Name, item.Album.Title, item.ID),
+			MediaType:   "song",
+			Provider:    "squidwtf",
 		}
+		setAudioFormat(song, item.AudioQuality, item.AudioModes, item.BitDepth, item.SampleRate)
 		return nil
 	})
 
 	if err != nil {
+		s.cacheNegative(ctx, cacheKey, err)
 		return nil, err
 	}
 
+	// Track responses carry no genre, so songs inherit their album's, as album listings do.
+	// Sync-on-play tags files from this song.
+	if album, _, err := s.GetAlbum(ctx, song.AlbumID); err == nil {
+		song.Genre = album.Genre
+	} else {
+		logging.FromContext(ctx).Debug("No album to take the song's genre from", "id", id, "album", song.AlbumID, "error", err)
+	}
+
 	// Cache Result
 	if data, err := json.Marshal(song); err == nil {
-		s.redis.Set(ctx, cacheKey, data, 7*24*time.Hour)
+		s.cache.Set(ctx, cacheKey, string(data), 7*24*time.Hour)
 	}
 
 	return song, nil
@@ -177,28 +250,177 @@ func (s *SquidService) GetAlbum(ctx context.Context, id string) (*subsonic.Album
 	cacheKey := CachePrefix + fmt.Sprintf("album:%s", id)
 
 	// Check Cache
-	if val, err := s.redis.Get(ctx, cacheKey).Result(); err == nil {
+	if val, err := s.cache.Get(ctx, cacheKey); err == nil {
+		if val == negativeCacheValue {
+			err := negativeCacheError(id)
+			var stale albumCacheEntry
+			if s.serveStale(ctx, cacheKey, err, &stale) {
+				return stale.Album, stale.Songs, nil
+			}
+			return nil, nil, err
+		}
 		var entry albumCacheEntry
 		if err := json.Unmarshal([]byte(val), &entry); err == nil {
 			return entry.Album, entry.Songs, nil
 		}
 	}
 
-	// ID format: ext-squidwtf-album-{numericID}
-	parts := strings.Split(id, "-")
-	if len(parts) < 4 {
-		return nil, nil, fmt.Errorf("invalid id format")
+	entry, err := shared(s, ctx, cacheKey, func(ctx context.Context) (albumCacheEntry, error) {
+		album, songs, err := s.loadAlbum(ctx, id, cacheKey)
+		return albumCacheEntry{Album: album, Songs: songs}, err
+	})
+	if err != nil {
+		var stale albumCacheEntry
+		if s.serveStale(ctx, cacheKey, err, &stale) {
+			return stale.Album, stale.Songs, nil
+		}
+		return nil, nil, err
 	}
-	numericID := parts[3]
+	album := *entry.Album
+	return &album, append([]subsonic.Song(nil), entry.Songs...), nil
+}
 
-	var album *subsonic.Album
-	var songs []subsonic.Song
+// Album tracks are requested in pages; maxAlbumPages bounds the loop if the
+// upstream keeps reporting more tracks than it returns
+const (
+	albumPageSize = 100
+	maxAlbumPages = 20
+)
+
+// releaseYear extracts the year from a Tidal date such as "2019-05-17" or "2019-05-17T00:00:00.000+0000"
+func releaseYear(date string) int {
+	year := 0
+	if len(date) >= 4 {
+		fmt.Sscanf(date[:4], "%d", &year)
+	}
+	return year
+}
 
+// discNumber picks the disc from Tidal's volumeNumber, or discNumber on mirrors that rename it.
+// Single-disc releases often omit both, so the default is disc 1.
+func discNumber(volume, disc int) int {
+	if volume > 0 {
+		return volume
+	}
+	if disc > 0 {
+		return disc
+	}
+	return 1
+}
+
+// setAudioFormat fills a song's channel count, sampling rate and bit depth from Tidal's audio
+// quality tier and modes. Explicit bitDepth/sampleRate win; whatever the tier doesn't pin down
+// (hi-res sampling rates, surround channel layouts) is left unset.
+func setAudioFormat(song *subsonic.Song, quality string, modes []string, bitDepth, sampleRate int) {
+	if len(modes) == 1 && modes[0] == "STEREO" {
+		song.ChannelCount = 2
+	}
+	switch quality {
+	case "LOSSLESS":
+		song.BitDepth, song.SamplingRate = 16, 44100
+	case "HI_RES", "HI_RES_LOSSLESS":
+		song.BitDepth = 24
+	}
+	if bitDepth > 0 {
+		song.BitDepth = bitDepth
+	}
+	if sampleRate > 0 {
+		song.SamplingRate = sampleRate
+	}
+}
+
+// nameList decodes a list of names given as a single string, a list of strings, or a list of
+// {"name": ...} objects. Squid mirrors don't agree on the shape, and none of it is worth
+// failing an album over, so anything else decodes as empty.
+type nameList []string
+
+func (n *nameList) UnmarshalJSON(data []byte) error {
+	var one string
+	if json.Unmarshal(data, &one) == nil {
+		if one != "" {
+			*n = nameList{one}
+		}
+		return nil
+	}
+	var many []json.RawMessage
+	if json.Unmarshal(data, &many) != nil {
+		return nil
+	}
+	for _, raw := range many {
+		var name string
+		if json.Unmarshal(raw, &name) != nil {
+			var obj struct {
+				Name string `json:"name"`
+			}
+			json.Unmarshal(raw, &obj)
+			name = obj.Name
+		}
+		if name != "" {
+			*n = append(*n, name)
+		}
+	}
+	return nil
+}
+
+// setAlbumGenres fills an album's genre, genres and record labels. genre is the primary genre
+// when the upstream gives one, otherwise the first of genres.
+func setAlbumGenres(album *subsonic.Album, genre string, genres, labels nameList) {
+	if genre == "" && len(genres) > 0 {
+		genre = genres[0]
+	}
+	if genre != "" && !slices.Contains(genres, genre) {
+		genres = append(nameList{genre}, genres...)
+	}
+	album.Genre = genre
+	album.Genres = nil
+	for _, name := range genres {
+		album.Genres = append(album.Genres, subsonic.ItemGenre{Name: name})
+	}
+	album.RecordLabels = nil
+	for _, name := range labels {
+		album.RecordLabels = append(album.RecordLabels, subsonic.RecordLabel{Name: name})
+	}
+}
+
+// albumPage is one page of the Squid /album/ response
+type albumPage struct {
+	ID          int64    `json:"id"`
+	Title       string   `json:"title"`
+	Cover       string   `json:"cover"`
+	ReleaseDate string   `json:"releaseDate"`
+	Genre       string   `json:"genre"`
+	Genres      nameList `json:"genres"`
+	Label       nameList `json:"recordLabel"`
+	Artist      struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	} `json:"artist"`
+	Items []struct {
+		Item struct {
+			ID           int64    `json:"id"`
+			Title        string   `json:"title"`
+			Duration     int      `json:"duration"`
+			TrackNumber  int      `json:"trackNumber"`
+			VolumeNumber int      `json:"volumeNumber"`
+			DiscNumber   int      `json:"discNumber"`
+			Bpm          int      `json:"bpm"`
+			AudioQuality string   `json:"audioQuality"`
+			AudioModes   []string `json:"audioModes"`
+		} `json:"item"`
+	} `json:"items"`
+	NumberOfTracks int `json:"numberOfTracks"`
+}
+
+func (s *SquidService) fetchAlbumPage(ctx context.Context, numericID string, offset int) (*albumPage, error) {
+	var page *albumPage
 	err := s.tryWithFallback(ctx, func(baseURL string) error {
 		urlStr := fmt.Sprintf("%s/album/?id=%s", baseURL, numericID)
+		if offset > 0 {
+			urlStr += fmt.Sprintf("&offset=%d&limit=%d", offset, albumPageSize)
+		}
 
 		req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
-		req.Header.Set("User-Agent", UserAgent)
+		req.Header.Set("User-Agent", s.NextUserAgent())
 		resp, err := s.client.Do(req)
 		if err != nil {
 			return err
@@ -206,90 +428,126 @@ func (s *SquidService) GetAlbum(ctx context.Context, id string) (*subsonic.Album
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("HTTP %d", resp.StatusCode)
+			return newStatusError(resp)
 		}
 
 		// Parse
 		var result struct {
-			Data struct {
-				ID          int64  `json:"id"`
-				Title       string `json:"title"`
-				Cover       string `json:"cover"`
-				ReleaseDate string `json:"releaseDate"`
-				Artist      struct {
-					ID   int64  `json:"id"`
-					Name string `json:"name"`
-				} `json:"artist"`
-				Items []struct {
-					Item struct {
-						ID          int64  `json:"id"`
-						Title       string `json:"title"`
-						Duration    int    `json:"duration"`
-						TrackNumber int    `json:"trackNumber"`
-					} `json:"item"`
-				} `json:"items"`
-				NumberOfTracks int `json:"numberOfTracks"`
-			} `json:"data"`
+			Data albumPage `json:"data"`
 		}
-
 		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 			return err
 		}
+		page = &result.Data
+		return nil
+	})
+	return page, err
+}
 
-		data := result.Data
+// loadAlbum fetches an album and all of its tracks from Squid, following pages for
+// long albums, and caches the result
+func (s *SquidService) loadAlbum(ctx context.Context, id, cacheKey string) (*subsonic.Album, []subsonic.Song, error) {
+	// ID format: ext-squidwtf-album-{numericID}
+	parts := strings.Split(id, "-")
+	if len(parts) < 4 {
+		return nil, nil, fmt.Errorf("%w: invalid id format %s", ErrNotFound, id)
+	}
+	numericID := parts[3]
 
-		// Map Album
-		year := 0
-		if len(data.ReleaseDate) >= 4 {
-			fmt.Sscanf(data.ReleaseDate, "%d", &year)
-		}
+	data, err := s.fetchAlbumPage(ctx, numericID, 0)
+	if err != nil {
+		s.cacheNegative(ctx, cacheKey, err)
+		return nil, nil, err
+	}
 
-		album = &subsonic.Album{
-			ID:        subsonic.BuildID("squidwtf", "album", fmt.Sprintf("%d", data.ID)),
-			Title:     data.Title,
-			Name:      data.Title,
-			SongCount: data.NumberOfTracks,
-			Year:      year,
-			CoverArt:  subsonic.BuildID("squidwtf", "album", fmt.Sprintf("%d", data.ID)),
-			Artist:    data.Artist.Name,
-			ArtistID:  subsonic.BuildID("squidwtf", "artist", fmt.Sprintf("%d", data.Artist.ID)),
-			IsDir:     true,
-		}
+	// Map Album
+	year := releaseYear(data.ReleaseDate)
+
+	album := &subsonic.Album{
+		ID:        subsonic.BuildID("squidwtf", "album", fmt.Sprintf("%d", data.ID)),
+		Title:     data.Title,
+		Name:      data.Title,
+		SongCount: data.NumberOfTracks,
+		Year:      year,
+		CoverArt:  subsonic.BuildID("squidwtf", "album", fmt.Sprintf("%d", data.ID)),
+		Artist:    data.Artist.Name,
+		ArtistID:  subsonic.BuildID("squidwtf", "artist", fmt.Sprintf("%d", data.Artist.ID)),
+		IsDir:     true,
+		Provider:  "squidwtf",
+	}
+	setAlbumGenres(album, data.Genre, data.Genres, data.Label)
 
-		// Map Tracks
-		songs = []subsonic.Song{}
+	// Map Tracks, fetching further pages while fewer than NumberOfTracks have arrived
+	songs := []subsonic.Song{}
+	seen := make(map[int64]bool)
+	for pageNum := 0; ; pageNum++ {
+		added := 0
 		for _, wrapper := range data.Items {
 			t := wrapper.Item
+			if seen[t.ID] {
+				continue
+			}
+			seen[t.ID] = true
+			added++
+
+			// Position in the full listing when the upstream omits a track number
+			track := t.TrackNumber
+			if track == 0 {
+				track = len(songs) + 1
+			}
+
 			songs = append(songs, subsonic.Song{
 				ID:          subsonic.BuildID("squidwtf", "song", fmt.Sprintf("%d", t.ID)),
 				Parent:      album.ID,
 				Title:       t.Title,
-				Artist:      data.Artist.Name,
+				Artist:      album.Artist,
 				ArtistID:    album.ArtistID,
-				Album:       data.Title,
+				AlbumArtist: album.Artist,
+				Album:       album.Title,
 				AlbumID:     album.ID,
 				CoverArt:    album.ID,
 				Duration:    t.Duration,
-				Track:       t.TrackNumber,
+				Track:       track,
+				DiscNumber:  discNumber(t.VolumeNumber, t.DiscNumber),
+				Year:        album.Year,
+				Genre:       album.Genre, // Tagged into synced files
+				BPM:         t.Bpm,
 				Suffix:      "mp3",
 				ContentType: "audio/mpeg",
 				IsDir:       false,
 				IsVideo:     false,
-				Path:        fmt.Sprintf("squidwtf/%s/%s/%d.mp3", data.Artist.Name, data.Title, t.ID),
+				Path:        fmt.Sprintf("squidwtf/%s/%s/%d.mp3", album.Artist, album.Title, t.ID),
+				MediaType:   "song",
+				Provider:    "squidwtf",
 			})
+			setAudioFormat(&songs[len(songs)-1], t.AudioQuality, t.AudioModes, 0, 0)
 		}
 
-		return nil
-	})
+		if len(songs) >= album.SongCount || added == 0 {
+			break
+		}
+		if pageNum+1 >= maxAlbumPages {
+			logging.FromContext(ctx).Warn("Album page cap reached, returning partial track list", "album", album.Title, "tracks", len(songs), "expected", album.SongCount)
+			break
+		}
 
-	if err != nil {
-		return nil, nil, err
+		data, err = s.fetchAlbumPage(ctx, numericID, len(songs))
+		if err != nil {
+			// Keep what we have rather than failing the whole album
+			logging.FromContext(ctx).Warn("Failed to fetch album page", "album", album.Title, "offset", len(songs), "error", err)
+			break
+		}
 	}
 
-	// Cache Result
+	// Cache Result; an incomplete listing is only kept briefly so it gets retried
+	ttl := 7 * 24 * time.Hour
+	if len(songs) < album.SongCount {
+		ttl = time.Hour
+	}
 	entry := albumCacheEntry{Album: album, Songs: songs}
 	if data, err := json.Marshal(entry); err == nil {
-		s.redis.Set(ctx, cacheKey, data, 7*24*time.Hour)
+		s.cache.Set(ctx, cacheKey, string(data), ttl)
+		s.keepStale(ctx, cacheKey, string(data))
 	}
 	return album, songs, nil
 }
@@ -299,23 +557,41 @@ func (s *SquidService) GetArtist(ctx context.Context, id string) (*subsonic.Arti
 	cacheKey := CachePrefix + fmt.Sprintf("artist:%s", id)
 
 	// Check Cache
-	if val, err := s.redis.Get(ctx, cacheKey).Result(); err == nil {
+	if val, err := s.cache.Get(ctx, cacheKey); err == nil {
 		var entry artistCacheEntry
 		if err := json.Unmarshal([]byte(val), &entry); err == nil {
 			return entry.Artist, entry.Albums, nil
 		}
 	}
 
+	entry, err := shared(s, ctx, cacheKey, func(ctx context.Context) (artistCacheEntry, error) {
+		artist, albums, err := s.loadArtist(ctx, id, cacheKey)
+		return artistCacheEntry{Artist: artist, Albums: albums}, err
+	})
+	if err != nil {
+		var stale artistCacheEntry
+		if s.serveStale(ctx, cacheKey, err, &stale) {
+			return stale.Artist, stale.Albums, nil
+		}
+		return nil, nil, err
+	}
+	artist := *entry.Artist
+	return &artist, append([]subsonic.Album(nil), entry.Albums...), nil
+}
+
+// loadArtist fetches an artist and its albums from Squid and caches the result
+func (s *SquidService) loadArtist(ctx context.Context, id, cacheKey string) (*subsonic.Artist, []subsonic.Album, error) {
 	parts := strings.Split(id, "-")
 	if len(parts) < 4 {
-		return nil, nil, fmt.Errorf("invalid id format")
+		return nil, nil, fmt.Errorf("%w: invalid id format %s", ErrNotFound, id)
 	}
 	numericID := parts[3]
 
 	// Parallel Requests
 	var (
-		artistName string
-		items      []struct {
+		artistName    string
+		artistPicture string
+		items         []struct {
 			ID     int64  `json:"id"`
 			Title  string `json:"title"`
 			Artist struct {
@@ -332,20 +608,21 @@ func (s *SquidService) GetArtist(ctx context.Context, id string) (*subsonic.Arti
 	var metaErr error
 	go func() {
 		defer wg.Done()
+		defer safego.Recover()
 		metaErr = s.tryWithFallback(ctx, func(baseURL string) error {
 			metaURL := fmt.Sprintf("%s/artist/?id=%s", baseURL, numericID)
 			reqMeta, _ := http.NewRequestWithContext(ctx, "GET", metaURL, nil)
-			reqMeta.Header.Set("User-Agent", UserAgent)
+			reqMeta.Header.Set("User-Agent", s.NextUserAgent())
 			respMeta, err := s.client.Do(reqMeta)
-
-			if err != nil || respMeta.StatusCode != http.StatusOK {
-				if respMeta != nil && respMeta.StatusCode == http.StatusTooManyRequests {
-					return fmt.Errorf("HTTP 429")
-				}
-				return fmt.Errorf("failed to fetch artist metadata")
+			if err != nil {
+				return err
 			}
 			defer respMeta.Body.Close()
 
+			if respMeta.StatusCode != http.StatusOK {
+				return newStatusError(respMeta)
+			}
+
 			var metaResult struct {
 				Artist struct {
 					Name    string `json:"name"`
@@ -354,6 +631,7 @@ func (s *SquidService) GetArtist(ctx context.Context, id string) (*subsonic.Arti
 			}
 			json.NewDecoder(respMeta.Body).Decode(&metaResult)
 			artistName = metaResult.Artist.Name
+			artistPicture = metaResult.Artist.Picture
 			return nil
 		})
 	}()
@@ -362,19 +640,21 @@ func (s *SquidService) GetArtist(ctx context.Context, id string) (*subsonic.Arti
 	var errAlbums error
 	go func() {
 		defer wg.Done()
+		defer safego.Recover()
 		errAlbums = s.tryWithFallback(ctx, func(baseURL string) error {
 			urlStr := fmt.Sprintf("%s/artist/?f=%s", baseURL, numericID)
 			req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
-			req.Header.Set("User-Agent", UserAgent)
+			req.Header.Set("User-Agent", s.NextUserAgent())
 			resp, err := s.client.Do(req)
-			if err != nil || resp.StatusCode != http.StatusOK {
-				if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
-					return fmt.Errorf("HTTP 429")
-				}
-				return fmt.Errorf("failed to fetch artist albums")
+			if err != nil {
+				return err
 			}
 			defer resp.Body.Close()
 
+			if resp.StatusCode != http.StatusOK {
+				return newStatusError(resp)
+			}
+
 			var result struct {
 				Albums struct {
 					Items []struct {
@@ -399,7 +679,7 @@ func (s *SquidService) GetArtist(ctx context.Context, id string) (*subsonic.Arti
 	wg.Wait()
 
 	if metaErr != nil || errAlbums != nil {
-		slog.Error("Failed to fetch artist info", "metaErr", metaErr, "albumsErr", errAlbums)
+		logging.FromContext(ctx).Error("Failed to fetch artist info", "metaErr", metaErr, "albumsErr", errAlbums)
 		return nil, nil, fmt.Errorf("failed to fetch artist info")
 	}
 
@@ -408,10 +688,12 @@ func (s *SquidService) GetArtist(ctx context.Context, id string) (*subsonic.Arti
 	}
 
 	artist := &subsonic.Artist{
-		ID:         subsonic.BuildID("squidwtf", "artist", numericID),
-		Name:       artistName,
-		AlbumCount: len(items),
-		CoverArt:   subsonic.BuildID("squidwtf", "artist", numericID),
+		ID:             subsonic.BuildID("squidwtf", "artist", numericID),
+		Name:           artistName,
+		AlbumCount:     len(items),
+		CoverArt:       subsonic.BuildID("squidwtf", "artist", numericID),
+		ArtistImageUrl: artistImageURL(artistPicture),
+		Provider:       "squidwtf",
 	}
 
 	var albums []subsonic.Album
@@ -432,13 +714,15 @@ func (s *SquidService) GetArtist(ctx context.Context, id string) (*subsonic.Arti
 			ArtistID: artist.ID,
 			CoverArt: albumID,
 			IsDir:    true,
+			Provider: "squidwtf",
 		})
 	}
 
 	// Cache Result
 	entry := artistCacheEntry{Artist: artist, Albums: albums}
 	if data, err := json.Marshal(entry); err == nil {
-		s.redis.Set(ctx, cacheKey, data, 7*24*time.Hour)
+		s.cache.Set(ctx, cacheKey, string(data), 7*24*time.Hour)
+		s.keepStale(ctx, cacheKey, string(data))
 	}
 
 	return artist, albums, nil
@@ -448,31 +732,103 @@ func (s *SquidService) GetPlaylist(ctx context.Context, id string) (*subsonic.Pl
 	cacheKey := CachePrefix + fmt.Sprintf("playlist:%s", id)
 
 	// Check Cache
-	if val, err := s.redis.Get(ctx, cacheKey).Result(); err == nil {
+	if val, err := s.cache.Get(ctx, cacheKey); err == nil {
 		var entry playlistCacheEntry
 		if err := json.Unmarshal([]byte(val), &entry); err == nil {
 			return entry.Playlist, entry.Songs, nil
 		}
 	}
 
+	entry, err := shared(s, ctx, cacheKey, func(ctx context.Context) (playlistCacheEntry, error) {
+		playlist, songs, err := s.loadPlaylist(ctx, id, cacheKey)
+		return playlistCacheEntry{Playlist: playlist, Songs: songs}, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	playlist := *entry.Playlist
+	return &playlist, append([]subsonic.Song(nil), entry.Songs...), nil
+}
+
+// featuredPlaylistsTTL bounds how stale the injected playlist names/counts can get
+const featuredPlaylistsTTL = 6 * time.Hour
+
+// FeaturedPlaylists resolves the FEATURED_PLAYLISTS UUIDs into playlist summaries (no entries),
+// in configured order. Playlists that fail to resolve are left out.
+func (s *SquidService) FeaturedPlaylists(ctx context.Context) []subsonic.Playlist {
+	ids := s.cfg.Get().FeaturedPlaylists
+	if len(ids) == 0 {
+		return nil
+	}
+
+	cacheKey := CachePrefix + fmt.Sprintf("featured:%x", sha256.Sum256([]byte(strings.Join(ids, ","))))
+	if val, err := s.cache.Get(ctx, cacheKey); err == nil {
+		var playlists []subsonic.Playlist
+		if err := json.Unmarshal([]byte(val), &playlists); err == nil {
+			return playlists
+		}
+	}
+
+	resolved := make([]*subsonic.Playlist, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		if !strings.HasPrefix(id, "ext-") {
+			id = subsonic.BuildID("squidwtf", "playlist", id)
+		}
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			defer safego.Recover()
+			playlist, _, err := s.GetPlaylist(ctx, id)
+			if err != nil {
+				logging.FromContext(ctx).Warn("Failed to resolve featured playlist", "id", id, "error", err)
+				return
+			}
+			resolved[i] = playlist
+		}(i, id)
+	}
+	wg.Wait()
+
+	playlists := []subsonic.Playlist{}
+	complete := true
+	for _, p := range resolved {
+		if p == nil {
+			complete = false
+			continue
+		}
+		playlists = append(playlists, *p)
+	}
+
+	// Only cache a full resolution so a mirror hiccup doesn't hide playlists for hours
+	if complete {
+		if data, err := json.Marshal(playlists); err == nil {
+			s.cache.Set(ctx, cacheKey, string(data), featuredPlaylistsTTL)
+		}
+	}
+	return playlists
+}
+
+// loadPlaylist fetches a playlist and its tracks from Squid and caches the result
+func (s *SquidService) loadPlaylist(ctx context.Context, id, cacheKey string) (*subsonic.Playlist, []subsonic.Song, error) {
 	_, _, _, uuid := subsonic.ParseID(id)
 
 	var playlist *subsonic.Playlist
 	var songs []subsonic.Song
 	err := s.tryWithFallback(ctx, func(baseURL string) error {
 		urlStr := fmt.Sprintf("%s/playlist/?id=%s", baseURL, uuid)
-		slog.Debug("Squid Playlist Request", "url", urlStr)
+		logging.FromContext(ctx).Debug("Squid Playlist Request", "url", urlStr)
 		req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
-		req.Header.Set("User-Agent", UserAgent)
+		req.Header.Set("User-Agent", s.NextUserAgent())
 		resp, err := s.client.Do(req)
-		if err != nil || resp.StatusCode != http.StatusOK {
-			if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
-				return fmt.Errorf("HTTP 429")
-			}
-			return fmt.Errorf("playlist not found or api error")
+		if err != nil {
+			return err
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode != http.StatusOK {
+			return newStatusError(resp)
+		}
+
 		// Correct structure: Root has "playlist" and "items"
 		var result struct {
 			Playlist struct {
@@ -506,7 +862,7 @@ func (s *SquidService) GetPlaylist(ctx context.Context, id string) (*subsonic.Pl
 
 		data := result.Playlist
 		if data.UUID == "" {
-			return fmt.Errorf("playlist not found (empty uuid)")
+			return fmt.Errorf("%w: playlist has empty uuid", ErrNotFound)
 		}
 
 		playlist = &subsonic.Playlist{
@@ -535,6 +891,7 @@ func (s *SquidService) GetPlaylist(ctx context.Context, id string) (*subsonic.Pl
 				ContentType: "audio/mpeg",
 				IsDir:       false,
 				IsVideo:     false,
+				Provider:    "squidwtf",
 			})
 		}
 		return nil
@@ -547,17 +904,64 @@ func (s *SquidService) GetPlaylist(ctx context.Context, id string) (*subsonic.Pl
 	// Cache Result
 	entry := playlistCacheEntry{Playlist: playlist, Songs: songs}
 	if data, err := json.Marshal(entry); err == nil {
-		s.redis.Set(ctx, cacheKey, data, 7*24*time.Hour)
+		s.cache.Set(ctx, cacheKey, string(data), 7*24*time.Hour)
 	}
 
 	return playlist, songs, nil
 }
 
-func (s *SquidService) GetCoverURL(ctx context.Context, id string) (string, error) {
+// tidalImageURL maps a Tidal image UUID to its CDN URL at the given square size
+func tidalImageURL(imageID string, size int) string {
+	path := strings.ToLower(strings.ReplaceAll(imageID, "-", "/"))
+	return fmt.Sprintf("https://resources.tidal.com/images/%s/%dx%d.jpg", path, size, size)
+}
+
+// artistImageSize is the largest square Tidal publishes for artist pictures
+const artistImageSize = 750
+
+// artistImageURL maps a Tidal artist picture UUID to its public image URL, or "" without one
+func artistImageURL(picture string) string {
+	if picture == "" {
+		return ""
+	}
+	return tidalImageURL(picture, artistImageSize)
+}
+
+// Square sizes served by the Tidal image CDN
+var coverSizes = []int{80, 160, 320, 640, 750, 1280}
+
+// DefaultCoverSize is used when a client doesn't ask for a size
+const DefaultCoverSize = 320
+
+// SnapCoverSize rounds size up to the nearest size Tidal serves, capped at the largest.
+// Missing or invalid sizes (<= 0) map to DefaultCoverSize.
+func SnapCoverSize(size int) int {
+	if size <= 0 {
+		return DefaultCoverSize
+	}
+	for _, s := range coverSizes {
+		if size <= s {
+			return s
+		}
+	}
+	return coverSizes[len(coverSizes)-1]
+}
+
+// GetCoverURL resolves the Tidal image URL for an album, song, artist or playlist at the given size
+func (s *SquidService) GetCoverURL(ctx context.Context, id string, size int) (string, error) {
+	size = SnapCoverSize(size)
+
+	// The default size keeps its original key so existing cache entries stay valid
 	cacheKey := CachePrefix + fmt.Sprintf("cover:%s", id)
+	if size != DefaultCoverSize {
+		cacheKey = CachePrefix + fmt.Sprintf("cover:%s:%d", id, size)
+	}
 
 	// Check Cache
-	if val, err := s.redis.Get(ctx, cacheKey).Result(); err == nil && val != "" {
+	if val, err := s.cache.Get(ctx, cacheKey); err == nil && val != "" {
+		if val == negativeCacheValue {
+			return "", negativeCacheError(id)
+		}
 		return val, nil
 	}
 
@@ -567,23 +971,24 @@ func (s *SquidService) GetCoverURL(ctx context.Context, id string) (string, erro
 	if strings.Contains(id, "-album-") {
 		parts := strings.Split(id, "-")
 		if len(parts) < 4 {
-			return "", fmt.Errorf("invalid id")
+			return "", fmt.Errorf("%w: invalid id %s", ErrNotFound, id)
 		}
 		numericID := parts[3]
 
 		err = s.tryWithFallback(ctx, func(baseURL string) error {
 			urlStr := fmt.Sprintf("%s/album/?id=%s", baseURL, numericID)
 			req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
-			req.Header.Set("User-Agent", UserAgent)
+			req.Header.Set("User-Agent", s.NextUserAgent())
 			resp, err2 := s.client.Do(req)
-			if err2 != nil || resp.StatusCode != http.StatusOK {
-				if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
-					return fmt.Errorf("HTTP 429")
-				}
-				return fmt.Errorf("failed to fetch album cover info")
+			if err2 != nil {
+				return err2
 			}
 			defer resp.Body.Close()
 
+			if resp.StatusCode != http.StatusOK {
+				return newStatusError(resp)
+			}
+
 			var result struct {
 				Data struct {
 					Cover string `json:"cover"`
@@ -594,32 +999,32 @@ func (s *SquidService) GetCoverURL(ctx context.Context, id string) (string, erro
 			}
 
 			if result.Data.Cover == "" {
-				return fmt.Errorf("no cover art for album")
+				return fmt.Errorf("%w: no cover art for album", ErrNotFound)
 			}
-			uuid := strings.ToLower(strings.ReplaceAll(result.Data.Cover, "-", "/"))
-			coverURL = fmt.Sprintf("https://resources.tidal.com/images/%s/320x320.jpg", uuid)
+			coverURL = tidalImageURL(result.Data.Cover, size)
 			return nil
 		})
 	} else if strings.Contains(id, "-song-") {
 		parts := strings.Split(id, "-")
 		if len(parts) < 4 {
-			return "", fmt.Errorf("invalid id")
+			return "", fmt.Errorf("%w: invalid id %s", ErrNotFound, id)
 		}
 		numericID := parts[3]
 
 		err = s.tryWithFallback(ctx, func(baseURL string) error {
 			urlStr := fmt.Sprintf("%s/info/?id=%s", baseURL, numericID)
 			req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
-			req.Header.Set("User-Agent", UserAgent)
+			req.Header.Set("User-Agent", s.NextUserAgent())
 			resp, err2 := s.client.Do(req)
-			if err2 != nil || resp.StatusCode != http.StatusOK {
-				if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
-					return fmt.Errorf("HTTP 429")
-				}
-				return fmt.Errorf("failed to fetch song cover info")
+			if err2 != nil {
+				return err2
 			}
 			defer resp.Body.Close()
 
+			if resp.StatusCode != http.StatusOK {
+				return newStatusError(resp)
+			}
+
 			var result struct {
 				Data struct {
 					Album struct {
@@ -632,32 +1037,32 @@ func (s *SquidService) GetCoverURL(ctx context.Context, id string) (string, erro
 			}
 
 			if result.Data.Album.Cover == "" {
-				return fmt.Errorf("no cover art for song/album")
+				return fmt.Errorf("%w: no cover art for song/album", ErrNotFound)
 			}
-			uuid := strings.ToLower(strings.ReplaceAll(result.Data.Album.Cover, "-", "/"))
-			coverURL = fmt.Sprintf("https://resources.tidal.com/images/%s/320x320.jpg", uuid)
+			coverURL = tidalImageURL(result.Data.Album.Cover, size)
 			return nil
 		})
 	} else if strings.Contains(id, "-artist-") {
 		parts := strings.Split(id, "-")
 		if len(parts) < 4 {
-			return "", fmt.Errorf("invalid id")
+			return "", fmt.Errorf("%w: invalid id %s", ErrNotFound, id)
 		}
 		numericID := parts[3]
 
 		err = s.tryWithFallback(ctx, func(baseURL string) error {
 			urlStr := fmt.Sprintf("%s/artist/?id=%s", baseURL, numericID)
 			req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
-			req.Header.Set("User-Agent", UserAgent)
+			req.Header.Set("User-Agent", s.NextUserAgent())
 			resp, err2 := s.client.Do(req)
-			if err2 != nil || resp.StatusCode != http.StatusOK {
-				if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
-					return fmt.Errorf("HTTP 429")
-				}
-				return fmt.Errorf("failed to fetch artist cover info")
+			if err2 != nil {
+				return err2
 			}
 			defer resp.Body.Close()
 
+			if resp.StatusCode != http.StatusOK {
+				return newStatusError(resp)
+			}
+
 			var result struct {
 				Artist struct {
 					Picture string `json:"picture"`
@@ -668,10 +1073,9 @@ func (s *SquidService) GetCoverURL(ctx context.Context, id string) (string, erro
 			}
 
 			if result.Artist.Picture == "" {
-				return fmt.Errorf("no picture for artist")
+				return fmt.Errorf("%w: no picture for artist", ErrNotFound)
 			}
-			uuid := strings.ToLower(strings.ReplaceAll(result.Artist.Picture, "-", "/"))
-			coverURL = fmt.Sprintf("https://resources.tidal.com/images/%s/320x320.jpg", uuid)
+			coverURL = tidalImageURL(result.Artist.Picture, size)
 			return nil
 		})
 	} else if strings.Contains(id, "-playlist-") {
@@ -679,16 +1083,17 @@ func (s *SquidService) GetCoverURL(ctx context.Context, id string) (string, erro
 		err = s.tryWithFallback(ctx, func(baseURL string) error {
 			urlStr := fmt.Sprintf("%s/playlist/?id=%s", baseURL, uuid)
 			req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
-			req.Header.Set("User-Agent", UserAgent)
+			req.Header.Set("User-Agent", s.NextUserAgent())
 			resp, err2 := s.client.Do(req)
-			if err2 != nil || resp.StatusCode != http.StatusOK {
-				if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
-					return fmt.Errorf("HTTP 429")
-				}
-				return fmt.Errorf("failed to fetch playlist cover info")
+			if err2 != nil {
+				return err2
 			}
 			defer resp.Body.Close()
 
+			if resp.StatusCode != http.StatusOK {
+				return newStatusError(resp)
+			}
+
 			var result struct {
 				Playlist struct {
 					SquareImage string `json:"squareImage"`
@@ -699,10 +1104,9 @@ func (s *SquidService) GetCoverURL(ctx context.Context, id string) (string, erro
 			}
 
 			if result.Playlist.SquareImage == "" {
-				return fmt.Errorf("no cover art for playlist")
+				return fmt.Errorf("%w: no cover art for playlist", ErrNotFound)
 			}
-			imgUuid := strings.ToLower(strings.ReplaceAll(result.Playlist.SquareImage, "-", "/"))
-			coverURL = fmt.Sprintf("https://resources.tidal.com/images/%s/320x320.jpg", imgUuid)
+			coverURL = tidalImageURL(result.Playlist.SquareImage, size)
 			return nil
 		})
 	} else {
@@ -710,12 +1114,137 @@ func (s *SquidService) GetCoverURL(ctx context.Context, id string) (string, erro
 	}
 
 	if coverURL != "" {
-		s.redis.Set(ctx, cacheKey, coverURL, 7*7*24*time.Hour)
+		s.cache.Set(ctx, cacheKey, coverURL, 7*7*24*time.Hour)
+	} else if err != nil {
+		s.cacheNegative(ctx, cacheKey, err)
 	}
 
 	return coverURL, err
 }
 
+// GetArtistInfo fetches biography and image URLs for an external artist
+func (s *SquidService) GetArtistInfo(ctx context.Context, id string) (*subsonic.ArtistInfo, error) {
+	cacheKey := CachePrefix + fmt.Sprintf("artistinfo:%s", id)
+
+	// Check Cache
+	if val, err := s.cache.Get(ctx, cacheKey); err == nil {
+		var info subsonic.ArtistInfo
+		if err := json.Unmarshal([]byte(val), &info); err == nil {
+			return &info, nil
+		}
+	}
+
+	_, _, _, numericID := subsonic.ParseID(id)
+
+	var info *subsonic.ArtistInfo
+	err := s.tryWithFallback(ctx, func(baseURL string) error {
+		urlStr := fmt.Sprintf("%s/artist/?id=%s", baseURL, numericID)
+		req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+		req.Header.Set("User-Agent", s.NextUserAgent())
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return newStatusError(resp)
+		}
+
+		var result struct {
+			Artist struct {
+				Name    string `json:"name"`
+				Picture string `json:"picture"`
+				Bio     string `json:"bio"`
+			} `json:"artist"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return err
+		}
+
+		info = &subsonic.ArtistInfo{
+			Biography: result.Artist.Bio,
+		}
+		if result.Artist.Picture != "" {
+			info.SmallImageUrl = tidalImageURL(result.Artist.Picture, 160)
+			info.MediumImageUrl = tidalImageURL(result.Artist.Picture, 320)
+			info.LargeImageUrl = tidalImageURL(result.Artist.Picture, 750)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Cache Result
+	if data, err := json.Marshal(info); err == nil {
+		s.cache.Set(ctx, cacheKey, string(data), 7*24*time.Hour)
+	}
+
+	return info, nil
+}
+
+// GetAlbumInfo fetches notes and cover image URLs for an external album.
+// Albums without a cover still succeed with empty image URLs.
+func (s *SquidService) GetAlbumInfo(ctx context.Context, id string) (*subsonic.AlbumInfo, error) {
+	cacheKey := CachePrefix + fmt.Sprintf("albuminfo:%s", id)
+
+	// Check Cache
+	if val, err := s.cache.Get(ctx, cacheKey); err == nil {
+		var info subsonic.AlbumInfo
+		if err := json.Unmarshal([]byte(val), &info); err == nil {
+			return &info, nil
+		}
+	}
+
+	_, _, _, numericID := subsonic.ParseID(id)
+
+	var info *subsonic.AlbumInfo
+	err := s.tryWithFallback(ctx, func(baseURL string) error {
+		urlStr := fmt.Sprintf("%s/album/?id=%s", baseURL, numericID)
+		req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+		req.Header.Set("User-Agent", s.NextUserAgent())
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return newStatusError(resp)
+		}
+
+		var result struct {
+			Data struct {
+				Cover string `json:"cover"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return err
+		}
+
+		info = &subsonic.AlbumInfo{}
+		if result.Data.Cover != "" {
+			info.SmallImageUrl = tidalImageURL(result.Data.Cover, 160)
+			info.MediumImageUrl = tidalImageURL(result.Data.Cover, 320)
+			info.LargeImageUrl = tidalImageURL(result.Data.Cover, 1280)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Cache Result
+	if data, err := json.Marshal(info); err == nil {
+		s.cache.Set(ctx, cacheKey, string(data), 7*24*time.Hour)
+	}
+
+	return info, nil
+}
+
 func (s *SquidService) GetSimilarArtists(ctx context.Context, id string) ([]subsonic.Artist, error) {
 	_, _, _, numericID := subsonic.ParseID(id)
 	var artists []subsonic.Artist
@@ -723,16 +1252,17 @@ func (s *SquidService) GetSimilarArtists(ctx context.Context, id string) ([]subs
 		urlStr := fmt.Sprintf("%s/artist/similar/?id=%s", baseURL, numericID)
 
 		req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
-		req.Header.Set("User-Agent", UserAgent)
+		req.Header.Set("User-Agent", s.NextUserAgent())
 		resp, err := s.client.Do(req)
-		if err != nil || resp.StatusCode != http.StatusOK {
-			if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
-				return fmt.Errorf("HTTP 429")
-			}
-			return fmt.Errorf("failed to fetch similar artists")
+		if err != nil {
+			return err
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode != http.StatusOK {
+			return newStatusError(resp)
+		}
+
 		var result struct {
 			Artists []struct {
 				ID      int64  `json:"id"`
@@ -748,9 +1278,11 @@ func (s *SquidService) GetSimilarArtists(ctx context.Context, id string) ([]subs
 		artists = []subsonic.Artist{}
 		for _, item := range result.Artists {
 			artists = append(artists, subsonic.Artist{
-				ID:       subsonic.BuildID("squidwtf", "artist", fmt.Sprintf("%d", item.ID)),
-				Name:     item.Name,
-				CoverArt: subsonic.BuildID("squidwtf", "artist", fmt.Sprintf("%d", item.ID)),
+				ID:             subsonic.BuildID("squidwtf", "artist", fmt.Sprintf("%d", item.ID)),
+				Name:           item.Name,
+				CoverArt:       subsonic.BuildID("squidwtf", "artist", fmt.Sprintf("%d", item.ID)),
+				ArtistImageUrl: artistImageURL(item.Picture),
+				Provider:       "squidwtf",
 			})
 		}
 		return nil
@@ -762,6 +1294,112 @@ func (s *SquidService) GetSimilarArtists(ctx context.Context, id string) ([]subs
 	return artists, nil
 }
 
+// curatedGenres is the set of Tidal genres exposed to the genre browser.
+// Squid has no genre listing endpoint, so this stands in for one.
+var curatedGenres = []string{
+	"Pop", "Rock", "Hip-Hop", "R&B", "Electronic", "Dance", "Jazz", "Classical",
+	"Country", "Folk", "Metal", "Blues", "Latin", "Reggae", "Soul", "Indie",
+	"Alternative", "Punk", "Funk", "K-Pop", "Soundtrack", "Ambient", "Gospel", "World",
+}
+
+// GetGenres returns the genres available from the external provider. Their song and album
+// counts aren't known, so they are left at zero and omitted from responses.
+func (s *SquidService) GetGenres(ctx context.Context) ([]subsonic.Genre, error) {
+	genres := make([]subsonic.Genre, 0, len(curatedGenres))
+	for _, name := range curatedGenres {
+		genres = append(genres, subsonic.Genre{Name: name})
+	}
+	return genres, nil
+}
+
+// GetArtistTopTracks returns an artist's most popular tracks in Tidal's order,
+// read from the tracks section of the artist's full listing
+func (s *SquidService) GetArtistTopTracks(ctx context.Context, artistID string, count int) ([]subsonic.Song, error) {
+	cacheKey := CachePrefix + fmt.Sprintf("toptracks:%s", artistID)
+
+	// Check Cache
+	if val, err := s.cache.Get(ctx, cacheKey); err == nil {
+		var songs []subsonic.Song
+		if err := json.Unmarshal([]byte(val), &songs); err == nil {
+			return limitSongs(songs, count), nil
+		}
+	}
+
+	_, _, _, numericID := subsonic.ParseID(artistID)
+
+	var songs []subsonic.Song
+	err := s.tryWithFallback(ctx, func(baseURL string) error {
+		urlStr := fmt.Sprintf("%s/artist/?f=%s", baseURL, numericID)
+		req, _ := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+		req.Header.Set("User-Agent", s.NextUserAgent())
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return newStatusError(resp)
+		}
+
+		var result struct {
+			Tracks []struct {
+				ID          int64  `json:"id"`
+				Title       string `json:"title"`
+				Duration    int    `json:"duration"`
+				TrackNumber int    `json:"trackNumber"`
+				Artist      struct {
+					ID   int64  `json:"id"`
+					Name string `json:"name"`
+				} `json:"artist"`
+				Album struct {
+					ID    int64  `json:"id"`
+					Title string `json:"title"`
+				} `json:"album"`
+			} `json:"tracks"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return err
+		}
+		if len(result.Tracks) == 0 {
+			return fmt.Errorf("%w: no top tracks for artist", ErrNotFound)
+		}
+
+		songs = make([]subsonic.Song, 0, len(result.Tracks))
+		for _, item := range result.Tracks {
+			albumID := subsonic.BuildID("squidwtf", "album", fmt.Sprintf("%d", item.Album.ID))
+			songs = append(songs, subsonic.Song{
+				ID:          subsonic.BuildID("squidwtf", "song", fmt.Sprintf("%d", item.ID)),
+				Parent:      albumID,
+				Title:       item.Title,
+				Album:       item.Album.Title,
+				AlbumID:     albumID,
+				Artist:      item.Artist.Name,
+				ArtistID:    subsonic.BuildID("squidwtf", "artist", fmt.Sprintf("%d", item.Artist.ID)),
+				CoverArt:    albumID,
+				Duration:    item.Duration,
+				Track:       item.TrackNumber,
+				Suffix:      "mp3",
+				ContentType: "audio/mpeg",
+				Path:        fmt.Sprintf("squidwtf/%s/%s/%d.mp3", item.Artist.Name, item.Album.Title, item.ID),
+				Provider:    "squidwtf",
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Cache Result
+	if data, err := json.Marshal(songs); err == nil {
+		s.cache.Set(ctx, cacheKey, string(data), 24*time.Hour)
+	}
+
+	return limitSongs(songs, count), nil
+}
+
+// GetTopSongsByArtist approximates top songs by searching for the artist name
 func (s *SquidService) GetTopSongsByArtist(ctx context.Context, artistName string, count int) ([]subsonic.Song, error) {
 	// We use the search endpoint to get popular tracks for the artist
 	res, err := s.Search(ctx, artistName)
@@ -791,3 +1429,101 @@ func (s *SquidService) GetTopSongsByArtist(ctx context.Context, artistName strin
 
 	return topSongs, nil
 }
+
+// GetSimilarSongs assembles songs related to a seed song (or artist): the seed artist's
+// top tracks followed by top tracks from similar artists. The pool is cached per ID.
+func (s *SquidService) GetSimilarSongs(ctx context.Context, id string, count int) ([]subsonic.Song, error) {
+	cacheKey := CachePrefix + fmt.Sprintf("similarsongs:%s", id)
+
+	// Check Cache
+	if val, err := s.cache.Get(ctx, cacheKey); err == nil {
+		var songs []subsonic.Song
+		if err := json.Unmarshal([]byte(val), &songs); err == nil {
+			return limitSongs(songs, count), nil
+		}
+	}
+
+	// 1. Resolve the seed artist
+	var artistName, artistID string
+	_, _, mediaType, _ := subsonic.ParseID(id)
+	if mediaType == "artist" {
+		artist, _, err := s.GetArtist(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		artistName, artistID = artist.Name, artist.ID
+	} else {
+		song, err := s.GetSong(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		artistName, artistID = song.Artist, song.ArtistID
+	}
+
+	// 2. Seed artist top songs + similar artists in parallel
+	var (
+		seedSongs []subsonic.Song
+		similar   []subsonic.Artist
+		wg        sync.WaitGroup
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer safego.Recover()
+		seedSongs, _ = s.GetTopSongsByArtist(ctx, artistName, 10)
+	}()
+	go func() {
+		defer wg.Done()
+		defer safego.Recover()
+		similar, _ = s.GetSimilarArtists(ctx, artistID)
+	}()
+	wg.Wait()
+
+	// 3. Top songs from the first few similar artists
+	const maxSimilarArtists = 5
+	if len(similar) > maxSimilarArtists {
+		similar = similar[:maxSimilarArtists]
+	}
+	related := make([][]subsonic.Song, len(similar))
+	for i, artist := range similar {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			defer safego.Recover()
+			related[i], _ = s.GetTopSongsByArtist(ctx, name, 5)
+		}(i, artist.Name)
+	}
+	wg.Wait()
+
+	// 4. Combine, skipping the seed song and duplicates
+	seen := map[string]bool{id: true}
+	songs := []subsonic.Song{}
+	for _, group := range append([][]subsonic.Song{seedSongs}, related...) {
+		for _, song := range group {
+			if seen[song.ID] {
+				continue
+			}
+			seen[song.ID] = true
+			songs = append(songs, song)
+		}
+	}
+
+	if len(songs) == 0 {
+		return nil, fmt.Errorf("%w: no similar songs for %s", ErrNotFound, id)
+	}
+
+	// Cache Result
+	if data, err := json.Marshal(songs); err == nil {
+		s.cache.Set(ctx, cacheKey, string(data), 24*time.Hour)
+	}
+
+	return limitSongs(songs, count), nil
+}
+
+// limitSongs trims songs to at most count entries (count <= 0 means no limit)
+func limitSongs(songs []subsonic.Song, count int) []subsonic.Song {
+	if count > 0 && len(songs) > count {
+		return songs[:count]
+	}
+	return songs
+}
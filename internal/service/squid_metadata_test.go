@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"jetstream/internal/config"
+	"jetstream/pkg/subsonic"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// GetSong maps the BPM and album release year of a Squid /info/ response onto the song
+func TestGetSongMapsYearAndBPM(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/info/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"data":{"id":1,"title":"Song","duration":200,"trackNumber":3,"bpm":128,
+			"streamReady":true,"artist":{"id":2,"name":"Artist"},
+			"album":{"id":4,"title":"Album","releaseDate":"2019-05-17"}}}`))
+	}))
+	defer srv.Close()
+
+	s := NewSquidService(config.NewLive(&config.Config{
+		SquidURLs:      []string{srv.URL},
+		CacheBackend:   "memory",
+		CacheEntries:   10,
+		SquidMaxPasses: 1,
+	}))
+	song, err := s.GetSong(context.Background(), "ext-squidwtf-song-1")
+	if err != nil {
+		t.Fatalf("GetSong: %v", err)
+	}
+	if song.Year != 2019 || song.BPM != 128 {
+		t.Errorf("GetSong year = %d, bpm = %d; want 2019, 128", song.Year, song.BPM)
+	}
+}
+
+// Year, BPM and genre survive the sidecar written next to a synced file
+func TestSidecarRoundTripsYearBPMGenre(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.mp3")
+	want := &subsonic.Song{ID: "ext-squidwtf-song-1", Title: "Song", Year: 2019, BPM: 128, Genre: "Jazz"}
+
+	data, err := json.MarshalIndent(songSidecar{Song: want}, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".json", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readSidecar(path)
+	if err != nil {
+		t.Fatalf("readSidecar: %v", err)
+	}
+	if got.Year != want.Year || got.BPM != want.BPM || got.Genre != want.Genre {
+		t.Errorf("sidecar read back year = %d, bpm = %d, genre = %q; want %d, %d, %q",
+			got.Year, got.BPM, got.Genre, want.Year, want.BPM, want.Genre)
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"jetstream/internal/safego"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// probeTimeout bounds a single latency probe; a failed probe counts as this slow
+	probeTimeout = 5 * time.Second
+
+	// latencyAlpha weighs the newest probe in the latency EWMA
+	latencyAlpha = 0.3
+)
+
+// RunLatencyProbe measures every mirror's latency each SQUID_LATENCY_PROBE_INTERVAL until ctx
+// is done, then reorders the mirrors fastest first. The interval is re-read every round so a
+// config reload can enable, disable or retune probing.
+func (s *SquidService) RunLatencyProbe(ctx context.Context) {
+	for {
+		interval := s.cfg.Get().SquidLatencyProbe
+		wait := interval
+		if interval <= 0 {
+			wait = time.Minute // Disabled: just check again for a reload
+		} else {
+			s.probeLatencies(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// probeLatencies times a request to each mirror's base URL. Any HTTP response counts, since
+// only the round trip matters; errors are recorded as probeTimeout. Probes go around
+// tryWithFallback, so they never put a mirror on cooldown.
+func (s *SquidService) probeLatencies(ctx context.Context) {
+	s.urlMutex.RLock()
+	states := make([]URLState, len(s.urlStates))
+	copy(states, s.urlStates)
+	s.urlMutex.RUnlock()
+	if len(states) < 2 {
+		return // Nothing to choose between
+	}
+
+	measured := make(map[string]time.Duration, len(states))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, st := range states {
+		wg.Add(1)
+		go func(baseURL string) {
+			defer wg.Done()
+			defer safego.Recover()
+			d := s.probe(ctx, baseURL)
+			mu.Lock()
+			measured[baseURL] = d
+			mu.Unlock()
+		}(st.URL)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	s.urlMutex.Lock()
+	defer s.urlMutex.Unlock()
+	for i := range s.urlStates {
+		d, ok := measured[s.urlStates[i].URL]
+		if !ok {
+			continue // Added by a reload mid-probe
+		}
+		if prev := s.urlStates[i].Latency; prev > 0 {
+			d = time.Duration(latencyAlpha*float64(d) + (1-latencyAlpha)*float64(prev))
+		}
+		s.urlStates[i].Latency = d
+	}
+
+	// SQUID_MIRRORS keep their configured order ahead of everything else
+	sort.SliceStable(s.urlStates, func(i, j int) bool {
+		a, b := s.urlStates[i], s.urlStates[j]
+		if a.Preferred != b.Preferred {
+			return a.Preferred
+		}
+		if a.Preferred {
+			return false
+		}
+		return a.Latency < b.Latency
+	})
+	// Start over from the fastest, undoing rotations from failures since the last round
+	s.currentURLIndex = 0
+	slog.Debug("Squid mirrors reordered by latency", "fastest", s.urlStates[0].URL, "latency", s.urlStates[0].Latency)
+}
+
+// probe returns how long baseURL took to answer, or probeTimeout if it didn't
+func (s *SquidService) probe(ctx context.Context, baseURL string) time.Duration {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/", nil)
+	if err != nil {
+		return probeTimeout
+	}
+	req.Header.Set("User-Agent", s.NextUserAgent())
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		slog.Debug("Squid latency probe failed", "url", baseURL, "error", err)
+		return probeTimeout
+	}
+	resp.Body.Close()
+	return time.Since(start)
+}
@@ -4,93 +4,138 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"jetstream/internal/logging"
+	"jetstream/internal/safego"
 	"jetstream/pkg/subsonic"
-	"log/slog"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 // Search performs a search on triton.squid.wtf and maps to Subsonic models
 func (s *SquidService) Search(ctx context.Context, query string) (*subsonic.SearchResult3, error) {
-	cacheKey := CachePrefix + fmt.Sprintf("search:%s", query)
+	cacheKey := s.searchCacheKey(query)
 
 	// Check Cache
-	if val, err := s.redis.Get(ctx, cacheKey).Result(); err == nil {
+	if val, err := s.cache.Get(ctx, cacheKey); err == nil {
 		var res subsonic.SearchResult3
 		if err := json.Unmarshal([]byte(val), &res); err == nil {
 			return &res, nil
 		}
 	}
 
-	var (
-		songs     []subsonic.Song
-		albums    []subsonic.Album
-		artists   []subsonic.Artist
-		playlists []subsonic.Playlist
-		wg        sync.WaitGroup
-	)
+	res, err := shared(s, ctx, cacheKey, func(ctx context.Context) (*subsonic.SearchResult3, error) {
+		return s.loadSearch(ctx, query, cacheKey)
+	})
+	if err != nil {
+		var stale subsonic.SearchResult3
+		if s.serveStale(ctx, cacheKey, err, &stale) {
+			return &stale, nil
+		}
+		return nil, err
+	}
+	result := *res
+	return &result, nil
+}
 
-	wg.Add(4)
+// searchFetchers run one Squid search category each, storing what they find in res. Each
+// writes its own field, so they can run concurrently.
+var searchFetchers = map[string]func(s *SquidService, ctx context.Context, query string, res *subsonic.SearchResult3) error{
+	"songs": func(s *SquidService, ctx context.Context, query string, res *subsonic.SearchResult3) (err error) {
+		res.Song, err = s.fetchSongs(ctx, query)
+		return err
+	},
+	"albums": func(s *SquidService, ctx context.Context, query string, res *subsonic.SearchResult3) (err error) {
+		res.Album, err = s.fetchAlbums(ctx, query)
+		return err
+	},
+	"artists": func(s *SquidService, ctx context.Context, query string, res *subsonic.SearchResult3) (err error) {
+		res.Artist, err = s.fetchArtists(ctx, query)
+		return err
+	},
+	"playlists": func(s *SquidService, ctx context.Context, query string, res *subsonic.SearchResult3) (err error) {
+		res.Playlist, err = s.fetchPlaylists(ctx, query)
+		return err
+	},
+}
 
-	// 1. Search Songs
-	go func() {
-		defer wg.Done()
-		var err error
-		songs, err = s.fetchSongs(ctx, query)
-		if err != nil {
-			slog.Error("Error fetching songs", "error", err, "query", query)
-		}
-	}()
+// searchCacheKey keys cached search results by query, and by SEARCH_CATEGORIES when that
+// leaves categories out, so a result missing some categories is never served for all of them
+func (s *SquidService) searchCacheKey(query string) string {
+	categories := s.cfg.Get().SearchCategories
+	if len(categories) == len(searchFetchers) {
+		return CachePrefix + fmt.Sprintf("search:%s", query)
+	}
+	return CachePrefix + fmt.Sprintf("search:%s:%s", strings.Join(categories, ","), query)
+}
 
-	// 2. Search Albums
-	go func() {
-		defer wg.Done()
-		var err error
-		albums, err = s.fetchAlbums(ctx, query)
-		if err != nil {
-			slog.Error("Error fetching albums", "error", err, "query", query)
+// loadSearch queries the SEARCH_CATEGORIES categories, in parallel or SEARCH_CATEGORY_DELAY
+// apart, and caches the combined result
+func (s *SquidService) loadSearch(ctx context.Context, query, cacheKey string) (*subsonic.SearchResult3, error) {
+	cfg := s.cfg.Get()
+	categories := cfg.SearchCategories
+	res := &subsonic.SearchResult3{}
+	errs := make([]error, len(categories))
+
+	run := func(i int) {
+		errs[i] = searchFetchers[categories[i]](s, ctx, query, res)
+		if errs[i] != nil {
+			logging.FromContext(ctx).Error("Error fetching "+categories[i], "error", errs[i], "query", query)
 		}
-	}()
+	}
 
-	// 3. Search Artists
-	go func() {
-		defer wg.Done()
-		var err error
-		artists, err = s.fetchArtists(ctx, query)
-		if err != nil {
-			slog.Error("Error fetching artists", "error", err, "query", query)
+	if cfg.SearchDelay > 0 {
+		// One category at a time keeps a rate-limited mirror from seeing a burst per search
+		for i := range categories {
+			if i > 0 {
+				select {
+				case <-time.After(cfg.SearchDelay):
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+			run(i)
 		}
-	}()
+	} else {
+		var wg sync.WaitGroup
+		for i := range categories {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer safego.Recover()
+				run(i)
+			}(i)
+		}
+		wg.Wait()
+	}
 
-	// 4. Search Playlists
-	go func() {
-		defer wg.Done()
-		var err error
-		playlists, err = s.fetchPlaylists(ctx, query)
+	// Every category failing means the mirrors are down, not that nothing matched; don't cache
+	// an empty result for that
+	failed := 0
+	for _, err := range errs {
 		if err != nil {
-			slog.Error("Error fetching playlists", "error", err, "query", query)
+			failed++
 		}
-	}()
-
-	wg.Wait()
-
-	res := &subsonic.SearchResult3{
-		Song:     songs,
-		Album:    albums,
-		Artist:   artists,
-		Playlist: playlists,
+	}
+	if failed == len(categories) {
+		return nil, errs[0]
 	}
 
 	if data, err := json.Marshal(res); err == nil {
-		s.redis.Set(ctx, cacheKey, data, 48*time.Hour)
+		s.cache.Set(ctx, cacheKey, string(data), 48*time.Hour)
+		s.keepStale(ctx, cacheKey, string(data))
 	}
 
 	return res, nil
 }
 
-// SearchOne attempts to find a single song ID matching the artist and title.
+// SearchOne finds the song best matching artist and title, or ErrNotFound when nothing scores
+// at least MATCH_THRESHOLD.
 func (s *SquidService) SearchOne(ctx context.Context, artist, title string) (string, error) {
 	query := fmt.Sprintf("%s %s", artist, title)
 	res, err := s.Search(ctx, query)
@@ -98,28 +143,37 @@ func (s *SquidService) SearchOne(ctx context.Context, artist, title string) (str
 		return "", err
 	}
 
-	if len(res.Song) == 0 {
-		return "", fmt.Errorf("no matches found")
+	best, score := bestMatch(len(res.Song), func(i int) int {
+		return matchScore(
+			matchField{artist, res.Song[i].Artist, 0.4},
+			matchField{title, res.Song[i].Title, 0.6},
+		)
+	})
+	if err := s.checkMatch(ctx, query, best, score); err != nil {
+		return "", err
 	}
-
-	return res.Song[0].ID, nil
+	return res.Song[best].ID, nil
 }
 
-// SearchOneArtist attempts to find a single artist ID matching the name.
+// SearchOneArtist finds the artist best matching name, or ErrNotFound when nothing scores at
+// least MATCH_THRESHOLD.
 func (s *SquidService) SearchOneArtist(ctx context.Context, name string) (string, error) {
 	res, err := s.Search(ctx, name)
 	if err != nil {
 		return "", err
 	}
 
-	if len(res.Artist) == 0 {
-		return "", fmt.Errorf("no matches found")
+	best, score := bestMatch(len(res.Artist), func(i int) int {
+		return matchScore(matchField{name, res.Artist[i].Name, 1})
+	})
+	if err := s.checkMatch(ctx, name, best, score); err != nil {
+		return "", err
 	}
-
-	return res.Artist[0].ID, nil
+	return res.Artist[best].ID, nil
 }
 
-// SearchOneAlbum attempts to find a single album ID matching the artist and title.
+// SearchOneAlbum finds the album best matching artist and title, or ErrNotFound when nothing
+// scores at least MATCH_THRESHOLD.
 func (s *SquidService) SearchOneAlbum(ctx context.Context, artist, title string) (string, error) {
 	query := fmt.Sprintf("%s %s", artist, title)
 	res, err := s.Search(ctx, query)
@@ -127,11 +181,238 @@ func (s *SquidService) SearchOneAlbum(ctx context.Context, artist, title string)
 		return "", err
 	}
 
-	if len(res.Album) == 0 {
-		return "", fmt.Errorf("no matches found")
+	best, score := bestMatch(len(res.Album), func(i int) int {
+		return matchScore(
+			matchField{artist, res.Album[i].Artist, 0.4},
+			matchField{title, res.Album[i].Title, 0.6},
+		)
+	})
+	if err := s.checkMatch(ctx, query, best, score); err != nil {
+		return "", err
+	}
+	return res.Album[best].ID, nil
+}
+
+// checkMatch rejects a bestMatch result below MATCH_THRESHOLD
+func (s *SquidService) checkMatch(ctx context.Context, query string, best, score int) error {
+	if best < 0 {
+		return fmt.Errorf("%w: no matches found for %q", ErrNotFound, query)
+	}
+	if score < s.cfg.Get().MatchThreshold {
+		logging.FromContext(ctx).Debug("Rejected low-scoring match", "query", query, "score", score, "threshold", s.cfg.Get().MatchThreshold)
+		return fmt.Errorf("%w: best match for %q scored %d, below %d", ErrNotFound, query, score, s.cfg.Get().MatchThreshold)
+	}
+	return nil
+}
+
+// SearchByGenre searches Squid using the genre as the query and tags each result with it.
+// Results are cached per genre; count/offset page through the cached list.
+func (s *SquidService) SearchByGenre(ctx context.Context, genre string, count, offset int) ([]subsonic.Song, error) {
+	cacheKey := CachePrefix + fmt.Sprintf("genre:%s", strings.ToLower(genre))
+
+	var songs []subsonic.Song
+	if val, err := s.cache.Get(ctx, cacheKey); err == nil {
+		json.Unmarshal([]byte(val), &songs)
+	}
+
+	if songs == nil {
+		res, err := s.Search(ctx, genre)
+		if err != nil {
+			return nil, err
+		}
+
+		songs = make([]subsonic.Song, 0, len(res.Song))
+		for _, song := range res.Song {
+			song.Genre = genre
+			songs = append(songs, song)
+		}
+
+		if data, err := json.Marshal(songs); err == nil {
+			s.cache.Set(ctx, cacheKey, string(data), 24*time.Hour)
+		}
+	}
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(songs) {
+		return []subsonic.Song{}, nil
+	}
+	songs = songs[offset:]
+	if count > 0 && len(songs) > count {
+		songs = songs[:count]
+	}
+	return songs, nil
+}
+
+// randomSeedQueries are rotated through to give getRandomSongs some variety
+var randomSeedQueries = []string{
+	"Hits", "Top", "Chill", "Classics", "New", "Love", "Summer",
+	"Party", "Acoustic", "Remix", "Live", "Night", "Dance", "Soul",
+}
+
+// GetRandomSongs returns a shuffled selection of external songs drawn from a couple of
+// rotating seed queries (or the genre, when given). fromYear/toYear filter on the album
+// release year; songs whose year can't be determined are dropped when a filter is set.
+func (s *SquidService) GetRandomSongs(ctx context.Context, count int, genre string, fromYear, toYear int) ([]subsonic.Song, error) {
+	seeds := []string{genre}
+	if genre == "" {
+		perm := rand.Perm(len(randomSeedQueries))
+		seeds = []string{randomSeedQueries[perm[0]], randomSeedQueries[perm[1]]}
+	}
+
+	var songs []subsonic.Song
+	seen := make(map[string]bool)
+	for _, seed := range seeds {
+		res, err := s.Search(ctx, seed)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Random songs seed search failed", "seed", seed, "error", err)
+			continue
+		}
+
+		// Search songs carry no year, borrow it from the matching albums
+		albumYears := make(map[string]int, len(res.Album))
+		for _, album := range res.Album {
+			albumYears[album.ID] = album.Year
+		}
+
+		for _, song := range res.Song {
+			if seen[song.ID] {
+				continue
+			}
+			if song.Year == 0 {
+				song.Year = albumYears[song.AlbumID]
+			}
+			if (fromYear > 0 || toYear > 0) && song.Year == 0 {
+				continue
+			}
+			if fromYear > 0 && song.Year < fromYear {
+				continue
+			}
+			if toYear > 0 && song.Year > toYear {
+				continue
+			}
+			if genre != "" {
+				song.Genre = genre
+			}
+			seen[song.ID] = true
+			songs = append(songs, song)
+		}
+	}
+
+	rand.Shuffle(len(songs), func(i, j int) { songs[i], songs[j] = songs[j], songs[i] })
+	if count > 0 && len(songs) > count {
+		songs = songs[:count]
+	}
+	return songs, nil
+}
+
+// externalAlbumListTypes are the getAlbumList2 types that map onto Squid searches.
+// The rest (newest, frequent, recent, starred, alphabetical...) depend on library state.
+var externalAlbumListTypes = map[string]bool{
+	"random":  true,
+	"byGenre": true,
+	"byYear":  true,
+}
+
+// SupportsAlbumList reports whether GetAlbumList can produce external albums for listType
+func SupportsAlbumList(listType string) bool {
+	return externalAlbumListTypes[listType]
+}
+
+// GetAlbumList returns external albums for a getAlbumList2 type. byGenre searches the genre
+// name, byYear searches the years in range and filters by release year, and random
+// samples a couple of seed queries.
+func (s *SquidService) GetAlbumList(ctx context.Context, listType, genre string, fromYear, toYear, size, offset int) ([]subsonic.Album, error) {
+	var queries []string
+	switch listType {
+	case "random":
+		perm := rand.Perm(len(randomSeedQueries))
+		queries = []string{randomSeedQueries[perm[0]], randomSeedQueries[perm[1]]}
+	case "byGenre":
+		if genre == "" {
+			return nil, fmt.Errorf("byGenre requires a genre")
+		}
+		queries = []string{genre}
+	case "byYear":
+		if fromYear == 0 && toYear == 0 {
+			return nil, fmt.Errorf("byYear requires fromYear/toYear")
+		}
+		lo, hi := fromYear, toYear
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		// Searching every year of a wide range would fan out too far; sample the ends and middle
+		for _, y := range []int{hi, (lo + hi) / 2, lo} {
+			q := strconv.Itoa(y)
+			if len(queries) == 0 || queries[len(queries)-1] != q {
+				queries = append(queries, q)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported album list type %q", listType)
+	}
+
+	var albums []subsonic.Album
+	seen := make(map[string]bool)
+	for _, q := range queries {
+		res, err := s.Search(ctx, q)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Album list search failed", "type", listType, "query", q, "error", err)
+			continue
+		}
+		for _, album := range res.Album {
+			if seen[album.ID] {
+				continue
+			}
+			if listType == "byYear" && !yearInRange(album.Year, fromYear, toYear) {
+				continue
+			}
+			if listType == "byGenre" {
+				album.Genre = genre
+			}
+			seen[album.ID] = true
+			albums = append(albums, album)
+		}
+	}
+
+	switch listType {
+	case "random":
+		rand.Shuffle(len(albums), func(i, j int) { albums[i], albums[j] = albums[j], albums[i] })
+	case "byYear":
+		// Subsonic sorts byYear descending when fromYear > toYear
+		desc := fromYear > toYear
+		sort.SliceStable(albums, func(i, j int) bool {
+			if desc {
+				return albums[i].Year > albums[j].Year
+			}
+			return albums[i].Year < albums[j].Year
+		})
+	}
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(albums) {
+		return []subsonic.Album{}, nil
 	}
+	albums = albums[offset:]
+	if size > 0 && len(albums) > size {
+		albums = albums[:size]
+	}
+	return albums, nil
+}
 
-	return res.Album[0].ID, nil
+// yearInRange checks year against a Subsonic fromYear/toYear pair, which may be reversed
+func yearInRange(year, fromYear, toYear int) bool {
+	if year == 0 {
+		return false
+	}
+	lo, hi := fromYear, toYear
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	return year >= lo && year <= hi
 }
 
 func (s *SquidService) fetchSongs(ctx context.Context, query string) ([]subsonic.Song, error) {
@@ -142,17 +423,18 @@ func (s *SquidService) fetchSongs(ctx context.Context, query string) ([]subsonic
 		if err != nil {
 			return err
 		}
-		req.Header.Set("User-Agent", UserAgent)
+		req.Header.Set("User-Agent", s.NextUserAgent())
 
 		resp, err := s.client.Do(req)
-		if err != nil || resp.StatusCode != http.StatusOK {
-			if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
-				return fmt.Errorf("HTTP 429")
-			}
-			return fmt.Errorf("failed to fetch songs")
+		if err != nil {
+			return err
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode != http.StatusOK {
+			return newStatusError(resp)
+		}
+
 		var result struct {
 			Data struct {
 				Items []struct {
@@ -221,7 +503,7 @@ func (s *SquidService) fetchSongs(ctx context.Context, query string) ([]subsonic
 
 		songs = []subsonic.Song{}
 		for i, item := range items {
-			if s.cfg.SearchLimit > 0 && i >= s.cfg.SearchLimit {
+			if s.cfg.Get().SearchLimit > 0 && i >= s.cfg.Get().SearchLimit {
 				break
 			}
 			songs = append(songs, subsonic.Song{
@@ -241,6 +523,7 @@ func (s *SquidService) fetchSongs(ctx context.Context, query string) ([]subsonic
 				IsDir:       false,
 				IsVideo:     false,
 				Path:        fmt.Sprintf("squidwtf/%s/%s/%d.mp3", item.Artist.Name, item.Album.Title, item.ID),
+				Provider:    "squidwtf",
 			})
 		}
 		return nil
@@ -257,24 +540,28 @@ func (s *SquidService) fetchAlbums(ctx context.Context, query string) ([]subsoni
 		if err != nil {
 			return err
 		}
-		req.Header.Set("User-Agent", UserAgent)
+		req.Header.Set("User-Agent", s.NextUserAgent())
 
 		resp, err := s.client.Do(req)
-		if err != nil || resp.StatusCode != http.StatusOK {
-			if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
-				return fmt.Errorf("HTTP 429")
-			}
-			return fmt.Errorf("failed to fetch albums")
+		if err != nil {
+			return err
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode != http.StatusOK {
+			return newStatusError(resp)
+		}
+
 		var result struct {
 			Data struct {
 				Albums struct {
 					Items []struct {
-						ID          int64  `json:"id"`
-						Title       string `json:"title"`
-						ReleaseDate string `json:"releaseDate"`
+						ID          int64    `json:"id"`
+						Title       string   `json:"title"`
+						ReleaseDate string   `json:"releaseDate"`
+						Genre       string   `json:"genre"`
+						Genres      nameList `json:"genres"`
+						Label       nameList `json:"recordLabel"`
 						Artists     []struct {
 							ID   int64  `json:"id"`
 							Name string `json:"name"`
@@ -291,13 +578,10 @@ func (s *SquidService) fetchAlbums(ctx context.Context, query string) ([]subsoni
 
 		albums = []subsonic.Album{}
 		for i, item := range result.Data.Albums.Items {
-			if s.cfg.SearchLimit > 0 && i >= s.cfg.SearchLimit {
+			if s.cfg.Get().SearchLimit > 0 && i >= s.cfg.Get().SearchLimit {
 				break
 			}
-			year := 0
-			if len(item.ReleaseDate) >= 4 {
-				fmt.Sscanf(item.ReleaseDate, "%d", &year)
-			}
+			year := releaseYear(item.ReleaseDate)
 
 			artistName := ""
 			artistID := int64(0)
@@ -315,7 +599,9 @@ func (s *SquidService) fetchAlbums(ctx context.Context, query string) ([]subsoni
 				Year:     year,
 				CoverArt: subsonic.BuildID("squidwtf", "album", fmt.Sprintf("%d", item.ID)),
 				IsDir:    true,
+				Provider: "squidwtf",
 			})
+			setAlbumGenres(&albums[len(albums)-1], item.Genre, item.Genres, item.Label)
 		}
 		return nil
 	})
@@ -330,17 +616,18 @@ func (s *SquidService) fetchArtists(ctx context.Context, query string) ([]subson
 		if err != nil {
 			return err
 		}
-		req.Header.Set("User-Agent", UserAgent)
+		req.Header.Set("User-Agent", s.NextUserAgent())
 
 		resp, err := s.client.Do(req)
-		if err != nil || resp.StatusCode != http.StatusOK {
-			if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
-				return fmt.Errorf("HTTP 429")
-			}
-			return fmt.Errorf("failed to fetch artists")
+		if err != nil {
+			return err
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode != http.StatusOK {
+			return newStatusError(resp)
+		}
+
 		var result struct {
 			Data struct {
 				Artists struct {
@@ -359,13 +646,15 @@ func (s *SquidService) fetchArtists(ctx context.Context, query string) ([]subson
 
 		artists = []subsonic.Artist{}
 		for i, item := range result.Data.Artists.Items {
-			if s.cfg.SearchLimit > 0 && i >= s.cfg.SearchLimit {
+			if s.cfg.Get().SearchLimit > 0 && i >= s.cfg.Get().SearchLimit {
 				break
 			}
 			artists = append(artists, subsonic.Artist{
-				ID:       subsonic.BuildID("squidwtf", "artist", fmt.Sprintf("%d", item.ID)),
-				Name:     item.Name,
-				CoverArt: subsonic.BuildID("squidwtf", "artist", fmt.Sprintf("%d", item.ID)),
+				ID:             subsonic.BuildID("squidwtf", "artist", fmt.Sprintf("%d", item.ID)),
+				Name:           item.Name,
+				CoverArt:       subsonic.BuildID("squidwtf", "artist", fmt.Sprintf("%d", item.ID)),
+				ArtistImageUrl: artistImageURL(item.Picture),
+				Provider:       "squidwtf",
 			})
 		}
 		return nil
@@ -381,17 +670,18 @@ func (s *SquidService) fetchPlaylists(ctx context.Context, query string) ([]subs
 		if err != nil {
 			return err
 		}
-		req.Header.Set("User-Agent", UserAgent)
+		req.Header.Set("User-Agent", s.NextUserAgent())
 
 		resp, err := s.client.Do(req)
-		if err != nil || resp.StatusCode != http.StatusOK {
-			if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
-				return fmt.Errorf("HTTP 429")
-			}
-			return fmt.Errorf("failed to fetch playlists")
+		if err != nil {
+			return err
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode != http.StatusOK {
+			return newStatusError(resp)
+		}
+
 		var result struct {
 			Data struct {
 				Playlists struct {
@@ -413,7 +703,7 @@ func (s *SquidService) fetchPlaylists(ctx context.Context, query string) ([]subs
 
 		playlists = []subsonic.Playlist{}
 		for i, item := range result.Data.Playlists.Items {
-			if s.cfg.SearchLimit > 0 && i >= s.cfg.SearchLimit {
+			if s.cfg.Get().SearchLimit > 0 && i >= s.cfg.Get().SearchLimit {
 				break
 			}
 			playlists = append(playlists, subsonic.Playlist{
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"jetstream/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newMirrorTestService(urls ...string) *SquidService {
+	cfg := &config.Config{SquidURLs: urls, SquidCooldownBase: time.Minute, SquidCooldownMax: time.Hour}
+	return &SquidService{cfg: config.NewLive(cfg), urlStates: buildURLStates(cfg, nil)}
+}
+
+// Many requests fail on the same mirror at once: only the first failure counts, so the
+// cooldown escalates once and rotation stops at the next mirror instead of skipping it
+func TestConcurrentFailuresDoNotSkipMirrors(t *testing.T) {
+	const workers = 64
+	s := newMirrorTestService("https://a.example", "https://b.example", "https://c.example")
+
+	selected := make([]string, workers)
+	var picked, failed sync.WaitGroup
+	start := make(chan struct{})
+	picked.Add(workers)
+	failed.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func(i int) {
+			defer failed.Done()
+			<-start
+			selected[i] = s.selectURL()
+			picked.Done()
+			picked.Wait() // Every request holds its mirror before any of them fails
+			s.markFailure(selected[i], failureServer, 0)
+		}(i)
+	}
+	close(start)
+	failed.Wait()
+
+	for i, u := range selected {
+		if u != "https://a.example" {
+			t.Fatalf("request %d selected %s, want the first mirror", i, u)
+		}
+	}
+	if got := s.urlStates[0].Failures; got != 1 {
+		t.Errorf("first mirror counted %d failures, want 1", got)
+	}
+	if got := s.selectURL(); got != "https://b.example" {
+		t.Errorf("selectURL = %s after the failures, want the second mirror", got)
+	}
+	for _, st := range s.urlStates[1:] {
+		if st.Failures != 0 || st.NextAvailable.After(time.Now()) {
+			t.Errorf("healthy mirror %s was marked failed", st.URL)
+		}
+	}
+}
+
+// Interleaved selections and failures must leave the rotation state consistent; run with
+// -race to catch unsynchronized access
+func TestSelectAndFailConcurrently(t *testing.T) {
+	s := newMirrorTestService("https://a.example", "https://b.example", "https://c.example")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.markFailure(s.selectURL(), failureTransient, 0)
+			}
+		}()
+	}
+	wg.Wait()
+
+	s.urlMutex.RLock()
+	defer s.urlMutex.RUnlock()
+	if s.currentURLIndex < 0 || s.currentURLIndex >= len(s.urlStates) {
+		t.Fatalf("currentURLIndex = %d, out of range", s.currentURLIndex)
+	}
+	for _, st := range s.urlStates {
+		if st.Failures != 0 {
+			t.Errorf("transient failures put %s on cooldown (%d failures)", st.URL, st.Failures)
+		}
+	}
+}
+
+// Cancelling the caller's context aborts a GetStreamURL whose mirror hasn't answered yet,
+// without blaming the mirror for it
+func TestGetStreamURLAbortsOnCancel(t *testing.T) {
+	arrived := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-r.Context().Done() // Never answers on its own
+	}))
+	defer srv.Close()
+
+	s := NewSquidService(config.NewLive(&config.Config{
+		SquidURLs:      []string{srv.URL},
+		CacheBackend:   "memory",
+		CacheEntries:   10,
+		SquidMaxPasses: 1,
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-arrived
+		cancel()
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.GetStreamURL(ctx, "ext-squidwtf-song-1", "LOSSLESS")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("GetStreamURL err = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetStreamURL still running after its context was cancelled")
+	}
+	if st := s.urlStates[0]; st.Failures != 0 || st.NextAvailable.After(time.Now()) {
+		t.Errorf("cancelled request put the mirror on cooldown (%d failures)", st.Failures)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"10", 10 * time.Second},
+		{" 120 ", 2 * time.Minute},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0}, // Already elapsed
+		{"0", 0},
+		{"-5", 0},
+		{"soon", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+// A 429's Retry-After replaces the computed cooldown, but never beyond SQUID_COOLDOWN_MAX
+func TestMarkFailureHonorsRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{"hint", 10 * time.Second, 10 * time.Second},
+		{"clamped", 3 * time.Hour, time.Hour},
+		{"no hint", 0, 4 * time.Minute}, // Rate limits start one step along the schedule
+	}
+	for _, tt := range tests {
+		s := newMirrorTestService("https://a.example", "https://b.example")
+		before := time.Now()
+		s.markFailure("https://a.example", failureRateLimit, tt.retryAfter)
+		got := s.urlStates[0].NextAvailable.Sub(before)
+		if got < tt.want || got > tt.want+time.Second {
+			t.Errorf("%s: cooldown = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
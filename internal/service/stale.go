@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"jetstream/internal/logging"
+	"strings"
+	"sync/atomic"
+)
+
+// staleCachePrefix holds the long-lived copies kept for SERVE_STALE_ON_ERROR, separate from the
+// regular entries so their TTLs and purges don't interfere
+const staleCachePrefix = CachePrefix + "stale:"
+
+type staleKey struct{}
+
+// WithStaleTracking returns a context in which services record whether any result they
+// returned was served stale
+func WithStaleTracking(ctx context.Context) context.Context {
+	return context.WithValue(ctx, staleKey{}, new(atomic.Bool))
+}
+
+// ServedStale reports whether a stale result was returned under ctx
+func ServedStale(ctx context.Context) bool {
+	flag, ok := ctx.Value(staleKey{}).(*atomic.Bool)
+	return ok && flag.Load()
+}
+
+// keepStale stores a long-lived copy of a freshly fetched cache entry
+func (s *SquidService) keepStale(ctx context.Context, cacheKey, data string) {
+	if !s.cfg.Get().ServeStaleOnError {
+		return
+	}
+	s.cache.Set(ctx, staleCachePrefix+strings.TrimPrefix(cacheKey, CachePrefix), data, s.cfg.Get().StaleCacheTTL)
+}
+
+// serveStale decodes the stale copy of cacheKey into out after fetchErr, marking ctx as served
+// stale. It reports false when stale serving is off, the caller gave up, or there is no copy.
+func (s *SquidService) serveStale(ctx context.Context, cacheKey string, fetchErr error, out interface{}) bool {
+	if !s.cfg.Get().ServeStaleOnError || ctx.Err() != nil {
+		return false
+	}
+	val, err := s.cache.Get(ctx, staleCachePrefix+strings.TrimPrefix(cacheKey, CachePrefix))
+	if err != nil || json.Unmarshal([]byte(val), out) != nil {
+		return false
+	}
+
+	if flag, ok := ctx.Value(staleKey{}).(*atomic.Bool); ok {
+		flag.Store(true)
+	}
+	logging.FromContext(ctx).Warn("Fetch failed, serving stale cache entry", "key", cacheKey, "error", fetchErr)
+	return true
+}
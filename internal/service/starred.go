@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jetstream/internal/cache"
+	"jetstream/internal/logging"
+	"jetstream/pkg/subsonic"
+	"sort"
+	"time"
+)
+
+// Starred external IDs of each media type live in one cache entry, a JSON object of ID to the
+// unix time it was starred. The entries never expire, but with CACHE_BACKEND=memory they
+// are lost on restart or when the LRU evicts them.
+const starredKeyPrefix = "starred:"
+
+// legacyStarredKeyPrefix names the Redis sorted sets stars were kept in before they moved into
+// the cache. They are imported once and then removed.
+const legacyStarredKeyPrefix = "jetstream:starred:"
+
+// StarredItem is an external ID and when it was starred
+type StarredItem struct {
+	ID        string
+	StarredAt time.Time
+}
+
+// starredKey maps a media type ("song", "album", "artist") to its starred set
+func starredKey(mediaType string) (string, error) {
+	switch mediaType {
+	case "song":
+		return starredKeyPrefix + "songs", nil
+	case "album":
+		return starredKeyPrefix + "albums", nil
+	case "artist":
+		return starredKeyPrefix + "artists", nil
+	}
+	return "", fmt.Errorf("unsupported starred type %q", mediaType)
+}
+
+// starredKeyForID resolves the starred set an external ID belongs to
+func starredKeyForID(id string) (string, error) {
+	isExternal, _, mediaType, _ := subsonic.ParseID(id)
+	if !isExternal {
+		return "", fmt.Errorf("cannot star non-external id %q", id)
+	}
+	return starredKey(mediaType)
+}
+
+// loadStarred reads a starred set, importing its legacy sorted set the first time. The caller
+// holds starredMu.
+func (s *SquidService) loadStarred(ctx context.Context, key string) (map[string]int64, error) {
+	val, err := s.cache.Get(ctx, key)
+	if err == nil {
+		starred := make(map[string]int64)
+		if err := json.Unmarshal([]byte(val), &starred); err != nil {
+			return nil, fmt.Errorf("corrupt starred set %s: %w", key, err)
+		}
+		return starred, nil
+	}
+	if !errors.Is(err, cache.ErrMiss) {
+		return nil, err
+	}
+
+	starred := make(map[string]int64)
+	if s.redisBackend {
+		legacyKey := legacyStarredKeyPrefix + key[len(starredKeyPrefix):]
+		entries, err := s.redis.ZRangeWithScores(ctx, legacyKey, 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, z := range entries {
+			if id, ok := z.Member.(string); ok {
+				starred[id] = int64(z.Score)
+			}
+		}
+		if len(entries) > 0 {
+			logging.FromContext(ctx).Info("Imported stars from sorted set", "key", legacyKey, "count", len(entries))
+		}
+		if err := s.saveStarred(ctx, key, starred); err != nil {
+			return nil, err
+		}
+		s.redis.Del(ctx, legacyKey)
+	}
+	return starred, nil
+}
+
+func (s *SquidService) saveStarred(ctx context.Context, key string, starred map[string]int64) error {
+	data, err := json.Marshal(starred)
+	if err != nil {
+		return err
+	}
+	return s.cache.Set(ctx, key, string(data), 0)
+}
+
+// updateStarred applies change to the starred set id belongs to
+func (s *SquidService) updateStarred(ctx context.Context, id string, change func(starred map[string]int64)) error {
+	key, err := starredKeyForID(id)
+	if err != nil {
+		return err
+	}
+
+	s.starredMu.Lock()
+	defer s.starredMu.Unlock()
+	starred, err := s.loadStarred(ctx, key)
+	if err != nil {
+		return err
+	}
+	change(starred)
+	return s.saveStarred(ctx, key, starred)
+}
+
+// Star persists an external ID as starred
+func (s *SquidService) Star(ctx context.Context, id string) error {
+	return s.updateStarred(ctx, id, func(starred map[string]int64) {
+		starred[id] = time.Now().Unix()
+	})
+}
+
+// Unstar removes an external ID from the starred set
+func (s *SquidService) Unstar(ctx context.Context, id string) error {
+	return s.updateStarred(ctx, id, func(starred map[string]int64) {
+		delete(starred, id)
+	})
+}
+
+// StarredItems returns the starred external IDs of a media type ("song", "album", "artist"), newest first
+func (s *SquidService) StarredItems(ctx context.Context, mediaType string) ([]StarredItem, error) {
+	key, err := starredKey(mediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	s.starredMu.Lock()
+	starred, err := s.loadStarred(ctx, key)
+	s.starredMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]StarredItem, 0, len(starred))
+	for id, unix := range starred {
+		items = append(items, StarredItem{ID: id, StarredAt: time.Unix(unix, 0)})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].StarredAt.Equal(items[j].StarredAt) {
+			return items[i].StarredAt.After(items[j].StarredAt)
+		}
+		return items[i].ID < items[j].ID
+	})
+	return items, nil
+}
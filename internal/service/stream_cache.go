@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"jetstream/internal/config"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// streamCacheDir holds raw upstream audio captured while proxying streams.
+// It lives inside the library path but is hidden so Navidrome doesn't index it.
+const streamCacheDir = ".streamcache"
+
+// Source container extensions for the mime types Squid manifests report
+var streamCacheExts = map[string]string{
+	"audio/flac": ".flac",
+	"audio/mp4":  ".m4a",
+	"audio/mpeg": ".mp3",
+	"audio/ogg":  ".ogg",
+}
+
+// NewStreamClient returns the client for CDN audio. It bounds connecting and waiting for
+// headers by STREAM_DIAL_TIMEOUT and STREAM_HEADER_TIMEOUT, but sets no overall timeout
+// since a stream legitimately lasts as long as the track plays.
+func NewStreamClient(cfg *config.Config) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   cfg.StreamDialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   cfg.StreamDialTimeout,
+			ResponseHeaderTimeout: cfg.StreamHeaderTimeout,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   10,
+			IdleConnTimeout:       90 * time.Second,
+			// Keep byte ranges and Content-Length exact; transparent gzip would break seeking
+			DisableCompression: true,
+		},
+	}
+}
+
+// SyncQuality is the Squid quality tier SyncSong downloads. It is the only tier the stream
+// cache keeps, since the cache exists to spare the sync a second download.
+func (s *SyncService) SyncQuality() string {
+	return NormalizeQuality(s.cfg.Get().StreamQuality)
+}
+
+// streamCachePath is the cache file for songID at quality, without its extension. Keying on
+// the quality keeps a source cached before a STREAM_QUALITY change from being synced after it.
+func (s *SyncService) streamCachePath(songID, quality string) string {
+	return filepath.Join(s.libraryPath(), streamCacheDir, s.SanitizePath(songID)+"."+quality)
+}
+
+// cachedQuality resolves quality ("" for the STREAM_QUALITY default) and reports whether the
+// stream cache keeps that tier
+func (s *SyncService) cachedQuality(quality string) (string, bool) {
+	if quality == "" {
+		return s.SyncQuality(), true
+	}
+	quality = NormalizeQuality(quality)
+	return quality, quality == s.SyncQuality()
+}
+
+// AudioExtension maps an upstream mime type to a file extension including the dot, or "" if it
+// isn't one Squid serves
+func AudioExtension(mimeType string) string {
+	return streamCacheExts[strings.ToLower(mimeType)]
+}
+
+// CachesQuality reports whether streams fetched at quality are teed into the stream cache
+func (s *SyncService) CachesQuality(quality string) bool {
+	_, cached := s.cachedQuality(quality)
+	return cached
+}
+
+// CachedStream returns the fully downloaded upstream source for a song at quality ("" for the
+// STREAM_QUALITY default), if one exists
+func (s *SyncService) CachedStream(songID, quality string) (path, mimeType string, ok bool) {
+	quality, cached := s.cachedQuality(quality)
+	if !cached {
+		return "", "", false
+	}
+	base := s.streamCachePath(songID, quality)
+	for mt, ext := range streamCacheExts {
+		if info, err := os.Stat(base + ext); err == nil && info.Mode().IsRegular() {
+			return base + ext, mt, true
+		}
+	}
+	return "", "", false
+}
+
+// dropCachedStream deletes the cached source of songID, if there is one
+func (s *SyncService) dropCachedStream(songID string) {
+	if path, _, ok := s.CachedStream(songID, ""); ok {
+		if err := os.Remove(path); err != nil {
+			slog.Warn("Failed to delete cached stream", "path", path, "error", err)
+		}
+	}
+}
+
+// beginCache claims the cache slot for songID. When another request is already writing it,
+// it returns false and a channel that is closed once that write ends.
+func (s *SyncService) beginCache(songID string) (<-chan struct{}, bool) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	if done, busy := s.caching[songID]; busy {
+		return done, false
+	}
+	s.caching[songID] = make(chan struct{})
+	return nil, true
+}
+
+func (s *SyncService) endCache(songID string) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	close(s.caching[songID])
+	delete(s.caching, songID)
+}
+
+// StreamAndCache copies body, fetched at quality ("" for the STREAM_QUALITY default), to w
+// while teeing the bytes into the stream cache. Once the full body (expectedSize, when known)
+// has been written the cache file is promoted to a complete source that later range requests
+// and SyncSong read from. Only one request per song writes the cache; concurrent requests,
+// streams at another quality than SyncQuality and previews just stream.
+func (s *SyncService) StreamAndCache(songID, quality, mimeType string, body io.Reader, w io.Writer, expectedSize int64) (int64, error) {
+	ext, known := streamCacheExts[strings.ToLower(mimeType)]
+	quality, cached := s.cachedQuality(quality)
+	if !known || !cached || s.providers.PreviewOnly(songID) {
+		return io.Copy(w, body)
+	}
+	if _, ok := s.beginCache(songID); !ok {
+		return io.Copy(w, body)
+	}
+	defer s.endCache(songID)
+	return s.writeCache(songID, quality, ext, body, w, expectedSize)
+}
+
+// BufferStream downloads body, fetched at quality, into the stream cache and returns the
+// finished file. If another request is already writing the song's cache it waits for that
+// download instead, and only reads body when that one fails.
+func (s *SyncService) BufferStream(ctx context.Context, songID, quality, mimeType string, body io.Reader) (path, cachedType string, err error) {
+	ext, known := streamCacheExts[strings.ToLower(mimeType)]
+	quality, cached := s.cachedQuality(quality)
+	if !known || !cached || s.providers.PreviewOnly(songID) {
+		return "", "", errors.New("stream can't be cached")
+	}
+
+	for {
+		done, ok := s.beginCache(songID)
+		if path, cachedType, cachedOK := s.CachedStream(songID, quality); cachedOK {
+			if ok {
+				s.endCache(songID)
+			}
+			return path, cachedType, nil
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return "", "", ctx.Err()
+		}
+	}
+
+	_, err = s.writeCache(songID, quality, ext, body, io.Discard, -1)
+	s.endCache(songID)
+	if path, cachedType, ok := s.CachedStream(songID, quality); ok {
+		return path, cachedType, nil
+	}
+	if err == nil {
+		err = errors.New("stream cache incomplete")
+	}
+	return "", "", err
+}
+
+// writeCache copies body to w and the cache file for songID, promoting the file once the full
+// body arrived. The caller holds the song's cache slot.
+func (s *SyncService) writeCache(songID, quality, ext string, body io.Reader, w io.Writer, expectedSize int64) (int64, error) {
+	base := s.streamCachePath(songID, quality)
+	if err := os.MkdirAll(filepath.Dir(base), 0755); err != nil {
+		slog.Warn("Failed to create stream cache dir", "error", err)
+		return io.Copy(w, body)
+	}
+
+	partPath := base + ".part"
+	f, err := os.Create(partPath)
+	if err != nil {
+		slog.Warn("Failed to create stream cache file", "path", partPath, "error", err)
+		return io.Copy(w, body)
+	}
+
+	// The cache is best effort: a failing cache write (e.g. a full disk) must not end the stream
+	cacheFile := &bestEffortWriter{w: f}
+	n, copyErr := io.Copy(io.MultiWriter(w, cacheFile), body)
+	closeErr := f.Close()
+
+	if cacheFile.err != nil {
+		slog.Warn("Failed to write stream cache, discarding", "path", partPath, "error", cacheFile.err)
+		os.Remove(partPath)
+		return n, copyErr
+	}
+	if copyErr != nil || closeErr != nil || (expectedSize > 0 && n != expectedSize) {
+		slog.Debug("Stream cache incomplete, discarding", "id", songID, "written", n, "expected", expectedSize)
+		os.Remove(partPath)
+		return n, copyErr
+	}
+
+	if err := os.Rename(partPath, base+ext); err != nil {
+		slog.Warn("Failed to promote stream cache", "path", partPath, "error", err)
+		os.Remove(partPath)
+		return n, nil
+	}
+
+	slog.Info("Cached upstream stream", "id", songID, "path", base+ext, "sizeMB", float64(n)/1024/1024)
+	return n, nil
+}
+
+// bestEffortWriter passes writes on to w until one fails, then records that error and
+// swallows every later write, so a tee into it never fails the copy
+type bestEffortWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (b *bestEffortWriter) Write(p []byte) (int, error) {
+	if b.err == nil {
+		_, b.err = b.w.Write(p)
+	}
+	return len(p), nil
+}
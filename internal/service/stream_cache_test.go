@@ -0,0 +1,41 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// failingWriter accepts limit bytes, then fails like a full disk
+type failingWriter struct {
+	limit   int
+	written int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if f.written+len(p) > f.limit {
+		return 0, errors.New("no space left on device")
+	}
+	f.written += len(p)
+	return len(p), nil
+}
+
+// A cache file that stops accepting writes must not cut off the client's copy of the stream
+func TestBestEffortWriterKeepsStreaming(t *testing.T) {
+	body := strings.Repeat("audio", 10000)
+	cacheFile := &bestEffortWriter{w: &failingWriter{limit: 1024}}
+	var client bytes.Buffer
+
+	n, err := io.Copy(io.MultiWriter(&client, cacheFile), strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	if n != int64(len(body)) || client.String() != body {
+		t.Errorf("client got %d of %d bytes", client.Len(), len(body))
+	}
+	if cacheFile.err == nil {
+		t.Error("cache write error wasn't recorded")
+	}
+}
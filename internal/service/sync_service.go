@@ -3,97 +3,417 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"jetstream/internal/cache"
 	"jetstream/internal/config"
+	"jetstream/internal/logging"
+	"jetstream/internal/metadata"
+	"jetstream/internal/safego"
 	"jetstream/pkg/subsonic"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
 )
 
 type SyncService struct {
-	squid *SquidService
-	redis *redis.Client
-	cfg   *config.Config
+	squid     *SquidService
+	providers *Providers // Routes songs and covers to the provider in their ID
+	cache     cache.Cache
+	cfg       *config.Live
+	sem       chan struct{} // Global limiter for concurrent ffmpeg jobs
+	mb        *metadata.MusicBrainz
+
+	coverSem    chan struct{} // Limits concurrent upstream cover fetches
+	coverClient *http.Client
+
+	sourceClient *http.Client // Downloads CDN sources, bounded like proxied streams
+
+	quotaMu  sync.Mutex       // Serializes LIBRARY_MAX_BYTES reservations, settles and evictions
+	usage    atomic.Int64     // Bytes taken by synced files, including in-flight reservations
+	reserved map[string]int64 // Output path of each sync in flight -> bytes usage counts for it
+
+	syncedArtistsMu sync.Mutex // Serializes updates of the synced artist index
+
+	cacheMu sync.Mutex
+	caching map[string]chan struct{} // Song IDs being written to the stream cache -> closed when done
+
+	syncing singleflight.Group // Collapses concurrent SyncSong calls for the same song
+
+	activeMu sync.Mutex
+	active   sync.WaitGroup     // In-flight SyncSong calls
+	closing  bool               // Set by Shutdown; new syncs are refused
+	stopCtx  context.Context    // Cancelled when Shutdown gives up waiting
+	stop     context.CancelFunc // Aborts every in-flight sync
+}
+
+// ErrShuttingDown is returned by SyncSong once Shutdown has started
+var ErrShuttingDown = errors.New("sync service is shutting down")
+
+// ErrTranscode is returned when ffmpeg fails to produce a synced file
+var ErrTranscode = errors.New("transcode failed")
+
+// ErrPreviewOnly is returned by SyncSong for songs whose provider only streams previews
+var ErrPreviewOnly = errors.New("provider only streams previews")
+
+func NewSyncService(squid *SquidService, providers *Providers, live *config.Live) *SyncService {
+	cfg := live.Get()
+	concurrency := cfg.SyncConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	coverConcurrency := max(cfg.CoverConcurrency, 1)
+
+	s := &SyncService{
+		squid:     squid,
+		providers: providers,
+		cache:     squid.GetCache(),
+		cfg:       live,
+		sem:       make(chan struct{}, concurrency),
+		mb:        metadata.NewMusicBrainz(cfg, squid.GetCache()),
+
+		coverSem:    make(chan struct{}, coverConcurrency),
+		coverClient: newCoverClient(coverConcurrency),
+
+		sourceClient: NewStreamClient(cfg),
+
+		reserved: make(map[string]int64),
+		caching:  make(map[string]chan struct{}),
+	}
+	s.stopCtx, s.stop = context.WithCancel(context.Background())
+	return s
+}
+
+// track registers an in-flight sync. The returned context is also cancelled when Shutdown
+// times out, and done must be called when the sync finishes.
+func (s *SyncService) track(ctx context.Context) (context.Context, func(), error) {
+	s.activeMu.Lock()
+	defer s.activeMu.Unlock()
+	if s.closing {
+		return nil, nil, ErrShuttingDown
+	}
+	s.active.Add(1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	stopAfter := context.AfterFunc(s.stopCtx, cancel)
+	return ctx, func() {
+		stopAfter()
+		cancel()
+		s.active.Done()
+	}, nil
+}
+
+// Shutdown refuses new syncs and waits for in-flight ones to finish. If ctx ends first the
+// remaining syncs are cancelled, which kills their ffmpeg processes and removes partial
+// files, and Shutdown waits briefly for that cleanup before returning ctx's error.
+func (s *SyncService) Shutdown(ctx context.Context) error {
+	s.activeMu.Lock()
+	s.closing = true
+	s.activeMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	slog.Warn("Timed out waiting for in-flight syncs, cancelling them")
+	s.stop()
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		slog.Warn("In-flight syncs did not stop after cancellation")
+	}
+	return ctx.Err()
 }
 
-func NewSyncService(squid *SquidService, cfg *config.Config) *SyncService {
-	return &SyncService{
-		squid: squid,
-		redis: squid.GetRedis(),
-		cfg:   cfg,
+// acquire blocks until a worker slot is free or ctx is done
+func (s *SyncService) acquire(ctx context.Context) error {
+	select {
+	case s.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
-func (s *SyncService) SyncAlbum(ctx context.Context, album *subsonic.Album, songs []subsonic.Song) error {
-	slog.Info("Syncing all tracks for album", "album", album.Title)
-	for _, song := range songs {
-		if err := s.SyncSong(ctx, &song); err != nil {
-			slog.Error("Failed to sync song", "title", song.Title, "error", err)
+func (s *SyncService) release() {
+	<-s.sem
+}
+
+// SyncProgress is emitted per track while an album syncs
+type SyncProgress struct {
+	Track  string `json:"track"`
+	Status string `json:"status"` // downloading, done or error
+	Err    error  `json:"-"`      // Why the track failed; callers decide what to show of it
+}
+
+// SyncAlbum syncs every track of an album. If progress is non-nil, a SyncProgress is sent
+// when each track starts and finishes; the caller owns the channel and closes it after return.
+func (s *SyncService) SyncAlbum(ctx context.Context, album *subsonic.Album, songs []subsonic.Song, progress chan<- SyncProgress) error {
+	logging.FromContext(ctx).Info("Syncing all tracks for album", "album", album.Title, "tracks", len(songs))
+
+	report := func(p SyncProgress) {
+		if progress == nil {
+			return
+		}
+		select {
+		case progress <- p:
+		case <-ctx.Done():
 		}
 	}
+
+	// Fan out across the worker pool; SyncSong enforces the concurrency ceiling
+	var failed int64
+	var errsMu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	for i := range songs {
+		wg.Add(1)
+		go func(song *subsonic.Song) {
+			defer wg.Done()
+			defer safego.Recover()
+			report(SyncProgress{Track: song.Title, Status: "downloading"})
+			if err := s.SyncSong(ctx, song); err != nil {
+				atomic.AddInt64(&failed, 1)
+				errsMu.Lock()
+				errs = append(errs, err)
+				errsMu.Unlock()
+				logging.FromContext(ctx).Error("Failed to sync song", "title", song.Title, "error", err)
+				report(SyncProgress{Track: song.Title, Status: "error", Err: err})
+				return
+			}
+			report(SyncProgress{Track: song.Title, Status: "done"})
+		}(&songs[i])
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		// Wrap the track errors so callers can tell an upstream outage from an ffmpeg failure
+		return fmt.Errorf("%d of %d tracks failed to sync: %w", failed, len(songs), errors.Join(errs...))
+	}
 	return nil
 }
 
+// ArtistSyncResult holds per-album outcomes of SyncArtist
+type ArtistSyncResult struct {
+	Albums  int
+	Synced  int
+	Skipped int // Already fully on disk
+	Failed  int
+}
+
+// SyncArtist downloads every album of an artist, skipping albums that are already synced
+func (s *SyncService) SyncArtist(ctx context.Context, artistID string) (ArtistSyncResult, error) {
+	var result ArtistSyncResult
+
+	artist, albums, err := s.squid.GetArtist(ctx, artistID)
+	if err != nil {
+		return result, err
+	}
+	result.Albums = len(albums)
+	logging.FromContext(ctx).Info("Syncing discography", "artist", artist.Name, "albums", len(albums))
+
+	for _, a := range albums {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		album, songs, err := s.squid.GetAlbum(ctx, a.ID)
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to fetch album for artist sync", "album", a.Title, "error", err)
+			result.Failed++
+			continue
+		}
+
+		if s.albumSynced(songs) {
+			logging.FromContext(ctx).Debug("Album already synced, skipping", "album", album.Title)
+			result.Skipped++
+			continue
+		}
+
+		if err := s.SyncAlbum(ctx, album, songs, nil); err != nil {
+			logging.FromContext(ctx).Error("Failed to sync album", "album", album.Title, "error", err)
+			result.Failed++
+			continue
+		}
+		result.Synced++
+	}
+
+	return result, nil
+}
+
+// albumSynced reports whether every track of an album already exists on disk
+func (s *SyncService) albumSynced(songs []subsonic.Song) bool {
+	if len(songs) == 0 {
+		return false
+	}
+	for i := range songs {
+		if _, err := os.Stat(s.LocalPath(&songs[i])); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// SyncSong downloads song into the library in DOWNLOAD_FORMAT. Calls for a song that is
+// already syncing wait for that sync and share its result instead of starting another, so
+// plays of a song served from the stream cache don't pile up syncs. Songs from preview-only
+// providers are refused with ErrPreviewOnly.
 func (s *SyncService) SyncSong(ctx context.Context, song *subsonic.Song) error {
-	// 1. Determine local path
-	artistDir := s.SanitizePath(song.Artist)
-	albumDir := s.SanitizePath(song.Album)
-	targetDir := filepath.Join("/music/jetstream", artistDir, albumDir)
-	if err := os.MkdirAll(targetDir, 0755); err != nil {
+	if s.providers.PreviewOnly(song.ID) {
+		return fmt.Errorf("%w: %s", ErrPreviewOnly, song.ID)
+	}
+	_, err, _ := s.syncing.Do(song.ID, func() (interface{}, error) {
+		return nil, s.syncSong(ctx, song)
+	})
+	return err
+}
+
+func (s *SyncService) syncSong(ctx context.Context, song *subsonic.Song) error {
+	ctx, done, err := s.track(ctx)
+	if err != nil {
 		return err
 	}
+	defer done()
+
+	// A source in the stream cache only exists for this sync and isn't counted against
+	// LIBRARY_MAX_BYTES, so it goes once the sync is over, whether the song synced, was on disk
+	// already or was refused for a full library or a failed transcode. Only a cancelled sync
+	// keeps it for the next attempt.
+	defer func() {
+		if ctx.Err() == nil {
+			s.dropCachedStream(song.ID)
+		}
+	}()
 
-	format := s.GetDownloadFormat()
+	// Every step reads the encoder settings from one snapshot, so a reload mid-sync can't
+	// switch the format or bitrate between naming, encoding and verifying the file
+	cfg := s.cfg.Get()
 
-	fileName := fmt.Sprintf("%02d - [%s] %s.%s", song.Track, song.ID, s.SanitizePath(song.Title), format)
-	outputPath := filepath.Join(targetDir, fileName)
+	// 1. Determine local path
+	format := downloadFormat(cfg)
+	outputPath := s.localPath(cfg, song)
+	targetDir := filepath.Dir(outputPath)
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return err
+	}
 
 	// 2. Save cover art as cover.jpg in the directory (best for Navidrome/Opus)
 	if song.CoverArt != "" {
 		coverPath := filepath.Join(targetDir, "cover.jpg")
 		if _, err := os.Stat(coverPath); os.IsNotExist(err) {
-			slog.Debug("Saving cover.jpg for album", "dir", targetDir)
+			logging.FromContext(ctx).Debug("Saving cover.jpg for album", "dir", targetDir)
 			coverData, err := s.downloadArt(ctx, song.CoverArt)
 			if err == nil {
 				os.WriteFile(coverPath, coverData, 0644)
 			} else {
-				slog.Warn("Failed to save cover.jpg", "error", err)
+				logging.FromContext(ctx).Warn("Failed to save cover.jpg", "error", err)
 			}
 		}
 	}
 
-	// 3. Check if song file exists and is complete
+	// 3. Check if song file exists and is complete. With VERIFY_ON_SYNC off, any file past the
+	// ghost threshold is trusted.
+	verify := cfg.VerifyOnSync
 	if _, err := os.Stat(outputPath); err == nil {
-		// Verify integrity
-		if err := s.VerifyIntegrity(outputPath); err == nil {
-			// Ensure metadata sidecar also exists
-			s.saveMetadata(song, outputPath)
+		var verifyErr error
+		if verify || s.IsGhostFile(outputPath) {
+			verifyErr = s.verifyFormatAs(ctx, cfg, outputPath, format)
+		}
+		if verifyErr == nil {
+			// Ensure metadata sidecar also exists, keeping what earlier syncs measured
+			sidecar := songSidecar{Song: song, MusicBrainz: s.lookupMusicBrainz(ctx, song)}
+			if prev, err := readSidecar(outputPath); err == nil {
+				sidecar.ReplayGain = prev.ReplayGain
+				sidecar.Original = prev.Original
+			}
+			s.saveMetadata(ctx, outputPath, sidecar)
 			return nil // Already synced and complete
 		}
-		slog.Warn("Existing file is corrupt or incomplete. Re-syncing.", "path", outputPath)
+		logging.FromContext(ctx).Warn("Existing file is corrupt or incomplete. Re-syncing.", "path", outputPath)
 	}
 
-	// 4. Get Stream URL
-	info, err := s.squid.GetStreamURL(ctx, song.ID)
+	// 4. Make room under LIBRARY_MAX_BYTES, then wait for a worker slot before touching the
+	// network/ffmpeg. Whatever ends up on disk, including a corrupt file replaced or removed,
+	// settles the reservation.
+	replaced := s.syncedSize(outputPath)
+	settle, err := s.reserve(ctx, cfg, song, outputPath, replaced)
 	if err != nil {
 		return err
 	}
+	defer func() { settle(s.syncedSize(outputPath) - replaced) }()
+
+	if err := s.acquire(ctx); err != nil {
+		return err
+	}
+	defer s.release()
+
+	// 5. Prefer a source already captured by the stream cache, otherwise get the Stream URL
+	source, mimeType, cached := s.CachedStream(song.ID, "")
+	if !cached {
+		info, err := s.providers.GetStreamURL(ctx, song.ID, "")
+		if err != nil {
+			return err
+		}
+		source = info.DownloadURL
+		mimeType = info.MimeType
+	}
+
+	// 6. With KEEP_ORIGINAL, save the untouched source first and transcode from that copy
+	transcodeSource, original := source, ""
+	if cfg.KeepOriginal {
+		if path, err := s.keepOriginal(ctx, source, mimeType, outputPath); err != nil {
+			logging.FromContext(ctx).Warn("Failed to keep original, transcoding without it", "songID", song.ID, "error", err)
+		} else {
+			transcodeSource, original = path, path
+		}
+	}
+
+	// ReplayGain analysis reads the whole source before the transcode does, so a remote
+	// source is downloaded once rather than streamed from the CDN twice
+	if strings.HasPrefix(transcodeSource, "http") && s.needsReplayGain(outputPath) {
+		if path, err := s.downloadSource(ctx, transcodeSource, outputPath); err != nil {
+			logging.FromContext(ctx).Warn("Failed to download source for ReplayGain, reading it from the CDN", "songID", song.ID, "error", err)
+		} else {
+			defer os.Remove(path)
+			transcodeSource = path
+		}
+	}
+
+	// 7. Download and Transcode
+	logging.FromContext(ctx).Info("Downloading and transcoding", "format", format, "path", outputPath, "fromCache", cached)
+	if err := s.downloadAndTranscode(ctx, cfg, song, transcodeSource, outputPath, format, original); err != nil {
+		return err
+	}
 
-	// 5. Download and Transcode
-	slog.Info("Downloading and transcoding", "format", format, "path", outputPath)
-	return s.downloadAndTranscode(ctx, song, info.DownloadURL, outputPath, format)
+	s.MarkPlayed(ctx, song.ID)
+	return nil
 }
 
-func (s *SyncService) downloadAndTranscode(ctx context.Context, song *subsonic.Song, url, outputPath, format string) error {
+// downloadAndTranscode encodes url into outputPath with the encoder settings in cfg. original,
+// if set, is the kept source recorded in the sidecar.
+func (s *SyncService) downloadAndTranscode(ctx context.Context, cfg *config.Config, song *subsonic.Song, url, outputPath, format, original string) error {
 	// Root context with timeout for the whole operation
 	ctx, cancel := context.WithTimeout(ctx, 15*time.Minute)
 	defer cancel()
@@ -106,6 +426,8 @@ func (s *SyncService) downloadAndTranscode(ctx context.Context, song *subsonic.S
 		codec = "libmp3lame"
 	case "aac":
 		codec = "aac"
+	case "flac":
+		codec = "flac"
 	default:
 		codec = "copy"
 	}
@@ -117,7 +439,7 @@ func (s *SyncService) downloadAndTranscode(ctx context.Context, song *subsonic.S
 		var err error
 		coverPath, cleanup, err = s.downloadCoverToTemp(ctx, song.CoverArt)
 		if err != nil {
-			slog.Warn("Failed to download cover art", "songID", song.ID, "error", err)
+			logging.FromContext(ctx).Warn("Failed to download cover art", "songID", song.ID, "error", err)
 		} else {
 			defer cleanup()
 		}
@@ -134,11 +456,11 @@ func (s *SyncService) downloadAndTranscode(ctx context.Context, song *subsonic.S
 	// Format-specific encoding
 	switch format {
 	case "opus":
-		args = append(args, "-c:a", codec, "-b:a", "128k")
+		args = append(args, "-c:a", codec, "-b:a", cfg.OpusBitrate)
 		args = append(args, "-map", "0:a")
 
 	case "mp3":
-		args = append(args, "-c:a", codec, "-q:a", "0")
+		args = append(args, "-c:a", codec, "-q:a", strconv.Itoa(cfg.MP3Quality))
 		if coverPath != "" {
 			args = append(args,
 				"-map", "0:a",
@@ -153,7 +475,7 @@ func (s *SyncService) downloadAndTranscode(ctx context.Context, song *subsonic.S
 		}
 
 	case "aac":
-		args = append(args, "-c:a", codec, "-b:a", "192k")
+		args = append(args, "-c:a", codec, "-b:a", cfg.AACBitrate)
 		if coverPath != "" {
 			args = append(args,
 				"-map", "0:a",
@@ -163,31 +485,69 @@ func (s *SyncService) downloadAndTranscode(ctx context.Context, song *subsonic.S
 			)
 		}
 
+	case "flac":
+		args = append(args, "-c:a", codec)
+		if coverPath != "" {
+			// The FLAC muxer stores an attached picture as a METADATA_BLOCK_PICTURE
+			args = append(args,
+				"-map", "0:a",
+				"-map", "1:0",
+				"-c:v", "copy",
+				"-disposition:v:0", "attached_pic",
+				"-metadata:s:v", "title=Album cover",
+				"-metadata:s:v", "comment=Cover (front)",
+			)
+		} else {
+			args = append(args, "-map", "0:a")
+		}
+
 	default:
 		args = append(args, "-c:a", "copy")
 	}
 
+	mbIDs := s.lookupMusicBrainz(ctx, song)
+	rg := s.replayGain(ctx, song, url, outputPath)
+
 	// Add comprehensive metadata
 	args = append(args,
 		"-metadata", "title="+song.Title,
 		"-metadata", "artist="+song.Artist,
-		"-metadata", "album_artist="+song.Artist,
+		"-metadata", "album_artist="+s.albumArtist(ctx, song),
 		"-metadata", "album="+song.Album,
 	)
 
 	if song.Track > 0 {
 		args = append(args, "-metadata", "track="+strconv.Itoa(song.Track))
 	}
+	if song.DiscNumber > 0 {
+		args = append(args, "-metadata", "disc="+strconv.Itoa(song.DiscNumber))
+	}
 	if song.Year > 0 {
 		args = append(args, "-metadata", "date="+strconv.Itoa(song.Year))
 	}
 	if song.Genre != "" {
 		args = append(args, "-metadata", "genre="+song.Genre)
 	}
+	if mbIDs != nil {
+		args = append(args, "-metadata", "MUSICBRAINZ_TRACKID="+mbIDs.TrackID)
+		if mbIDs.AlbumID != "" {
+			args = append(args, "-metadata", "MUSICBRAINZ_ALBUMID="+mbIDs.AlbumID)
+		}
+	}
+	// TIDAL_ID becomes a TXXX frame in MP3 and a Vorbis comment in opus/flac, which is
+	// what ResolveVirtualID reads to map a Navidrome song back to its external ID
+	args = append(args, "-metadata", "TIDAL_ID="+song.ID)
 	args = append(args, "-metadata", "comment=Synced by JetStream [ID:"+song.ID+"]")
+	if rg != nil {
+		args = append(args, rg.metadataArgs()...)
+	}
 
 	// Output to a temp file first to ensure atomicity
-	tmpOutputPath := outputPath + ".tmp"
+	tmpOutputPath, err := s.transcodeTempPath(outputPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpOutputPath) // Gone already once moved into place
 
 	// Help FFmpeg identify the format since we use .tmp extension
 	var ffmpegFormat string
@@ -198,6 +558,8 @@ func (s *SyncService) downloadAndTranscode(ctx context.Context, song *subsonic.S
 		ffmpegFormat = "mp3"
 	case "aac":
 		ffmpegFormat = "adts"
+	case "flac":
+		ffmpegFormat = "flac"
 	}
 
 	if ffmpegFormat != "" {
@@ -205,61 +567,74 @@ func (s *SyncService) downloadAndTranscode(ctx context.Context, song *subsonic.S
 	}
 	args = append(args, "-y", tmpOutputPath)
 
-	slog.Debug("FFmpeg command", "args", strings.Join(args, " "))
+	logging.FromContext(ctx).Debug("FFmpeg command", "args", strings.Join(args, " "))
 
 	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 	output, err := cmd.CombinedOutput()
 
 	if err != nil {
 		if ctx.Err() == context.DeadlineExceeded {
-			os.Remove(tmpOutputPath)
-			return fmt.Errorf("ffmpeg timed out")
+			return fmt.Errorf("%w: ffmpeg timed out", ErrTranscode)
 		}
 
-		slog.Warn("FFmpeg failed, retrying without complex mapping", "error", err, "output", string(output))
+		logging.FromContext(ctx).Warn("FFmpeg failed, retrying without complex mapping", "error", err, "output", string(output))
 
 		// Fallback: Transcode without cover art
 		argsNoCover := []string{"-i", url}
 		argsNoCover = append(argsNoCover, "-c:a", codec)
 		if format == "opus" {
-			argsNoCover = append(argsNoCover, "-b:a", "128k")
+			argsNoCover = append(argsNoCover, "-b:a", cfg.OpusBitrate)
 		} else if format == "mp3" {
-			argsNoCover = append(argsNoCover, "-q:a", "0", "-id3v2_version", "3")
+			argsNoCover = append(argsNoCover, "-q:a", strconv.Itoa(cfg.MP3Quality), "-id3v2_version", "3")
 		} else if format == "aac" {
-			argsNoCover = append(argsNoCover, "-b:a", "192k")
+			argsNoCover = append(argsNoCover, "-b:a", cfg.AACBitrate)
 		}
+		// Drop any video stream so a rejected cover can't fail the retry too
+		argsNoCover = append(argsNoCover, "-map", "0:a")
 
 		argsNoCover = append(argsNoCover,
 			"-metadata", "title="+song.Title,
 			"-metadata", "artist="+song.Artist,
 			"-metadata", "album="+song.Album,
-			"-y", tmpOutputPath,
+			"-metadata", "TIDAL_ID="+song.ID,
 		)
+		if rg != nil {
+			argsNoCover = append(argsNoCover, rg.metadataArgs()...)
+		}
+		if ffmpegFormat != "" {
+			argsNoCover = append(argsNoCover, "-f", ffmpegFormat)
+		}
+		argsNoCover = append(argsNoCover, "-y", tmpOutputPath)
 
-		slog.Debug("Fallback FFmpeg command", "args", strings.Join(argsNoCover, " "))
+		logging.FromContext(ctx).Debug("Fallback FFmpeg command", "args", strings.Join(argsNoCover, " "))
 		cmdFallback := exec.CommandContext(ctx, "ffmpeg", argsNoCover...)
 		if fallbackOutput, fallbackErr := cmdFallback.CombinedOutput(); fallbackErr != nil {
-			slog.Error("Fallback FFmpeg failed", "error", fallbackErr, "output", string(fallbackOutput))
-			os.Remove(tmpOutputPath)
-			return fmt.Errorf("ffmpeg failed: %v", fallbackErr)
+			logging.FromContext(ctx).Error("Fallback FFmpeg failed", "error", fallbackErr, "output", string(fallbackOutput))
+			return fmt.Errorf("%w: ffmpeg: %v", ErrTranscode, fallbackErr)
 		}
 	}
 
-	if err := os.Rename(tmpOutputPath, outputPath); err != nil {
-		slog.Error("Failed to move temp file", "from", tmpOutputPath, "to", outputPath, "error", err)
+	if err := moveFile(tmpOutputPath, outputPath); err != nil {
+		logging.FromContext(ctx).Error("Failed to move temp file", "from", tmpOutputPath, "to", outputPath, "error", err)
 		return err
 	}
 
 	if info, err := os.Stat(outputPath); err == nil {
-		slog.Info("Successfully synced", "path", outputPath, "sizeMB", float64(info.Size())/1024/1024)
-		// Perform immediate integrity check
-		if err := s.VerifyIntegrity(outputPath); err != nil {
-			slog.Error("File integrity check failed after sync, removing", "path", outputPath, "error", err)
-			os.Remove(outputPath)
-			return err
+		logging.FromContext(ctx).Info("Successfully synced", "path", outputPath, "sizeMB", float64(info.Size())/1024/1024)
+		// Perform immediate integrity check, unless VERIFY_ON_SYNC is off
+		if cfg.VerifyOnSync {
+			err := s.verifyFormatAs(ctx, cfg, outputPath, format)
+			if errors.Is(err, context.DeadlineExceeded) {
+				// Not judged; keep the file and let a later sync or scan check it again
+				logging.FromContext(ctx).Warn("File integrity check timed out after sync, keeping file", "path", outputPath, "error", err)
+			} else if err != nil {
+				logging.FromContext(ctx).Error("File integrity check failed after sync, removing", "path", outputPath, "error", err)
+				os.Remove(outputPath)
+				return err
+			}
 		}
 		// Save metadata sidecar
-		s.saveMetadata(song, outputPath)
+		s.saveMetadata(ctx, outputPath, songSidecar{Song: song, MusicBrainz: mbIDs, ReplayGain: rg, Original: original})
 	}
 
 	return nil
@@ -271,7 +646,13 @@ func (s *SyncService) downloadCoverToTemp(ctx context.Context, coverID string) (
 		return "", nil, err
 	}
 
-	tmpFile, err := os.CreateTemp("", "cover-*.jpg")
+	tmpDir := s.cfg.Get().TempDir
+	if tmpDir != "" {
+		if err := os.MkdirAll(tmpDir, 0755); err != nil {
+			return "", nil, err
+		}
+	}
+	tmpFile, err := os.CreateTemp(tmpDir, "cover-*.jpg")
 	if err != nil {
 		return "", nil, err
 	}
@@ -287,7 +668,7 @@ func (s *SyncService) downloadCoverToTemp(ctx context.Context, coverID string) (
 		os.Remove(tmpFile.Name())
 	}
 
-	slog.Debug("Downloaded cover art to temp file", "path", tmpFile.Name(), "size", len(coverData))
+	logging.FromContext(ctx).Debug("Downloaded cover art to temp file", "path", tmpFile.Name(), "size", len(coverData))
 	return tmpFile.Name(), cleanup, nil
 }
 
@@ -297,7 +678,7 @@ func (s *SyncService) downloadArt(ctx context.Context, coverID string) ([]byte,
 	if strings.HasPrefix(coverID, "http") {
 		url = coverID
 	} else {
-		url, err = s.squid.GetCoverURL(ctx, coverID)
+		url, err = s.providers.GetCoverURL(ctx, coverID, DefaultCoverSize)
 		if err != nil {
 			return nil, err
 		}
@@ -307,7 +688,7 @@ func (s *SyncService) downloadArt(ctx context.Context, coverID string) ([]byte,
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("User-Agent", s.squid.NextUserAgent())
 	req.Header.Set("Accept", "image/*,*/*")
 
 	client := &http.Client{Timeout: 30 * time.Second}
@@ -324,6 +705,139 @@ func (s *SyncService) downloadArt(ctx context.Context, coverID string) ([]byte,
 	return io.ReadAll(resp.Body)
 }
 
+// transcodeTempPath picks where ffmpeg writes before the result is moved to outputPath: a
+// unique file in TEMP_DIR when set, otherwise outputPath.tmp
+func (s *SyncService) transcodeTempPath(outputPath string) (string, error) {
+	if s.cfg.Get().TempDir == "" {
+		return outputPath + ".tmp", nil
+	}
+	if err := os.MkdirAll(s.cfg.Get().TempDir, 0755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(s.cfg.Get().TempDir, "transcode-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return f.Name(), nil
+}
+
+// moveFile renames src to dst, falling back to copy-and-delete when they are on different
+// filesystems (TEMP_DIR on another volume). The copy goes through dst.tmp so dst never
+// appears half-written.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	part := dst + ".tmp"
+	out, err := os.Create(part)
+	if err != nil {
+		return err
+	}
+	_, copyErr := io.Copy(out, in)
+	closeErr := out.Close()
+	if err := errors.Join(copyErr, closeErr); err != nil {
+		os.Remove(part)
+		return err
+	}
+	if err := os.Rename(part, dst); err != nil {
+		os.Remove(part)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// albumArtist returns the album-level artist to tag a song with. Songs resolved on their own
+// (e.g. when streamed) don't carry it, so it is looked up from the song's album; the track
+// artist is only used when that fails, since per-track values split compilations apart.
+func (s *SyncService) albumArtist(ctx context.Context, song *subsonic.Song) string {
+	if song.AlbumArtist != "" {
+		return song.AlbumArtist
+	}
+	if song.AlbumID != "" {
+		album, _, err := s.providers.GetAlbum(ctx, song.AlbumID)
+		if err == nil && album.Artist != "" {
+			return album.Artist
+		}
+		if err != nil {
+			logging.FromContext(ctx).Debug("Album artist lookup failed, tagging with track artist", "songID", song.ID, "error", err)
+		}
+	}
+	return song.Artist
+}
+
+// libraryPath returns the root directory synced songs are written to
+func (s *SyncService) libraryPath() string {
+	if s.cfg.Get().JetStreamLibraryPath != "" {
+		return s.cfg.Get().JetStreamLibraryPath
+	}
+	return "/music/jetstream"
+}
+
+// LocalPath returns where SyncSong stores a song. Without SYNC_PATH_TEMPLATE the layout is
+// {Library}/{Artist}/{Album}/{Track} - [{ID}] {Title}.{ext}
+func (s *SyncService) LocalPath(song *subsonic.Song) string {
+	return s.localPath(s.cfg.Get(), song)
+}
+
+// localPath is LocalPath under cfg's DOWNLOAD_FORMAT and SYNC_PATH_TEMPLATE
+func (s *SyncService) localPath(cfg *config.Config, song *subsonic.Song) string {
+	ext := downloadFormat(cfg)
+	if cfg.SyncPathTemplate != nil {
+		if rel, err := s.templatePath(cfg, song); err == nil {
+			return filepath.Join(s.libraryPath(), rel+"."+ext)
+		} else {
+			slog.Warn("SYNC_PATH_TEMPLATE failed, using default layout", "songID", song.ID, "error", err)
+		}
+	}
+
+	fileName := fmt.Sprintf("%02d - [%s] %s.%s", song.Track, song.ID, s.SanitizePath(song.Title), ext)
+	return filepath.Join(s.libraryPath(), s.SanitizePath(song.Artist), s.SanitizePath(song.Album), fileName)
+}
+
+// templatePath renders SYNC_PATH_TEMPLATE for a song. Values are sanitized before rendering so
+// a "/" inside a title can't add directories, then each rendered segment is sanitized again.
+func (s *SyncService) templatePath(cfg *config.Config, song *subsonic.Song) (string, error) {
+	disc := song.DiscNumber
+	if disc == 0 {
+		disc = 1
+	}
+	fields := config.SyncPathFields{
+		Artist: s.SanitizePath(song.Artist),
+		Album:  s.SanitizePath(song.Album),
+		Title:  s.SanitizePath(song.Title),
+		ID:     s.SanitizePath(song.ID),
+		Track:  song.Track,
+		Disc:   disc,
+		Year:   song.Year,
+	}
+
+	var sb strings.Builder
+	if err := cfg.SyncPathTemplate.Execute(&sb, fields); err != nil {
+		return "", err
+	}
+
+	var segments []string
+	for _, seg := range strings.Split(filepath.ToSlash(sb.String()), "/") {
+		seg = s.SanitizePath(seg)
+		if seg == "" || seg == "." || seg == ".." {
+			continue
+		}
+		segments = append(segments, seg)
+	}
+	if len(segments) == 0 {
+		return "", fmt.Errorf("template rendered an empty path")
+	}
+	return filepath.Join(segments...), nil
+}
+
 func (s *SyncService) SanitizePath(p string) string {
 	p = strings.ReplaceAll(p, "/", "_")
 	p = strings.ReplaceAll(p, "\\", "_")
@@ -337,18 +851,87 @@ func (s *SyncService) SanitizePath(p string) string {
 	return strings.TrimSpace(p)
 }
 
+// GetDownloadFormat returns the configured sync format, which doubles as the file extension
 func (s *SyncService) GetDownloadFormat() string {
-	f := os.Getenv("DOWNLOAD_FORMAT")
+	return downloadFormat(s.cfg.Get())
+}
+
+// downloadFormat is the sync format cfg selects
+func downloadFormat(cfg *config.Config) string {
+	f := strings.ToLower(strings.TrimSpace(cfg.DownloadFormat))
 	if f == "" {
 		return "opus"
 	}
 	return f
 }
 
-// VerifyIntegrity checks if an audio file is valid using ffprobe and ffmpeg demuxing
-func (s *SyncService) VerifyIntegrity(path string) error {
-	// Root context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+// IsGhostFile reports whether path is missing or a placeholder rather than real audio. Every
+// "is this a real file" check goes through here. Files of at least GHOST_SIZE_THRESHOLD are
+// always real. With GHOST_DETECTION=duration, smaller ones are real if they hold at least
+// GHOST_MIN_BITRATE worth of audio for their duration, so short tracks aren't mistaken for
+// placeholders; with GHOST_DETECTION=size they are all ghosts.
+func (s *SyncService) IsGhostFile(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return true
+	}
+	cfg := s.cfg.Get()
+	if info.Size() >= cfg.GhostSizeThreshold {
+		return false
+	}
+	if cfg.GhostDetection != "duration" {
+		return true
+	}
+
+	duration := audioDuration(path)
+	if duration <= 1 {
+		return true // Tags and cover art only
+	}
+	return info.Size() < int64(duration*float64(cfg.GhostMinBitRate)*1000/8)
+}
+
+// audioDuration returns the length in seconds of the audio in path, preferring the synced
+// sidecar over running ffprobe. 0 means unknown or no audio.
+func audioDuration(path string) float64 {
+	if sidecar, err := readSidecar(path); err == nil && sidecar.Duration > 0 {
+		return float64(sidecar.Duration)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	).Output()
+	if err != nil {
+		return 0
+	}
+	duration, _ := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	return duration
+}
+
+// VerifyIntegrity checks if an audio file is valid using ffprobe and ffmpeg demuxing. Files
+// unchanged since they last passed are accepted without running either.
+func (s *SyncService) VerifyIntegrity(ctx context.Context, path string) error {
+	if s.isVerified(ctx, path, "") {
+		return nil
+	}
+	if err := s.checkIntegrity(ctx, path); err != nil {
+		s.forgetVerified(ctx, path)
+		return err
+	}
+	s.markVerified(ctx, path, "")
+	return nil
+}
+
+// checkIntegrity is VerifyIntegrity without the verified marker. A check that runs out of time
+// returns an error wrapping context.DeadlineExceeded: the file wasn't judged, so callers must
+// not treat it as corrupt.
+func (s *SyncService) checkIntegrity(ctx context.Context, path string) error {
+	// Bound the check even if the caller's ctx has no deadline
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// 1. Basic size check
@@ -356,7 +939,7 @@ func (s *SyncService) VerifyIntegrity(path string) error {
 	if err != nil {
 		return err
 	}
-	if info.Size() < 128*1024 { // 128KB minimum for any audio file
+	if s.IsGhostFile(path) {
 		return fmt.Errorf("file is too small (%d bytes)", info.Size())
 	}
 
@@ -371,6 +954,9 @@ func (s *SyncService) VerifyIntegrity(path string) error {
 	cmdProbe := exec.CommandContext(ctx, "ffprobe", argsProbe...)
 	outputProbe, err := cmdProbe.CombinedOutput()
 	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("ffprobe: %w", ctx.Err())
+		}
 		return fmt.Errorf("ffprobe failed: %v (output: %s)", err, string(outputProbe))
 	}
 
@@ -401,22 +987,91 @@ func (s *SyncService) VerifyIntegrity(path string) error {
 	cmdMux := exec.CommandContext(ctx, "ffmpeg", argsMux...)
 	outputMux, err := cmdMux.CombinedOutput()
 	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("stream integrity check (demux): %w", ctx.Err())
+		}
 		return fmt.Errorf("stream integrity check (demux) failed: %v (output: %s)", err, string(outputMux))
 	}
 
 	return nil
 }
 
-// MaintenanceScan crawls the music folder and verifies all files
-func (s *SyncService) MaintenanceScan(ctx context.Context) (int, int, error) {
-	root := "/music/jetstream"
-	var total, corrupt int
+// CleanupTempFiles removes leftover transcode (.tmp) and download (.part) files under the
+// library, and JetStream's own scratch files in TEMP_DIR, that are older than maxAge, e.g.
+// from a process killed mid-sync. Younger files may belong to a sync still in progress and
+// are left alone. It returns how many were removed.
+func (s *SyncService) CleanupTempFiles(ctx context.Context, maxAge time.Duration) (int, error) {
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	sweep := func(root string, isTemp func(path string) bool) error {
+		return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil // Skip errors
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if info.IsDir() || !info.ModTime().Before(cutoff) || !isTemp(path) {
+				return nil
+			}
+			if err := os.Remove(path); err != nil {
+				logging.FromContext(ctx).Warn("Failed to remove stale temp file", "path", path, "error", err)
+				return nil
+			}
+			logging.FromContext(ctx).Debug("Removed stale temp file", "path", path, "age", time.Since(info.ModTime()).Round(time.Second))
+			removed++
+			return nil
+		})
+	}
+
+	err := sweep(s.libraryPath(), func(path string) bool {
+		ext := filepath.Ext(path)
+		return ext == ".tmp" || ext == ".part"
+	})
+	if err == nil && s.cfg.Get().TempDir != "" {
+		// Only the scratch files JetStream names itself; TEMP_DIR may be shared
+		err = sweep(s.cfg.Get().TempDir, func(path string) bool {
+			name := filepath.Base(path)
+			return strings.HasPrefix(name, "cover-") || strings.HasPrefix(name, "transcode-") || strings.HasPrefix(name, "source-")
+		})
+	}
+	return removed, err
+}
+
+// ScanResult summarizes a maintenance scan
+type ScanResult struct {
+	Total            int
+	Corrupt          int      // Unreadable or truncated files
+	WrongFormat      int      // Readable files whose codec/bitrate doesn't match their extension
+	WrongFormatFiles []string // Paths of the wrong-format files, which are never deleted
+	WouldDelete      []string // Corrupt files left in place because of a dry run
+}
 
+// MaintenanceScan crawls the music folder and verifies all files with VerifyFormat, using each
+// file's extension as the expected format. Corrupt files are deleted unless dryRun is set, in
+// which case they are only reported. Wrong-format files are playable (e.g. synced under an
+// earlier OPUS_BITRATE) and are only ever reported.
+func (s *SyncService) MaintenanceScan(ctx context.Context, dryRun bool) (ScanResult, error) {
+	root := s.libraryPath()
+	if dryRun {
+		logging.FromContext(ctx).Info("Starting maintenance scan in DRY-RUN mode: corrupt files will be reported, not deleted", "root", root)
+	} else {
+		logging.FromContext(ctx).Warn("Starting maintenance scan in DESTRUCTIVE mode: corrupt files will be deleted", "root", root)
+	}
+
+	// 1. Collect candidates; verification happens afterwards in parallel
+	var paths []string
 	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil // Skip errors
 		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if info.IsDir() {
+			if info.Name() == streamCacheDir || info.Name() == coverCacheDir {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
@@ -426,50 +1081,271 @@ func (s *SyncService) MaintenanceScan(ctx context.Context) (int, int, error) {
 			return nil
 		}
 
-		total++
-		if err := s.VerifyIntegrity(path); err != nil {
-			corrupt++
-			slog.Warn("Found corrupt file, deleting", "path", path, "error", err)
-			os.Remove(path)
-			os.Remove(path + ".json")
-		} else {
-			// If file is good, check if we can index its metadata
-			jsonPath := path + ".json"
-			if data, err := os.ReadFile(jsonPath); err == nil {
-				var song subsonic.Song
-				if err := json.Unmarshal(data, &song); err == nil {
-					// Index ID to Path in Redis
-					s.redis.Set(ctx, "path:"+song.ID, path, 90*24*time.Hour)
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return ScanResult{}, err
+	}
+
+	// 2. Verify with a bounded worker pool
+	workers := s.cfg.Get().ScanConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var total, corrupt, wrongFormat int64
+	var mu sync.Mutex
+	var wouldDelete, wrongFormatFiles []string
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer safego.Recover()
+			for path := range jobs {
+				atomic.AddInt64(&total, 1)
+				if err := s.verifyScanned(ctx, path, dryRun); err != nil {
+					if errors.Is(err, ErrWrongFormat) {
+						atomic.AddInt64(&wrongFormat, 1)
+						mu.Lock()
+						wrongFormatFiles = append(wrongFormatFiles, path)
+						mu.Unlock()
+					} else {
+						atomic.AddInt64(&corrupt, 1)
+						if dryRun {
+							mu.Lock()
+							wouldDelete = append(wouldDelete, path)
+							mu.Unlock()
+						}
+					}
 				}
 			}
-		}
+		}()
+	}
 
-		// Check context
+feed:
+	for _, path := range paths {
 		select {
+		case jobs <- path:
 		case <-ctx.Done():
-			return ctx.Err()
-		default:
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Strings(wouldDelete)
+	sort.Strings(wrongFormatFiles)
+	return ScanResult{
+		Total:            int(total),
+		Corrupt:          int(corrupt),
+		WrongFormat:      int(wrongFormat),
+		WrongFormatFiles: wrongFormatFiles,
+		WouldDelete:      wouldDelete,
+	}, ctx.Err()
+}
+
+// verifyScanned checks a single file, deleting it if corrupt (unless dryRun) and indexing it
+// otherwise. Wrong-format files are reported but kept. It returns the VerifyFormat error for
+// bad files and nil for good ones.
+func (s *SyncService) verifyScanned(ctx context.Context, path string, dryRun bool) error {
+	if err := s.VerifyFormat(ctx, path, formatForPath(path)); err != nil {
+		if ctx.Err() != nil {
+			return nil // Scan aborted mid-check; the file wasn't actually judged
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			// The check timed out, e.g. on a huge file or a slow disk; not judged either
+			logging.FromContext(ctx).Warn("Could not verify file in time, skipping", "path", path, "error", err)
+			return nil
+		}
+		if errors.Is(err, ErrWrongFormat) {
+			logging.FromContext(ctx).Warn("Found wrong-format file, keeping it", "path", path, "error", err)
+			return err
+		}
+		if dryRun {
+			logging.FromContext(ctx).Warn("Found bad file, would delete", "path", path, "error", err)
+			return err
 		}
+		logging.FromContext(ctx).Warn("Found bad file, deleting", "path", path, "error", err)
+		os.Remove(path)
+		os.Remove(path + ".json")
+		return err
+	}
+
+	// If file is good, check if we can index its metadata
+	jsonPath := path + ".json"
+	if data, err := os.ReadFile(jsonPath); err == nil {
+		var song subsonic.Song
+		if err := json.Unmarshal(data, &song); err == nil {
+			// Index ID to Path in the cache
+			s.cache.Set(ctx, "path:"+song.ID, path, 90*24*time.Hour)
+			s.recordSyncedArtist(ctx, &song)
+		}
+	}
+	return nil
+}
 
+// lookupMusicBrainz returns the MusicBrainz IDs for a song, or nil when enrichment is off or nothing matched
+func (s *SyncService) lookupMusicBrainz(ctx context.Context, song *subsonic.Song) *metadata.MusicBrainzIDs {
+	if !s.mb.Enabled() {
 		return nil
-	})
+	}
+	ids, err := s.mb.LookupRecording(ctx, song.Artist, song.Title, song.Album)
+	if err != nil {
+		logging.FromContext(ctx).Warn("MusicBrainz lookup failed", "songID", song.ID, "error", err)
+		return nil
+	}
+	return ids
+}
 
-	return total, corrupt, err
+// songSidecar is the JSON written next to each synced file
+type songSidecar struct {
+	*subsonic.Song
+	MusicBrainz *metadata.MusicBrainzIDs `json:"musicBrainz,omitempty"`
+	ReplayGain  *ReplayGain              `json:"replayGain,omitempty"`
+	Original    string                   `json:"original,omitempty"` // Untouched source kept by KEEP_ORIGINAL
 }
 
-func (s *SyncService) saveMetadata(song *subsonic.Song, mediaPath string) {
+// readSidecar loads the sidecar written next to a synced file
+func readSidecar(mediaPath string) (*songSidecar, error) {
+	data, err := os.ReadFile(mediaPath + ".json")
+	if err != nil {
+		return nil, err
+	}
+	sidecar := &songSidecar{Song: &subsonic.Song{}}
+	if err := json.Unmarshal(data, sidecar); err != nil {
+		return nil, err
+	}
+	return sidecar, nil
+}
+
+func (s *SyncService) saveMetadata(ctx context.Context, mediaPath string, sidecar songSidecar) {
+	song := sidecar.Song
 	jsonPath := mediaPath + ".json"
-	data, err := json.MarshalIndent(song, "", "  ")
+	data, err := json.MarshalIndent(sidecar, "", "  ")
 	if err != nil {
-		slog.Error("Failed to marshal generic song metadata", "error", err)
+		logging.FromContext(ctx).Error("Failed to marshal generic song metadata", "error", err)
 		return
 	}
 	if err := os.WriteFile(jsonPath, data, 0644); err != nil {
-		slog.Error("Failed to save metadata sidecar", "path", jsonPath, "error", err)
+		logging.FromContext(ctx).Error("Failed to save metadata sidecar", "path", jsonPath, "error", err)
 	} else {
-		slog.Debug("Saved metadata sidecar", "path", jsonPath)
+		logging.FromContext(ctx).Debug("Saved metadata sidecar", "path", jsonPath)
+	}
+
+	// Also index this ID to this path in the cache for fast lookup (long-lived)
+	s.cache.Set(ctx, "path:"+song.ID, mediaPath, 90*24*time.Hour)
+	s.recordSyncedArtist(ctx, song)
+}
+
+// EnrichSong fills fields Squid leaves empty (bitrate, size, genre, BPM, ...) from the synced
+// copy of the song and its sidecar. Fields already set on song win. It is a no-op unless
+// ENRICH_SONGS is on or when the song has never been synced; the return reports whether
+// anything was merged.
+func (s *SyncService) EnrichSong(ctx context.Context, song *subsonic.Song) bool {
+	if !s.cfg.Get().EnrichSongs || song == nil {
+		return false
 	}
 
-	// Also index this ID to this path in Redis for fast lookup (long-lived)
-	s.redis.Set(context.Background(), "path:"+song.ID, mediaPath, 90*24*time.Hour)
+	mediaPath, err := s.cache.Get(ctx, "path:"+song.ID)
+	if err != nil {
+		mediaPath = s.LocalPath(song)
+	}
+	info, err := os.Stat(mediaPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	local := subsonic.Song{}
+	if data, err := os.ReadFile(mediaPath + ".json"); err == nil {
+		sidecar := songSidecar{Song: &local}
+		if err := json.Unmarshal(data, &sidecar); err != nil {
+			logging.FromContext(ctx).Debug("Ignoring unreadable metadata sidecar", "path", mediaPath+".json", "error", err)
+		}
+		if sidecar.MusicBrainz != nil {
+			local.MusicBrainzID = sidecar.MusicBrainz.TrackID
+		}
+	}
+
+	ext := strings.TrimPrefix(filepath.Ext(mediaPath), ".")
+	local.Suffix = ext
+	local.ContentType = AudioContentType(ext)
+	local.Size = info.Size()
+	duration := local.Duration
+	if duration == 0 {
+		duration = song.Duration
+	}
+	if duration > 0 {
+		// Average bitrate of the file on disk, in kbps
+		local.BitRate = int(info.Size() * 8 / int64(duration) / 1000)
+	}
+
+	// Once synced, the track is served from disk, so describe that file rather than the upstream stream
+	song.Suffix = ""
+	song.ContentType = ""
+	song.SamplingRate = 0 // Transcoding resamples; the upstream figures no longer apply
+	song.BitDepth = 0
+	mergeSong(song, &local)
+	return true
+}
+
+// mergeSong copies fields that are unset on dst from src
+func mergeSong(dst, src *subsonic.Song) {
+	if dst.Genre == "" {
+		dst.Genre = src.Genre
+	}
+	if dst.Year == 0 {
+		dst.Year = src.Year
+	}
+	if dst.BPM == 0 {
+		dst.BPM = src.BPM
+	}
+	if dst.BitRate == 0 {
+		dst.BitRate = src.BitRate
+	}
+	if dst.Size == 0 {
+		dst.Size = src.Size
+	}
+	if dst.Suffix == "" {
+		dst.Suffix = src.Suffix
+	}
+	if dst.ContentType == "" {
+		dst.ContentType = src.ContentType
+	}
+	if dst.Track == 0 {
+		dst.Track = src.Track
+	}
+	if dst.DiscNumber == 0 {
+		dst.DiscNumber = src.DiscNumber
+	}
+	if dst.Duration == 0 {
+		dst.Duration = src.Duration
+	}
+	if dst.MusicBrainzID == "" {
+		dst.MusicBrainzID = src.MusicBrainzID
+	}
+	if dst.Comment == "" {
+		dst.Comment = src.Comment
+	}
+}
+
+// AudioContentType maps a synced file extension to its MIME type
+func AudioContentType(ext string) string {
+	switch ext {
+	case "opus":
+		return "audio/ogg"
+	case "mp3":
+		return "audio/mpeg"
+	case "aac":
+		return "audio/aac"
+	case "m4a":
+		return "audio/mp4"
+	case "flac":
+		return "audio/flac"
+	default:
+		return "application/octet-stream"
+	}
 }
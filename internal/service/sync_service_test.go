@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jetstream/internal/config"
+	"jetstream/pkg/subsonic"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+const testGhostThreshold = 256 * 1024
+
+func newGhostTestService(detection string) *SyncService {
+	return &SyncService{cfg: config.NewLive(&config.Config{
+		GhostSizeThreshold: testGhostThreshold,
+		GhostDetection:     detection,
+		GhostMinBitRate:    16,
+	})}
+}
+
+// writeSized creates a file of size bytes in dir, with a sidecar giving its duration if set
+func writeSized(t *testing.T, dir, name string, size int64, durationSec int) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if durationSec > 0 {
+		sidecar := []byte(`{"duration":` + strconv.Itoa(durationSec) + `}`)
+		if err := os.WriteFile(path+".json", sidecar, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestIsGhostFileThreshold(t *testing.T) {
+	s := newGhostTestService("size")
+	dir := t.TempDir()
+
+	tests := []struct {
+		name  string
+		size  int64
+		ghost bool
+	}{
+		{"below", testGhostThreshold - 1, true},
+		{"at", testGhostThreshold, false},
+		{"above", testGhostThreshold + 1, false},
+	}
+	for _, tt := range tests {
+		path := writeSized(t, dir, tt.name+".mp3", tt.size, 0)
+		if got := s.IsGhostFile(path); got != tt.ghost {
+			t.Errorf("IsGhostFile(%d bytes) = %v, want %v", tt.size, got, tt.ghost)
+		}
+	}
+}
+
+func TestIsGhostFileMissing(t *testing.T) {
+	s := newGhostTestService("size")
+	if !s.IsGhostFile(filepath.Join(t.TempDir(), "missing.mp3")) {
+		t.Error("IsGhostFile of a missing file = false, want true")
+	}
+}
+
+// Under GHOST_DETECTION=duration a short track below the size threshold is real when it
+// averages GHOST_MIN_BITRATE for its length (here 9s at 16 kbps = 18000 bytes)
+func TestIsGhostFileDuration(t *testing.T) {
+	s := newGhostTestService("duration")
+	dir := t.TempDir()
+
+	tests := []struct {
+		name  string
+		size  int64
+		ghost bool
+	}{
+		{"below", 18000 - 1, true},
+		{"at", 18000, false},
+		{"above", 18000 + 1, false},
+	}
+	for _, tt := range tests {
+		path := writeSized(t, dir, tt.name+".mp3", tt.size, 9)
+		if got := s.IsGhostFile(path); got != tt.ghost {
+			t.Errorf("IsGhostFile(%d bytes, 9s) = %v, want %v", tt.size, got, tt.ghost)
+		}
+	}
+}
+
+// Tracks of a compilation each have their own artist, but are all tagged with the album's
+// artist so Navidrome keeps them in one album
+func TestAlbumArtistOfCompilation(t *testing.T) {
+	trackArtists := map[string]string{"1": "Artist A", "2": "Artist B"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		switch r.URL.Path {
+		case "/album/":
+			w.Write([]byte(`{"data":{"id":4,"title":"Hits","numberOfTracks":2,
+				"artist":{"id":9,"name":"Various Artists"},
+				"items":[{"item":{"id":1,"title":"One","trackNumber":1}},{"item":{"id":2,"title":"Two","trackNumber":2}}]}}`))
+		case "/info/":
+			fmt.Fprintf(w, `{"data":{"id":%s,"title":"Track %s","artist":{"id":%s,"name":%q},"album":{"id":4,"title":"Hits"}}}`,
+				id, id, id, trackArtists[id])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{SquidURLs: []string{srv.URL}, CacheBackend: "memory", CacheEntries: 10, SquidMaxPasses: 1}
+	live := config.NewLive(cfg)
+	squid := NewSquidService(live)
+	s := &SyncService{squid: squid, providers: NewProviders(cfg, squid), cfg: live}
+	ctx := context.Background()
+
+	_, albumSongs, err := squid.GetAlbum(ctx, "ext-squidwtf-album-4")
+	if err != nil {
+		t.Fatalf("GetAlbum: %v", err)
+	}
+	for _, song := range albumSongs {
+		if got := s.albumArtist(ctx, &song); got != "Various Artists" {
+			t.Errorf("album track %s: album_artist = %q, want Various Artists", song.ID, got)
+		}
+	}
+
+	// Songs resolved on their own, as when streamed, carry only their track artist
+	for id, artist := range trackArtists {
+		song, err := squid.GetSong(ctx, "ext-squidwtf-song-"+id)
+		if err != nil {
+			t.Fatalf("GetSong(%s): %v", id, err)
+		}
+		if song.Artist != artist {
+			t.Fatalf("GetSong(%s) artist = %q, want %q", id, song.Artist, artist)
+		}
+		if got := s.albumArtist(ctx, song); got != "Various Artists" {
+			t.Errorf("song %s by %s: album_artist = %q, want Various Artists", id, artist, got)
+		}
+	}
+}
+
+// A song refused for a full library must not leave its stream-cached source behind, since
+// that source isn't counted against LIBRARY_MAX_BYTES and nothing else would delete it
+func TestSyncSongDropsCachedSourceWhenLibraryFull(t *testing.T) {
+	live := config.NewLive(&config.Config{
+		JetStreamLibraryPath: t.TempDir(),
+		LibraryMaxBytes:      1,
+		DownloadFormat:       "flac",
+		CacheBackend:         "memory",
+		CacheEntries:         10,
+	})
+	squid := NewSquidService(live)
+	s := NewSyncService(squid, NewProviders(live.Get(), squid), live)
+
+	song := &subsonic.Song{ID: "ext-squidwtf-song-1", Title: "Song", Artist: "Artist", Album: "Album", Duration: 200}
+	cachePath := s.streamCachePath(song.ID, s.SyncQuality()) + ".flac"
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cachePath, make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SyncSong(context.Background(), song); !errors.Is(err, ErrLibraryFull) {
+		t.Fatalf("SyncSong err = %v, want ErrLibraryFull", err)
+	}
+	if _, err := os.Stat(cachePath); !os.IsNotExist(err) {
+		t.Errorf("cached source still on disk after the sync was refused (stat err = %v)", err)
+	}
+}
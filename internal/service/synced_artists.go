@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"jetstream/internal/cache"
+	"jetstream/pkg/subsonic"
+	"sort"
+)
+
+// syncedArtistsKey holds a JSON object of external artist ID to name for every artist with a
+// synced song, so listings can show them before Navidrome rescans. The library is the source
+// of truth: when the entry is missing (purged, or evicted by the memory backend) it is
+// rebuilt from the sidecars.
+const syncedArtistsKey = CachePrefix + "synced-artists"
+
+// loadSyncedArtists returns the synced artist index, rebuilding it if it isn't cached. The
+// caller holds syncedArtistsMu.
+func (s *SyncService) loadSyncedArtists(ctx context.Context) (map[string]string, error) {
+	val, err := s.cache.Get(ctx, syncedArtistsKey)
+	if err == nil {
+		artists := make(map[string]string)
+		if err := json.Unmarshal([]byte(val), &artists); err == nil {
+			return artists, nil
+		}
+	} else if !errors.Is(err, cache.ErrMiss) {
+		return nil, err
+	}
+
+	artists := make(map[string]string)
+	if _, err := s.walkSynced(ctx, func(f syncedFile) {
+		if isSyncedArtist(f.sidecar.Song) {
+			artists[f.sidecar.ArtistID] = f.sidecar.Artist
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return artists, s.saveSyncedArtists(ctx, artists)
+}
+
+func (s *SyncService) saveSyncedArtists(ctx context.Context, artists map[string]string) error {
+	data, err := json.Marshal(artists)
+	if err != nil {
+		return err
+	}
+	return s.cache.Set(ctx, syncedArtistsKey, string(data), 0)
+}
+
+// isSyncedArtist reports whether song names an external artist the index should list
+func isSyncedArtist(song *subsonic.Song) bool {
+	if song.ArtistID == "" || song.Artist == "" {
+		return false
+	}
+	isExternal, _, _, _ := subsonic.ParseID(song.ArtistID)
+	return isExternal
+}
+
+// recordSyncedArtist adds the artist of a synced song to the synced artist index
+func (s *SyncService) recordSyncedArtist(ctx context.Context, song *subsonic.Song) {
+	if !isSyncedArtist(song) {
+		return
+	}
+	s.syncedArtistsMu.Lock()
+	defer s.syncedArtistsMu.Unlock()
+
+	artists, err := s.loadSyncedArtists(ctx)
+	if err != nil || artists[song.ArtistID] == song.Artist {
+		return
+	}
+	artists[song.ArtistID] = song.Artist
+	s.saveSyncedArtists(ctx, artists)
+}
+
+// forgetSyncedArtists drops artists that no longer have a synced song from the index
+func (s *SyncService) forgetSyncedArtists(ctx context.Context, artistIDs []string) {
+	if len(artistIDs) == 0 {
+		return
+	}
+	s.syncedArtistsMu.Lock()
+	defer s.syncedArtistsMu.Unlock()
+
+	artists, err := s.loadSyncedArtists(ctx)
+	if err != nil {
+		return
+	}
+	for _, id := range artistIDs {
+		delete(artists, id)
+	}
+	s.saveSyncedArtists(ctx, artists)
+}
+
+// SyncedArtists returns the external artists that have at least one synced song, by name
+func (s *SyncService) SyncedArtists(ctx context.Context) ([]subsonic.Artist, error) {
+	s.syncedArtistsMu.Lock()
+	entries, err := s.loadSyncedArtists(ctx)
+	s.syncedArtistsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	artists := make([]subsonic.Artist, 0, len(entries))
+	for id, name := range entries {
+		artists = append(artists, subsonic.Artist{ID: id, Name: name})
+	}
+	sort.Slice(artists, func(i, j int) bool { return artists[i].Name < artists[j].Name })
+	return artists, nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"io"
+	"jetstream/internal/logging"
+	"strconv"
+	"strings"
+)
+
+// streamCodec is how TranscodeStream encodes one Subsonic stream format
+type streamCodec struct {
+	codec       string // ffmpeg encoder
+	muxer       string // ffmpeg muxer that can write to a pipe
+	contentType string
+}
+
+// streamCodecs maps the Subsonic format parameter to an encoder. "ogg" is an alias for opus.
+var streamCodecs = map[string]streamCodec{
+	"mp3":  {"libmp3lame", "mp3", "audio/mpeg"},
+	"opus": {"libopus", "ogg", "audio/ogg"},
+	"ogg":  {"libopus", "ogg", "audio/ogg"},
+	"aac":  {"aac", "adts", "audio/aac"},
+}
+
+// TranscodeFormat normalizes a Subsonic format parameter to one TranscodeStream can produce,
+// defaulting to mp3 as most players can decode it
+func TranscodeFormat(format string) string {
+	format = strings.ToLower(format)
+	if _, ok := streamCodecs[format]; ok {
+		return format
+	}
+	return "mp3"
+}
+
+// TranscodeContentType is the Content-Type TranscodeStream writes for format
+func TranscodeContentType(format string) string {
+	return streamCodecs[TranscodeFormat(format)].contentType
+}
+
+// TranscodeStream re-encodes the audio of input (a URL or local path) to format at kbps and
+// writes it to w as it goes, starting offset seconds in
+func TranscodeStream(ctx context.Context, input string, offset int, format string, kbps int, w io.Writer) error {
+	sc := streamCodecs[TranscodeFormat(format)]
+	args := []string{"-hide_banner", "-loglevel", "error"}
+	if offset > 0 {
+		args = append(args, "-ss", strconv.Itoa(offset))
+	}
+	args = append(args, "-i", input, "-map", "0:a", "-c:a", sc.codec, "-b:a", strconv.Itoa(kbps)+"k", "-f", sc.muxer, "pipe:1")
+
+	logging.FromContext(ctx).Debug("FFmpeg stream transcode command", "format", format, "kbps", kbps, "offset", offset)
+	return runFFmpegPipe(ctx, "transcode", args, w)
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// verifiedTTL matches the "path:" index, so a library that is left alone is verified about
+// once a quarter
+const verifiedTTL = 90 * 24 * time.Hour
+
+// verifiedKey stores the mtime and size a file had when it last passed verification. Any
+// write to the file changes one of them, which invalidates the marker.
+func verifiedKey(path string) string {
+	return "verified:" + path
+}
+
+// fileStamp identifies one version of a file
+func fileStamp(info os.FileInfo) string {
+	return fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// isVerified reports whether path is unchanged since it last passed verification. format is
+// the DOWNLOAD_FORMAT VerifyFormat checked it against; "" accepts a file verified for any format.
+func (s *SyncService) isVerified(ctx context.Context, path, format string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	val, err := s.cache.Get(ctx, verifiedKey(path))
+	if err != nil {
+		return false
+	}
+	stamp, verifiedFormat, _ := strings.Cut(val, "|")
+	if stamp != fileStamp(info) {
+		return false
+	}
+	return format == "" || verifiedFormat == format
+}
+
+// markVerified records that path, as it is now, passed verification for format ("" for a bare
+// integrity check). A format marker is never downgraded by a later integrity check.
+func (s *SyncService) markVerified(ctx context.Context, path, format string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if format == "" && s.isVerified(ctx, path, "") {
+		return
+	}
+	s.cache.Set(ctx, verifiedKey(path), fileStamp(info)+"|"+format, verifiedTTL)
+}
+
+// forgetVerified drops the marker for a file that failed verification or is being replaced
+func (s *SyncService) forgetVerified(ctx context.Context, path string) {
+	s.cache.Delete(ctx, verifiedKey(path))
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jetstream/internal/config"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrCorrupt marks files that are unreadable, truncated or too short
+	ErrCorrupt = errors.New("corrupt file")
+	// ErrWrongFormat marks readable files whose codec or stream parameters don't match what was requested
+	ErrWrongFormat = errors.New("wrong format")
+)
+
+// formatCodecs maps a DOWNLOAD_FORMAT to the codec ffprobe must report for it
+var formatCodecs = map[string]string{
+	"opus": "opus",
+	"mp3":  "mp3",
+	"aac":  "aac",
+	"flac": "flac",
+}
+
+// VerifyFormat runs VerifyIntegrity and then checks that the audio stream is expectedFormat with
+// a plausible sample rate and bitrate. Errors wrap ErrCorrupt or ErrWrongFormat, or
+// context.DeadlineExceeded when a check timed out before judging the file. Files unchanged since
+// they last passed for expectedFormat are accepted without probing.
+func (s *SyncService) VerifyFormat(ctx context.Context, path, expectedFormat string) error {
+	return s.verifyFormatAs(ctx, s.cfg.Get(), path, expectedFormat)
+}
+
+// verifyFormatAs is VerifyFormat judging the bitrate against the encoder settings in cfg
+func (s *SyncService) verifyFormatAs(ctx context.Context, cfg *config.Config, path, expectedFormat string) error {
+	if s.isVerified(ctx, path, expectedFormat) {
+		return nil
+	}
+	if err := s.verifyFormat(ctx, cfg, path, expectedFormat); err != nil {
+		s.forgetVerified(ctx, path)
+		return err
+	}
+	s.markVerified(ctx, path, expectedFormat)
+	return nil
+}
+
+// verifyFormat is VerifyFormat without the verified marker
+func (s *SyncService) verifyFormat(ctx context.Context, cfg *config.Config, path, expectedFormat string) error {
+	if err := s.checkIntegrity(ctx, path); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return err // Not judged, so not corrupt either
+		}
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+
+	wantCodec, ok := formatCodecs[expectedFormat]
+	if !ok {
+		return nil // Stream copies keep whatever the CDN sent
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "stream=codec_name,sample_rate,bit_rate:format=bit_rate",
+		"-of", "json",
+		path,
+	)
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("ffprobe: %w", ctx.Err())
+		}
+		return fmt.Errorf("%w: ffprobe failed: %v", ErrCorrupt, err)
+	}
+
+	var probe struct {
+		Streams []struct {
+			CodecName  string `json:"codec_name"`
+			SampleRate string `json:"sample_rate"`
+			BitRate    string `json:"bit_rate"`
+		} `json:"streams"`
+		Format struct {
+			BitRate string `json:"bit_rate"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return fmt.Errorf("%w: unreadable ffprobe output: %v", ErrCorrupt, err)
+	}
+	if len(probe.Streams) == 0 {
+		return fmt.Errorf("%w: no audio stream", ErrWrongFormat)
+	}
+	stream := probe.Streams[0]
+
+	if stream.CodecName != wantCodec {
+		return fmt.Errorf("%w: codec %s, expected %s", ErrWrongFormat, stream.CodecName, wantCodec)
+	}
+
+	sampleRate, _ := strconv.Atoi(stream.SampleRate)
+	if sampleRate < 8000 || sampleRate > 384000 || (wantCodec == "opus" && sampleRate != 48000) {
+		return fmt.Errorf("%w: implausible sample rate %s", ErrWrongFormat, stream.SampleRate)
+	}
+
+	// Ogg/Opus only reports the container bitrate
+	bitRate, err := strconv.Atoi(stream.BitRate)
+	if err != nil {
+		bitRate, _ = strconv.Atoi(probe.Format.BitRate)
+	}
+	if bitRate > 0 {
+		minKbps, maxKbps := bitrateRange(cfg, expectedFormat)
+		if kbps := bitRate / 1000; kbps < minKbps || kbps > maxKbps {
+			return fmt.Errorf("%w: bitrate %dkbps outside %d-%dkbps", ErrWrongFormat, kbps, minKbps, maxKbps)
+		}
+	}
+	return nil
+}
+
+// bitrateRange is the accepted bitrate window for a format encoded under cfg. Lossy targets are VBR and the
+// container bitrate includes embedded cover art, so the bounds are deliberately loose.
+func bitrateRange(cfg *config.Config, format string) (int, int) {
+	switch format {
+	case "opus":
+		target := kbps(cfg.OpusBitrate)
+		return target / 3, target*2 + 64
+	case "aac":
+		target := kbps(cfg.AACBitrate)
+		return target / 3, target*2 + 64
+	case "mp3":
+		return 32, 400 // V9 through V0 and everything in between
+	case "flac":
+		return 100, 10000
+	default:
+		return 0, 1 << 30
+	}
+}
+
+// kbps parses a bitrate such as "128k"
+func kbps(bitrate string) int {
+	n, _ := strconv.Atoi(strings.TrimSuffix(bitrate, "k"))
+	return n
+}
+
+// formatForPath maps a synced file's extension back to the DOWNLOAD_FORMAT that produced it
+func formatForPath(path string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+}
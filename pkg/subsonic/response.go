@@ -15,6 +15,8 @@ type Response struct {
 	Artist                 *ArtistWithAlbums       `xml:"artist,omitempty" json:"artist,omitempty"`
 	Album                  *AlbumWithSongs         `xml:"album,omitempty" json:"album,omitempty"`
 	Directory              *Directory              `xml:"directory,omitempty" json:"directory,omitempty"`
+	Indexes                *Indexes                `xml:"indexes,omitempty" json:"indexes,omitempty"`
+	Artists                *Indexes                `xml:"artists,omitempty" json:"artists,omitempty"`
 	ArtistInfo             *ArtistInfo             `xml:"artistInfo,omitempty" json:"artistInfo,omitempty"`
 	ArtistInfo2            *ArtistInfo             `xml:"artistInfo2,omitempty" json:"artistInfo2,omitempty"`
 	SimilarArtists         *SimilarArtists         `xml:"similarArtists,omitempty" json:"similarArtists,omitempty"`
@@ -30,8 +32,15 @@ type Response struct {
 	RandomSongs            *RandomSongs            `xml:"randomSongs,omitempty" json:"randomSongs,omitempty"`
 	SongsByGenre           *RandomSongs            `xml:"songsByGenre,omitempty" json:"songsByGenre,omitempty"`
 	Song                   *Song                   `xml:"song,omitempty" json:"song,omitempty"`
+	Songs                  *Songs                  `xml:"songs,omitempty" json:"songs,omitempty"`
+	Albums                 *Albums                 `xml:"albums,omitempty" json:"albums,omitempty"`
 	Lyrics                 *Lyrics                 `xml:"lyrics,omitempty" json:"lyrics,omitempty"`
+	LyricsList             *LyricsList             `xml:"lyricsList,omitempty" json:"lyricsList,omitempty"`
 	OpenSubsonicExtensions *OpenSubsonicExtensions `xml:"openSubsonicExtensions,omitempty" json:"openSubsonicExtensions,omitempty"`
+	Genres                 *Genres                 `xml:"genres,omitempty" json:"genres,omitempty"`
+	ScanStatus             *ScanStatus             `xml:"scanStatus,omitempty" json:"scanStatus,omitempty"`
+	License                *License                `xml:"license,omitempty" json:"license,omitempty"`
+	InternetRadioStations  *InternetRadioStations  `xml:"internetRadioStations,omitempty" json:"internetRadioStations,omitempty"`
 	Error                  *Error                  `xml:"error,omitempty" json:"error,omitempty"`
 }
 
@@ -45,10 +54,52 @@ type AlbumWithSongs struct {
 	Song []Song `xml:"song"`
 }
 
+type License struct {
+	Valid          bool   `xml:"valid,attr" json:"valid"`
+	Email          string `xml:"email,attr,omitempty" json:"email,omitempty"`
+	LicenseExpires string `xml:"licenseExpires,attr,omitempty" json:"licenseExpires,omitempty"` // ISO 8601 date
+}
+
+// InternetRadioStations is the getInternetRadioStations list
+type InternetRadioStations struct {
+	InternetRadioStation []InternetRadioStation `xml:"internetRadioStation" json:"internetRadioStation"`
+}
+
+type InternetRadioStation struct {
+	ID          string `xml:"id,attr" json:"id"`
+	Name        string `xml:"name,attr" json:"name"`
+	StreamURL   string `xml:"streamUrl,attr" json:"streamUrl"`
+	HomePageURL string `xml:"homePageUrl,attr,omitempty" json:"homePageUrl,omitempty"`
+}
+
+type ScanStatus struct {
+	Scanning bool `xml:"scanning,attr" json:"scanning"`
+	Count    int  `xml:"count,attr,omitempty" json:"count,omitempty"`
+}
+
 type Lyrics struct {
 	Value string `xml:",chardata" json:"value"`
 }
 
+// LyricsList is the OpenSubsonic getLyricsBySongId payload
+type LyricsList struct {
+	StructuredLyrics []StructuredLyrics `xml:"structuredLyrics,omitempty" json:"structuredLyrics,omitempty"`
+}
+
+type StructuredLyrics struct {
+	DisplayArtist string      `xml:"displayArtist,attr,omitempty" json:"displayArtist,omitempty"`
+	DisplayTitle  string      `xml:"displayTitle,attr,omitempty" json:"displayTitle,omitempty"`
+	Lang          string      `xml:"lang,attr" json:"lang"`     // ISO 639 code, "xxx" when unknown
+	Offset        int         `xml:"offset,attr" json:"offset"` // Milliseconds to shift every line by
+	Synced        bool        `xml:"synced,attr" json:"synced"`
+	Line          []LyricLine `xml:"line" json:"line"`
+}
+
+type LyricLine struct {
+	Start *int64 `xml:"start,attr,omitempty" json:"start,omitempty"` // Milliseconds; only set when synced
+	Value string `xml:",chardata" json:"value"`
+}
+
 type Error struct {
 	Code    int    `xml:"code,attr" json:"code"`
 	Message string `xml:"message,attr" json:"message"`
@@ -97,10 +148,28 @@ type Playlist struct {
 }
 
 type Artist struct {
-	ID         string `xml:"id,attr" json:"id"`
-	Name       string `xml:"name,attr" json:"name"`
-	CoverArt   string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
-	AlbumCount int    `xml:"albumCount,attr,omitempty" json:"albumCount,omitempty"`
+	ID             string `xml:"id,attr" json:"id"`
+	Name           string `xml:"name,attr" json:"name"`
+	CoverArt       string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	ArtistImageUrl string `xml:"artistImageUrl,attr,omitempty" json:"artistImageUrl,omitempty"` // Direct image URL for clients that skip getCoverArt
+	AlbumCount     int    `xml:"albumCount,attr,omitempty" json:"albumCount,omitempty"`
+	Starred        string `xml:"starred,attr,omitempty" json:"starred,omitempty"` // ISO 8601 date
+	Provider       string `xml:"provider,attr,omitempty" json:"provider,omitempty"`
+}
+
+// Indexes is the alphabetical artist index returned by getIndexes and (as "artists") getArtists
+type Indexes struct {
+	LastModified    int64    `xml:"lastModified,attr,omitempty" json:"lastModified,omitempty"` // getIndexes only
+	IgnoredArticles string   `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Shortcut        []Artist `xml:"shortcut,omitempty" json:"shortcut,omitempty"`
+	Index           []Index  `xml:"index,omitempty" json:"index,omitempty"`
+	Child           []Song   `xml:"child,omitempty" json:"child,omitempty"` // Loose files at the top of a music folder
+}
+
+// Index groups the artists filed under one initial letter (or range such as "X-Z")
+type Index struct {
+	Name   string   `xml:"name,attr" json:"name"`
+	Artist []Artist `xml:"artist" json:"artist"`
 }
 
 type Album struct {
@@ -113,8 +182,25 @@ type Album struct {
 	SongCount int    `xml:"songCount,attr,omitempty" json:"songCount,omitempty"`
 	Duration  int    `xml:"duration,attr,omitempty" json:"duration,omitempty"`
 	Year      int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre     string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
 	Starred   string `xml:"starred,attr,omitempty" json:"starred,omitempty"` // ISO 8601 date
 	IsDir     bool   `xml:"isDir,attr" json:"isDir"`
+
+	// OpenSubsonic additions; omitted when unknown
+	Genres       []ItemGenre   `xml:"genres,omitempty" json:"genres,omitempty"`
+	RecordLabels []RecordLabel `xml:"recordLabels,omitempty" json:"recordLabels,omitempty"`
+
+	Provider string `xml:"provider,attr,omitempty" json:"provider,omitempty"` // See Song.Provider
+}
+
+// ItemGenre is one of an album's genres (OpenSubsonic)
+type ItemGenre struct {
+	Name string `xml:"name,attr" json:"name"`
+}
+
+// RecordLabel is a label an album was released on (OpenSubsonic)
+type RecordLabel struct {
+	Name string `xml:"name,attr" json:"name"`
 }
 
 type Song struct {
@@ -126,10 +212,12 @@ type Song struct {
 	AlbumID     string `xml:"albumId,attr,omitempty" json:"albumId,omitempty"`
 	Artist      string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
 	ArtistID    string `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	AlbumArtist string `xml:"displayAlbumArtist,attr,omitempty" json:"displayAlbumArtist,omitempty"` // Album-level artist, e.g. "Various Artists" on compilations
 	CoverArt    string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
 	Duration    int    `xml:"duration,attr,omitempty" json:"duration,omitempty"`
 	BitRate     int    `xml:"bitRate,attr,omitempty" json:"bitRate,omitempty"`
 	Track       int    `xml:"track,attr,omitempty" json:"track,omitempty"`
+	DiscNumber  int    `xml:"discNumber,attr,omitempty" json:"discNumber,omitempty"`
 	Year        int    `xml:"year,attr,omitempty" json:"year,omitempty"`
 	Genre       string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
 	Size        int64  `xml:"size,attr,omitempty" json:"size,omitempty"`
@@ -141,6 +229,19 @@ type Song struct {
 	BPM         int    `xml:"bpm,attr,omitempty" json:"bpm,omitempty"`
 	Comment     string `xml:"comment,attr,omitempty" json:"comment,omitempty"`
 	SortName    string `xml:"sortName,attr,omitempty" json:"sortName,omitempty"`
+
+	// OpenSubsonic additions; omitted when unknown
+	MediaType     string `xml:"mediaType,attr,omitempty" json:"mediaType,omitempty"` // "song" for tracks
+	MusicBrainzID string `xml:"musicBrainzId,attr,omitempty" json:"musicBrainzId,omitempty"`
+	ChannelCount  int    `xml:"channelCount,attr,omitempty" json:"channelCount,omitempty"`
+	SamplingRate  int    `xml:"samplingRate,attr,omitempty" json:"samplingRate,omitempty"` // Hz
+	BitDepth      int    `xml:"bitDepth,attr,omitempty" json:"bitDepth,omitempty"`
+	PlayCount     int64  `xml:"playCount,attr,omitempty" json:"playCount,omitempty"`
+
+	// JetStream addition: the external provider an item comes from ("squidwtf", "deezer").
+	// Empty for Navidrome's own items, which are local. Clients that don't know the
+	// attribute ignore it.
+	Provider string `xml:"provider,attr,omitempty" json:"provider,omitempty"`
 }
 
 type Directory struct {
@@ -181,6 +282,16 @@ type Starred struct {
 	Song   []Song   `xml:"song,omitempty" json:"song,omitempty"`
 }
 
+// Songs is the getSongs batch result, in request order
+type Songs struct {
+	Song []Song `xml:"song,omitempty" json:"song,omitempty"`
+}
+
+// Albums is the getAlbums batch result, in request order
+type Albums struct {
+	Album []Album `xml:"album,omitempty" json:"album,omitempty"`
+}
+
 type AlbumList2 struct {
 	Album []Album `xml:"album,omitempty" json:"album,omitempty"`
 }
@@ -192,3 +303,15 @@ type RandomSongs struct {
 type SimilarSongs struct {
 	Song []Song `xml:"song,omitempty" json:"song,omitempty"`
 }
+
+type Genres struct {
+	Genre []Genre `xml:"genre,omitempty" json:"genre,omitempty"`
+}
+
+// Genre counts are omitted when unknown, as for external genres: some clients hide genres
+// that report no songs
+type Genre struct {
+	Name       string `xml:",chardata" json:"value"`
+	SongCount  int    `xml:"songCount,attr,omitempty" json:"songCount,omitempty"`
+	AlbumCount int    `xml:"albumCount,attr,omitempty" json:"albumCount,omitempty"`
+}